@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const notAuthorizedMessage = "🚫 Sorry, you're not authorized to use this bot. Please contact the bot's operator for access."
+
+const bannedKey = "banned_users"
+
+const allowlistUsage = "Usage:\n/allowlist\n/allowlist add user|chat <id>\n/allowlist remove user|chat <id>"
+
+type accessList struct {
+	mu     sync.RWMutex
+	users  map[int64]bool
+	chats  map[int64]bool
+	banned map[int64]bool
+}
+
+var allowlist = &accessList{users: map[int64]bool{}, chats: map[int64]bool{}, banned: map[int64]bool{}}
+
+func (a *accessList) load(userIDs, chatIDs []int64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, id := range userIDs {
+		a.users[id] = true
+	}
+	for _, id := range chatIDs {
+		a.chats[id] = true
+	}
+}
+
+// allows reports whether a user may use the bot in a chat. An empty
+// allowlist means the bot is open to everyone who isn't banned.
+func (a *accessList) allows(userID, chatID int64) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.banned[userID] {
+		return false
+	}
+	if len(a.users) == 0 && len(a.chats) == 0 {
+		return true
+	}
+	return a.users[userID] || a.chats[chatID]
+}
+
+func (a *accessList) set(kind string, id int64, allowed bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var m map[int64]bool
+	switch kind {
+	case "user":
+		m = a.users
+	case "chat":
+		m = a.chats
+	default:
+		return fmt.Errorf("unknown entry type %q", kind)
+	}
+
+	if allowed {
+		m[id] = true
+	} else {
+		delete(m, id)
+	}
+	return nil
+}
+
+// setBanned bans or unbans a user and saves the bans, which loadBans
+// restores at startup.
+func (a *accessList) setBanned(userID int64, banned bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if banned {
+		a.banned[userID] = true
+	} else {
+		delete(a.banned, userID)
+	}
+	ids := make([]int64, 0, len(a.banned))
+	for id := range a.banned {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return store.put(bucketMeta, bannedKey, ids)
+}
+
+func (a *accessList) loadBans() {
+	var ids []int64
+	if _, err := store.get(bucketMeta, bannedKey, &ids); err != nil {
+		slog.Error("Error loading bans", "error", err)
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, id := range ids {
+		a.banned[id] = true
+	}
+}
+
+func (a *accessList) String() string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if len(a.users) == 0 && len(a.chats) == 0 {
+		return "🔓 The allowlist is empty, everyone can use the bot."
+	}
+	return fmt.Sprintf("🔒 Allowed users: %s\n🔒 Allowed chats: %s", formatIDs(a.users), formatIDs(a.chats))
+}
+
+func formatIDs(m map[int64]bool) string {
+	if len(m) == 0 {
+		return "none"
+	}
+	ids := make([]int64, 0, len(m))
+	for id := range m {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = strconv.FormatInt(id, 10)
+	}
+	return strings.Join(parts, ", ")
+}
+
+func isAdmin(userID int64) bool {
+	for _, id := range cfg.AdminIDs {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// isObserver reports whether the user is a read-only observer, who sees
+// the queue, stats and everyone's history but can't change anything or
+// download.
+func isObserver(userID int64) bool {
+	return slices.Contains(cfg.ObserverIDs, userID) && !isAdmin(userID)
+}
+
+func canObserve(userID int64) bool {
+	return isAdmin(userID) || isObserver(userID)
+}
+
+func isAuthorized(message *tgbotapi.Message) bool {
+	if message.From == nil {
+		return allowlist.allows(0, message.Chat.ID)
+	}
+	if isObserver(message.From.ID) {
+		return false
+	}
+	return isAdmin(message.From.ID) || allowlist.allows(message.From.ID, message.Chat.ID)
+}
+
+func handleAllowlistCommand(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
+	if message.From == nil || !isAdmin(message.From.ID) {
+		sendErrorMessage(bot, message.Chat.ID, "🚫 Only bot admins can manage the allowlist.")
+		return
+	}
+
+	args, err := commandArgs(message)
+	if err != nil {
+		sendErrorMessage(bot, message.Chat.ID, usageError(err, allowlistUsage))
+		return
+	}
+	if len(args) == 0 {
+		sendMessage(bot, message.Chat.ID, allowlist.String())
+		return
+	}
+	if len(args) != 3 || (args[0] != "add" && args[0] != "remove") {
+		sendErrorMessage(bot, message.Chat.ID, allowlistUsage)
+		return
+	}
+
+	id, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		sendErrorMessage(bot, message.Chat.ID, "❌ Invalid ID: "+args[2])
+		return
+	}
+	if err := allowlist.set(args[1], id, args[0] == "add"); err != nil {
+		sendErrorMessage(bot, message.Chat.ID, allowlistUsage)
+		return
+	}
+
+	if args[0] == "add" {
+		sendMessage(bot, message.Chat.ID, fmt.Sprintf("✅ Added %s %d to the allowlist.", args[1], id))
+	} else {
+		sendMessage(bot, message.Chat.ID, fmt.Sprintf("✅ Removed %s %d from the allowlist.", args[1], id))
+	}
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+type adminCommand struct {
+	usage string
+	run   func(bot *tgbotapi.BotAPI, message *tgbotapi.Message, args []string)
+}
+
+var adminCommands map[string]adminCommand
+
+// observerCommands are the admin commands observers may use too, which only
+// show things.
+var observerCommands = map[string]bool{"help": true, "stats": true}
+
+func init() {
+	// Assigned in init because adminHelp refers back to the map.
+	adminCommands = map[string]adminCommand{
+		"help":        {"/admin help", adminHelp},
+		"stats":       {"/admin stats [label]", adminStats},
+		"ban":         {"/admin ban <user id>", adminBan},
+		"unban":       {"/admin unban <user id>", adminUnban},
+		"purge":       {"/admin purge", adminPurge},
+		"setlimit":    {"/admin setlimit <MB|default> [chat_id]", adminSetLimit},
+		"hash":        {"/admin hash list|allow|deny|hold|remove [sha256] [note]", adminHash},
+		"maintenance": {"/admin maintenance", adminMaintenance},
+	}
+}
+
+type botStats struct {
+	startedAt  time.Time
+	jobs       atomic.Int64
+	active     atomic.Int64
+	succeeded  atomic.Int64
+	failed     atomic.Int64
+	bytesTotal atomic.Int64
+	// lastProgress is when a job last took a slot, reported download
+	// progress or finished, in Unix nanoseconds.
+	lastProgress atomic.Int64
+	usage        usageTotals
+}
+
+var stats = &botStats{startedAt: time.Now()}
+
+func (s *botStats) touch() {
+	s.lastProgress.Store(time.Now().UnixNano())
+}
+
+// fileSizeLimitMB is the effective size limit; it starts out as the
+// configured value and can be changed at runtime with /admin setlimit.
+var fileSizeLimitMB atomic.Int64
+
+func maxFileSizeMB() int64 {
+	return fileSizeLimitMB.Load()
+}
+
+func maxFileSize() int64 {
+	return maxFileSizeMB() * 1024 * 1024
+}
+
+// telegramLimitMB is the largest upload Telegram accepts: 50 MB through
+// the public Bot API, 2000 MB through a local Bot API server.
+func telegramLimitMB() int64 {
+	if cfg.TelegramAPIURL != "" {
+		return localAPIFileSizeMB
+	}
+	return MAX_TELEGRAM_FILE_SIZE / 1024 / 1024
+}
+
+// chatMaxFileSizeMB is the limit that applies to downloads for chatID.
+func chatMaxFileSizeMB(chatID int64) int64 {
+	var chat chatRecord
+	found, err := store.get(bucketChats, chatKey(chatID), &chat)
+	if err != nil {
+		slog.Error("Error reading chat limit", "chat_id", chatID, "error", err)
+	}
+	limit := maxFileSizeMB()
+	if found && chat.MaxFileSizeMB > 0 {
+		limit = chat.MaxFileSizeMB
+	}
+	if found && chat.SizeCapMB > 0 {
+		limit = min(limit, chat.SizeCapMB)
+	}
+	return limit
+}
+
+func handleAdminCommand(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
+	if message.From == nil || !canObserve(message.From.ID) {
+		sendErrorMessage(bot, message.Chat.ID, "🚫 Only bot admins can use /admin.")
+		return
+	}
+
+	args, err := commandArgs(message)
+	if err != nil {
+		sendErrorMessage(bot, message.Chat.ID, usageError(err, "Try /admin help."))
+		return
+	}
+	if len(args) == 0 {
+		adminHelp(bot, message, nil)
+		return
+	}
+
+	cmd, ok := adminCommands[strings.ToLower(args[0])]
+	if !ok {
+		sendErrorMessage(bot, message.Chat.ID, fmt.Sprintf("❌ Unknown admin command %q. Try /admin help.", args[0]))
+		return
+	}
+	if isObserver(message.From.ID) && !observerCommands[strings.ToLower(args[0])] {
+		sendErrorMessage(bot, message.Chat.ID, "🚫 Observers can only look, not change anything.")
+		return
+	}
+
+	slog.Info("Admin command", "admin_id", message.From.ID, "command", strings.Join(args, " "))
+	cmd.run(bot, message, args[1:])
+}
+
+func adminHelp(bot *tgbotapi.BotAPI, message *tgbotapi.Message, _ []string) {
+	observer := message.From != nil && isObserver(message.From.ID)
+	names := make([]string, 0, len(adminCommands))
+	for name := range adminCommands {
+		if !observer || observerCommands[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	lines := []string{"🛠 Admin commands:"}
+	for _, name := range names {
+		lines = append(lines, adminCommands[name].usage)
+	}
+	if observer {
+		lines = append(lines, "/queue", "/status [verbose]", "/history all [label]")
+	} else {
+		lines = append(lines, "/allowlist", strings.TrimPrefix(cancelAllUsage, "Usage: "), strings.TrimPrefix(requeueUsage, "Usage: "))
+	}
+	sendMessage(bot, message.Chat.ID, strings.Join(lines, "\n"))
+}
+
+func adminStats(bot *tgbotapi.BotAPI, message *tgbotapi.Message, args []string) {
+	if len(args) > 0 {
+		adminLabelStats(bot, message, args[0])
+		return
+	}
+	jobs := stats.jobs.Load()
+	active := stats.active.Load()
+	succeeded := stats.succeeded.Load()
+	failed := stats.failed.Load()
+
+	text := fmt.Sprintf("📊 Stats\n\nUptime: %s\nJobs: %d\nActive: %d\nSucceeded: %d\nFailed: %d\nDelivered: %.1f MB\nSize limit: %d MB\n\nCPU time: %s\nNetwork: %s in, %s out\nLargest temp disk use of a job: %s",
+		time.Since(stats.startedAt).Round(time.Second),
+		jobs, active, succeeded, failed,
+		float64(stats.bytesTotal.Load())/1024/1024,
+		maxFileSizeMB(),
+		time.Duration(stats.usage.cpu.Load()).Round(100*time.Millisecond),
+		formatMB(stats.usage.bytesIn.Load()), formatMB(stats.usage.bytesOut.Load()),
+		formatMB(stats.usage.peakDisk.Load()))
+	if _, reasons := shedding(); reasons != nil {
+		text += "\n\n⚠️ Shedding load: " + strings.Join(reasons, "; ")
+	}
+	sendMessage(bot, message.Chat.ID, text)
+}
+
+func parseUserID(bot *tgbotapi.BotAPI, message *tgbotapi.Message, args []string, usage string) (int64, bool) {
+	if len(args) != 1 {
+		sendErrorMessage(bot, message.Chat.ID, "Usage: "+usage)
+		return 0, false
+	}
+	id, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		sendErrorMessage(bot, message.Chat.ID, "❌ Invalid user ID: "+args[0])
+		return 0, false
+	}
+	return id, true
+}
+
+func adminBan(bot *tgbotapi.BotAPI, message *tgbotapi.Message, args []string) {
+	id, ok := parseUserID(bot, message, args, adminCommands["ban"].usage)
+	if !ok {
+		return
+	}
+	if isAdmin(id) {
+		sendErrorMessage(bot, message.Chat.ID, "❌ Admins can't be banned.")
+		return
+	}
+	if err := allowlist.setBanned(id, true); err != nil {
+		slog.Error("Error saving bans", "error", err)
+		sendErrorMessage(bot, message.Chat.ID, "❌ Failed to save the ban")
+		return
+	}
+	sendMessage(bot, message.Chat.ID, fmt.Sprintf("🔨 User %d is banned.", id))
+}
+
+func adminUnban(bot *tgbotapi.BotAPI, message *tgbotapi.Message, args []string) {
+	id, ok := parseUserID(bot, message, args, adminCommands["unban"].usage)
+	if !ok {
+		return
+	}
+	if err := allowlist.setBanned(id, false); err != nil {
+		slog.Error("Error saving bans", "error", err)
+		sendErrorMessage(bot, message.Chat.ID, "❌ Failed to save the ban")
+		return
+	}
+	sendMessage(bot, message.Chat.ID, fmt.Sprintf("✅ User %d is no longer banned.", id))
+}
+
+// adminPurge removes temp files left behind by previous runs. Files created
+// since startup may belong to running jobs and are left alone, and so are
+// partial downloads waiting to be resumed.
+func adminPurge(bot *tgbotapi.BotAPI, message *tgbotapi.Message, _ []string) {
+	removed, freed := purgeTempFiles(stats.startedAt)
+	sendMessage(bot, message.Chat.ID, fmt.Sprintf("🧹 Removed %d stale temp files (%.1f MB).", removed, float64(freed)/1024/1024))
+}
+
+func adminSetLimit(bot *tgbotapi.BotAPI, message *tgbotapi.Message, args []string) {
+	if len(args) != 1 && len(args) != 2 {
+		sendErrorMessage(bot, message.Chat.ID, "Usage: "+adminCommands["setlimit"].usage)
+		return
+	}
+
+	var mb int64
+	if args[0] != "default" || len(args) == 1 {
+		var err error
+		mb, err = strconv.ParseInt(args[0], 10, 64)
+		if err != nil || mb <= 0 {
+			sendErrorMessage(bot, message.Chat.ID, "❌ The limit must be a positive number of MB.")
+			return
+		}
+		if mb > telegramLimitMB() {
+			sendErrorMessage(bot, message.Chat.ID, fmt.Sprintf("❌ Telegram doesn't accept files over %d MB; larger limits need a local Bot API server.", telegramLimitMB()))
+			return
+		}
+	}
+
+	if len(args) == 1 {
+		// Saved with the /setup settings, which are applied at startup.
+		err := updateRecord(store, bucketMeta, setupKey, func(s *setupSettings, _ bool) error {
+			s.MaxFileSizeMB = mb
+			return nil
+		})
+		if err != nil {
+			slog.Error("Error saving file size limit", "error", err)
+			sendErrorMessage(bot, message.Chat.ID, "❌ Failed to save the limit")
+			return
+		}
+		fileSizeLimitMB.Store(mb)
+		sendMessage(bot, message.Chat.ID, fmt.Sprintf("✅ File size limit set to %d MB.", mb))
+		return
+	}
+
+	chatID, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		sendErrorMessage(bot, message.Chat.ID, "❌ Invalid chat ID: "+args[1])
+		return
+	}
+	err = updateRecord(store, bucketChats, chatKey(chatID), func(r *chatRecord, exists bool) error {
+		if !exists {
+			// Not seen yet; don't let the inactivity sweep take it for
+			// long abandoned.
+			r.LastActivity = time.Now()
+		}
+		r.ID = chatID
+		r.MaxFileSizeMB = mb
+		return nil
+	})
+	if err != nil {
+		slog.Error("Error saving chat limit", "chat_id", chatID, "error", err)
+		sendErrorMessage(bot, message.Chat.ID, "❌ Failed to save the limit")
+		return
+	}
+	if mb == 0 {
+		sendMessage(bot, message.Chat.ID, fmt.Sprintf("✅ Chat %d now uses the default limit of %d MB.", chatID, maxFileSizeMB()))
+	} else {
+		sendMessage(bot, message.Chat.ID, fmt.Sprintf("✅ File size limit for chat %d set to %d MB.", chatID, mb))
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const aliasUsage = "Usage:\n/alias set [chat|me] <name> <url>\n/alias remove [chat|me] <name>\n/alias list"
+
+var aliasNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+// aliasRecord is a saved URL. Chat aliases are shared by everyone in the
+// chat; personal ones ("me") follow the user around and take precedence.
+type aliasRecord struct {
+	URL       string    `json:"url"`
+	CreatedBy int64     `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func aliasPrefix(scope string, id int64) string {
+	return fmt.Sprintf("%s:%d:", scope, id)
+}
+
+// resolveAlias returns the URL saved under name for the sender of message,
+// looking at their personal aliases before the chat's.
+func resolveAlias(message *tgbotapi.Message, name string) (string, bool) {
+	name = strings.ToLower(name)
+	var prefixes []string
+	if message.From != nil {
+		prefixes = append(prefixes, aliasPrefix("user", message.From.ID))
+	}
+	prefixes = append(prefixes, aliasPrefix("chat", message.Chat.ID))
+
+	for _, prefix := range prefixes {
+		var alias aliasRecord
+		found, err := store.get(bucketAliases, prefix+name, &alias)
+		if err != nil {
+			slog.Error("Error looking up alias", "key", prefix+name, "error", err)
+			continue
+		}
+		if found {
+			return alias.URL, true
+		}
+	}
+	return "", false
+}
+
+// isAliasName tells an alias apart from a URL in /url arguments.
+func isAliasName(s string) bool {
+	return aliasNamePattern.MatchString(strings.ToLower(s))
+}
+
+func handleAliasCommand(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
+	if !isAuthorized(message) {
+		sendErrorMessage(bot, message.Chat.ID, notAuthorizedMessage)
+		return
+	}
+
+	args, err := commandArgs(message)
+	if err != nil {
+		sendErrorMessage(bot, message.Chat.ID, usageError(err, aliasUsage))
+		return
+	}
+	if len(args) == 0 || args[0] == "list" {
+		sendMessage(bot, message.Chat.ID, listAliases(message))
+		return
+	}
+	if args[0] != "set" && args[0] != "remove" {
+		sendErrorMessage(bot, message.Chat.ID, aliasUsage)
+		return
+	}
+
+	action, args := args[0], args[1:]
+	scope := "chat"
+	if len(args) > 0 && (args[0] == "chat" || args[0] == "me") {
+		scope, args = args[0], args[1:]
+	}
+	if (action == "set" && len(args) != 2) || (action == "remove" && len(args) != 1) {
+		sendErrorMessage(bot, message.Chat.ID, aliasUsage)
+		return
+	}
+
+	name := strings.ToLower(args[0])
+	if !isAliasName(name) {
+		sendErrorMessage(bot, message.Chat.ID, "❌ Alias names can use letters, digits, '_' and '-', up to 32 characters.")
+		return
+	}
+
+	var key string
+	if scope == "me" {
+		if message.From == nil {
+			sendErrorMessage(bot, message.Chat.ID, aliasUsage)
+			return
+		}
+		key = aliasPrefix("user", message.From.ID) + name
+	} else {
+		key = aliasPrefix("chat", message.Chat.ID) + name
+	}
+
+	if action == "remove" {
+		if err := store.delete(bucketAliases, key); err != nil {
+			slog.Error("Error removing alias", "key", key, "error", err)
+			sendErrorMessage(bot, message.Chat.ID, "❌ Failed to remove the alias")
+			return
+		}
+		sendMessage(bot, message.Chat.ID, fmt.Sprintf("✅ Removed alias %s.", name))
+		return
+	}
+
+	url := args[1]
+	if problem, ok := validateURL(url); !ok {
+		sendErrorMessage(bot, message.Chat.ID, problem)
+		return
+	}
+	alias := aliasRecord{URL: url, CreatedAt: time.Now()}
+	if message.From != nil {
+		alias.CreatedBy = message.From.ID
+	}
+	if err := store.put(bucketAliases, key, alias); err != nil {
+		slog.Error("Error saving alias", "key", key, "error", err)
+		sendErrorMessage(bot, message.Chat.ID, "❌ Failed to save the alias")
+		return
+	}
+	sendMessage(bot, message.Chat.ID, fmt.Sprintf("✅ Saved alias %s. Use it with /url %s", name, name))
+}
+
+func listAliases(message *tgbotapi.Message) string {
+	collect := func(prefix string) []string {
+		var lines []string
+		err := store.forEachPrefix(bucketAliases, prefix, func(key, value []byte) error {
+			var alias aliasRecord
+			if err := json.Unmarshal(value, &alias); err != nil {
+				return err
+			}
+			lines = append(lines, fmt.Sprintf("• %s → %s", strings.TrimPrefix(string(key), prefix), alias.URL))
+			return nil
+		})
+		if err != nil {
+			slog.Error("Error listing aliases", "prefix", prefix, "error", err)
+		}
+		sort.Strings(lines)
+		return lines
+	}
+
+	var b strings.Builder
+	chatAliases := collect(aliasPrefix("chat", message.Chat.ID))
+	b.WriteString("🔖 Chat aliases:\n")
+	if len(chatAliases) == 0 {
+		b.WriteString("(none)\n")
+	}
+	for _, line := range chatAliases {
+		b.WriteString(line + "\n")
+	}
+
+	if message.From != nil {
+		b.WriteString("\n👤 Your aliases:\n")
+		userAliases := collect(aliasPrefix("user", message.From.ID))
+		if len(userAliases) == 0 {
+			b.WriteString("(none)\n")
+		}
+		for _, line := range userAliases {
+			b.WriteString(line + "\n")
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+type apiErrorHint struct {
+	pattern string
+	hint    string
+}
+
+// Matched in order against the lowercased Bot API description, so more
+// specific patterns must come first.
+var apiErrorHints = []apiErrorHint{
+	{"not enough rights to send documents", "❌ I need the 'Send Media' permission in this group to send files."},
+	{"not enough rights to send text messages", "❌ I need the 'Send Messages' permission in this group."},
+	{"have no rights to send", "❌ I don't have permission to send messages here. Please ask an admin to grant me 'Send Messages' and 'Send Media'."},
+	{"not enough rights", "❌ I don't have enough rights in this chat. Please ask an admin to check my permissions."},
+	{"bot was blocked by the user", "❌ You have blocked the bot. Unblock it in our private chat and try again."},
+	{"bot was kicked", "❌ I was removed from that chat and can't deliver files there anymore."},
+	{"bot is not a member", "❌ I'm not a member of that chat. Please add me first."},
+	{"chat not found", "❌ I can't find that chat. Make sure I'm a member and the chat still exists."},
+	{"user is deactivated", "❌ That Telegram account has been deleted."},
+	{"wrong file identifier", "❌ Telegram rejected the file reference. Please try the download again."},
+	{"failed to get http url content", "❌ Telegram couldn't fetch the file from the source. Please try again later."},
+	{"request entity too large", "❌ Telegram rejected the upload because the file is too large."},
+	{"file is too big", "❌ Telegram rejected the upload because the file is too large."},
+	{"message thread not found", "❌ The topic I was asked to reply in no longer exists."},
+	{"replied message not found", "❌ The original message was deleted before I could reply."},
+}
+
+func describeSendError(err error, fallback string) string {
+	if err == nil {
+		return fallback
+	}
+
+	var apiErr *tgbotapi.Error
+	if errors.As(err, &apiErr) {
+		if apiErr.RetryAfter > 0 {
+			return fmt.Sprintf("❌ Telegram is rate limiting me. Please try again in %d seconds.", apiErr.RetryAfter)
+		}
+		if apiErr.Code == 413 {
+			return "❌ Telegram rejected the upload because the file is too large."
+		}
+	}
+
+	description := strings.ToLower(err.Error())
+	for _, h := range apiErrorHints {
+		if strings.Contains(description, h.pattern) {
+			return h.hint
+		}
+	}
+	return fallback
+}
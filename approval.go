@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const memberApprovalTimeout = 24 * time.Hour
+
+// memberRecord marks a user a group admin let use the bot in that group.
+type memberRecord struct {
+	ChatID     int64     `json:"chat_id"`
+	UserID     int64     `json:"user_id"`
+	ApprovedBy int64     `json:"approved_by"`
+	ApprovedAt time.Time `json:"approved_at"`
+}
+
+type memberPrompt struct {
+	job    *Job
+	choice chan bool
+}
+
+var (
+	memberMu      sync.Mutex
+	memberPrompts = map[int64]*memberPrompt{}
+)
+
+func memberKey(chatID, userID int64) string {
+	return fmt.Sprintf("%d:%d", chatID, userID)
+}
+
+func isChatAdmin(bot *tgbotapi.BotAPI, chatID, userID int64) bool {
+	member, err := bot.GetChatMember(tgbotapi.GetChatMemberConfig{
+		ChatConfigWithUser: tgbotapi.ChatConfigWithUser{ChatID: chatID, UserID: userID},
+	})
+	if err != nil {
+		slog.Error("Error checking chat admin", "chat_id", chatID, "user_id", userID, "error", err)
+		return false
+	}
+	return member.IsCreator() || member.IsAdministrator()
+}
+
+// needsMemberApproval reports whether the job comes from someone a group
+// admin has to let in first, with cfg.ApproveNewUsers. Group admins
+// themselves are let in the first time they ask.
+func needsMemberApproval(bot *tgbotapi.BotAPI, job *Job) bool {
+	// Groups, supergroups and channels have negative IDs.
+	if !cfg.ApproveNewUsers || job.ChatID > 0 || job.UserID == 0 || isAdmin(job.UserID) {
+		return false
+	}
+	var member memberRecord
+	found, err := store.get(bucketMembers, memberKey(job.ChatID, job.UserID), &member)
+	if err != nil {
+		slog.Error("Error reading member approval", "chat_id", job.ChatID, "user_id", job.UserID, "error", err)
+	}
+	if found {
+		return false
+	}
+	if isChatAdmin(bot, job.ChatID, job.UserID) {
+		approveMember(job.ChatID, job.UserID, job.UserID)
+		return false
+	}
+	return true
+}
+
+func approveMember(chatID, userID, approvedBy int64) {
+	record := memberRecord{ChatID: chatID, UserID: userID, ApprovedBy: approvedBy, ApprovedAt: time.Now()}
+	if err := store.put(bucketMembers, memberKey(chatID, userID), record); err != nil {
+		slog.Error("Error saving member approval", "chat_id", chatID, "user_id", userID, "error", err)
+	}
+}
+
+// awaitMemberApproval asks the group's admins to let the job's requester
+// in, and waits for one of them to answer.
+func awaitMemberApproval(bot *tgbotapi.BotAPI, job *Job) error {
+	prompt := &memberPrompt{job: job, choice: make(chan bool, 1)}
+	memberMu.Lock()
+	memberPrompts[job.ID] = prompt
+	memberMu.Unlock()
+	defer func() {
+		memberMu.Lock()
+		delete(memberPrompts, job.ID)
+		memberMu.Unlock()
+	}()
+
+	id := strconv.FormatInt(job.ID, 10)
+	msg := tgbotapi.NewMessage(job.ChatID, "👋 This is the first download request from this member here. A group admin needs to approve them first.")
+	msg.ReplyToMessageID = job.MessageID
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("✅ Approve", "member:"+id+":approve"),
+		tgbotapi.NewInlineKeyboardButtonData("❌ Decline", "member:"+id+":decline"),
+	))
+	if _, err := bot.Send(msg); err != nil {
+		return failJob("❌ Failed to ask the group admins for approval", err)
+	}
+	job.logger().Info("Waiting for a group admin to approve the requester")
+
+	select {
+	case approved := <-prompt.choice:
+		if !approved {
+			return &jobError{userMessage: "🚫 A group admin declined the request.", result: resultRejected}
+		}
+		return nil
+	case <-time.After(memberApprovalTimeout):
+		return &jobError{userMessage: "⌛ No group admin approved the request in time.", result: resultRejected}
+	case <-job.ctx.Done():
+		return job.ctx.Err()
+	}
+}
+
+func handleMemberCallback(bot *tgbotapi.BotAPI, query *tgbotapi.CallbackQuery, args []string) string {
+	if len(args) != 2 || query.From == nil || query.Message == nil {
+		return ""
+	}
+	jobID, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return ""
+	}
+
+	memberMu.Lock()
+	prompt, ok := memberPrompts[jobID]
+	memberMu.Unlock()
+	if !ok {
+		return "This request is no longer waiting."
+	}
+	job := prompt.job
+	if !isAdmin(query.From.ID) && !isChatAdmin(bot, job.ChatID, query.From.ID) {
+		return "Only group admins can decide."
+	}
+
+	// Checked again in case another admin answered in the meantime.
+	memberMu.Lock()
+	_, ok = memberPrompts[jobID]
+	delete(memberPrompts, jobID)
+	memberMu.Unlock()
+	if !ok {
+		return "Someone already decided."
+	}
+
+	approved := args[1] == "approve"
+	text := fmt.Sprintf("🚫 Declined by %s.", query.From.FirstName)
+	if approved {
+		approveMember(job.ChatID, job.UserID, query.From.ID)
+		text = fmt.Sprintf("✅ Approved by %s.", query.From.FirstName)
+	}
+	updateMessage(bot, query.Message.Chat.ID, query.Message.MessageID, text)
+	prompt.choice <- approved
+	return ""
+}
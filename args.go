@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// closingQuotes maps the quotes an argument can be put in to the one that
+// ends it. Phone keyboards like to turn straight quotes into curly ones.
+var closingQuotes = map[rune]rune{'"': '"', '\'': '\'', '“': '”', '„': '“', '‘': '’', '«': '»'}
+
+// splitArgs splits command arguments at whitespace, keeping quoted parts
+// like "my file.pdf" or name="my file.pdf" in one argument. A quote only
+// opens at the start of an argument or after '=', so apostrophes in
+// words stay as they are. Inside double quotes a backslash escapes the
+// next character.
+func splitArgs(text string) ([]string, error) {
+	var args []string
+	var current strings.Builder
+	inArg := false
+	runes := []rune(text)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		if unicode.IsSpace(r) {
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+			continue
+		}
+		closing, isQuote := closingQuotes[r]
+		atStart := !inArg || strings.HasSuffix(current.String(), "=")
+		if !isQuote || !atStart {
+			current.WriteRune(r)
+			inArg = true
+			continue
+		}
+
+		inArg = true
+		closed := false
+		for i++; i < len(runes); i++ {
+			c := runes[i]
+			if c == closing {
+				closed = true
+				break
+			}
+			if c == '\\' && r == '"' && i+1 < len(runes) {
+				i++
+				c = runes[i]
+			}
+			current.WriteRune(c)
+		}
+		if !closed {
+			return nil, fmt.Errorf("a quote was opened with %c but never closed", r)
+		}
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+	return args, nil
+}
+
+// commandArgs is splitArgs for the arguments of a command message.
+func commandArgs(message *tgbotapi.Message) ([]string, error) {
+	return splitArgs(message.CommandArguments())
+}
+
+// usageError is the reply to a command whose arguments didn't parse.
+func usageError(err error, usage string) string {
+	return "❌ " + err.Error() + ".\n\n" + usage
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const audioUsage = "Usage: /audio <url> [mp3|m4a]"
+
+// audioCodecs are the ffmpeg encoder arguments for each output format.
+var audioCodecs = map[string][]string{
+	"mp3": {"-c:a", "libmp3lame"},
+	"m4a": {"-c:a", "aac", "-movflags", "+faststart"},
+}
+
+func handleAudioCommand(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
+	if !isAuthorized(message) {
+		sendErrorMessage(bot, message.Chat.ID, notAuthorizedMessage)
+		return
+	}
+	args, err := commandArgs(message)
+	if err != nil {
+		sendErrorMessage(bot, message.Chat.ID, usageError(err, audioUsage))
+		return
+	}
+	format := "mp3"
+	if n := len(args); n > 1 {
+		if _, ok := audioCodecs[strings.ToLower(args[n-1])]; ok {
+			format = strings.ToLower(args[n-1])
+			args = args[:n-1]
+		}
+	}
+	if len(args) == 0 {
+		sendErrorMessage(bot, message.Chat.ID, audioUsage)
+		return
+	}
+	if _, err := exec.LookPath(cfg.FFmpegPath); err != nil {
+		slog.Warn("ffmpeg not found for /audio", "path", cfg.FFmpegPath, "error", err)
+		sendErrorMessage(bot, message.Chat.ID, ffmpegMissingMessage)
+		return
+	}
+
+	url, ok := resolveURLArgs(message, args)
+	if !ok {
+		sendErrorMessage(bot, message.Chat.ID, url)
+		return
+	}
+	if problem, ok := validateURL(url); !ok {
+		sendErrorMessage(bot, message.Chat.ID, problem)
+		return
+	}
+	if duplicates.isDuplicate(message) {
+		slog.Debug("Ignoring duplicate /audio", "chat_id", message.Chat.ID)
+		return
+	}
+	var userID int64
+	if message.From != nil {
+		userID = message.From.ID
+	}
+	if slowDown, ok := checkRateLimit(userID); !ok {
+		sendErrorMessage(bot, message.Chat.ID, slowDown)
+		return
+	}
+	go handleURL(bot, jobs.start(message, url, jobOptions{AudioFormat: format}))
+}
+
+// extractAudio runs ffmpeg over the downloaded file to get its audio track
+// in the requested format at cfg.AudioBitrateKbps.
+func extractAudio(bot *tgbotapi.BotAPI, job *Job, file *os.File) (*os.File, error) {
+	format := job.options.AudioFormat
+	out, err := os.CreateTemp(cfg.TempDir, "telegram-*."+format)
+	if err != nil {
+		return nil, failJob("❌ Failed to extract the audio", err)
+	}
+	out.Close()
+	discard := func() { os.Remove(out.Name()) }
+
+	updateMessage(bot, job.ChatID, job.StatusMessageID, "🎵 Extracting audio...")
+	done := job.timeStage("audio")
+	args := []string{"-i", file.Name(), "-vn", "-map", "0:a:0"}
+	args = append(args, audioCodecs[format]...)
+	args = append(args, "-b:a", fmt.Sprintf("%dk", cfg.AudioBitrateKbps), out.Name())
+	if err := runFFmpeg(job, args...); err != nil {
+		discard()
+		var jerr *jobError
+		if errors.As(err, &jerr) || job.ctx.Err() != nil {
+			return nil, err
+		}
+		return nil, &jobError{
+			userMessage: "❌ Couldn't extract any audio. Is the link a video or audio file?",
+			result:      resultRejected,
+			err:         err,
+		}
+	}
+
+	audio, err := os.Open(out.Name())
+	if err != nil {
+		discard()
+		return nil, failJob("❌ Failed to extract the audio", err)
+	}
+	info, err := audio.Stat()
+	if err != nil {
+		audio.Close()
+		discard()
+		return nil, failJob("❌ Failed to extract the audio", err)
+	}
+	if info.Size() > job.uploadLimit() {
+		audio.Close()
+		discard()
+		return nil, tooLargeError(info.Size(), job.limitMB)
+	}
+	done()
+	job.logger().Info("Extracted audio", "format", format, "bytes", info.Size())
+	return audio, nil
+}
+
+// audioFileName swaps the extension of name for the audio format's.
+func audioFileName(name, format string) string {
+	return strings.TrimSuffix(name, filepath.Ext(name)) + "." + format
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"log/slog"
+	neturl "net/url"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	autoDownloadUsage = "Usage: /autodownload on|off"
+	// maxAutoDownloads is how many links of one message are picked up.
+	maxAutoDownloads = 3
+)
+
+func handleAutoDownloadCommand(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
+	if !isAuthorized(message) {
+		sendErrorMessage(bot, message.Chat.ID, notAuthorizedMessage)
+		return
+	}
+	arg := strings.ToLower(strings.TrimSpace(message.CommandArguments()))
+	if arg == "" {
+		if autoDownloadEnabled(message.Chat.ID) {
+			sendMessage(bot, message.Chat.ID, "⚡ Links to files posted here are downloaded automatically. Turn it off with /autodownload off.")
+		} else {
+			sendMessage(bot, message.Chat.ID, "Links posted here need /url to be downloaded. Turn on automatic downloads with /autodownload on.")
+		}
+		return
+	}
+	if arg != "on" && arg != "off" {
+		sendErrorMessage(bot, message.Chat.ID, autoDownloadUsage)
+		return
+	}
+	if message.From == nil || !canManageChat(bot, message.Chat, message.From.ID) {
+		sendErrorMessage(bot, message.Chat.ID, "🚫 Only admins of this group can change that.")
+		return
+	}
+
+	err := updateRecord(store, bucketChats, chatKey(message.Chat.ID), func(r *chatRecord, exists bool) error {
+		if !exists {
+			r.ID = message.Chat.ID
+			r.Title = message.Chat.Title
+			r.Type = message.Chat.Type
+			r.LastActivity = time.Now()
+		}
+		r.AutoDownload = arg == "on"
+		return nil
+	})
+	if err != nil {
+		slog.Error("Error saving auto-download setting", "chat_id", message.Chat.ID, "error", err)
+		sendErrorMessage(bot, message.Chat.ID, "❌ Failed to save the setting")
+		return
+	}
+	slog.Info("Auto-download changed", "chat_id", message.Chat.ID, "user_id", message.From.ID, "on", arg == "on")
+	switch {
+	case arg == "off":
+		sendMessage(bot, message.Chat.ID, "✅ Links posted here need /url again.")
+	case message.Chat.IsPrivate():
+		sendMessage(bot, message.Chat.ID, "✅ Links to files you send me are downloaded automatically now.")
+	default:
+		// With privacy mode on, Telegram only passes commands and replies
+		// to the bot on.
+		sendMessage(bot, message.Chat.ID, "✅ Links to files posted here are downloaded automatically now. I only see every message if I'm an admin of the group or my privacy mode is off.")
+	}
+}
+
+func autoDownloadEnabled(chatID int64) bool {
+	var chat chatRecord
+	found, err := store.get(bucketChats, chatKey(chatID), &chat)
+	if err != nil {
+		slog.Error("Error loading chat", "chat_id", chatID, "error", err)
+	}
+	return found && chat.AutoDownload
+}
+
+// isDirectFileLink reports whether the link points at a file rather than
+// a web page, judging by its extension.
+func isDirectFileLink(link string) bool {
+	u, err := neturl.Parse(link)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return false
+	}
+	return !webPageExtensions[strings.ToLower(path.Ext(u.Path))]
+}
+
+// autoDownload starts downloads for the file links in a message of a chat
+// with /autodownload on. Other links are left alone, so talking about a
+// web page doesn't get it downloaded. It reports whether the chat has the
+// mode on.
+func autoDownload(bot *tgbotapi.BotAPI, message *tgbotapi.Message) bool {
+	links := messageLinks(message)
+	if len(links) == 0 || !autoDownloadEnabled(message.Chat.ID) {
+		return false
+	}
+	if !isAuthorized(message) || chatDisabled(message.Chat.ID) {
+		return true
+	}
+	var files []string
+	for _, link := range links {
+		if isDirectFileLink(link) && !slices.Contains(files, link) {
+			if _, ok := validateURL(link); ok {
+				files = append(files, link)
+			}
+		}
+	}
+	// Only a message that would start a download is stopped for
+	// verification, and before the duplicate check, which would take the
+	// message for a repeat once it's taken up again.
+	if len(files) == 0 || !passesGate(bot, message) || duplicates.isDuplicate(message) {
+		return true
+	}
+	var userID int64
+	if message.From != nil {
+		userID = message.From.ID
+	}
+
+	started := map[string]bool{}
+	for _, link := range files {
+		if len(started) == maxAutoDownloads {
+			break
+		}
+		if slowDown, ok := checkRateLimit(userID); !ok {
+			sendErrorMessage(bot, message.Chat.ID, slowDown)
+			break
+		}
+		started[link] = true
+		go handleURL(bot, jobs.start(message, link, jobOptions{}))
+	}
+	return true
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	// throttleChunk is the most one read takes before waiting for the
+	// limit, so a large buffer doesn't come in as one burst.
+	throttleChunk = 64 * 1024
+	// throttleBurst is how far ahead of the limit a transfer may get
+	// after being idle.
+	throttleBurst = 250 * time.Millisecond
+)
+
+// speedLimiter hands out bytes at a steady rate to everyone sharing it.
+type speedLimiter struct {
+	mu sync.Mutex
+	// bytesPerSecond is the rate, next when the bytes handed out so far
+	// are paid off.
+	bytesPerSecond float64
+	next           time.Time
+}
+
+func newSpeedLimiter(mbps int) *speedLimiter {
+	return &speedLimiter{bytesPerSecond: float64(mbps) * 1e6 / 8}
+}
+
+// take accounts for n bytes and returns how long to wait before the
+// next ones, which is up to the limiter's other users too.
+func (l *speedLimiter) take(n int, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if earliest := now.Add(-throttleBurst); l.next.Before(earliest) {
+		l.next = earliest
+	}
+	l.next = l.next.Add(time.Duration(float64(n) / l.bytesPerSecond * float64(time.Second)))
+	return l.next.Sub(now)
+}
+
+// bandwidth holds the limiters of cfg.MaxSpeedMbps, one for downloads
+// and one for uploads, and of cfg.UserMaxSpeedMbps for each user.
+var bandwidth = struct {
+	mu    sync.Mutex
+	total map[string]*speedLimiter
+	users map[string]map[int64]*speedLimiter
+}{total: map[string]*speedLimiter{}, users: map[string]map[int64]*speedLimiter{}}
+
+// idle reports whether the limiter has nothing left to pay off, so a new
+// one would do the same.
+func (l *speedLimiter) idle(now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.next.Before(now.Add(-throttleBurst))
+}
+
+// limiters returns the limiters a job's transfer in direction, download
+// or upload, goes through.
+func (j *Job) limiters(direction string) []*speedLimiter {
+	bandwidth.mu.Lock()
+	defer bandwidth.mu.Unlock()
+	var limiters []*speedLimiter
+	if cfg.MaxSpeedMbps > 0 {
+		l, ok := bandwidth.total[direction]
+		if !ok {
+			l = newSpeedLimiter(cfg.MaxSpeedMbps)
+			bandwidth.total[direction] = l
+		}
+		limiters = append(limiters, l)
+	}
+	if cfg.UserMaxSpeedMbps > 0 && j.UserID != 0 {
+		users := bandwidth.users[direction]
+		if users == nil {
+			users = map[int64]*speedLimiter{}
+			bandwidth.users[direction] = users
+		}
+		now := time.Now()
+		for userID, l := range users {
+			if l.idle(now) {
+				delete(users, userID)
+			}
+		}
+		l, ok := users[j.UserID]
+		if !ok {
+			l = newSpeedLimiter(cfg.UserMaxSpeedMbps)
+			users[j.UserID] = l
+		}
+		limiters = append(limiters, l)
+	}
+	if cfg.JobMaxSpeedMbps > 0 {
+		if j.speedLimits == nil {
+			j.speedLimits = map[string]*speedLimiter{}
+		}
+		l, ok := j.speedLimits[direction]
+		if !ok {
+			l = newSpeedLimiter(cfg.JobMaxSpeedMbps)
+			j.speedLimits[direction] = l
+		}
+		limiters = append(limiters, l)
+	}
+	return limiters
+}
+
+// throttle slows r down to the job's bandwidth limits in direction.
+// Uploads aren't stopped with the job, since those under way when the bot
+// shuts down are left to finish.
+func (j *Job) throttle(r io.Reader, direction string) io.Reader {
+	limiters := j.limiters(direction)
+	if len(limiters) == 0 {
+		return r
+	}
+	ctx := j.ctx
+	if direction == "upload" {
+		ctx = context.Background()
+	}
+	return &throttledReader{Reader: r, ctx: ctx, limiters: limiters}
+}
+
+type throttledReader struct {
+	io.Reader
+	ctx      context.Context
+	limiters []*speedLimiter
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > throttleChunk {
+		p = p[:throttleChunk]
+	}
+	n, err := t.Reader.Read(p)
+	if n == 0 {
+		return n, err
+	}
+	now := time.Now()
+	var wait time.Duration
+	for _, l := range t.limiters {
+		wait = max(wait, l.take(n, now))
+	}
+	if wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-t.ctx.Done():
+			return n, t.ctx.Err()
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	cancelAllUsage = "Usage: /cancelall all|user <id>|host <host>"
+	requeueUsage   = "Usage: /requeue failed [hours]"
+	// bulkPromptTTL is how long a confirmation prompt can be answered.
+	bulkPromptTTL = 10 * time.Minute
+)
+
+// bulkOp is a bulk operation waiting for the admin to confirm it. The jobs
+// are picked when it is proposed, so the confirmation acts on exactly the
+// ones that were listed.
+type bulkOp struct {
+	adminID  int64
+	created  time.Time
+	describe string
+	run      func() string
+}
+
+var bulkOps = struct {
+	mu   sync.Mutex
+	next int
+	ops  map[int]bulkOp
+}{ops: map[int]bulkOp{}}
+
+func proposeBulkOp(bot *tgbotapi.BotAPI, message *tgbotapi.Message, op bulkOp) {
+	bulkOps.mu.Lock()
+	bulkOps.next++
+	id := bulkOps.next
+	bulkOps.ops[id] = op
+	bulkOps.mu.Unlock()
+
+	msg := tgbotapi.NewMessage(message.Chat.ID, op.describe+"\n\nAre you sure?")
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("✅ Yes", fmt.Sprintf("bulk:%d:yes", id)),
+		tgbotapi.NewInlineKeyboardButtonData("✖️ No", fmt.Sprintf("bulk:%d:no", id)),
+	))
+	if _, err := bot.Send(msg); err != nil {
+		slog.Error("Error sending bulk confirmation", "error", err)
+	}
+}
+
+func handleBulkCallback(bot *tgbotapi.BotAPI, query *tgbotapi.CallbackQuery, args []string) string {
+	if query.From == nil || !isAdmin(query.From.ID) {
+		return "Only bot admins can do this."
+	}
+	if len(args) != 2 || query.Message == nil {
+		return ""
+	}
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		return ""
+	}
+
+	bulkOps.mu.Lock()
+	op, ok := bulkOps.ops[id]
+	if ok && op.adminID == query.From.ID {
+		delete(bulkOps.ops, id)
+	}
+	bulkOps.mu.Unlock()
+	switch {
+	case !ok:
+		return "This was already answered."
+	case op.adminID != query.From.ID:
+		return "Only the admin who asked can confirm this."
+	}
+
+	text := "✖️ Nothing was changed."
+	if args[1] == "yes" {
+		if time.Since(op.created) > bulkPromptTTL {
+			text = "⌛ This confirmation expired. Please run the command again."
+		} else {
+			slog.Info("Admin confirmed bulk operation", "admin_id", query.From.ID, "operation", op.describe)
+			text = op.run()
+		}
+	}
+	bot.Send(tgbotapi.NewEditMessageText(query.Message.Chat.ID, query.Message.MessageID, text))
+	return ""
+}
+
+func handleCancelAllCommand(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
+	if message.From == nil || !isAdmin(message.From.ID) {
+		sendErrorMessage(bot, message.Chat.ID, "🚫 Only bot admins can use /cancelall.")
+		return
+	}
+
+	args, err := commandArgs(message)
+	if err != nil {
+		sendErrorMessage(bot, message.Chat.ID, usageError(err, cancelAllUsage))
+		return
+	}
+	var match func(jobSnapshot) bool
+	var what string
+	switch {
+	case len(args) == 1 && strings.EqualFold(args[0], "all"):
+		match, what = func(jobSnapshot) bool { return true }, "all jobs"
+	case len(args) == 2 && strings.EqualFold(args[0], "user"):
+		userID, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			sendErrorMessage(bot, message.Chat.ID, "❌ Invalid user ID: "+args[1])
+			return
+		}
+		match, what = func(s jobSnapshot) bool { return s.UserID == userID }, fmt.Sprintf("the jobs of user %d", userID)
+	case len(args) == 2 && strings.EqualFold(args[0], "host"):
+		host := strings.ToLower(args[1])
+		match = func(s jobSnapshot) bool { return matchesAnyDomain(urlHost(s.URL), []string{host}) }
+		what = "the jobs for " + host
+	default:
+		sendErrorMessage(bot, message.Chat.ID, cancelAllUsage)
+		return
+	}
+
+	var ids []int64
+	for _, s := range jobs.list() {
+		if match(s) {
+			ids = append(ids, s.ID)
+		}
+	}
+	if len(ids) == 0 {
+		sendMessage(bot, message.Chat.ID, "📋 No jobs match.")
+		return
+	}
+
+	adminID := message.From.ID
+	proposeBulkOp(bot, message, bulkOp{
+		adminID:  adminID,
+		created:  time.Now(),
+		describe: fmt.Sprintf("🛑 Cancel %s: %d running or queued.", what, len(ids)),
+		run: func() string {
+			cancelled := 0
+			for _, id := range ids {
+				if job, ok := jobs.get(id); ok {
+					job.cancel(errCancelledByAdmin)
+					job.logger().Info("Admin cancelled job", "admin_id", adminID)
+					cancelled++
+				}
+			}
+			return fmt.Sprintf("🛑 Cancelled %d jobs; %d had already finished.", cancelled, len(ids)-cancelled)
+		},
+	})
+}
+
+// handleRequeueCommand starts the jobs that failed within the last hours
+// again, once each, for example after the bot or a host was down.
+func handleRequeueCommand(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
+	if message.From == nil || !isAdmin(message.From.ID) {
+		sendErrorMessage(bot, message.Chat.ID, "🚫 Only bot admins can use /requeue.")
+		return
+	}
+
+	args, err := commandArgs(message)
+	if err != nil {
+		sendErrorMessage(bot, message.Chat.ID, usageError(err, requeueUsage))
+		return
+	}
+	hours := 1
+	switch {
+	case len(args) == 1 && strings.EqualFold(args[0], "failed-last-hour"):
+	case len(args) >= 1 && len(args) <= 2 && strings.EqualFold(args[0], "failed"):
+		if len(args) == 2 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n <= 0 {
+				sendErrorMessage(bot, message.Chat.ID, "❌ The number of hours must be positive.")
+				return
+			}
+			hours = n
+		}
+	default:
+		sendErrorMessage(bot, message.Chat.ID, requeueUsage)
+		return
+	}
+
+	records, err := requeueCandidates(time.Now().Add(-time.Duration(hours) * time.Hour))
+	if err != nil {
+		slog.Error("Error reading job history", "error", err)
+		sendErrorMessage(bot, message.Chat.ID, "❌ Failed to load the failed jobs")
+		return
+	}
+	if len(records) == 0 {
+		sendMessage(bot, message.Chat.ID, fmt.Sprintf("📋 No jobs failed in the last %d hours.", hours))
+		return
+	}
+
+	proposeBulkOp(bot, message, bulkOp{
+		adminID:  message.From.ID,
+		created:  time.Now(),
+		describe: fmt.Sprintf("🔁 Requeue %d jobs that failed in the last %d hours.", len(records), hours),
+		run: func() string {
+			retryMu.Lock()
+			defer retryMu.Unlock()
+			started := 0
+			for _, r := range records {
+				// It may have been retried since the admin asked.
+				var current jobRecord
+				if found, err := store.get(bucketJobs, jobKey(r.ID), &current); err != nil || !found || current.RequeuedAs != 0 {
+					continue
+				}
+				job := jobs.requeue(r)
+				err := updateRecord(store, bucketJobs, jobKey(r.ID), func(rec *jobRecord, _ bool) error {
+					rec.RequeuedAs = job.ID
+					return nil
+				})
+				if err != nil {
+					slog.Error("Error marking job requeued", "job_id", r.ID, "error", err)
+				}
+				job.logger().Info("Requeued failed job", "failed_job_id", r.ID)
+				go handleURL(bot, job)
+				started++
+			}
+			return fmt.Sprintf("🔁 Requeued %d jobs.", started)
+		},
+	})
+}
+
+// requeueCandidates returns the jobs that failed since, leaving out those
+// already requeued and repeats of the same link in the same chat.
+func requeueCandidates(since time.Time) ([]jobRecord, error) {
+	type target struct {
+		chatID int64
+		url    string
+	}
+	seen := make(map[target]bool)
+	var records []jobRecord
+	err := store.forEachReverse(bucketJobs, func(_, value []byte) (bool, error) {
+		var r jobRecord
+		if err := json.Unmarshal(value, &r); err != nil {
+			return false, err
+		}
+		if r.FinishedAt.Before(since) {
+			// Jobs are stored in creation order, so everything older
+			// is done with, save for jobs that ran for a long time.
+			return r.CreatedAt.After(since.Add(-24 * time.Hour)), nil
+		}
+		t := target{r.ChatID, r.URL}
+		if r.Result != resultFailed || r.RequeuedAs != 0 || seen[t] {
+			return true, nil
+		}
+		seen[t] = true
+		records = append(records, r)
+		return true, nil
+	})
+	return records, err
+}
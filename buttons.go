@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+var errCancelledByUser = errors.New("cancelled by the requester")
+
+func cancelledByUser(job *Job) bool {
+	return errors.Is(context.Cause(job.ctx), errCancelledByUser)
+}
+
+type statusKey struct {
+	chatID    int64
+	messageID int
+}
+
+// statusJobs maps status messages to their running job, so every edit of
+// the message keeps the job's buttons on it. An edit without a keyboard
+// removes it, which is what the final status wants.
+var statusJobs = struct {
+	mu        sync.Mutex
+	byMessage map[statusKey]*Job
+}{byMessage: map[statusKey]*Job{}}
+
+func trackStatus(job *Job) {
+	statusJobs.mu.Lock()
+	defer statusJobs.mu.Unlock()
+	statusJobs.byMessage[statusKey{job.ChatID, job.StatusMessageID}] = job
+}
+
+func untrackStatus(job *Job) {
+	statusJobs.mu.Lock()
+	defer statusJobs.mu.Unlock()
+	delete(statusJobs.byMessage, statusKey{job.ChatID, job.StatusMessageID})
+}
+
+// statusKeyboard is the keyboard for an edit of the message, or nil if it
+// isn't the status of a running job.
+func statusKeyboard(chatID int64, messageID int) *tgbotapi.InlineKeyboardMarkup {
+	statusJobs.mu.Lock()
+	job, ok := statusJobs.byMessage[statusKey{chatID, messageID}]
+	statusJobs.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	markup := job.buttons()
+	return &markup
+}
+
+// buttons are Cancel while the job runs, and Rename until the upload
+// starts for jobs that upload a file under its own name.
+func (j *Job) buttons() tgbotapi.InlineKeyboardMarkup {
+	id := strconv.FormatInt(j.ID, 10)
+	row := tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("🛑 Cancel", "job:"+id+":cancel"))
+	if j.renamable() {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData("✏️ Rename", "job:"+id+":rename"))
+	}
+	return tgbotapi.NewInlineKeyboardMarkup(row)
+}
+
+// offerRename lets the requester pick the name the file is uploaded
+// under, until the upload starts.
+func (j *Job) offerRename() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.renameOffered = true
+}
+
+func (j *Job) renamable() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.renameOffered && j.state != jobUploading
+}
+
+// setCustomName records the name the requester typed after tapping
+// Rename.
+func (j *Job) setCustomName(name string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.customName = name
+	j.awaitingName = false
+}
+
+// customFileName returns the name asked for with Rename, if any, and
+// closes the offer.
+func (j *Job) customFileName() string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	name := j.customName
+	j.customName = ""
+	j.renameOffered = false
+	return name
+}
+
+func (j *Job) isAwaitingName() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.awaitingName
+}
+
+// awaitingName returns the user's job in the chat that waits for a name
+// typed after Rename.
+func (r *jobRegistry) awaitingName(chatID, userID int64) (*Job, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, job := range r.jobs {
+		if job.ChatID == chatID && job.UserID == userID && job.isAwaitingName() {
+			return job, true
+		}
+	}
+	return nil, false
+}
+
+// failureButtons are the buttons under a failure message: Retry for
+// failures worth trying again, and the issue report if there's one.
+func failureButtons(job *Job, jerr *jobError, report bool) *tgbotapi.InlineKeyboardMarkup {
+	var row []tgbotapi.InlineKeyboardButton
+	// The passphrase isn't kept, so encrypted jobs can't be started again.
+	if jerr.result == resultFailed && !job.options.Encrypt {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData("🔁 Retry", "job:"+strconv.FormatInt(job.ID, 10)+":retry"))
+	}
+	if report {
+		row = append(row, reportButton(job.ID).InlineKeyboard[0]...)
+	}
+	if len(row) == 0 {
+		return nil
+	}
+	markup := tgbotapi.NewInlineKeyboardMarkup(row)
+	return &markup
+}
+
+func handleJobCallback(bot *tgbotapi.BotAPI, query *tgbotapi.CallbackQuery, args []string) string {
+	if len(args) != 2 || query.From == nil {
+		return ""
+	}
+	jobID, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return ""
+	}
+	if args[1] == "retry" {
+		return retryJob(bot, query, jobID)
+	}
+
+	job, ok := jobs.get(jobID)
+	if !ok {
+		return "This download has already finished."
+	}
+	owner := query.From.ID == job.UserID
+	if !owner && !isAdmin(query.From.ID) {
+		return "Only the person who requested the file can do that."
+	}
+	switch args[1] {
+	case "cancel":
+		if owner {
+			job.cancel(errCancelledByUser)
+		} else {
+			job.cancel(errCancelledByAdmin)
+		}
+		job.logger().Info("Job cancelled from its status message", "user_id", query.From.ID)
+		return "🛑 Cancelling…"
+	case "rename":
+		if !owner {
+			return "Only the person who requested the file can rename it."
+		}
+		if !job.renamable() {
+			return "The upload has already started."
+		}
+		job.mu.Lock()
+		job.awaitingName = true
+		name := job.FileName
+		job.mu.Unlock()
+		msg := tgbotapi.NewMessage(job.ChatID, fmt.Sprintf("✏️ Reply with the name to send %s under.", name))
+		msg.ReplyToMessageID = job.StatusMessageID
+		msg.ReplyMarkup = tgbotapi.ForceReply{ForceReply: true, Selective: true}
+		bot.Send(msg)
+	}
+	return ""
+}
+
+// retryMu makes checking a record's RequeuedAs and setting it one step,
+// so a double tap on Retry, or Retry during /requeue, doesn't start the
+// job twice.
+var retryMu sync.Mutex
+
+// retryJob starts a failed job again from its record, once.
+func retryJob(bot *tgbotapi.BotAPI, query *tgbotapi.CallbackQuery, jobID int64) string {
+	retryMu.Lock()
+	defer retryMu.Unlock()
+
+	var rec jobRecord
+	found, err := store.get(bucketJobs, jobKey(jobID), &rec)
+	if err != nil || !found {
+		return "This download is no longer in the history."
+	}
+	if query.From.ID != rec.UserID && !isAdmin(query.From.ID) {
+		return "Only the person who requested the file can retry it."
+	}
+	// The user may have been banned or taken off the allowlist since.
+	if isObserver(query.From.ID) || !(isAdmin(query.From.ID) || allowlist.allows(query.From.ID, rec.ChatID)) {
+		return "You're not allowed to download files here."
+	}
+	if rec.RequeuedAs != 0 {
+		return fmt.Sprintf("Already retried as #%d.", rec.RequeuedAs)
+	}
+	if rec.Result != resultFailed || rec.Options.Encrypt {
+		return "This download can't be retried."
+	}
+	if !userPassesGate(bot, query.From) {
+		return "Please send the link again first, so I can check you're human."
+	}
+	if slowDown, ok := checkRateLimit(query.From.ID); !ok {
+		return slowDown
+	}
+
+	job := jobs.requeue(rec)
+	err = updateRecord(store, bucketJobs, jobKey(rec.ID), func(r *jobRecord, _ bool) error {
+		r.RequeuedAs = job.ID
+		return nil
+	})
+	if err != nil {
+		job.logger().Error("Error marking job retried", "failed_job_id", rec.ID, "error", err)
+	}
+	job.logger().Info("Retrying failed job", "failed_job_id", rec.ID, "user_id", query.From.ID)
+	if query.Message != nil && query.Message.ReplyMarkup != nil {
+		// Keeps the issue report button, if there is one.
+		retry := "job:" + strconv.FormatInt(jobID, 10) + ":retry"
+		keyboard := [][]tgbotapi.InlineKeyboardButton{}
+		for _, row := range query.Message.ReplyMarkup.InlineKeyboard {
+			var rest []tgbotapi.InlineKeyboardButton
+			for _, button := range row {
+				if button.CallbackData == nil || *button.CallbackData != retry {
+					rest = append(rest, button)
+				}
+			}
+			if len(rest) > 0 {
+				keyboard = append(keyboard, rest)
+			}
+		}
+		bot.Request(tgbotapi.NewEditMessageReplyMarkup(query.Message.Chat.ID, query.Message.MessageID,
+			tgbotapi.InlineKeyboardMarkup{InlineKeyboard: keyboard}))
+	}
+	go handleURL(bot, job)
+	return fmt.Sprintf("🔁 Retrying as #%d", job.ID)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// byteRange is the part of the file asked for with --range, from Start to
+// End inclusive, as in an HTTP Range header. End is -1 for the rest of the
+// file.
+type byteRange struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+}
+
+func parseByteRange(value string) (*byteRange, error) {
+	start, end, ok := strings.Cut(strings.TrimSpace(value), "-")
+	if !ok {
+		return nil, fmt.Errorf("--range needs a byte range like 0-10485760")
+	}
+	r := &byteRange{End: -1}
+	var err error
+	if r.Start, err = strconv.ParseInt(start, 10, 64); err != nil || r.Start < 0 {
+		return nil, fmt.Errorf("%q isn't a byte range like 0-10485760", value)
+	}
+	if end != "" {
+		if r.End, err = strconv.ParseInt(end, 10, 64); err != nil || r.End < r.Start {
+			return nil, fmt.Errorf("%q isn't a byte range like 0-10485760", value)
+		}
+	}
+	return r, nil
+}
+
+// header is the Range header for the range, offset bytes into it.
+func (r *byteRange) header(offset int64) string {
+	if r.End < 0 {
+		return fmt.Sprintf("bytes=%d-", r.Start+offset)
+	}
+	return fmt.Sprintf("bytes=%d-%d", r.Start+offset, r.End)
+}
+
+// size is how many bytes of a file of fileSize the range covers, or -1 if
+// that isn't known.
+func (r *byteRange) size(fileSize int64) int64 {
+	end := r.End
+	if fileSize > 0 && (end < 0 || end >= fileSize) {
+		end = fileSize - 1
+	}
+	if end < 0 {
+		return -1
+	}
+	return end - r.Start + 1
+}
+
+// fileName is the name a part of the file is sent under.
+func (r *byteRange) fileName(name string, fileSize int64) string {
+	if n := r.size(fileSize); n > 0 {
+		return fmt.Sprintf("%s.%d-%d.part", name, r.Start, r.Start+n-1)
+	}
+	return fmt.Sprintf("%s.%d-.part", name, r.Start)
+}
+
+// checkRangeResponse makes sure the server sent the part asked for rather
+// than the whole file.
+func checkRangeResponse(resp *http.Response) error {
+	switch {
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		return &jobError{
+			userMessage: "❌ That range starts past the end of the file.",
+			result:      resultRejected,
+			err:         fmt.Errorf("range not satisfiable: %s", resp.Status),
+		}
+	case resp.StatusCode < 400 && resp.StatusCode != http.StatusPartialContent:
+		return &jobError{
+			userMessage: "❌ The server doesn't send parts of this file, so --range can't be used with it.",
+			result:      resultRejected,
+			err:         fmt.Errorf("range ignored: %s", resp.Status),
+		}
+	}
+	return nil
+}
+
+// contentRangeStart is where the part a 206 response carries starts, or -1
+// if its Content-Range doesn't say.
+func contentRangeStart(resp *http.Response) int64 {
+	value, ok := strings.CutPrefix(resp.Header.Get("Content-Range"), "bytes ")
+	if !ok {
+		return -1
+	}
+	start, _, _ := strings.Cut(value, "-")
+	n, err := strconv.ParseInt(start, 10, 64)
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+// rangeValidator is what If-Range can check a resumed download against:
+// a strong ETag, or else the Last-Modified date.
+func rangeValidator(h http.Header) string {
+	if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return h.Get("Last-Modified")
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// cacheEntry describes a downloaded file kept in cfg.CacheDir, or in the
+// S3 bucket under cfg.CacheS3Prefix, so repeat requests for the same URL,
+// from any chat, skip the download while the entry is fresh and the
+// origin's validators still match.
+type cacheEntry struct {
+	URL  string `json:"url"`
+	Path string `json:"path,omitempty"`
+	// ObjectKey is set instead of Path for copies kept in the bucket.
+	ObjectKey    string    `json:"object_key,omitempty"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	ContentType  string    `json:"content_type,omitempty"`
+	Size         int64     `json:"size"`
+	SHA256       string    `json:"sha256"`
+	StoredAt     time.Time `json:"stored_at"`
+}
+
+func cacheKey(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return hex.EncodeToString(sum[:])
+}
+
+func cacheEnabled() bool {
+	return cfg.CacheDir != "" || cfg.CacheS3Prefix != ""
+}
+
+func cacheTTL() time.Duration {
+	return time.Duration(cfg.CacheTTLMinutes) * time.Minute
+}
+
+// matches reports whether the origin still serves the cached version,
+// judging by the validators of a fresh HEAD response.
+func (e cacheEntry) matches(header http.Header) bool {
+	if etag := header.Get("ETag"); etag != "" && e.ETag != "" {
+		return etag == e.ETag
+	}
+	if modified := header.Get("Last-Modified"); modified != "" && e.LastModified != "" {
+		return modified == e.LastModified
+	}
+	return true
+}
+
+func dropCacheEntry(key string, entry cacheEntry) {
+	if entry.ObjectKey != "" {
+		if s3Enabled() {
+			if err := deleteS3Object(context.Background(), entry.ObjectKey); err != nil {
+				slog.Error("Error removing cached object", "object_key", entry.ObjectKey, "error", err)
+			}
+		}
+	} else if entry.Path != "" {
+		os.Remove(entry.Path)
+	}
+	if err := store.delete(bucketCache, key); err != nil {
+		slog.Error("Error removing cache entry", "key", key, "error", err)
+	}
+}
+
+// loadFromCache copies a cached copy of the job's URL into file, if there
+// is a usable one, and returns the headers it was originally served with.
+func loadFromCache(job *Job, file *os.File, headHeader http.Header) (http.Header, bool) {
+	if !cacheEnabled() || job.options.Range != nil {
+		return nil, false
+	}
+
+	key := cacheKey(job.URL)
+	var entry cacheEntry
+	found, err := store.get(bucketCache, key, &entry)
+	if err != nil {
+		slog.Error("Error reading cache entry", "key", key, "error", err)
+		return nil, false
+	}
+	if !found {
+		return nil, false
+	}
+	if time.Since(entry.StoredAt) > cacheTTL() || !entry.matches(headHeader) {
+		dropCacheEntry(key, entry)
+		return nil, false
+	}
+
+	cached, err := openCached(job, entry)
+	if err != nil {
+		job.logger().Warn("Cached copy is gone", "error", err)
+		dropCacheEntry(key, entry)
+		return nil, false
+	}
+	defer cached.Close()
+
+	if err := file.Truncate(0); err != nil {
+		return nil, false
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, false
+	}
+	if _, err := io.Copy(file, cached); err != nil {
+		job.logger().Error("Error copying from cache", "path", entry.Path, "object_key", entry.ObjectKey, "error", err)
+		return nil, false
+	}
+
+	job.Size = entry.Size
+	job.SHA256 = entry.SHA256
+	job.logger().Info("Served from cache", "bytes", entry.Size, "age", time.Since(entry.StoredAt).Round(time.Second))
+
+	header := http.Header{}
+	for name, value := range map[string]string{"Content-Type": entry.ContentType, "ETag": entry.ETag, "Last-Modified": entry.LastModified} {
+		if value != "" {
+			header.Set(name, value)
+		}
+	}
+	return header, true
+}
+
+// openCached opens the cached copy, wherever the entry says it is. Copies
+// in the bucket are streamed from it rather than kept on disk.
+func openCached(job *Job, entry cacheEntry) (io.ReadCloser, error) {
+	if entry.ObjectKey == "" {
+		return os.Open(entry.Path)
+	}
+	if !s3Enabled() {
+		return nil, fmt.Errorf("S3 storage is no longer configured")
+	}
+	return getS3Object(job.ctx, entry.ObjectKey)
+}
+
+// storeInCache keeps a copy of a finished download for later requests.
+func storeInCache(job *Job, file *os.File, header http.Header) {
+	if !cacheEnabled() || job.options.Range != nil {
+		return
+	}
+
+	key := cacheKey(job.URL)
+	entry := cacheEntry{
+		URL:          job.URL,
+		ETag:         header.Get("ETag"),
+		LastModified: header.Get("Last-Modified"),
+		ContentType:  header.Get("Content-Type"),
+		Size:         job.Size,
+		SHA256:       job.SHA256,
+		StoredAt:     time.Now(),
+	}
+	if cfg.CacheS3Prefix != "" {
+		entry.ObjectKey = cfg.CacheS3Prefix + key
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return
+		}
+		if err := putS3Object(job.ctx, entry.ObjectKey, file, job.Size); err != nil {
+			job.logger().Error("Error uploading cache object", "object_key", entry.ObjectKey, "error", err)
+			return
+		}
+	} else {
+		entry.Path = filepath.Join(cfg.CacheDir, key)
+		if err := copyFile(file, entry.Path); err != nil {
+			job.logger().Error("Error writing cache file", "path", entry.Path, "error", err)
+			os.Remove(entry.Path)
+			return
+		}
+	}
+
+	if err := store.put(bucketCache, key, entry); err != nil {
+		slog.Error("Error saving cache entry", "key", key, "error", err)
+	}
+}
+
+// copyFile writes the contents of src to dst through a temporary file, so
+// a concurrent reader never sees half a file.
+func copyFile(src *os.File, dst string) error {
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".partial-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, src); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dst)
+}
+
+// spilledPrefix marks a checkpoint's temp path as an object in the bucket
+// rather than a file.
+const spilledPrefix = "s3:"
+
+// spillPartial moves an interrupted download into the bucket when the
+// cache lives there, so the job can resume on a container that doesn't
+// have this one's disk. It returns where the partial download is now.
+func spillPartial(job *Job, file *os.File) string {
+	if cfg.CacheS3Prefix == "" {
+		return file.Name()
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return file.Name()
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return file.Name()
+	}
+	// The job's own context is already cancelled by the shutdown.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.ShutdownTimeoutSeconds)*time.Second)
+	defer cancel()
+	key := fmt.Sprintf("%spartial/%d", cfg.CacheS3Prefix, job.ID)
+	if err := putS3Object(ctx, key, file, info.Size()); err != nil {
+		job.logger().Error("Error spilling partial download, keeping it on disk", "object_key", key, "error", err)
+		return file.Name()
+	}
+	job.logger().Info("Spilled partial download to the bucket", "object_key", key, "bytes", info.Size())
+	return spilledPrefix + key
+}
+
+// restorePartial fetches a spilled partial download back into a new temp
+// file and removes it from the bucket. The caller checkpoints the file.
+func restorePartial(job *Job, dir, fileName string) (*os.File, int64, error) {
+	key := strings.TrimPrefix(job.partialPath, spilledPrefix)
+	file, err := os.CreateTemp(dir, "telegram-*-"+fileName)
+	if err != nil {
+		return nil, 0, err
+	}
+	if !s3Enabled() {
+		job.logger().Info("Partial download is in the bucket, but S3 is no longer configured, starting over", "object_key", key)
+		return file, 0, nil
+	}
+	body, err := getS3Object(job.ctx, key)
+	if err != nil {
+		job.logger().Info("Spilled partial download is gone, starting over", "object_key", key, "error", err)
+		return file, 0, nil
+	}
+	defer body.Close()
+	n, err := io.Copy(file, body)
+	if err != nil {
+		job.logger().Warn("Error restoring partial download, starting over", "object_key", key, "error", err)
+		if err := file.Truncate(0); err != nil {
+			file.Close()
+			os.Remove(file.Name())
+			return nil, 0, err
+		}
+		file.Seek(0, io.SeekStart)
+		return file, 0, nil
+	}
+	if err := deleteS3Object(job.ctx, key); err != nil {
+		job.logger().Warn("Error removing spilled partial download", "object_key", key, "error", err)
+	}
+	return file, n, nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"log/slog"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// callbackHandlers maps the prefix of an inline button's callback data
+// (everything before the first ':') to the code handling it. The handler
+// gets the remaining, colon-separated fields and returns the text to show
+// in the callback answer, if any.
+var callbackHandlers = map[string]func(bot *tgbotapi.BotAPI, query *tgbotapi.CallbackQuery, args []string) string{
+	"bulk":     handleBulkCallback,
+	"history":  handleHistoryCallback,
+	"hold":     handleHoldCallback,
+	"job":      handleJobCallback,
+	"member":   handleMemberCallback,
+	"queue":    handleQueueCallback,
+	"rename":   handleRenameCallback,
+	"report":   handleReportCallback,
+	"settings": handleSettingsCallback,
+	"setup":    handleSetupCallback,
+}
+
+func handleCallbackQuery(bot *tgbotapi.BotAPI, query *tgbotapi.CallbackQuery) {
+	parts := strings.Split(query.Data, ":")
+
+	answer := ""
+	if handler, ok := callbackHandlers[parts[0]]; ok {
+		answer = handler(bot, query, parts[1:])
+	} else {
+		slog.Warn("Unknown callback data", "data", query.Data)
+	}
+
+	if _, err := bot.Request(tgbotapi.NewCallback(query.ID, answer)); err != nil {
+		slog.Error("Error answering callback query", "error", err)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"log/slog"
+	"mime"
+	"net/http"
+	"regexp"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// reprDigestSHA256 finds the SHA-256 in a Repr-Digest (RFC 9530) or the
+// older Digest header, as in "sha-256=:base64:" or "SHA-256=base64".
+var reprDigestSHA256 = regexp.MustCompile(`(?i)sha-256=:?([A-Za-z0-9+/=]+):?`)
+
+// knownSHA256 returns the file's SHA-256 without downloading it, if the
+// cache has a copy the origin still serves, or the server announced it.
+func knownSHA256(url string, header http.Header) string {
+	var entry cacheEntry
+	found, err := store.get(bucketCache, cacheKey(url), &entry)
+	if err != nil {
+		slog.Error("Error reading cache entry", "url", redactURL(url), "error", err)
+	}
+	if found && entry.SHA256 != "" && entry.matches(header) {
+		return entry.SHA256
+	}
+
+	for _, value := range []string{header.Get("Repr-Digest"), header.Get("Digest"), header.Get("X-Amz-Checksum-Sha256")} {
+		if value == "" {
+			continue
+		}
+		if m := reprDigestSHA256.FindStringSubmatch(value); m != nil {
+			value = m[1]
+		}
+		if sum, err := base64.StdEncoding.DecodeString(value); err == nil && len(sum) == 32 {
+			return hex.EncodeToString(sum)
+		}
+	}
+	return ""
+}
+
+// sendCard posts what is known about the file instead of the file, for
+// --card. Nothing is downloaded, so the size limit and quota don't apply.
+func sendCard(bot *tgbotapi.BotAPI, job *Job, size int64, header http.Header) error {
+	contentType := header.Get("Content-Type")
+	category := classifyFile(job.FileName, contentType)
+	job.SHA256 = knownSHA256(job.URL, header)
+
+	lines := []string{"📇 " + job.FileName, "Size: " + formatJobSize(size)}
+	kind := string(category)
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		kind += " (" + mediaType + ")"
+	}
+	lines = append(lines, "Type: "+kind)
+	if modified := header.Get("Last-Modified"); modified != "" {
+		lines = append(lines, "Last modified: "+modified)
+	}
+	if job.SHA256 != "" {
+		lines = append(lines, "SHA-256: "+job.SHA256)
+	}
+	lines = append(lines, "Host: "+urlHost(job.URL))
+	if tags := buildHashtags(category, job.URL); tags != "" {
+		lines = append(lines, "", tags)
+	}
+
+	msg := tgbotapi.NewMessage(job.deliveryChatID(), strings.Join(lines, "\n"))
+	msg.ReplyToMessageID = job.deliveryReplyID()
+	msg.DisableWebPagePreview = true
+	msg.DisableNotification = job.silent
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonURL("🔗 Source", job.URL),
+	))
+	if _, err := bot.Send(msg); err != nil {
+		return failJob(describeSendError(err, "❌ Failed to post the card"), err)
+	}
+	job.logger().Info("Posted link card", "size", size, "hash_known", job.SHA256 != "")
+	return nil
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Activity is only written to the database once per interval per chat so
+// busy groups don't turn every message into a disk write.
+const chatActivityWriteInterval = time.Hour
+
+const inactivityWarning = "👋 Nobody has used me here for a while. I'll leave this chat in %d days unless someone sends a message or I'm asked to post a file here."
+
+type chatRecord struct {
+	ID           int64     `json:"id"`
+	Title        string    `json:"title"`
+	Type         string    `json:"type"`
+	LastActivity time.Time `json:"last_activity"`
+	WarnedAt     time.Time `json:"warned_at,omitempty"`
+	// MaxFileSizeMB overrides the global size limit for this chat if set.
+	MaxFileSizeMB int64 `json:"max_file_size_mb,omitempty"`
+	// Digest opts the chat in to the weekly digest of its own jobs.
+	Digest bool `json:"digest,omitempty"`
+	// AutoDownload has direct file links posted in the chat downloaded
+	// without /url.
+	AutoDownload bool `json:"auto_download,omitempty"`
+	// Disabled is set while group admins have turned downloads off with
+	// /disable.
+	Disabled bool `json:"disabled,omitempty"`
+	// The rest are the chat's own settings, changed with /settings.
+	// SizeCapMB lowers the size limit for the chat; AllowedTypes, if set,
+	// are the only kinds of file it takes; Silent sends the files without
+	// a notification; Language is asked of servers for their pages and
+	// files.
+	SizeCapMB    int64          `json:"size_cap_mb,omitempty"`
+	AllowedTypes []fileCategory `json:"allowed_types,omitempty"`
+	Silent       bool           `json:"silent,omitempty"`
+	Language     string         `json:"language,omitempty"`
+}
+
+var (
+	chatTouchMu sync.Mutex
+	chatTouched = map[int64]time.Time{}
+)
+
+func chatKey(id int64) string {
+	return strconv.FormatInt(id, 10)
+}
+
+func touchChat(chat *tgbotapi.Chat) {
+	noteChatActivity(chat.ID, chat)
+}
+
+// noteDelivery counts a file the bot posted in a chat as activity there,
+// so channels it only posts to aren't left as inactive.
+func noteDelivery(chatID int64) {
+	if chatID > 0 {
+		// Private chats are never left.
+		return
+	}
+	noteChatActivity(chatID, nil)
+}
+
+func noteChatActivity(chatID int64, chat *tgbotapi.Chat) {
+	now := time.Now()
+
+	chatTouchMu.Lock()
+	if now.Sub(chatTouched[chatID]) < chatActivityWriteInterval {
+		chatTouchMu.Unlock()
+		return
+	}
+	chatTouched[chatID] = now
+	chatTouchMu.Unlock()
+
+	err := updateRecord(store, bucketChats, chatKey(chatID), func(r *chatRecord, _ bool) error {
+		r.ID = chatID
+		if chat != nil {
+			r.Title = chat.Title
+			r.Type = chat.Type
+		}
+		r.LastActivity = now
+		r.WarnedAt = time.Time{}
+		return nil
+	})
+	if err != nil {
+		slog.Error("Error recording chat activity", "chat_id", chatID, "error", err)
+	}
+}
+
+// forgetChat clears a chat's activity once the bot has left it. The rest
+// of the record is the chat's settings, which are kept for when the bot is
+// added back.
+func forgetChat(chatID int64) {
+	chatTouchMu.Lock()
+	delete(chatTouched, chatID)
+	chatTouchMu.Unlock()
+
+	err := updateRecord(store, bucketChats, chatKey(chatID), func(r *chatRecord, _ bool) error {
+		r.ID = chatID
+		r.LastActivity = time.Time{}
+		r.WarnedAt = time.Time{}
+		return nil
+	})
+	if err != nil {
+		slog.Error("Error removing chat from registry", "chat_id", chatID, "error", err)
+	}
+}
+
+func knownChats() ([]chatRecord, error) {
+	var chats []chatRecord
+	err := store.forEach(bucketChats, func(_, value []byte) error {
+		var r chatRecord
+		if err := json.Unmarshal(value, &r); err != nil {
+			return err
+		}
+		chats = append(chats, r)
+		return nil
+	})
+	return chats, err
+}
+
+func runInactiveChatJanitor(bot *tgbotapi.BotAPI) {
+	if cfg.LeaveInactiveAfterMonths <= 0 {
+		return
+	}
+
+	for {
+		if err := sweepInactiveChats(bot, time.Now()); err != nil {
+			slog.Error("Error sweeping inactive chats", "error", err)
+		}
+		time.Sleep(12 * time.Hour)
+	}
+}
+
+func sweepInactiveChats(bot *tgbotapi.BotAPI, now time.Time) error {
+	chats, err := knownChats()
+	if err != nil {
+		return err
+	}
+
+	subscribed, err := subscribedChats()
+	if err != nil {
+		return err
+	}
+
+	grace := time.Duration(cfg.InactiveWarningDays) * 24 * time.Hour
+	cutoff := now.AddDate(0, -cfg.LeaveInactiveAfterMonths, 0)
+
+	for _, chat := range chats {
+		// A zero LastActivity is a chat the bot has already left.
+		if chat.Type == "private" || chat.ID > 0 || chat.LastActivity.IsZero() || chat.LastActivity.After(cutoff) || subscribed[chat.ID] {
+			continue
+		}
+
+		if chat.WarnedAt.IsZero() {
+			sendMessage(bot, chat.ID, fmt.Sprintf(inactivityWarning, cfg.InactiveWarningDays))
+			err := updateRecord(store, bucketChats, chatKey(chat.ID), func(r *chatRecord, _ bool) error {
+				r.WarnedAt = now
+				return nil
+			})
+			if err != nil {
+				slog.Error("Error marking chat as warned", "chat_id", chat.ID, "error", err)
+			}
+			continue
+		}
+
+		if now.Sub(chat.WarnedAt) < grace {
+			continue
+		}
+
+		slog.Info("Leaving inactive chat", "chat_id", chat.ID, "title", chat.Title, "last_active", chat.LastActivity.Format(time.DateOnly))
+		_, err := bot.Request(tgbotapi.LeaveChatConfig{ChatID: chat.ID})
+		var apiErr *tgbotapi.Error
+		if err != nil && !errors.As(err, &apiErr) {
+			// Network trouble; try again on the next sweep.
+			slog.Error("Error leaving chat", "chat_id", chat.ID, "error", err)
+			continue
+		}
+		forgetChat(chat.ID)
+	}
+	return nil
+}
+
+// subscribedChats are the chats /cron, /watch and /schedule still post
+// to, which the janitor doesn't leave however quiet they are.
+func subscribedChats() (map[int64]bool, error) {
+	chats := map[int64]bool{}
+	add := func(chatID int64, opts jobOptions) {
+		chats[chatID] = true
+		if opts.TargetChatID != 0 {
+			chats[opts.TargetChatID] = true
+		}
+	}
+
+	crons, err := loadCronJobs()
+	if err != nil {
+		return nil, err
+	}
+	for _, cj := range crons {
+		add(cj.ChatID, cj.Options)
+	}
+	feeds, err := loadFeeds()
+	if err != nil {
+		return nil, err
+	}
+	for _, sub := range feeds {
+		add(sub.ChatID, sub.Options)
+	}
+	scheduled, err := loadScheduledJobs()
+	if err != nil {
+		return nil, err
+	}
+	for _, sj := range scheduled {
+		add(sj.ChatID, sj.Options)
+	}
+	return chats, nil
+}
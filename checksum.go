@@ -0,0 +1,126 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Telegram only lets bots download files up to 20 MB through getFile; a
+// local Bot API server has no such limit.
+const maxBotDownloadSize = 20 * 1024 * 1024
+
+// handleVerifyCommand handles /verify <sha256> sent as a reply to a
+// document: the document is downloaded back from Telegram and its hash
+// compared, so recipients can check what actually arrived. Without a digest
+// the computed hash is just shown.
+func handleVerifyCommand(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
+	reply := message.ReplyToMessage
+	if reply == nil || reply.Document == nil {
+		sendErrorMessage(bot, message.Chat.ID, "❌ Reply to a file with /verify <sha256> to check it.")
+		return
+	}
+
+	want := message.CommandArguments()
+	if want != "" {
+		var ok bool
+		if want, ok = normalizeHash(want); !ok {
+			sendErrorMessage(bot, message.Chat.ID, "❌ That doesn't look like a SHA-256 hash (64 hex characters).")
+			return
+		}
+	}
+
+	if cfg.TelegramAPIURL == "" && reply.Document.FileSize > maxBotDownloadSize {
+		sendErrorMessage(bot, message.Chat.ID, "❌ Telegram doesn't let bots download files larger than 20 MB, so I can't check this one.")
+		return
+	}
+
+	got, err := hashTelegramFile(bot, reply.Document.FileID)
+	if err != nil {
+		slog.Error("Error hashing Telegram file", "file_id", reply.Document.FileID, "error", err)
+		sendErrorMessage(bot, message.Chat.ID, "❌ Failed to download the file from Telegram")
+		return
+	}
+
+	msg := tgbotapi.NewMessage(message.Chat.ID, "")
+	msg.ReplyToMessageID = reply.MessageID
+	switch {
+	case want == "":
+		msg.Text = fmt.Sprintf("🔐 SHA-256: %s", got)
+	case want == got:
+		msg.Text = fmt.Sprintf("✅ Checksum matches.\n\nSHA-256: %s", got)
+	default:
+		msg.Text = fmt.Sprintf("❌ Checksum does NOT match!\n\nExpected: %s\nActual: %s", want, got)
+	}
+	if _, err := bot.Send(msg); err != nil {
+		slog.Error("Error sending message", "chat_id", message.Chat.ID, "error", err)
+	}
+}
+
+func hashTelegramFile(bot *tgbotapi.BotAPI, fileID string) (string, error) {
+	file, err := bot.GetFile(tgbotapi.FileConfig{FileID: fileID})
+	if err != nil {
+		return "", withoutTokenURL(err)
+	}
+
+	var body io.Reader
+	if filepath.IsAbs(file.FilePath) {
+		// A local Bot API server in --local mode hands out paths on its
+		// own disk, which the bot shares.
+		f, err := os.Open(file.FilePath)
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		body = f
+	} else {
+		req, err := http.NewRequest(http.MethodGet, telegramFileURL(bot.Token, file.FilePath), nil)
+		if err != nil {
+			return "", withoutTokenURL(err)
+		}
+		resp, err := bot.Client.Do(req)
+		if err != nil {
+			return "", withoutTokenURL(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("telegram file download: %s", resp.Status)
+		}
+		body = resp.Body
+	}
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, body); err != nil {
+		return "", withoutTokenURL(err)
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// telegramFileURL is where a file Telegram stored at path is downloaded
+// from, on the configured Bot API server.
+func telegramFileURL(token, path string) string {
+	if cfg.TelegramAPIURL == "" {
+		return fmt.Sprintf(tgbotapi.FileEndpoint, token, path)
+	}
+	return strings.TrimSuffix(cfg.TelegramAPIURL, "/") + "/file/bot" + token + "/" + path
+}
+
+// withoutTokenURL drops the URL from a request error, since Bot API URLs
+// carry the bot token.
+func withoutTokenURL(err error) error {
+	var uerr *neturl.Error
+	if errors.As(err, &uerr) {
+		return fmt.Errorf("%s Telegram: %w", uerr.Op, uerr.Err)
+	}
+	return err
+}
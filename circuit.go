@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+)
+
+type hostCircuit struct {
+	failures  int
+	openUntil time.Time
+}
+
+// circuitBreaker keeps track of hosts that keep failing. After
+// cfg.CircuitFailures failures in a row a host's circuit opens and new jobs
+// for it fail fast for cfg.CircuitCooldownSeconds. Once that is over jobs
+// go through again, but the next failure opens the circuit right away; a
+// success closes it.
+type circuitBreaker struct {
+	mu    sync.Mutex
+	hosts map[string]*hostCircuit
+}
+
+var circuits = &circuitBreaker{hosts: map[string]*hostCircuit{}}
+
+func circuitCooldown() time.Duration {
+	return time.Duration(cfg.CircuitCooldownSeconds) * time.Second
+}
+
+// openFor returns how much longer host's circuit stays open, if it is.
+func (b *circuitBreaker) openFor(host string) (time.Duration, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c, ok := b.hosts[host]
+	if !ok {
+		return 0, false
+	}
+	wait := time.Until(c.openUntil)
+	return wait, wait > 0
+}
+
+func (b *circuitBreaker) record(host string, failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !failed {
+		delete(b.hosts, host)
+		return
+	}
+
+	c, ok := b.hosts[host]
+	if !ok {
+		c = &hostCircuit{}
+		b.hosts[host] = c
+	}
+	c.failures++
+	if c.failures >= cfg.CircuitFailures && time.Now().After(c.openUntil) {
+		c.openUntil = time.Now().Add(circuitCooldown())
+		slog.Warn("Circuit opened", "host", host, "failures", c.failures, "cooldown", circuitCooldown())
+	}
+}
+
+// isHostFailure reports whether err says more about the host than about
+// the job: it is unreachable, unresolvable or failing transiently.
+func isHostFailure(err error) bool {
+	var dnsErr *net.DNSError
+	return isTransient(err) || errors.Is(err, syscall.ECONNREFUSED) || errors.As(err, &dnsErr)
+}
+
+// recordHostResult feeds a finished job into the circuit breaker.
+func recordHostResult(job *Job, err error) {
+	if cfg.CircuitFailures <= 0 {
+		return
+	}
+	// A /zip job records each of its links separately.
+	host := urlHost(job.URL)
+	if host == "" || len(job.options.ZipURLs) > 0 || (err != nil && !isHostFailure(err)) {
+		return
+	}
+	circuits.record(host, err != nil)
+}
+
+// checkCircuit returns a message explaining why a job for url won't be
+// tried if its host's circuit is open.
+func checkCircuit(url string) (string, bool) {
+	if cfg.CircuitFailures <= 0 {
+		return "", true
+	}
+	host := urlHost(url)
+	wait, open := circuits.openFor(host)
+	if !open {
+		return "", true
+	}
+	return fmt.Sprintf("⚡ %s has been failing repeatedly, so I'm not trying it again for %s. Please try later.",
+		host, wait.Round(time.Second)), false
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	clamdChunkSize = 64 << 10
+	clamdTimeout   = 5 * time.Minute
+)
+
+// infectedError is returned by scanFile for a file clamd found something
+// in.
+type infectedError struct {
+	threat string
+}
+
+func (e *infectedError) Error() string {
+	return "file is infected: " + e.threat
+}
+
+// clamdNetwork splits cfg.ClamdAddress, which is either
+// unix:///path/to/clamd.sock or host:port (optionally as tcp://host:port).
+func clamdNetwork(address string) (string, string) {
+	if path, ok := strings.CutPrefix(address, "unix://"); ok {
+		return "unix", path
+	}
+	return "tcp", strings.TrimPrefix(address, "tcp://")
+}
+
+// scanFile sends the file to clamd with the INSTREAM command. It returns
+// an infectedError naming what was found, or another error if the scan
+// itself failed.
+func scanFile(ctx context.Context, file *os.File) error {
+	network, address := clamdNetwork(cfg.ClamdAddress)
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, address)
+	if err != nil {
+		return fmt.Errorf("connecting to clamd: %w", err)
+	}
+	defer conn.Close()
+	deadline := time.Now().Add(clamdTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	w := bufio.NewWriterSize(conn, clamdChunkSize+4)
+	w.WriteString("zINSTREAM\x00")
+	chunk := make([]byte, clamdChunkSize)
+	for {
+		n, err := file.Read(chunk)
+		if n > 0 {
+			binary.Write(w, binary.BigEndian, uint32(n))
+			if _, err := w.Write(chunk[:n]); err != nil {
+				return fmt.Errorf("sending to clamd: %w", err)
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	binary.Write(w, binary.BigEndian, uint32(0))
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("sending to clamd: %w", err)
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("reading clamd reply: %w", err)
+	}
+	reply = strings.TrimSpace(strings.TrimRight(reply, "\x00"))
+	result := strings.TrimPrefix(reply, "stream: ")
+	switch {
+	case result == "OK":
+		return nil
+	case strings.HasSuffix(result, " FOUND"):
+		return &infectedError{threat: strings.TrimSuffix(result, " FOUND")}
+	default:
+		return fmt.Errorf("clamd: %s", reply)
+	}
+}
+
+// scanJobFile runs the virus scan for a job, if configured. An infected
+// file is reported to the requester and to the log channel; when clamd
+// can't be reached the file isn't sent either.
+func scanJobFile(bot *tgbotapi.BotAPI, job *Job, file *os.File) error {
+	if cfg.ClamdAddress == "" {
+		return nil
+	}
+	updateMessage(bot, job.ChatID, job.StatusMessageID, "🔍 Scanning for viruses...")
+	scanned := job.timeStage("scan")
+	err := scanFile(job.ctx, file)
+	if err == nil {
+		scanned()
+		return nil
+	}
+
+	var infected *infectedError
+	if errors.As(err, &infected) {
+		threat := infected.threat
+		job.logger().Warn("Blocked infected file", "threat", threat, "sha256", job.SHA256)
+		if cfg.LogChannelID != 0 {
+			sendMessage(bot, cfg.LogChannelID, fmt.Sprintf("🦠 Blocked %s for user %d in chat %d: %s\nURL: %s\nSHA-256: %s",
+				job.FileName, job.UserID, job.ChatID, threat, redactURL(job.URL), job.SHA256))
+		}
+		return &jobError{
+			userMessage: fmt.Sprintf("🦠 The virus scanner flagged this file (%s), so it was not sent.", threat),
+			result:      resultBlocked,
+			err:         err,
+		}
+	}
+	return failJob("❌ Couldn't scan the file for viruses, so it was not sent. Please try again later.", err)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"mime"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+type fileCategory string
+
+const (
+	categoryVideo   fileCategory = "video"
+	categoryAudio   fileCategory = "audio"
+	categoryImage   fileCategory = "image"
+	categoryArchive fileCategory = "archive"
+	categoryDoc     fileCategory = "doc"
+	categoryOther   fileCategory = "file"
+)
+
+var categoryByExtension = map[string]fileCategory{
+	".mp4": categoryVideo, ".mkv": categoryVideo, ".webm": categoryVideo, ".mov": categoryVideo,
+	".avi": categoryVideo, ".m4v": categoryVideo, ".flv": categoryVideo, ".wmv": categoryVideo,
+	".mp3": categoryAudio, ".m4a": categoryAudio, ".flac": categoryAudio, ".wav": categoryAudio,
+	".ogg": categoryAudio, ".opus": categoryAudio, ".aac": categoryAudio,
+	".jpg": categoryImage, ".jpeg": categoryImage, ".png": categoryImage, ".gif": categoryImage,
+	".webp": categoryImage, ".bmp": categoryImage, ".svg": categoryImage, ".heic": categoryImage,
+	".zip": categoryArchive, ".rar": categoryArchive, ".7z": categoryArchive, ".tar": categoryArchive,
+	".gz": categoryArchive, ".tgz": categoryArchive, ".bz2": categoryArchive, ".xz": categoryArchive,
+	".zst": categoryArchive,
+	".pdf": categoryDoc, ".doc": categoryDoc, ".docx": categoryDoc, ".xls": categoryDoc,
+	".xlsx": categoryDoc, ".ppt": categoryDoc, ".pptx": categoryDoc, ".odt": categoryDoc,
+	".txt": categoryDoc, ".csv": categoryDoc, ".md": categoryDoc, ".epub": categoryDoc,
+}
+
+func classifyFile(fileName, contentType string) fileCategory {
+	if category, ok := categoryByExtension[strings.ToLower(filepath.Ext(fileName))]; ok {
+		return category
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return categoryOther
+	}
+
+	switch {
+	case strings.HasPrefix(mediaType, "video/"):
+		return categoryVideo
+	case strings.HasPrefix(mediaType, "audio/"):
+		return categoryAudio
+	case strings.HasPrefix(mediaType, "image/"):
+		return categoryImage
+	case strings.HasPrefix(mediaType, "text/"), mediaType == "application/pdf":
+		return categoryDoc
+	case strings.Contains(mediaType, "zip"), strings.Contains(mediaType, "compressed"),
+		strings.Contains(mediaType, "x-tar"), strings.Contains(mediaType, "x-7z"):
+		return categoryArchive
+	}
+	return categoryOther
+}
+
+// hashtagFor turns arbitrary text into something Telegram recognises as a
+// hashtag: only letters, digits and underscores are allowed.
+func hashtagFor(text string) string {
+	var b strings.Builder
+	for _, r := range text {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(unicode.ToLower(r))
+		} else if b.Len() > 0 && !strings.HasSuffix(b.String(), "_") {
+			b.WriteByte('_')
+		}
+	}
+	tag := strings.Trim(b.String(), "_")
+	if tag == "" {
+		return ""
+	}
+	return "#" + tag
+}
+
+func buildHashtags(category fileCategory, rawURL string) string {
+	if !cfg.EnableHashtags {
+		return ""
+	}
+
+	tags := []string{hashtagFor(string(category))}
+
+	if u, err := url.Parse(rawURL); err == nil {
+		if tag := hashtagFor(strings.TrimPrefix(u.Hostname(), "www.")); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+
+	for _, extra := range cfg.ExtraHashtags {
+		if tag := hashtagFor(extra); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+
+	return strings.Join(tags, " ")
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	// compressDownloadFactor is how much larger than the upload limit a
+	// download may be with --compress, betting on it shrinking enough.
+	compressDownloadFactor = 4
+	// minCompressSaving is the share a file has to shrink by to be sent
+	// compressed; less isn't worth making the recipient unpack it.
+	minCompressSaving = 0.1
+)
+
+var compressibleExtensions = map[string]bool{
+	".log": true, ".txt": true, ".csv": true, ".tsv": true, ".json": true, ".jsonl": true,
+	".ndjson": true, ".xml": true, ".html": true, ".htm": true, ".sql": true, ".md": true,
+	".yaml": true, ".yml": true, ".js": true, ".css": true, ".svg": true,
+}
+
+// isCompressible guesses from the name and type whether a file is text
+// that gzip shrinks a lot. Media and archives already are compressed.
+func isCompressible(fileName, contentType string) bool {
+	if compressibleExtensions[strings.ToLower(filepath.Ext(fileName))] {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(mediaType, "text/") || strings.HasSuffix(mediaType, "json") ||
+		strings.HasSuffix(mediaType, "xml") || mediaType == "application/javascript"
+}
+
+// shrinks reports whether the file sent is made from the download in a
+// way that usually makes it smaller: with --compress or /audio only that
+// file has to fit the upload limit.
+func (j *Job) shrinks() bool {
+	return (j.options.Compress || j.options.AudioFormat != "") && len(j.options.ZipURLs) == 0
+}
+
+// downloadLimitMB and downloadLimit are how large the download itself may
+// get.
+func (j *Job) downloadLimitMB() int64 {
+	if j.options.Destination != "" {
+		return cfg.RcloneMaxFileSizeMB
+	}
+	if j.shrinks() {
+		return max(j.limitMB*compressDownloadFactor, j.overflowLimitMB())
+	}
+	return max(j.limitMB, j.overflowLimitMB())
+}
+
+func (j *Job) downloadLimit() int64 {
+	if j.shrinks() || j.overflowLimitMB() > 0 || j.options.Destination != "" {
+		return j.downloadLimitMB() * 1024 * 1024
+	}
+	return j.uploadLimit()
+}
+
+// compressJobFile gzips the downloaded file if it looks compressible and
+// shrinks enough. It returns nil, without an error, when the original
+// should be sent instead; that one may still be over the upload limit.
+func compressJobFile(bot *tgbotapi.BotAPI, job *Job, file *os.File, contentType string) (*os.File, error) {
+	limit := job.uploadLimit()
+	if !isCompressible(job.FileName, contentType) {
+		if job.Size > limit {
+			return nil, tooLargeError(job.Size, job.limitMB)
+		}
+		job.logger().Info("Not compressing, file doesn't look compressible", "content_type", contentType)
+		return nil, nil
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, failJob("❌ Failed to compress the file", err)
+	}
+	compressed, err := os.CreateTemp(cfg.TempDir, "telegram-*.gz")
+	if err != nil {
+		return nil, failJob("❌ Failed to compress the file", err)
+	}
+	discard := func() {
+		compressed.Close()
+		os.Remove(compressed.Name())
+	}
+
+	done := job.timeStage("compress")
+	gz, _ := gzip.NewWriterLevel(compressed, gzip.BestCompression)
+	gz.Name = job.FileName
+	src := &ProgressReader{
+		Reader:     file,
+		total:      job.Size,
+		onProgress: progressUpdater(bot, job, "process", "🗜 Compressing..."),
+	}
+	if _, err := io.CopyBuffer(gz, src, make([]byte, copyBufferSize())); err != nil {
+		discard()
+		return nil, failJob("❌ Failed to compress the file", err)
+	}
+	if err := gz.Close(); err != nil {
+		discard()
+		return nil, failJob("❌ Failed to compress the file", err)
+	}
+	size, err := compressed.Seek(0, io.SeekCurrent)
+	if err != nil {
+		discard()
+		return nil, failJob("❌ Failed to compress the file", err)
+	}
+	done()
+
+	job.logger().Info("Compressed file", "bytes", job.Size, "compressed", size)
+	if float64(size) > float64(job.Size)*(1-minCompressSaving) && job.Size <= limit {
+		discard()
+		return nil, nil
+	}
+	if size > limit {
+		discard()
+		return nil, &jobError{
+			userMessage: fmt.Sprintf("❌ Even compressed the file is too large (%.1f MB). The limit here is %d MB.",
+				float64(size)/1024/1024, job.limitMB),
+			result: resultRejected,
+		}
+	}
+	if _, err := compressed.Seek(0, io.SeekStart); err != nil {
+		discard()
+		return nil, failJob("❌ Failed to compress the file", err)
+	}
+	return compressed, nil
+}
@@ -0,0 +1,656 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+type Config struct {
+	TelegramToken     string   `yaml:"telegram_token"`
+	MaxFileSizeMB     int64    `yaml:"max_file_size_mb"`
+	MaxConcurrentJobs int      `yaml:"max_concurrent_jobs"`
+	TempDir           string   `yaml:"temp_dir"`
+	DownloadProxy     string   `yaml:"download_proxy"`
+	TelegramProxy     string   `yaml:"telegram_proxy"`
+	TelegramAPIURL    string   `yaml:"telegram_api_url"`
+	Profile           string   `yaml:"profile"`
+	PrefetchDNS       bool     `yaml:"prefetch_dns"`
+	PrewarmTLS        bool     `yaml:"prewarm_tls"`
+	VerifyContent     bool     `yaml:"verify_content"`
+	StreamUploads     bool     `yaml:"stream_uploads"`
+	AllowPrivate      bool     `yaml:"allow_private"`
+	AllowedSchemes    []string `yaml:"allowed_schemes"`
+	AllowURLCreds     bool     `yaml:"allow_url_credentials"`
+	AllowedDomains    []string `yaml:"allowed_domains"`
+	BlockedDomains    []string `yaml:"blocked_domains"`
+	Debug             bool     `yaml:"debug"`
+	LogLevel          string   `yaml:"log_level"`
+	LogFormat         string   `yaml:"log_format"`
+	EnableHashtags    bool     `yaml:"enable_hashtags"`
+	ExtraHashtags     []string `yaml:"extra_hashtags"`
+	AdminIDs          []int64  `yaml:"admin_ids"`
+	ObserverIDs       []int64  `yaml:"observer_ids"`
+	AllowedUserIDs    []int64  `yaml:"allowed_user_ids"`
+	AllowedChatIDs    []int64  `yaml:"allowed_chat_ids"`
+	DBPath            string   `yaml:"db_path"`
+	LogChannelID      int64    `yaml:"log_channel_id"`
+	WeeklyDigest      bool     `yaml:"weekly_digest"`
+	ApproveNewUsers   bool     `yaml:"approve_new_users"`
+	HealthAddr        string   `yaml:"health_addr"`
+	CacheDir          string   `yaml:"cache_dir"`
+	CacheS3Prefix     string   `yaml:"cache_s3_prefix"`
+	SigningKey        string   `yaml:"signing_key"`
+	ClamdAddress      string   `yaml:"clamd_address"`
+	FFmpegPath        string   `yaml:"ffmpeg_path"`
+	ChromePath        string   `yaml:"chrome_path"`
+	GalleryDLPath     string   `yaml:"gallery_dl_path"`
+	RclonePath        string   `yaml:"rclone_path"`
+	RcloneRemotes     []string `yaml:"rclone_remotes"`
+	MemoryDir         string   `yaml:"memory_dir"`
+	S3Endpoint        string   `yaml:"s3_endpoint"`
+	S3Bucket          string   `yaml:"s3_bucket"`
+	S3Region          string   `yaml:"s3_region"`
+	S3AccessKeyID     string   `yaml:"s3_access_key_id"`
+	S3SecretAccessKey string   `yaml:"s3_secret_access_key"`
+
+	// Only settable in the config file.
+	PresignCredentials []presignCredential `yaml:"presign_credentials"`
+	// HoldURLPatterns are regular expressions for URLs whose files need
+	// an admin's approval before they are sent.
+	HoldURLPatterns []string `yaml:"hold_url_patterns"`
+	holdURLs        []*regexp.Regexp
+	// Hooks run on every file before it is sent, in order.
+	Hooks []hookConfig `yaml:"hooks"`
+	// LabelRoutes copies files of jobs with a label to another chat.
+	LabelRoutes map[string]int64 `yaml:"label_routes"`
+	// ChatWeights gives chats a larger share of the job slots when
+	// several chats have jobs waiting.
+	ChatWeights map[int64]int `yaml:"chat_weights"`
+
+	DuplicateWindowSeconds int   `yaml:"duplicate_window_seconds"`
+	DailyQuotaMB           int64 `yaml:"daily_quota_mb"`
+	UserJobsPerMinute      int   `yaml:"user_jobs_per_minute"`
+	UserMaxConcurrentJobs  int   `yaml:"user_max_concurrent_jobs"`
+	DownloadRetries        int   `yaml:"download_retries"`
+	ShutdownTimeoutSeconds int   `yaml:"shutdown_timeout_seconds"`
+	CacheTTLMinutes        int   `yaml:"cache_ttl_minutes"`
+	MaxRedirects           int   `yaml:"max_redirects"`
+	RetryBudget            int   `yaml:"retry_budget"`
+	StallTimeoutSeconds    int   `yaml:"stall_timeout_seconds"`
+	CircuitFailures        int   `yaml:"circuit_failures"`
+	CircuitCooldownSeconds int   `yaml:"circuit_cooldown_seconds"`
+	DownloadTimeoutMinutes int   `yaml:"download_timeout_minutes"`
+	AudioBitrateKbps       int   `yaml:"audio_bitrate_kbps"`
+	DiskHeadroomMB         int64 `yaml:"disk_headroom_mb"`
+	TempMaxAgeHours        int   `yaml:"temp_max_age_hours"`
+	S3LinkTTLHours         int   `yaml:"s3_link_ttl_hours"`
+	S3MaxFileSizeMB        int64 `yaml:"s3_max_file_size_mb"`
+	RcloneMaxFileSizeMB    int64 `yaml:"rclone_max_file_size_mb"`
+	MemoryBufferMB         int64 `yaml:"memory_buffer_mb"`
+	MemoryBudgetMB         int64 `yaml:"memory_budget_mb"`
+	FeedPollMinutes        int   `yaml:"feed_poll_minutes"`
+
+	// The bot sheds load when free temp disk falls below ShedDiskFreeMB,
+	// its memory grows past ShedMemoryMB or ShedQueueDepth jobs wait for a
+	// slot; 0 leaves that one unwatched. It then defers automatic jobs and
+	// files over ShedLargeFileMB until it recovers.
+	ShedDiskFreeMB  int64 `yaml:"shed_disk_free_mb"`
+	ShedMemoryMB    int64 `yaml:"shed_memory_mb"`
+	ShedQueueDepth  int   `yaml:"shed_queue_depth"`
+	ShedLargeFileMB int64 `yaml:"shed_large_file_mb"`
+
+	// MaxSpeedMbps caps the bot's downloads, and separately its uploads,
+	// across all jobs, UserMaxSpeedMbps those of each user and
+	// JobMaxSpeedMbps those of each job, in megabits per second; 0 leaves
+	// them uncapped.
+	MaxSpeedMbps     int `yaml:"max_speed_mbps"`
+	UserMaxSpeedMbps int `yaml:"user_max_speed_mbps"`
+	JobMaxSpeedMbps  int `yaml:"job_max_speed_mbps"`
+
+	// UserMaxConcurrentDownloads is how many of a user's jobs take job
+	// slots at once; the others wait in the queue. UserMaxConcurrentJobs
+	// caps how many the user has at all, running or queued, and new ones
+	// past it are turned away. MaxConcurrentUploads is how many files are
+	// sent to Telegram at once.
+	UserMaxConcurrentDownloads int `yaml:"user_max_concurrent_downloads"`
+	MaxConcurrentUploads       int `yaml:"max_concurrent_uploads"`
+
+	// MaintenanceSchedule is a cron spec for checking the cache and the
+	// database and compacting it; empty turns that off.
+	MaintenanceSchedule string `yaml:"maintenance_schedule"`
+	maintenance         *cronSchedule
+
+	// Verification has users pass a check before their first job, for
+	// bots open to the public: "captcha" asks them a sum, "channel" wants
+	// them to have joined VerifyChannel (@name or ID). Empty lets anyone
+	// the allowlist takes straight in.
+	Verification  string `yaml:"verification"`
+	VerifyChannel string `yaml:"verify_channel"`
+
+	// The most users may raise the timeouts to with --timeout and
+	// --stall; 0 doesn't let them.
+	MaxDownloadTimeoutMinutes int `yaml:"max_download_timeout_minutes"`
+	MaxStallTimeoutSeconds    int `yaml:"max_stall_timeout_seconds"`
+
+	LeaveInactiveAfterMonths int `yaml:"leave_inactive_after_months"`
+	InactiveWarningDays      int `yaml:"inactive_warning_days"`
+}
+
+var cfg = defaultConfig()
+
+func defaultConfig() *Config {
+	return &Config{
+		MaxFileSizeMB:     MAX_TELEGRAM_FILE_SIZE / 1024 / 1024,
+		MaxConcurrentJobs: 4,
+		TempDir:           os.TempDir(),
+		PrefetchDNS:       true,
+		VerifyContent:     true,
+		AllowedSchemes:    []string{"http", "https"},
+		DBPath:            "bot.db",
+		LogLevel:          "info",
+		LogFormat:         "text",
+		Profile:           profileDefault,
+		FFmpegPath:        "ffmpeg",
+		GalleryDLPath:     "gallery-dl",
+		RclonePath:        "rclone",
+		MemoryDir:         "/dev/shm",
+		S3Region:          "us-east-1",
+
+		DuplicateWindowSeconds: 10,
+		UserJobsPerMinute:      3,
+		UserMaxConcurrentJobs:  2,
+		DownloadRetries:        3,
+		ShutdownTimeoutSeconds: 60,
+		CacheTTLMinutes:        60,
+		MaxRedirects:           10,
+		RetryBudget:            6,
+		StallTimeoutSeconds:    60,
+		CircuitFailures:        5,
+		CircuitCooldownSeconds: 300,
+		AudioBitrateKbps:       192,
+		DiskHeadroomMB:         50,
+		TempMaxAgeHours:        24,
+		S3LinkTTLHours:         24,
+		S3MaxFileSizeMB:        4096,
+		RcloneMaxFileSizeMB:    4096,
+		MemoryBudgetMB:         256,
+		FeedPollMinutes:        30,
+		ShedLargeFileMB:        100,
+		MaintenanceSchedule:    "0 4 * * *",
+
+		MaxDownloadTimeoutMinutes: 240,
+		MaxStallTimeoutSeconds:    600,
+
+		InactiveWarningDays: 7,
+	}
+}
+
+// LoadConfig builds the configuration from, in increasing order of
+// precedence: built-in defaults, the YAML config file, environment variables
+// (including a .env file if present) and command-line flags.
+func LoadConfig(args []string) (*Config, error) {
+	if err := godotenv.Load(); err != nil && !os.IsNotExist(err) {
+		slog.Warn("Error loading .env file", "error", err)
+	}
+
+	// First pass only to find out which config file to read; the real flag
+	// values are applied after the file and environment have been loaded.
+	pre := flag.NewFlagSet("url-to-file-telegram-bot", flag.ContinueOnError)
+	configPath := pre.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML config file")
+	defaultConfig().registerFlags(pre)
+	if err := pre.Parse(args); err != nil {
+		return nil, err
+	}
+
+	c := defaultConfig()
+	if *configPath != "" {
+		data, err := os.ReadFile(*configPath)
+		if err != nil {
+			return nil, fmt.Errorf("reading config file: %w", err)
+		}
+		if err := yaml.Unmarshal(data, c); err != nil {
+			return nil, fmt.Errorf("parsing config file %s: %w", *configPath, err)
+		}
+	}
+
+	if err := c.applyEnv(); err != nil {
+		return nil, err
+	}
+
+	fs := flag.NewFlagSet("url-to-file-telegram-bot", flag.ContinueOnError)
+	fs.String("config", *configPath, "path to a YAML config file")
+	c.registerFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	// -debug used to only turn on Bot API request dumps, which are now
+	// logged at debug level.
+	if c.Debug {
+		c.LogLevel = "debug"
+	}
+	c.applyProfile()
+
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// registerFlags binds flags directly to the config fields, using their
+// current values as defaults so only flags given explicitly override them.
+func (c *Config) registerFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.TelegramToken, "token", c.TelegramToken, "Telegram bot token")
+	fs.Int64Var(&c.MaxFileSizeMB, "max-file-size-mb", c.MaxFileSizeMB, "maximum file size in MB")
+	fs.IntVar(&c.MaxConcurrentJobs, "concurrency", c.MaxConcurrentJobs, "maximum number of concurrent jobs")
+	fs.IntVar(&c.MaxConcurrentUploads, "upload-concurrency", c.MaxConcurrentUploads, "maximum number of concurrent uploads to Telegram (unlimited if 0)")
+	fs.StringVar(&c.TempDir, "temp-dir", c.TempDir, "directory for temporary download files")
+	fs.StringVar(&c.DBPath, "db", c.DBPath, "path to the bot's database file")
+	fs.Int64Var(&c.MemoryBufferMB, "memory-buffer-mb", c.MemoryBufferMB, "keep downloads up to this size in memory-dir instead of temp-dir (disabled if 0)")
+	fs.StringVar(&c.CacheDir, "cache-dir", c.CacheDir, "directory for caching downloads shared across chats (disabled if empty)")
+	fs.StringVar(&c.CacheS3Prefix, "cache-s3-prefix", c.CacheS3Prefix, "key prefix for caching downloads in the S3 bucket instead of a directory")
+	fs.StringVar(&c.HealthAddr, "health-addr", c.HealthAddr, "address for the /healthz and /readyz endpoints, e.g. :8080 (disabled if empty)")
+	fs.StringVar(&c.DownloadProxy, "download-proxy", c.DownloadProxy, "proxy URL used for downloads")
+	fs.StringVar(&c.TelegramProxy, "telegram-proxy", c.TelegramProxy, "proxy URL used for the Telegram Bot API")
+	fs.StringVar(&c.TelegramAPIURL, "telegram-api-url", c.TelegramAPIURL, "base URL of a local Bot API server, e.g. http://localhost:8081")
+	fs.BoolVar(&c.Debug, "debug", c.Debug, "shorthand for -log-level debug")
+	fs.StringVar(&c.LogLevel, "log-level", c.LogLevel, "log level: debug, info, warn or error")
+	fs.StringVar(&c.LogFormat, "log-format", c.LogFormat, "log format: text or json")
+	fs.StringVar(&c.Profile, "profile", c.Profile, "resource profile: default or low-memory")
+	fs.BoolVar(&c.StreamUploads, "stream-uploads", c.StreamUploads, "stream downloads of known size straight to Telegram without a temp file (no resuming or retries)")
+	fs.BoolVar(&c.ApproveNewUsers, "approve-new-users", c.ApproveNewUsers, "have a group admin approve each member's first request")
+	fs.BoolVar(&c.WeeklyDigest, "weekly-digest", c.WeeklyDigest, "post a weekly job digest to the log channel and opted-in chats")
+	fs.BoolVar(&c.EnableHashtags, "hashtags", c.EnableHashtags, "append category and host hashtags to captions")
+	fs.IntVar(&c.MaxSpeedMbps, "max-speed-mbps", c.MaxSpeedMbps, "cap downloads and uploads, each across all jobs, in Mbit/s (disabled if 0)")
+	fs.IntVar(&c.JobMaxSpeedMbps, "job-max-speed-mbps", c.JobMaxSpeedMbps, "cap the download and upload of each job in Mbit/s (disabled if 0)")
+}
+
+func (c *Config) applyEnv() error {
+	envString("TELEGRAM_BOT_TOKEN", &c.TelegramToken)
+	envString("TMP_DIR", &c.TempDir)
+	envString("DB_PATH", &c.DBPath)
+	envString("DOWNLOAD_PROXY", &c.DownloadProxy)
+	envString("TELEGRAM_PROXY", &c.TelegramProxy)
+	envString("TELEGRAM_API_URL", &c.TelegramAPIURL)
+	envString("LOG_LEVEL", &c.LogLevel)
+	envString("LOG_FORMAT", &c.LogFormat)
+	envString("PROFILE", &c.Profile)
+	envString("HEALTH_ADDR", &c.HealthAddr)
+	envString("CACHE_DIR", &c.CacheDir)
+	envString("CACHE_S3_PREFIX", &c.CacheS3Prefix)
+	envString("SIGNING_KEY", &c.SigningKey)
+	envString("CLAMD_ADDRESS", &c.ClamdAddress)
+	envString("FFMPEG_PATH", &c.FFmpegPath)
+	envString("CHROME_PATH", &c.ChromePath)
+	envString("GALLERY_DL_PATH", &c.GalleryDLPath)
+	envString("RCLONE_PATH", &c.RclonePath)
+	envString("MEMORY_DIR", &c.MemoryDir)
+	envString("S3_ENDPOINT", &c.S3Endpoint)
+	envString("S3_BUCKET", &c.S3Bucket)
+	envString("S3_REGION", &c.S3Region)
+	envString("S3_ACCESS_KEY_ID", &c.S3AccessKeyID)
+	envString("S3_SECRET_ACCESS_KEY", &c.S3SecretAccessKey)
+	envString("MAINTENANCE_SCHEDULE", &c.MaintenanceSchedule)
+	envString("VERIFICATION", &c.Verification)
+	envString("VERIFY_CHANNEL", &c.VerifyChannel)
+	envList("EXTRA_HASHTAGS", &c.ExtraHashtags)
+	envList("ALLOWED_SCHEMES", &c.AllowedSchemes)
+	envList("ALLOWED_DOMAINS", &c.AllowedDomains)
+	envList("BLOCKED_DOMAINS", &c.BlockedDomains)
+	envList("RCLONE_REMOTES", &c.RcloneRemotes)
+
+	if err := envIDList("ADMIN_IDS", &c.AdminIDs); err != nil {
+		return err
+	}
+	if err := envIDList("OBSERVER_IDS", &c.ObserverIDs); err != nil {
+		return err
+	}
+	if err := envIDList("ALLOWED_USER_IDS", &c.AllowedUserIDs); err != nil {
+		return err
+	}
+	if err := envIDList("ALLOWED_CHAT_IDS", &c.AllowedChatIDs); err != nil {
+		return err
+	}
+	if err := envInt64("LOG_CHANNEL_ID", &c.LogChannelID); err != nil {
+		return err
+	}
+	if err := envInt64("MAX_FILE_SIZE_MB", &c.MaxFileSizeMB); err != nil {
+		return err
+	}
+	if err := envInt("MAX_CONCURRENT_JOBS", &c.MaxConcurrentJobs); err != nil {
+		return err
+	}
+	if err := envInt64("DAILY_QUOTA_MB", &c.DailyQuotaMB); err != nil {
+		return err
+	}
+	if err := envInt("USER_JOBS_PER_MINUTE", &c.UserJobsPerMinute); err != nil {
+		return err
+	}
+	if err := envInt("USER_MAX_CONCURRENT_JOBS", &c.UserMaxConcurrentJobs); err != nil {
+		return err
+	}
+	if err := envInt("USER_MAX_CONCURRENT_DOWNLOADS", &c.UserMaxConcurrentDownloads); err != nil {
+		return err
+	}
+	if err := envInt("MAX_CONCURRENT_UPLOADS", &c.MaxConcurrentUploads); err != nil {
+		return err
+	}
+	// TRUNCATION_RETRIES is the old name, from when only cut-off
+	// downloads were retried.
+	if err := envInt("TRUNCATION_RETRIES", &c.DownloadRetries); err != nil {
+		return err
+	}
+	if err := envInt("DOWNLOAD_RETRIES", &c.DownloadRetries); err != nil {
+		return err
+	}
+	if err := envInt("SHUTDOWN_TIMEOUT_SECONDS", &c.ShutdownTimeoutSeconds); err != nil {
+		return err
+	}
+	if err := envInt("CACHE_TTL_MINUTES", &c.CacheTTLMinutes); err != nil {
+		return err
+	}
+	if err := envInt("FEED_POLL_MINUTES", &c.FeedPollMinutes); err != nil {
+		return err
+	}
+	if err := envInt("MAX_REDIRECTS", &c.MaxRedirects); err != nil {
+		return err
+	}
+	if err := envInt("RETRY_BUDGET", &c.RetryBudget); err != nil {
+		return err
+	}
+	if err := envInt("STALL_TIMEOUT_SECONDS", &c.StallTimeoutSeconds); err != nil {
+		return err
+	}
+	if err := envInt("DOWNLOAD_TIMEOUT_MINUTES", &c.DownloadTimeoutMinutes); err != nil {
+		return err
+	}
+	if err := envInt("MAX_DOWNLOAD_TIMEOUT_MINUTES", &c.MaxDownloadTimeoutMinutes); err != nil {
+		return err
+	}
+	if err := envInt("MAX_STALL_TIMEOUT_SECONDS", &c.MaxStallTimeoutSeconds); err != nil {
+		return err
+	}
+	if err := envInt("CIRCUIT_FAILURES", &c.CircuitFailures); err != nil {
+		return err
+	}
+	if err := envInt("CIRCUIT_COOLDOWN_SECONDS", &c.CircuitCooldownSeconds); err != nil {
+		return err
+	}
+	if err := envInt("AUDIO_BITRATE_KBPS", &c.AudioBitrateKbps); err != nil {
+		return err
+	}
+	if err := envInt64("DISK_HEADROOM_MB", &c.DiskHeadroomMB); err != nil {
+		return err
+	}
+	if err := envInt("TEMP_MAX_AGE_HOURS", &c.TempMaxAgeHours); err != nil {
+		return err
+	}
+	if err := envInt("S3_LINK_TTL_HOURS", &c.S3LinkTTLHours); err != nil {
+		return err
+	}
+	if err := envInt64("S3_MAX_FILE_SIZE_MB", &c.S3MaxFileSizeMB); err != nil {
+		return err
+	}
+	if err := envInt64("RCLONE_MAX_FILE_SIZE_MB", &c.RcloneMaxFileSizeMB); err != nil {
+		return err
+	}
+	if err := envInt64("MEMORY_BUFFER_MB", &c.MemoryBufferMB); err != nil {
+		return err
+	}
+	if err := envInt64("MEMORY_BUDGET_MB", &c.MemoryBudgetMB); err != nil {
+		return err
+	}
+	if err := envInt64("SHED_DISK_FREE_MB", &c.ShedDiskFreeMB); err != nil {
+		return err
+	}
+	if err := envInt64("SHED_MEMORY_MB", &c.ShedMemoryMB); err != nil {
+		return err
+	}
+	if err := envInt("SHED_QUEUE_DEPTH", &c.ShedQueueDepth); err != nil {
+		return err
+	}
+	if err := envInt64("SHED_LARGE_FILE_MB", &c.ShedLargeFileMB); err != nil {
+		return err
+	}
+	if err := envInt("MAX_SPEED_MBPS", &c.MaxSpeedMbps); err != nil {
+		return err
+	}
+	if err := envInt("USER_MAX_SPEED_MBPS", &c.UserMaxSpeedMbps); err != nil {
+		return err
+	}
+	if err := envInt("JOB_MAX_SPEED_MBPS", &c.JobMaxSpeedMbps); err != nil {
+		return err
+	}
+	if err := envInt("DUPLICATE_WINDOW_SECONDS", &c.DuplicateWindowSeconds); err != nil {
+		return err
+	}
+	if err := envInt("LEAVE_INACTIVE_AFTER_MONTHS", &c.LeaveInactiveAfterMonths); err != nil {
+		return err
+	}
+	if err := envInt("INACTIVE_WARNING_DAYS", &c.InactiveWarningDays); err != nil {
+		return err
+	}
+	if err := envBool("DEBUG", &c.Debug); err != nil {
+		return err
+	}
+	if err := envBool("PREFETCH_DNS", &c.PrefetchDNS); err != nil {
+		return err
+	}
+	if err := envBool("PREWARM_TLS", &c.PrewarmTLS); err != nil {
+		return err
+	}
+	if err := envBool("VERIFY_CONTENT", &c.VerifyContent); err != nil {
+		return err
+	}
+	if err := envBool("STREAM_UPLOADS", &c.StreamUploads); err != nil {
+		return err
+	}
+	if err := envBool("WEEKLY_DIGEST", &c.WeeklyDigest); err != nil {
+		return err
+	}
+	if err := envBool("APPROVE_NEW_USERS", &c.ApproveNewUsers); err != nil {
+		return err
+	}
+	if err := envBool("ALLOW_PRIVATE", &c.AllowPrivate); err != nil {
+		return err
+	}
+	if err := envBool("ALLOW_URL_CREDENTIALS", &c.AllowURLCreds); err != nil {
+		return err
+	}
+	if err := envBool("ENABLE_HASHTAGS", &c.EnableHashtags); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c *Config) validate() error {
+	if c.TelegramToken == "" {
+		return fmt.Errorf("no Telegram bot token configured (set TELEGRAM_BOT_TOKEN, telegram_token or -token)")
+	}
+	if c.MaxFileSizeMB <= 0 {
+		return fmt.Errorf("max file size must be positive, got %d MB", c.MaxFileSizeMB)
+	}
+	if c.TelegramAPIURL == "" && c.MaxFileSizeMB > MAX_TELEGRAM_FILE_SIZE/1024/1024 {
+		return fmt.Errorf("max file size of %d MB needs a local Bot API server (telegram_api_url); the public API accepts up to %d MB", c.MaxFileSizeMB, MAX_TELEGRAM_FILE_SIZE/1024/1024)
+	}
+	if c.MaxFileSizeMB > localAPIFileSizeMB {
+		return fmt.Errorf("max file size can't exceed %d MB, got %d MB", localAPIFileSizeMB, c.MaxFileSizeMB)
+	}
+	if c.LeaveInactiveAfterMonths > 0 && c.InactiveWarningDays <= 0 {
+		return fmt.Errorf("inactive warning days must be positive when leaving inactive chats is enabled")
+	}
+	if c.MaxConcurrentJobs <= 0 {
+		return fmt.Errorf("max concurrent jobs must be positive, got %d", c.MaxConcurrentJobs)
+	}
+	for _, scheme := range c.AllowedSchemes {
+		if scheme != "http" && scheme != "https" {
+			return fmt.Errorf("unsupported URL scheme %q in allowed schemes (only http and https can be fetched)", scheme)
+		}
+	}
+	if c.RetryBudget < 0 {
+		return fmt.Errorf("retry budget can't be negative, got %d", c.RetryBudget)
+	}
+	if c.StallTimeoutSeconds < 0 || c.DownloadTimeoutMinutes < 0 || c.MaxStallTimeoutSeconds < 0 || c.MaxDownloadTimeoutMinutes < 0 {
+		return fmt.Errorf("download timeouts can't be negative")
+	}
+	if c.AudioBitrateKbps <= 0 {
+		return fmt.Errorf("audio bitrate must be positive, got %d kbps", c.AudioBitrateKbps)
+	}
+	if info, err := os.Stat(c.TempDir); err != nil || !info.IsDir() {
+		return fmt.Errorf("temp dir %s doesn't exist or isn't a directory", c.TempDir)
+	}
+	if c.TempMaxAgeHours < 0 {
+		return fmt.Errorf("temp file max age can't be negative, got %d hours", c.TempMaxAgeHours)
+	}
+	if len(c.RcloneRemotes) > 0 && c.RcloneMaxFileSizeMB <= 0 {
+		return fmt.Errorf("rclone max file size must be positive, got %d MB", c.RcloneMaxFileSizeMB)
+	}
+	if err := validateS3(c); err != nil {
+		return err
+	}
+	if err := validateMemoryBuffering(c); err != nil {
+		return err
+	}
+	if err := validateChatWeights(c.ChatWeights); err != nil {
+		return err
+	}
+	if err := validateLabelRoutes(c.LabelRoutes); err != nil {
+		return err
+	}
+	if err := validateHooks(c.Hooks); err != nil {
+		return err
+	}
+	if c.MaxRedirects < 0 {
+		return fmt.Errorf("max redirects can't be negative, got %d", c.MaxRedirects)
+	}
+	if c.CacheDir != "" && c.CacheS3Prefix != "" {
+		return fmt.Errorf("the cache can live in a directory or in the S3 bucket, not both")
+	}
+	if c.CacheS3Prefix != "" && (c.S3Endpoint == "" || c.S3Bucket == "") {
+		return fmt.Errorf("a cache in the S3 bucket needs S3 storage to be configured")
+	}
+	if (c.CacheDir != "" || c.CacheS3Prefix != "") && c.CacheTTLMinutes <= 0 {
+		return fmt.Errorf("cache TTL must be positive when caching is enabled, got %d minutes", c.CacheTTLMinutes)
+	}
+	if c.FeedPollMinutes <= 0 {
+		return fmt.Errorf("feed poll interval must be positive, got %d minutes", c.FeedPollMinutes)
+	}
+	if c.ShedDiskFreeMB < 0 || c.ShedMemoryMB < 0 || c.ShedQueueDepth < 0 || c.ShedLargeFileMB < 0 {
+		return fmt.Errorf("load shedding thresholds can't be negative")
+	}
+	if c.UserMaxConcurrentDownloads < 0 || c.MaxConcurrentUploads < 0 {
+		return fmt.Errorf("concurrency limits can't be negative")
+	}
+	if c.MaxSpeedMbps < 0 || c.UserMaxSpeedMbps < 0 || c.JobMaxSpeedMbps < 0 {
+		return fmt.Errorf("speed limits can't be negative")
+	}
+	switch c.Verification {
+	case "", verifyCaptcha:
+	case verifyChannel:
+		if c.VerifyChannel == "" {
+			return fmt.Errorf("channel verification needs a channel to join")
+		}
+	default:
+		return fmt.Errorf("verification must be %s or %s, got %q", verifyCaptcha, verifyChannel, c.Verification)
+	}
+	if _, err := parseLogLevel(c.LogLevel); err != nil {
+		return err
+	}
+	if c.Profile != profileDefault && c.Profile != profileLowMemory {
+		return fmt.Errorf("profile must be %s or %s, got %q", profileDefault, profileLowMemory, c.Profile)
+	}
+	if c.LogFormat != "text" && c.LogFormat != "json" {
+		return fmt.Errorf("log format must be text or json, got %q", c.LogFormat)
+	}
+	var err error
+	if c.holdURLs, err = compileHoldPatterns(c.HoldURLPatterns); err != nil {
+		return err
+	}
+	if c.MaintenanceSchedule != "" {
+		if c.maintenance, err = parseCron(c.MaintenanceSchedule); err != nil {
+			return fmt.Errorf("maintenance schedule: %w", err)
+		}
+	}
+	if len(c.holdURLs) > 0 && c.LogChannelID == 0 {
+		return fmt.Errorf("hold URL patterns need a log channel for the approval requests")
+	}
+	return nil
+}
+
+func envString(name string, dst *string) {
+	if v, ok := os.LookupEnv(name); ok {
+		*dst = v
+	}
+}
+
+func envList(name string, dst *[]string) {
+	if v, ok := os.LookupEnv(name); ok {
+		*dst = strings.FieldsFunc(v, func(r rune) bool { return r == ',' || r == ' ' })
+	}
+}
+
+func envIDList(name string, dst *[]int64) error {
+	var fields []string
+	envList(name, &fields)
+	if fields == nil {
+		return nil
+	}
+
+	ids := make([]int64, 0, len(fields))
+	for _, f := range fields {
+		id, err := strconv.ParseInt(f, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", name, err)
+		}
+		ids = append(ids, id)
+	}
+	*dst = ids
+	return nil
+}
+
+func envInt(name string, dst *int) error {
+	v, ok := os.LookupEnv(name)
+	if !ok {
+		return nil
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(v))
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", name, err)
+	}
+	*dst = n
+	return nil
+}
+
+func envInt64(name string, dst *int64) error {
+	v, ok := os.LookupEnv(name)
+	if !ok {
+		return nil
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", name, err)
+	}
+	*dst = n
+	return nil
+}
+
+func envBool(name string, dst *bool) error {
+	v, ok := os.LookupEnv(name)
+	if !ok {
+		return nil
+	}
+	b, err := strconv.ParseBool(strings.TrimSpace(v))
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", name, err)
+	}
+	*dst = b
+	return nil
+}
@@ -0,0 +1,409 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	cronUsage = "Usage: /cron \"<minute> <hour> <day> <month> <weekday>\" <url> [--changed] [options]\n/cron list\n/cron delete <id>\n\nFor example: /cron \"0 6 * * *\" https://example.com/report.pdf --changed"
+
+	maxCronJobs = 50
+)
+
+// errCronDeleted stops an update of a recurring download deleted in the
+// meantime from writing it back.
+var errCronDeleted = errors.New("recurring download was deleted")
+
+// cronJob is a link an admin has the bot fetch and post on a schedule.
+// With OnlyChanged the file is only posted when its content differs from
+// the last run's.
+type cronJob struct {
+	ID        int64  `json:"id"`
+	ChatID    int64  `json:"chat_id"`
+	CreatedBy int64  `json:"created_by"`
+	MessageID int    `json:"message_id"`
+	Spec      string `json:"spec"`
+	URL       string `json:"url"`
+	// Template and Params are what URL was filled in from, filled in
+	// again for every run.
+	Template    string     `json:"template,omitempty"`
+	Params      []string   `json:"params,omitempty"`
+	Options     jobOptions `json:"options"`
+	OnlyChanged bool       `json:"only_changed,omitempty"`
+	NextRun     time.Time  `json:"next_run"`
+	LastSHA256  string     `json:"last_sha256,omitempty"`
+	LastRun     time.Time  `json:"last_run,omitempty"`
+}
+
+// cronSchedule is a parsed five-field cron expression, with each field
+// as the set of values it matches.
+type cronSchedule struct {
+	minute, hour, day, month, weekday []bool
+	// anyDay and anyWeekday are set for *, since a day of the month and a
+	// day of the week given together match either one.
+	anyDay, anyWeekday bool
+}
+
+func parseCron(spec string) (*cronSchedule, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("a schedule has five fields: minute, hour, day, month and weekday")
+	}
+	var s cronSchedule
+	var err error
+	bounds := []struct {
+		name     string
+		min, max int
+		dst      *[]bool
+	}{
+		{"minute", 0, 59, &s.minute},
+		{"hour", 0, 23, &s.hour},
+		{"day", 1, 31, &s.day},
+		{"month", 1, 12, &s.month},
+		{"weekday", 0, 7, &s.weekday},
+	}
+	for i, b := range bounds {
+		if *b.dst, err = parseCronField(fields[i], b.min, b.max); err != nil {
+			return nil, fmt.Errorf("%s: %w", b.name, err)
+		}
+	}
+	// 7 is Sunday as well as 0.
+	if s.weekday[7] {
+		s.weekday[0] = true
+	}
+	s.anyDay, s.anyWeekday = fields[2] == "*", fields[4] == "*"
+	return &s, nil
+}
+
+// parseCronField understands *, single values, ranges like 1-5, lists
+// like 1,15 and steps like */15 or 9-17/2.
+func parseCronField(field string, min, max int) ([]bool, error) {
+	set := make([]bool, max+1)
+	for _, part := range strings.Split(field, ",") {
+		rng, stepText, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepText)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("%q isn't a valid step", part)
+			}
+			step = n
+		}
+		lo, hi := min, max
+		if rng != "*" {
+			first, last, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = strconv.Atoi(first); err != nil {
+				return nil, fmt.Errorf("%q isn't a number", part)
+			}
+			hi = lo
+			if isRange {
+				if hi, err = strconv.Atoi(last); err != nil {
+					return nil, fmt.Errorf("%q isn't a number", part)
+				}
+			} else if hasStep {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return nil, fmt.Errorf("%q is outside %d-%d", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			set[v] = true
+		}
+	}
+	return set, nil
+}
+
+func (s *cronSchedule) matchesDay(t time.Time) bool {
+	day, weekday := s.day[t.Day()], s.weekday[int(t.Weekday())]
+	switch {
+	case s.anyDay && s.anyWeekday:
+		return true
+	case s.anyDay:
+		return weekday
+	case s.anyWeekday:
+		return day
+	}
+	return day || weekday
+}
+
+// next is the first time after t the schedule matches, or the zero time
+// if it doesn't within the next few years, like on February 30th.
+func (s *cronSchedule) next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case !s.month[int(t.Month())]:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !s.matchesDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case !s.hour[t.Hour()]:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case !s.minute[t.Minute()]:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// splitCronSpec takes the schedule off the front of the /cron arguments,
+// in quotes as one argument or as the first five.
+func splitCronSpec(args []string) (string, []string, bool) {
+	if len(args) > 0 && len(strings.Fields(args[0])) == 5 {
+		return args[0], args[1:], true
+	}
+	if len(args) < 5 {
+		return "", nil, false
+	}
+	return strings.Join(args[:5], " "), args[5:], true
+}
+
+func handleCronCommand(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
+	if message.From == nil || !isAdmin(message.From.ID) {
+		sendErrorMessage(bot, message.Chat.ID, "🚫 Only bot admins can use /cron.")
+		return
+	}
+	if !hidePassphrase(bot, message) {
+		return
+	}
+	args, err := commandArgs(message)
+	if err != nil {
+		sendErrorMessage(bot, message.Chat.ID, usageError(err, cronUsage))
+		return
+	}
+	switch {
+	case len(args) == 1 && strings.EqualFold(args[0], "list"):
+		listCronJobs(bot, message)
+		return
+	case len(args) == 2 && strings.EqualFold(args[0], "delete"):
+		deleteCronJob(bot, message, args[1])
+		return
+	}
+
+	spec, args, ok := splitCronSpec(args)
+	if !ok || len(args) == 0 {
+		sendErrorMessage(bot, message.Chat.ID, cronUsage)
+		return
+	}
+	schedule, err := parseCron(spec)
+	if err != nil {
+		sendErrorMessage(bot, message.Chat.ID, usageError(err, cronUsage))
+		return
+	}
+	onlyChanged := false
+	rest := args[:0]
+	for _, arg := range args {
+		if strings.EqualFold(arg, "--changed") {
+			onlyChanged = true
+			continue
+		}
+		rest = append(rest, arg)
+	}
+	opts, rest, err := parseJobOptions(rest)
+	if err != nil {
+		sendErrorMessage(bot, message.Chat.ID, usageError(err, cronUsage))
+		return
+	}
+	if len(rest) == 0 {
+		sendErrorMessage(bot, message.Chat.ID, cronUsage)
+		return
+	}
+	if opts.Encrypt {
+		sendErrorMessage(bot, message.Chat.ID, "❌ Encrypted downloads can't be repeated.")
+		return
+	}
+	tmpl, ok := resolveURLTemplate(message, rest[0])
+	if !ok {
+		sendErrorMessage(bot, message.Chat.ID, tmpl)
+		return
+	}
+	url, ok := fillURLTemplate(tmpl, rest[1:], time.Now())
+	if !ok {
+		sendErrorMessage(bot, message.Chat.ID, url)
+		return
+	}
+	if problem, ok := validateURL(url); !ok {
+		sendErrorMessage(bot, message.Chat.ID, problem)
+		return
+	}
+	next := schedule.next(time.Now())
+	if next.IsZero() {
+		sendErrorMessage(bot, message.Chat.ID, "❌ That schedule never comes up.")
+		return
+	}
+
+	existing, err := loadCronJobs()
+	if err != nil {
+		slog.Error("Error loading cron jobs", "error", err)
+		sendErrorMessage(bot, message.Chat.ID, "❌ Failed to add the recurring download")
+		return
+	}
+	if len(existing) >= maxCronJobs {
+		sendErrorMessage(bot, message.Chat.ID, fmt.Sprintf("❌ There are already %d recurring downloads. Delete some with /cron delete <id> first.", len(existing)))
+		return
+	}
+	id, err := store.nextID(bucketCron)
+	if err != nil {
+		slog.Error("Error allocating cron ID", "error", err)
+		sendErrorMessage(bot, message.Chat.ID, "❌ Failed to add the recurring download")
+		return
+	}
+	cj := cronJob{
+		ID:          int64(id),
+		ChatID:      message.Chat.ID,
+		CreatedBy:   message.From.ID,
+		MessageID:   message.MessageID,
+		Spec:        strings.Join(strings.Fields(spec), " "),
+		URL:         url,
+		Template:    tmpl,
+		Params:      rest[1:],
+		Options:     opts,
+		OnlyChanged: onlyChanged,
+		NextRun:     next,
+	}
+	if err := store.put(bucketCron, jobKey(cj.ID), cj); err != nil {
+		slog.Error("Error saving cron job", "error", err)
+		sendErrorMessage(bot, message.Chat.ID, "❌ Failed to add the recurring download")
+		return
+	}
+	slog.Info("Added cron job", "cron_id", cj.ID, "admin_id", cj.CreatedBy, "spec", cj.Spec, "host", urlHost(url))
+	text := fmt.Sprintf("🔁 Added recurring download #%d (%s). The first run is %s.", cj.ID, cj.Spec, next.Format("Mon Jan 2 15:04"))
+	if onlyChanged {
+		text += "\nThe file is only posted when it changed."
+	}
+	sendMessage(bot, message.Chat.ID, text)
+}
+
+func loadCronJobs() ([]cronJob, error) {
+	var all []cronJob
+	err := store.forEach(bucketCron, func(_, value []byte) error {
+		var cj cronJob
+		if err := json.Unmarshal(value, &cj); err != nil {
+			return err
+		}
+		all = append(all, cj)
+		return nil
+	})
+	sort.Slice(all, func(i, j int) bool { return all[i].ID < all[j].ID })
+	return all, err
+}
+
+func listCronJobs(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
+	all, err := loadCronJobs()
+	if err != nil {
+		slog.Error("Error loading cron jobs", "error", err)
+		sendErrorMessage(bot, message.Chat.ID, "❌ Failed to load the recurring downloads")
+		return
+	}
+	if len(all) == 0 {
+		sendMessage(bot, message.Chat.ID, "🔁 There are no recurring downloads.")
+		return
+	}
+	lines := make([]string, 0, len(all))
+	for _, cj := range all {
+		line := fmt.Sprintf("#%d %s — %s — chat %d — next %s", cj.ID, cj.Spec, redactURL(cj.URL), cj.ChatID, cj.NextRun.Format("Mon 15:04"))
+		if cj.OnlyChanged {
+			line += " — when changed"
+		}
+		lines = append(lines, line)
+	}
+	msg := tgbotapi.NewMessage(message.Chat.ID, "🔁 Recurring downloads:\n\n"+strings.Join(lines, "\n"))
+	msg.DisableWebPagePreview = true
+	bot.Send(msg)
+}
+
+func deleteCronJob(bot *tgbotapi.BotAPI, message *tgbotapi.Message, arg string) {
+	id, err := strconv.ParseInt(strings.TrimPrefix(arg, "#"), 10, 64)
+	if err != nil {
+		sendErrorMessage(bot, message.Chat.ID, cronUsage)
+		return
+	}
+	var cj cronJob
+	found, err := store.get(bucketCron, jobKey(id), &cj)
+	if err == nil && found {
+		err = store.delete(bucketCron, jobKey(id))
+	}
+	switch {
+	case err != nil:
+		slog.Error("Error deleting cron job", "cron_id", id, "error", err)
+		sendErrorMessage(bot, message.Chat.ID, "❌ Failed to delete the recurring download")
+	case !found:
+		sendErrorMessage(bot, message.Chat.ID, fmt.Sprintf("❌ No recurring download #%d.", id))
+	default:
+		slog.Info("Deleted cron job", "cron_id", id, "admin_id", message.From.ID)
+		sendMessage(bot, message.Chat.ID, fmt.Sprintf("✅ Deleted recurring download #%d.", id))
+	}
+}
+
+// startDueCronJobs starts the recurring downloads that are due and moves
+// them on to their next run. Runs missed while the bot was down are made
+// up for once, not once per missed run.
+func startDueCronJobs(bot *tgbotapi.BotAPI, now time.Time) {
+	all, err := loadCronJobs()
+	if err != nil {
+		slog.Error("Error loading cron jobs", "error", err)
+		return
+	}
+	for _, cj := range all {
+		if cj.NextRun.After(now) {
+			continue
+		}
+		schedule, err := parseCron(cj.Spec)
+		if err != nil {
+			slog.Error("Invalid stored cron schedule", "cron_id", cj.ID, "spec", cj.Spec, "error", err)
+			continue
+		}
+		err = updateRecord(store, bucketCron, jobKey(cj.ID), func(r *cronJob, exists bool) error {
+			if !exists {
+				return errCronDeleted
+			}
+			r.NextRun = schedule.next(now)
+			r.LastRun = now
+			return nil
+		})
+		if errors.Is(err, errCronDeleted) {
+			continue
+		}
+		if err != nil {
+			slog.Error("Error updating cron job", "cron_id", cj.ID, "error", err)
+			continue
+		}
+		job := jobs.cron(cj)
+		job.logger().Info("Starting recurring download", "cron_id", cj.ID)
+		go handleURL(bot, job)
+	}
+}
+
+// noteCronRun remembers what a recurring download got, for comparing the
+// next run against.
+func noteCronRun(job *Job) {
+	err := updateRecord(store, bucketCron, jobKey(job.options.CronID), func(r *cronJob, exists bool) error {
+		if !exists {
+			return errCronDeleted
+		}
+		r.LastSHA256 = job.SHA256
+		return nil
+	})
+	if err != nil && !errors.Is(err, errCronDeleted) {
+		job.logger().Error("Error updating cron job", "cron_id", job.options.CronID, "error", err)
+	}
+}
+
+// unchangedSinceLastRun reports whether a recurring download that is only
+// posted on changes got the same content as last time.
+func (j *Job) unchangedSinceLastRun() bool {
+	return j.options.UnchangedSHA256 != "" && j.SHA256 == j.options.UnchangedSHA256
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// duplicateGuard remembers recent commands so that the same message resent
+// by a flaky client only starts one job.
+type duplicateGuard struct {
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+var duplicates = &duplicateGuard{seen: map[string]time.Time{}}
+
+func (g *duplicateGuard) isDuplicate(message *tgbotapi.Message) bool {
+	window := time.Duration(cfg.DuplicateWindowSeconds) * time.Second
+	if window <= 0 {
+		return false
+	}
+
+	var userID int64
+	if message.From != nil {
+		userID = message.From.ID
+	}
+	key := fmt.Sprintf("%d:%d:%s", message.Chat.ID, userID, strings.TrimSpace(message.Text))
+	now := time.Now()
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for k, t := range g.seen {
+		if now.Sub(t) > window {
+			delete(g.seen, k)
+		}
+	}
+
+	if _, ok := g.seen[key]; ok {
+		return true
+	}
+	g.seen[key] = now
+	return false
+}
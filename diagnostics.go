@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	neturl "net/url"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+var sensitiveHeaders = map[string]bool{
+	"Set-Cookie":           true,
+	"Cookie":               true,
+	"Authorization":        true,
+	"X-Amz-Security-Token": true,
+}
+
+type stageTiming struct {
+	Stage    string  `json:"stage"`
+	Seconds  float64 `json:"seconds"`
+	Finished bool    `json:"finished"`
+}
+
+// diagnostics is a sanitized snapshot of a failed job, stored so the
+// requester can forward it to the operator with the "Report issue" button.
+type diagnostics struct {
+	JobID      int64               `json:"job_id"`
+	URL        string              `json:"url"`
+	Headers    map[string][]string `json:"headers,omitempty"`
+	Timings    []stageTiming       `json:"timings"`
+	ErrorChain []string            `json:"error_chain"`
+	Version    string              `json:"version"`
+	GoVersion  string              `json:"go_version"`
+	CreatedAt  time.Time           `json:"created_at"`
+	Reported   bool                `json:"reported"`
+}
+
+// redactURL drops credentials and query values, which often carry tokens
+// or signatures, while keeping enough to reproduce the problem.
+func redactURL(raw string) string {
+	u, err := neturl.Parse(raw)
+	if err != nil {
+		return "<unparseable URL>"
+	}
+	u.User = nil
+	if u.RawQuery != "" {
+		q := u.Query()
+		for key := range q {
+			q[key] = []string{"REDACTED"}
+		}
+		u.RawQuery = q.Encode()
+	}
+	u.Fragment = ""
+	return u.String()
+}
+
+func redactHeaders(h http.Header) map[string][]string {
+	if h == nil {
+		return nil
+	}
+	out := map[string][]string{}
+	for key, values := range h {
+		if sensitiveHeaders[http.CanonicalHeaderKey(key)] {
+			out[key] = []string{"REDACTED"}
+			continue
+		}
+		out[key] = values
+	}
+	return out
+}
+
+func errorChain(err error) []string {
+	var chain []string
+	for e := err; e != nil; e = errors.Unwrap(e) {
+		if jerr, ok := e.(*jobError); ok {
+			chain = append(chain, fmt.Sprintf("%T: %s", e, jerr.userMessage))
+			continue
+		}
+		chain = append(chain, fmt.Sprintf("%T: %s", e, redactErrorText(e)))
+	}
+	return chain
+}
+
+// redactErrorText is err's message with the URL of every url.Error in its
+// chain redacted, since the errors wrapping one repeat it.
+func redactErrorText(err error) string {
+	text := err.Error()
+	for e := err; e != nil; e = errors.Unwrap(e) {
+		if uerr, ok := e.(*neturl.Error); ok && uerr.URL != "" {
+			text = strings.ReplaceAll(text, uerr.URL, redactURL(uerr.URL))
+		}
+	}
+	return text
+}
+
+func saveDiagnostics(job *Job, err error) {
+	d := diagnostics{
+		JobID:      job.ID,
+		URL:        redactURL(job.URL),
+		Headers:    redactHeaders(job.responseHeader),
+		Timings:    job.timings,
+		ErrorChain: errorChain(err),
+		Version:    version,
+		GoVersion:  runtime.Version(),
+		CreatedAt:  time.Now(),
+	}
+	if err := store.put(bucketDiagnostics, jobKey(job.ID), d); err != nil {
+		job.logger().Error("Error saving diagnostics", "error", err)
+	}
+}
+
+func reportButton(jobID int64) tgbotapi.InlineKeyboardMarkup {
+	return tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("🐞 Report issue", "report:"+strconv.FormatInt(jobID, 10)),
+	))
+}
+
+func formatDiagnostics(d diagnostics, reporter *tgbotapi.User) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "🐞 Issue report for job #%d\n\n", d.JobID)
+	if reporter != nil {
+		fmt.Fprintf(&b, "Reported by: %d (@%s)\n", reporter.ID, reporter.UserName)
+	}
+	fmt.Fprintf(&b, "URL: %s\nBot: %s (%s)\nFailed at: %s\n", d.URL, d.Version, d.GoVersion, d.CreatedAt.Format(time.RFC3339))
+
+	b.WriteString("\nTimings:\n")
+	for _, t := range d.Timings {
+		state := ""
+		if !t.Finished {
+			state = " (failed here)"
+		}
+		fmt.Fprintf(&b, "  %s: %.2fs%s\n", t.Stage, t.Seconds, state)
+	}
+
+	b.WriteString("\nError chain:\n")
+	for _, e := range d.ErrorChain {
+		fmt.Fprintf(&b, "  %s\n", e)
+	}
+
+	if len(d.Headers) > 0 {
+		keys := make([]string, 0, len(d.Headers))
+		for k := range d.Headers {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		b.WriteString("\nResponse headers:\n")
+		for _, k := range keys {
+			fmt.Fprintf(&b, "  %s: %s\n", k, strings.Join(d.Headers[k], ", "))
+		}
+	}
+	return b.String()
+}
+
+func handleReportCallback(bot *tgbotapi.BotAPI, query *tgbotapi.CallbackQuery, args []string) string {
+	if len(args) != 1 || cfg.LogChannelID == 0 {
+		return ""
+	}
+	jobID, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return ""
+	}
+
+	var d diagnostics
+	found, err := store.get(bucketDiagnostics, jobKey(jobID), &d)
+	if err != nil || !found {
+		return "Sorry, the diagnostics for this job are no longer available."
+	}
+	if d.Reported {
+		return "This issue has already been reported. Thanks!"
+	}
+
+	if _, err := bot.Send(tgbotapi.NewMessage(cfg.LogChannelID, formatDiagnostics(d, query.From))); err != nil {
+		slog.Error("Error sending issue report", "job_id", jobID, "error", err)
+		return "Sorry, I couldn't send the report."
+	}
+
+	d.Reported = true
+	if err := store.put(bucketDiagnostics, jobKey(jobID), d); err != nil {
+		slog.Error("Error marking job as reported", "job_id", jobID, "error", err)
+	}
+	return "Thanks! The report was sent to the operator."
+}
+
+// pruneDiagnostics drops snapshots older than a week; nobody reports
+// issues about jobs that old.
+func pruneDiagnostics() {
+	var stale []string
+	err := store.forEach(bucketDiagnostics, func(key, value []byte) error {
+		var d diagnostics
+		if err := json.Unmarshal(value, &d); err != nil {
+			return err
+		}
+		if time.Since(d.CreatedAt) > 7*24*time.Hour {
+			stale = append(stale, string(key))
+		}
+		return nil
+	})
+	if err != nil {
+		slog.Error("Error listing diagnostics", "error", err)
+		return
+	}
+
+	for _, key := range stale {
+		if err := store.delete(bucketDiagnostics, key); err != nil {
+			slog.Error("Error pruning diagnostics", "key", key, "error", err)
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	digestWeekday = time.Monday
+	digestHour    = 9
+	digestPeriod  = 7 * 24 * time.Hour
+	digestTopN    = 5
+	digestSentKey = "digest_sent_at"
+)
+
+// digest is a rollup of the job history over a period, either for the
+// whole bot or for one chat.
+type digest struct {
+	from, to  time.Time
+	jobs      int
+	results   map[string]int
+	bytes     int64
+	hosts     map[string]int
+	errors    map[string]int
+	stageTime map[string]float64
+	stageRuns map[string]int
+}
+
+// buildDigests walks the history from the newest job back to since and
+// returns the overall digest plus one per chat.
+func buildDigests(since, until time.Time) (*digest, map[int64]*digest, error) {
+	newDigest := func() *digest {
+		return &digest{
+			from: since, to: until,
+			results:   map[string]int{},
+			hosts:     map[string]int{},
+			errors:    map[string]int{},
+			stageTime: map[string]float64{},
+			stageRuns: map[string]int{},
+		}
+	}
+	overall := newDigest()
+	perChat := map[int64]*digest{}
+
+	err := store.forEachReverse(bucketJobs, func(_, value []byte) (bool, error) {
+		var r jobRecord
+		if err := json.Unmarshal(value, &r); err != nil {
+			return false, err
+		}
+		if r.FinishedAt.Before(since) {
+			return false, nil
+		}
+		if r.FinishedAt.After(until) {
+			return true, nil
+		}
+		chat, ok := perChat[r.ChatID]
+		if !ok {
+			chat = newDigest()
+			perChat[r.ChatID] = chat
+		}
+		overall.add(r)
+		chat.add(r)
+		return true, nil
+	})
+	return overall, perChat, err
+}
+
+func (d *digest) add(r jobRecord) {
+	d.jobs++
+	d.results[r.Result]++
+	if r.Result == resultSuccess {
+		d.bytes += r.Size
+	}
+	if host := urlHost(r.URL); host != "" {
+		d.hosts[host]++
+	}
+	if r.Result != resultSuccess && r.Result != resultInterrupted {
+		d.errors[errorKind(r)]++
+	}
+	for _, t := range r.Timings {
+		if t.Finished {
+			d.stageTime[t.Stage] += t.Seconds
+			d.stageRuns[t.Stage]++
+		}
+	}
+}
+
+// errorKind groups failures for the breakdown: the leading part of the
+// error ("download: 404 Not Found" becomes "download"), or the result
+// when there is nothing more specific.
+func errorKind(r jobRecord) string {
+	kind, _, found := strings.Cut(r.Error, ":")
+	if !found || kind == "" || len(kind) > 40 {
+		return r.Result
+	}
+	return kind
+}
+
+// topCounts returns the n largest entries of counts, largest first.
+func topCounts(counts map[string]int, n int) []string {
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	if len(keys) > n {
+		keys = keys[:n]
+	}
+	return keys
+}
+
+func (d *digest) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "📰 Weekly digest (%s – %s)\n\n", d.from.Format("Jan 2"), d.to.Format("Jan 2"))
+	if d.jobs == 0 {
+		b.WriteString("No jobs this week.")
+		return b.String()
+	}
+
+	fmt.Fprintf(&b, "Jobs: %d (", d.jobs)
+	var parts []string
+	for _, result := range []string{resultSuccess, resultFailed, resultRejected, resultBlocked, resultInterrupted} {
+		if n := d.results[result]; n > 0 {
+			parts = append(parts, fmt.Sprintf("%s %d", resultIcons[result], n))
+		}
+	}
+	fmt.Fprintf(&b, "%s)\nDelivered: %.1f MB\n", strings.Join(parts, ", "), float64(d.bytes)/1024/1024)
+
+	b.WriteString("\nTop hosts:\n")
+	for i, host := range topCounts(d.hosts, digestTopN) {
+		fmt.Fprintf(&b, "  %d. %s — %d\n", i+1, host, d.hosts[host])
+	}
+
+	if len(d.errors) > 0 {
+		b.WriteString("\nFailures:\n")
+		for _, kind := range topCounts(d.errors, digestTopN) {
+			fmt.Fprintf(&b, "  %s: %d\n", kind, d.errors[kind])
+		}
+	}
+
+	if len(d.stageRuns) > 0 {
+		averages := map[string]float64{}
+		for stage, total := range d.stageTime {
+			averages[stage] = total / float64(d.stageRuns[stage])
+		}
+		stages := make([]string, 0, len(averages))
+		for stage := range averages {
+			stages = append(stages, stage)
+		}
+		sort.Slice(stages, func(i, j int) bool { return averages[stages[i]] > averages[stages[j]] })
+		b.WriteString("\nSlowest stages (average):\n")
+		for _, stage := range stages[:min(3, len(stages))] {
+			fmt.Fprintf(&b, "  %s: %.1fs\n", stage, averages[stage])
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// digestDue reports whether the weekly digest should go out now, given
+// when the last one was sent.
+func digestDue(now, lastSent time.Time) bool {
+	if now.Weekday() != digestWeekday || now.Hour() < digestHour {
+		return false
+	}
+	// Anything within the last few days is this week's digest.
+	return now.Sub(lastSent) > 3*24*time.Hour
+}
+
+func runDigestReporter(bot *tgbotapi.BotAPI) {
+	if !cfg.WeeklyDigest {
+		return
+	}
+
+	for {
+		var lastSent time.Time
+		if _, err := store.get(bucketMeta, digestSentKey, &lastSent); err != nil {
+			slog.Error("Error reading digest state", "error", err)
+		}
+		if now := time.Now(); digestDue(now, lastSent) {
+			sendDigests(bot, now)
+			if err := store.put(bucketMeta, digestSentKey, now); err != nil {
+				slog.Error("Error saving digest state", "error", err)
+			}
+		}
+		time.Sleep(time.Hour)
+	}
+}
+
+func sendDigests(bot *tgbotapi.BotAPI, now time.Time) {
+	overall, perChat, err := buildDigests(now.Add(-digestPeriod), now)
+	if err != nil {
+		slog.Error("Error building weekly digest", "error", err)
+		return
+	}
+	if cfg.LogChannelID != 0 {
+		sendMessage(bot, cfg.LogChannelID, overall.String())
+	}
+
+	chats, err := knownChats()
+	if err != nil {
+		slog.Error("Error listing chats for the digest", "error", err)
+		return
+	}
+	for _, chat := range chats {
+		if !chat.Digest || chat.LastActivity.IsZero() {
+			continue
+		}
+		d, ok := perChat[chat.ID]
+		if !ok {
+			d = &digest{from: now.Add(-digestPeriod), to: now}
+		}
+		sendMessage(bot, chat.ID, d.String())
+	}
+	slog.Info("Sent weekly digest", "jobs", overall.jobs)
+}
+
+func handleDigestCommand(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
+	if !isAuthorized(message) {
+		sendErrorMessage(bot, message.Chat.ID, notAuthorizedMessage)
+		return
+	}
+	arg := strings.ToLower(strings.TrimSpace(message.CommandArguments()))
+	if arg != "on" && arg != "off" {
+		sendErrorMessage(bot, message.Chat.ID, "Usage: /digest on|off")
+		return
+	}
+	if !cfg.WeeklyDigest {
+		sendErrorMessage(bot, message.Chat.ID, "❌ Weekly digests are turned off on this bot.")
+		return
+	}
+
+	err := updateRecord(store, bucketChats, chatKey(message.Chat.ID), func(r *chatRecord, exists bool) error {
+		if !exists {
+			r.ID = message.Chat.ID
+			r.Title = message.Chat.Title
+			r.Type = message.Chat.Type
+			r.LastActivity = time.Now()
+		}
+		r.Digest = arg == "on"
+		return nil
+	})
+	if err != nil {
+		slog.Error("Error saving digest preference", "chat_id", message.Chat.ID, "error", err)
+		sendErrorMessage(bot, message.Chat.ID, "❌ Failed to save the setting")
+		return
+	}
+	if arg == "on" {
+		sendMessage(bot, message.Chat.ID, "✅ This chat will get a weekly digest every Monday.")
+	} else {
+		sendMessage(bot, message.Chat.ID, "✅ This chat won't get weekly digests anymore.")
+	}
+}
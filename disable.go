@@ -0,0 +1,54 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const chatDisabledMessage = "⏸ Downloads are turned off in this chat. Group admins can turn them back on with /enable."
+
+// downloadCommands are the commands that start downloads right away,
+// which a chat with downloads turned off doesn't take. Jobs for later,
+// from /schedule, /cron and /watch, are held back when they are due.
+var downloadCommands = map[string]bool{"url": true, "zip": true, "shot": true, "audio": true}
+
+func chatDisabled(chatID int64) bool {
+	return loadChatRecord(chatID).Disabled
+}
+
+// handleEnableCommand serves /enable and /disable, which turn the bot's
+// downloads in the chat on and off without removing it.
+func handleEnableCommand(bot *tgbotapi.BotAPI, message *tgbotapi.Message, enable bool) {
+	if !isAuthorized(message) {
+		sendErrorMessage(bot, message.Chat.ID, notAuthorizedMessage)
+		return
+	}
+	if message.From == nil || !canManageChat(bot, message.Chat, message.From.ID) {
+		sendErrorMessage(bot, message.Chat.ID, "🚫 Only admins of this group can turn downloads on or off.")
+		return
+	}
+
+	err := updateRecord(store, bucketChats, chatKey(message.Chat.ID), func(r *chatRecord, exists bool) error {
+		if !exists {
+			r.ID = message.Chat.ID
+			r.Title = message.Chat.Title
+			r.Type = message.Chat.Type
+			r.LastActivity = time.Now()
+		}
+		r.Disabled = !enable
+		return nil
+	})
+	if err != nil {
+		slog.Error("Error saving chat state", "chat_id", message.Chat.ID, "error", err)
+		sendErrorMessage(bot, message.Chat.ID, "❌ Failed to save the setting")
+		return
+	}
+	slog.Info("Chat downloads switched", "chat_id", message.Chat.ID, "user_id", message.From.ID, "enabled", enable)
+	if enable {
+		sendMessage(bot, message.Chat.ID, "▶️ Downloads are on again in this chat.")
+	} else {
+		sendMessage(bot, message.Chat.ID, "⏸ Downloads are off in this chat. Recurring downloads and watched feeds pause too. Turn them back on with /enable.")
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+import "errors"
+
+func diskFree(path string) (int64, error) {
+	return 0, errors.ErrUnsupported
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+)
+
+const diskFullMessage = "💾 The bot is running out of disk space and can't take this file right now. Please try again later."
+
+// checkDiskSpace fails fast when the temp directory can't hold a download
+// of size bytes (or of unknown size, if size is negative) plus
+// cfg.DiskHeadroomMB.
+func checkDiskSpace(size int64) error {
+	free, err := diskFree(cfg.TempDir)
+	if err != nil {
+		// Unsupported platform or an unreadable directory; creating the
+		// temp file will report the latter.
+		return nil
+	}
+
+	need := cfg.DiskHeadroomMB * 1024 * 1024
+	if size > 0 {
+		need += size
+	}
+	if free < need {
+		return &jobError{
+			userMessage: diskFullMessage,
+			result:      resultRejected,
+			err:         fmt.Errorf("need %d bytes in %s, only %d free: %w", need, cfg.TempDir, free, syscall.ENOSPC),
+		}
+	}
+	return nil
+}
+
+func isDiskFull(err error) bool {
+	return errors.Is(err, syscall.ENOSPC)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const dmUnreachableMessage = "✋ I can't message you privately yet. Open a chat with me, press Start and send the link again."
+
+// deliversToDM reports whether the job's file goes to the requester's
+// private chat rather than the group it was asked for in.
+func (j *Job) deliversToDM() bool {
+	return j.options.DM && j.ChatID < 0 && j.UserID != 0
+}
+
+// deliveryChatID is the chat the job's file is sent to.
+func (j *Job) deliveryChatID() int64 {
+	if j.options.TargetChatID != 0 {
+		return j.options.TargetChatID
+	}
+	if j.deliversToDM() {
+		return j.UserID
+	}
+	return j.ChatID
+}
+
+// deliveryReplyID is the message the file replies to, which only exists
+// in the chat the request came from.
+func (j *Job) deliveryReplyID() int {
+	if j.deliveryChatID() != j.ChatID {
+		return 0
+	}
+	return j.MessageID
+}
+
+// canMessageUser checks that the bot may start a private chat with the
+// user, which Telegram only allows after they started the bot themselves.
+func canMessageUser(bot *tgbotapi.BotAPI, userID int64) bool {
+	_, err := bot.Request(tgbotapi.NewChatAction(userID, tgbotapi.ChatTyping))
+	return err == nil
+}
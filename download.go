@@ -0,0 +1,863 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+type ProgressReader struct {
+	io.Reader
+	total      int64
+	downloaded int64
+	onProgress func(float64)
+	// meter, if set, measures the transfer's speed.
+	meter *speedMeter
+}
+
+func (pr *ProgressReader) Read(p []byte) (int, error) {
+	n, err := pr.Reader.Read(p)
+	pr.downloaded += int64(n)
+	if pr.meter != nil && n > 0 {
+		pr.meter.add(n, pr.downloaded, pr.total, time.Now())
+	}
+	if pr.total > 0 {
+		progress := float64(pr.downloaded) / float64(pr.total) * 100
+		pr.onProgress(progress)
+	}
+	return n, err
+}
+
+// jobError pairs the message shown to the user with the underlying cause,
+// which only goes to the logs and the job history.
+type jobError struct {
+	userMessage string
+	result      string
+	err         error
+}
+
+func (e *jobError) Error() string {
+	if e.err == nil {
+		return e.userMessage
+	}
+	return e.err.Error()
+}
+
+func (e *jobError) Unwrap() error {
+	return e.err
+}
+
+const blockedAddressMessage = "🚫 That link points to a private or internal address, which isn't allowed."
+
+func failJob(userMessage string, err error) error {
+	// A more specific explanation from deeper down, e.g. a refused
+	// redirect, wins over the generic one.
+	var inner *jobError
+	if errors.As(err, &inner) {
+		return &jobError{userMessage: inner.userMessage, result: inner.result, err: err}
+	}
+	if errors.Is(err, errBlockedAddress) {
+		return &jobError{userMessage: blockedAddressMessage, result: resultBlocked, err: err}
+	}
+	if errors.Is(err, errStalled) {
+		return &jobError{userMessage: stalledMessage(err), result: resultFailed, err: err}
+	}
+	if errors.Is(err, errDownloadTimeout) {
+		return &jobError{userMessage: downloadTimeoutMessage(err), result: resultFailed, err: err}
+	}
+	if isDiskFull(err) {
+		return &jobError{userMessage: diskFullMessage, result: resultFailed, err: err}
+	}
+	return &jobError{userMessage: userMessage, result: resultFailed, err: err}
+}
+
+func handleURL(bot *tgbotapi.BotAPI, job *Job) {
+	defer jobs.finish(job)
+
+	stats.jobs.Add(1)
+	stats.active.Add(1)
+	defer stats.active.Add(-1)
+	job.applyChatSettings()
+	if job.chatDisabled {
+		// Recurring downloads and feeds pick up again with /enable.
+		if job.automatic {
+			job.logger().Info("Skipping automatic job in a disabled chat")
+			return
+		}
+		job.StartedAt = time.Now()
+		finishJob(bot, job, &jobError{userMessage: chatDisabledMessage, result: resultRejected})
+		return
+	}
+
+	if msg, ok := checkCircuit(job.URL); !ok {
+		job.StartedAt = time.Now()
+		finishJob(bot, job, &jobError{userMessage: msg, result: resultRejected})
+		return
+	}
+
+	if needsMemberApproval(bot, job) {
+		if err := awaitMemberApproval(bot, job); err != nil {
+			job.StartedAt = time.Now()
+			finishJob(bot, job, err)
+			return
+		}
+	}
+
+	if job.options.TargetChat != "" {
+		if err := resolveTargetChat(bot, job); err != nil {
+			job.StartedAt = time.Now()
+			finishJob(bot, job, err)
+			return
+		}
+	}
+
+	if job.deliversToDM() && !canMessageUser(bot, job.UserID) {
+		job.StartedAt = time.Now()
+		finishJob(bot, job, &jobError{userMessage: dmUnreachableMessage, result: resultRejected})
+		return
+	}
+
+	if err := checkTarget(job.ctx, job.URL); err != nil {
+		job.StartedAt = time.Now()
+		finishJob(bot, job, failJob("", err))
+		return
+	}
+
+	gallery := len(job.options.ZipURLs) == 0 && job.options.ShotWidth == 0 && job.options.Destination == "" && isGalleryURL(job.URL)
+	if cfg.VerifyContent && job.options.ShotWidth == 0 && !gallery {
+		probed := job.timeStage("probe")
+		err := probeContent(job.ctx, job.URL)
+		if job.refreshSignature(err) {
+			err = probeContent(job.ctx, job.URL)
+		}
+		if err != nil {
+			job.StartedAt = time.Now()
+			finishJob(bot, job, err)
+			return
+		}
+		probed()
+	}
+
+	statusMsg := tgbotapi.NewMessage(job.ChatID, "⏳ Starting download...")
+	if job.resumed {
+		statusMsg.Text = "🔄 The bot restarted, resuming your download..."
+		statusMsg.ReplyToMessageID = job.MessageID
+	}
+	statusMsg.ReplyMarkup = job.buttons()
+	statusMsg.DisableNotification = job.silent
+	status, err := bot.Send(statusMsg)
+	if err != nil {
+		job.logger().Error("Error sending initial status", "error", err)
+		clearCheckpoint(job)
+		return
+	}
+	job.StatusMessageID = status.MessageID
+	trackStatus(job)
+
+	if job.automatic {
+		if err := deferUnderLoad(bot, job); err != nil {
+			job.StartedAt = time.Now()
+			finishJob(bot, job, err)
+			return
+		}
+	}
+	if !jobSlots.tryAcquire(job) {
+		updateMessage(bot, job.ChatID, status.MessageID, "⏳ Waiting in queue...")
+		go prefetch(job.URL)
+		if err := jobSlots.acquire(job); err != nil {
+			job.StartedAt = time.Now()
+			finishJob(bot, job, err)
+			return
+		}
+	}
+	defer job.releaseSlot()
+	stats.touch()
+
+	// The host may have gone down while the job was waiting.
+	if msg, ok := checkCircuit(job.URL); !ok {
+		job.StartedAt = time.Now()
+		finishJob(bot, job, &jobError{userMessage: msg, result: resultRejected})
+		return
+	}
+
+	job.StartedAt = time.Now()
+	job.setState(jobDownloading)
+	switch {
+	case len(job.options.ZipURLs) > 0:
+		finishJob(bot, job, runZipJob(bot, job))
+	case job.options.ShotWidth > 0:
+		finishJob(bot, job, runShotJob(bot, job))
+	case job.options.Destination != "":
+		finishJob(bot, job, runRcloneJob(bot, job))
+	case gallery:
+		finishJob(bot, job, runGalleryJob(bot, job))
+	default:
+		finishJob(bot, job, runJob(bot, job))
+	}
+}
+
+func finishJob(bot *tgbotapi.BotAPI, job *Job, err error) {
+	untrackStatus(job)
+	if err != nil && interrupted(job) {
+		job.logger().Info("Job interrupted by shutdown", "bytes", job.Size)
+		saveCheckpoint(job, job.partialPath, job.Size)
+		recordJob(job, &jobError{result: resultInterrupted, err: err})
+		job.settleQuota()
+		stats.usage.add(job.usage())
+		if job.StatusMessageID != 0 {
+			updateMessage(bot, job.ChatID, job.StatusMessageID, "⏸ Interrupted by a bot restart, will resume.")
+		}
+		return
+	}
+
+	if err != nil && cancelledByAdmin(job) {
+		err = &jobError{userMessage: "🛑 An admin cancelled this download.", result: resultRejected, err: err}
+	}
+	if err != nil && cancelledByUser(job) {
+		err = &jobError{userMessage: "🛑 Download cancelled.", result: resultRejected, err: err}
+	}
+
+	clearCheckpoint(job)
+	recordJob(job, err)
+	job.settleQuota()
+	stats.usage.add(job.usage())
+	recordHostResult(job, err)
+
+	if err != nil {
+		var jerr *jobError
+		if !errors.As(err, &jerr) {
+			jerr = &jobError{userMessage: "❌ Something went wrong", err: err}
+		}
+		job.logger().Warn("Job failed", "result", jerr.result, "bytes", job.Size, "duration", time.Since(job.StartedAt), "error", err)
+		if jerr.result == "" || jerr.result == resultFailed {
+			stats.failed.Add(1)
+		}
+
+		if job.StatusMessageID != 0 {
+			bot.Request(tgbotapi.NewEditMessageReplyMarkup(job.ChatID, job.StatusMessageID,
+				tgbotapi.InlineKeyboardMarkup{InlineKeyboard: [][]tgbotapi.InlineKeyboardButton{}}))
+		}
+		msg := tgbotapi.NewMessage(job.ChatID, jerr.userMessage)
+		report := cfg.LogChannelID != 0 && jerr.result == resultFailed
+		if report {
+			saveDiagnostics(job, err)
+		}
+		if markup := failureButtons(job, jerr, report); markup != nil {
+			msg.ReplyMarkup = markup
+		}
+		bot.Send(msg)
+		return
+	}
+
+	job.logger().Info("Job finished", "file", job.FileName, "bytes", job.Size, "duration", time.Since(job.StartedAt))
+	stats.succeeded.Add(1)
+	stats.bytesTotal.Add(job.Size)
+	if job.options.CronID != 0 {
+		noteCronRun(job)
+	}
+	if job.unchanged {
+		bot.Request(tgbotapi.NewDeleteMessage(job.ChatID, job.StatusMessageID))
+		return
+	}
+	if job.savedTo != "" {
+		updateMessage(bot, job.ChatID, job.StatusMessageID, "✅ Saved to "+job.savedTo+job.redirectNote()+job.checksumNote())
+		return
+	}
+	if job.options.TargetChatID != 0 {
+		updateMessage(bot, job.ChatID, job.StatusMessageID, "✅ Posted to "+job.options.TargetChat+".")
+		routeDelivery(bot, job)
+		return
+	}
+	if job.deliversToDM() {
+		updateMessage(bot, job.ChatID, job.StatusMessageID, "✅ Sent to you in a private chat.")
+		routeDelivery(bot, job)
+		return
+	}
+	if job.options.Card {
+		updateMessage(bot, job.ChatID, job.StatusMessageID, "✅ Card posted."+job.redirectNote())
+		return
+	}
+	if job.storedInS3 {
+		updateMessage(bot, job.ChatID, job.StatusMessageID, "✅ Uploaded to storage."+job.redirectNote()+job.checksumNote())
+		routeDelivery(bot, job)
+		return
+	}
+	updateMessage(bot, job.ChatID, job.StatusMessageID, job.transferSummary()+job.redirectNote()+job.checksumNote())
+	routeDelivery(bot, job)
+}
+
+// transferSummary is the completion message of a sent file: what was
+// sent, how much, from where, how long it took and how fast it came.
+func (j *Job) transferSummary() string {
+	line := "📦 " + formatJobSize(j.Size)
+	if host := urlHost(j.URL); host != "" {
+		line += " from " + host
+	}
+	if took := time.Since(j.StartedAt); took < time.Second {
+		line += " in under a second"
+	} else {
+		line += " in " + took.Round(time.Second).String()
+	}
+	if j.reusedUpload {
+		line += ", sent again from an earlier upload"
+	} else {
+		for _, stage := range []string{"download", "stream"} {
+			if m := j.existingMeter(stage); m != nil {
+				if average, _, _ := m.rates(); average > 0 {
+					line += ", " + formatSpeed(average) + " on average"
+					break
+				}
+			}
+		}
+	}
+	return "✅ Sent " + j.FileName + "\n" + line
+}
+
+// resolveFileName is the name the job's file goes by: the one given with
+// --name, or else the last part of the link, marked with the range if only
+// part of a file of fullSize bytes is fetched.
+func (j *Job) resolveFileName(fullSize int64) string {
+	if j.options.FileName != "" {
+		return safeFileName(j.options.FileName)
+	}
+	fileName := safeFileName(filepath.Base(j.URL))
+	if j.options.Range != nil {
+		fileName = j.options.Range.fileName(fileName, fullSize)
+	}
+	return fileName
+}
+
+// safeFileName keeps name from being empty or naming a directory, so it
+// can be joined onto a path.
+func safeFileName(name string) string {
+	if name, ok := validFileName(name); ok {
+		return name
+	}
+	return "downloaded_file"
+}
+
+// validFileName is the last part of name, if that is a file name rather
+// than empty or a directory.
+func validFileName(name string) (string, bool) {
+	name = filepath.Base(strings.TrimSpace(name))
+	return name, name != "." && name != ".." && name != "/"
+}
+
+func runJob(bot *tgbotapi.BotAPI, job *Job) error {
+	url := job.URL
+
+	fileSize, headHeader, err := fetchInfo(job)
+	if err != nil {
+		return err
+	}
+
+	fullSize := fileSize
+	if r := job.options.Range; r != nil {
+		if fileSize > 0 && r.Start >= fileSize {
+			return &jobError{userMessage: "❌ That range starts past the end of the file.", result: resultRejected}
+		}
+		fileSize = r.size(fileSize)
+	}
+	job.setExpectedSize(fileSize)
+	fileName := job.resolveFileName(fullSize)
+	job.setFileName(fileName)
+	if err := job.checkFileType(job.deliveredName(fileName), headHeader.Get("Content-Type")); err != nil {
+		return err
+	}
+	if job.options.Card {
+		return sendCard(bot, job, fileSize, headHeader)
+	}
+
+	job.limitMB = chatMaxFileSizeMB(job.ChatID)
+	// A text file that only misses the limit by the upload overhead is
+	// worth compressing rather than turning away.
+	if fileSize > job.uploadLimit() && fileSize <= job.limitMB*1024*1024 && !job.shrinks() &&
+		isCompressible(fileName, headHeader.Get("Content-Type")) {
+		job.logger().Info("File only fits without the upload overhead, compressing it", "size", fileSize)
+		job.options.Compress = true
+	}
+	if fileSize > job.downloadLimit() {
+		return tooLargeError(fileSize, job.downloadLimitMB())
+	}
+
+	if reuseUpload(bot, job, headHeader, fileSize) {
+		return nil
+	}
+
+	if quotaMsg, ok := job.reserveQuota(fileSize); !ok {
+		return &jobError{userMessage: quotaMsg, result: resultRejected}
+	}
+	if job.deferredUnderLoad(fileSize) {
+		if err := deferUnderLoad(bot, job); err != nil {
+			return err
+		}
+	}
+
+	if job.canStream(fileSize) {
+		job.planStages("stream")
+		return streamJob(bot, job, fileSize)
+	}
+	if job.options.Encrypt && job.options.passphrase == "" {
+		return &jobError{userMessage: passphraseLostMessage, result: resultRejected}
+	}
+	job.offerRename()
+	if job.options.processes() {
+		job.planStages("download", "process", "upload")
+	} else {
+		job.planStages("download", "upload")
+	}
+
+	dir := job.bufferDir(fileSize)
+	defer job.releaseBuffer()
+	if dir == cfg.TempDir {
+		if err := checkDiskSpace(fileSize); err != nil {
+			return err
+		}
+	}
+
+	tempFile, resumeFrom, err := openTempFile(job, dir, fileName)
+	if err != nil {
+		return failJob("❌ Failed to create temporary file", err)
+	}
+	saveCheckpoint(job, tempFile.Name(), resumeFrom)
+	keepTemp := false
+	defer func() {
+		tempFile.Close()
+		if !keepTemp {
+			os.Remove(tempFile.Name())
+		}
+	}()
+
+	header, cached := loadFromCache(job, tempFile, headHeader)
+	if !cached {
+		header, err = downloadWithRetries(bot, job, tempFile, fileSize, resumeFrom)
+		if err != nil && interrupted(job) {
+			job.partialPath = spillPartial(job, tempFile)
+			keepTemp = job.partialPath == tempFile.Name()
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	job.noteDiskBytes(job.Size)
+
+	if err := verifyChecksum(job, tempFile); err != nil {
+		return err
+	}
+	if err := scanJobFile(bot, job, tempFile); err != nil {
+		return err
+	}
+	if !cached {
+		storeInCache(job, tempFile, header)
+	}
+	if job.unchangedSinceLastRun() {
+		job.logger().Info("Recurring download unchanged, not posting it")
+		job.unchanged = true
+		return nil
+	}
+
+	hashInfo, listed := lookupHash(job.SHA256)
+	if listed && hashInfo.Verdict == hashDeny {
+		job.logger().Warn("Blocked denylisted file", "sha256", job.SHA256)
+		return &jobError{userMessage: "🚫 This file is blocked by the bot operator.", result: resultBlocked}
+	}
+	if reason, held := holdReason(job, hashInfo, listed); held {
+		if err := awaitApproval(bot, job, reason); err != nil {
+			return err
+		}
+	}
+
+	delivered, deliveredSize := tempFile, job.Size
+	hooked, err := runHooks(bot, job, tempFile, header.Get("Content-Type"))
+	if err != nil {
+		return err
+	}
+	if hooked != nil {
+		defer func() {
+			hooked.Close()
+			os.Remove(hooked.Name())
+		}()
+		info, err := hooked.Stat()
+		if err != nil {
+			return failJob("❌ Failed to run the checks", err)
+		}
+		job.noteDiskBytes(info.Size())
+		delivered, deliveredSize = hooked, info.Size()
+	}
+
+	caption := buildHashtags(classifyFile(fileName, header.Get("Content-Type")), url)
+	var file tgbotapi.RequestFileData
+	transformed, note, err := transformJobFile(bot, job, delivered, header.Get("Content-Type"))
+	if err != nil {
+		return err
+	}
+	if transformed != nil {
+		defer func() {
+			transformed.Close()
+			os.Remove(transformed.Name())
+		}()
+		info, err := transformed.Stat()
+		if err != nil {
+			return failJob("❌ Failed to process the file", err)
+		}
+		job.noteDiskBytes(info.Size())
+		delivered, deliveredSize = transformed, info.Size()
+	}
+	if note != "" {
+		if caption != "" {
+			caption += "\n"
+		}
+		caption += note
+	}
+	if name := job.customFileName(); name != "" {
+		job.setFileName(name)
+	}
+	var uploadedID string
+	reused := false
+	if delivered == tempFile && !job.options.Encrypt {
+		uploadedID, reused = lookupUpload(bot, job)
+	}
+	switch {
+	case job.options.Encrypt:
+		encrypted, err := encryptJobFile(bot, job, delivered)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			encrypted.Close()
+			os.Remove(encrypted.Name())
+		}()
+		info, err := encrypted.Stat()
+		if err != nil {
+			return failJob("❌ Failed to encrypt the file", err)
+		}
+		job.noteDiskBytes(info.Size())
+		delivered = encrypted
+		job.setFileName(job.FileName + ".enc")
+		file = tgbotapi.FileReader{Name: job.FileName, Reader: &ProgressReader{
+			Reader:     job.throttle(encrypted, "upload"),
+			total:      info.Size(),
+			onProgress: progressUpdater(bot, job, "upload", "📤 Uploading to Telegram..."+job.redirectNote()),
+			meter:      job.meter("upload"),
+		}}
+		// Hashtags would give away what the file is and where it's from.
+		caption = decryptInstructions
+	case listed && hashInfo.FileID != "" && delivered == tempFile:
+		file = tgbotapi.FileID(hashInfo.FileID)
+	case reused:
+		file = tgbotapi.FileID(uploadedID)
+		job.logger().Info("Sending file by file_id from an earlier upload")
+	default:
+		job.setFileName(resolveNameCollision(bot, job))
+		delivered.Seek(0, 0)
+		file = tgbotapi.FileReader{Name: job.FileName, Reader: &ProgressReader{
+			Reader:     job.throttle(delivered, "upload"),
+			total:      deliveredSize,
+			onProgress: progressUpdater(bot, job, "upload", "📤 Uploading to Telegram..."+job.redirectNote()),
+			meter:      job.meter("upload"),
+		}}
+	}
+
+	if job.overflowLimitMB() > 0 {
+		if info, err := delivered.Stat(); err == nil && info.Size() > job.uploadLimit() {
+			return sendViaS3(bot, job, delivered, info.Size(), caption)
+		}
+	}
+
+	var signature []byte
+	if signer != nil {
+		if signature, err = signFile(delivered, job.FileName); err != nil {
+			return failJob("❌ Failed to sign the file", err)
+		}
+	}
+
+	releaseUpload, err := acquireUploadSlot(bot, job, false)
+	if err != nil {
+		return err
+	}
+	defer releaseUpload()
+	job.setState(jobUploading)
+	updateMessage(bot, job.ChatID, job.StatusMessageID, "📤 Uploading to Telegram..."+job.redirectNote())
+
+	var doc tgbotapi.Chattable
+	if job.options.AudioFormat != "" && !job.options.Encrypt {
+		audio := tgbotapi.NewAudio(job.deliveryChatID(), file)
+		audio.ReplyToMessageID = job.deliveryReplyID()
+		audio.DisableNotification = job.silent
+		audio.Caption = caption
+		audio.Title = strings.TrimSuffix(job.FileName, filepath.Ext(job.FileName))
+		doc = audio
+	} else {
+		document := tgbotapi.NewDocument(job.deliveryChatID(), file)
+		document.ReplyToMessageID = job.deliveryReplyID()
+		document.DisableNotification = job.silent
+		document.Caption = caption
+		doc = document
+	}
+
+	if err := job.spendAttempt("upload"); err != nil {
+		return err
+	}
+	uploaded := job.timeStage("upload")
+	sent, err := bot.Send(doc)
+	if err != nil {
+		if reused {
+			forgetUpload(bot, job)
+		}
+		return failJob(describeSendError(err, "❌ Failed to send the file"), err)
+	}
+	uploaded()
+	indexDelivery(job, sent)
+	if listed && hashInfo.FileID == "" && sent.Document != nil && delivered == tempFile {
+		rememberHashFileID(job.SHA256, sent.Document.FileID)
+	}
+	if !reused && sent.Document != nil && delivered == tempFile {
+		rememberUpload(bot, job, sent.Document.FileID, header)
+	}
+	if signature != nil {
+		sendSignature(bot, job, sent.MessageID, signature)
+	}
+	return nil
+}
+
+// transformJobFile makes the file that is sent out of the download, for
+// /audio, image conversion and --compress. It returns nil when the
+// download is sent as is, and a note for the caption.
+func transformJobFile(bot *tgbotapi.BotAPI, job *Job, file *os.File, contentType string) (*os.File, string, error) {
+	switch opts := job.options; {
+	case opts.AudioFormat != "":
+		audio, err := extractAudio(bot, job, file)
+		if err != nil {
+			return nil, "", err
+		}
+		job.setFileName(audioFileName(job.FileName, opts.AudioFormat))
+		return audio, "", nil
+	case opts.ImageFormat != "" || opts.MaxDim > 0:
+		return convertImage(bot, job, file, contentType)
+	case opts.Compress:
+		compressed, err := compressJobFile(bot, job, file, contentType)
+		if err != nil || compressed == nil {
+			return nil, "", err
+		}
+		info, err := compressed.Stat()
+		if err != nil {
+			compressed.Close()
+			os.Remove(compressed.Name())
+			return nil, "", failJob("❌ Failed to compress the file", err)
+		}
+		note := fmt.Sprintf("🗜 Compressed from %.1f MB to %.1f MB", float64(job.Size)/1024/1024, float64(info.Size())/1024/1024)
+		job.setFileName(job.FileName + ".gz")
+		return compressed, note, nil
+	}
+	return nil, "", nil
+}
+
+// downloadWithRetries runs fetchToFile, starting over after a transient
+// failure (on another mirror if the host is on a known mirror network,
+// otherwise up to cfg.DownloadRetries times, backing off in between) or
+// when its pre-signed URL could be signed again.
+func downloadWithRetries(bot *tgbotapi.BotAPI, job *Job, file *os.File, headSize, resumeFrom int64) (http.Header, error) {
+	retries := 0
+	for {
+		downloaded := job.timeStage("download")
+		header, err := fetchToFile(bot, job, file, headSize, resumeFrom)
+		resumeFrom = 0
+		if err == nil {
+			downloaded()
+			return header, nil
+		}
+		if job.refreshSignature(err) {
+			continue
+		}
+		if !isTransient(err) {
+			return nil, err
+		}
+		if mirror, ok := job.nextMirror(); ok {
+			job.logger().Warn("Download failed, switching mirror", "mirror", urlHost(mirror), "error", err)
+			updateMessage(bot, job.ChatID, job.StatusMessageID, "⚠️ Download failed, trying the mirror "+urlHost(mirror)+"...")
+			continue
+		}
+		retries++
+		if retries > cfg.DownloadRetries {
+			return nil, err
+		}
+
+		delay := retryDelay(retries)
+		job.logger().Warn("Download failed, retrying", "attempt", retries+1, "delay", delay, "error", err)
+		updateMessage(bot, job.ChatID, job.StatusMessageID,
+			fmt.Sprintf("⚠️ Download failed, retrying in %s (%d/%d)...", delay.Round(time.Second), retries+1, cfg.DownloadRetries+1))
+		select {
+		case <-time.After(delay):
+		case <-job.ctx.Done():
+			return nil, err
+		}
+	}
+}
+
+// openTempFile reopens the partial download of a resumed job if it is still
+// around, returning how many bytes it already has, or creates a new one.
+func openTempFile(job *Job, dir, fileName string) (*os.File, int64, error) {
+	if strings.HasPrefix(job.partialPath, spilledPrefix) {
+		return restorePartial(job, dir, fileName)
+	}
+	if job.partialPath != "" {
+		file, err := os.OpenFile(job.partialPath, os.O_RDWR, 0)
+		if err == nil {
+			if info, err := file.Stat(); err == nil {
+				return file, info.Size(), nil
+			}
+			file.Close()
+		}
+		job.logger().Info("Partial download is gone, starting over", "path", job.partialPath)
+	}
+	file, err := os.CreateTemp(dir, "telegram-*-"+fileName)
+	return file, 0, err
+}
+
+type truncatedError struct {
+	got, want int64
+}
+
+func (e *truncatedError) Error() string {
+	return fmt.Sprintf("download truncated: got %d of %d bytes", e.got, e.want)
+}
+
+// requestFile starts the download of job.URL, from offset if positive. The
+// response body is watched for stalls and stops the request when closed.
+func requestFile(job *Job, offset int64) (*http.Response, error) {
+	if err := job.spendAttempt("download"); err != nil {
+		return nil, err
+	}
+	ctx, watchdog := downloadContext(job)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, job.downloadURL(), nil)
+	if err != nil {
+		watchdog.stop()
+		return nil, failJob("❌ That doesn't look like a valid URL.", err)
+	}
+	switch {
+	case job.options.Range != nil:
+		req.Header.Set("Range", job.options.Range.header(offset))
+	case offset > 0:
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		// Gets the whole file instead if it changed since.
+		if job.partialValidator != "" {
+			req.Header.Set("If-Range", job.partialValidator)
+		}
+	}
+	job.setLanguage(req)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		watchdog.stop()
+		return nil, failJob("❌ Failed to download the file", watchdog.cause(err))
+	}
+	watchdog.ReadCloser = resp.Body
+	resp.Body = watchdog
+	return resp, nil
+}
+
+// fetchToFile downloads job.URL into file and records the size and SHA-256
+// on the job. A positive offset asks the server for the rest of a partial
+// download already in file; otherwise, or if the server won't serve that
+// range of the same file, whatever an earlier attempt left there is
+// replaced. A body shorter
+// than the announced length is reported as a truncatedError.
+func fetchToFile(bot *tgbotapi.BotAPI, job *Job, file *os.File, headSize, offset int64) (http.Header, error) {
+	resp, err := requestFile(job, offset)
+	if err != nil {
+		return nil, err
+	}
+	if job.options.Range != nil {
+		if err := checkRangeResponse(resp); err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+	}
+	if offset > 0 && (resp.StatusCode != http.StatusPartialContent || contentRangeStart(resp) != offset) {
+		job.logger().Info("Server didn't honor the range, starting over", "status", resp.Status)
+		offset = 0
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			if resp, err = requestFile(job, 0); err != nil {
+				return nil, err
+			}
+		}
+	}
+	defer resp.Body.Close()
+	job.responseHeader = resp.Header
+	job.noteFinalURL(resp)
+	if err := checkPresignedResponse(resp); err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		return nil, statusError(resp)
+	}
+	job.partialValidator = rangeValidator(resp.Header)
+
+	hasher := sha256.New()
+	if offset > 0 {
+		// The hash has to cover the part downloaded before the restart too.
+		if _, err := io.Copy(hasher, io.NewSectionReader(file, 0, offset)); err != nil {
+			return nil, failJob("❌ Failed to save the file", err)
+		}
+	} else if err := file.Truncate(0); err != nil {
+		return nil, failJob("❌ Failed to save the file", err)
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return nil, failJob("❌ Failed to save the file", err)
+	}
+
+	// A transparently decompressed body has no meaningful length to compare
+	// against, and HEAD may have described the compressed representation.
+	expected := resp.ContentLength
+	if expected < 0 && !resp.Uncompressed && offset == 0 {
+		expected = headSize
+	} else if expected >= 0 {
+		expected += offset
+	}
+
+	limit := job.downloadLimit()
+	if job.memoryReserved > 0 {
+		// cfg.MemoryDir only has room for the size HEAD announced.
+		limit = min(limit, job.memoryReserved)
+	}
+	progressReader := &ProgressReader{
+		Reader:     &sizeGuard{Reader: job.throttle(resp.Body, "download"), limit: limit - offset},
+		total:      expected,
+		downloaded: offset,
+		onProgress: progressUpdater(bot, job, "download", "⏬ Downloading..."+job.redirectNote()),
+		meter:      job.meter("download"),
+	}
+
+	_, err = io.CopyBuffer(io.MultiWriter(file, hasher), progressReader, make([]byte, copyBufferSize()))
+	job.Size = progressReader.downloaded
+
+	// net/http reports a body cut short of its Content-Length as an
+	// unexpected EOF; count that as truncation too so it gets retried.
+	if errors.Is(err, io.ErrUnexpectedEOF) || (err == nil && expected > 0 && job.Size != expected) {
+		return nil, failJob("❌ The download was cut off before it finished. Please try again later.",
+			&truncatedError{got: job.Size, want: expected})
+	}
+	if errors.Is(err, errTooLarge) && limit < job.downloadLimit() {
+		return nil, failJob("❌ The server sent more than the file size it announced.",
+			fmt.Errorf("download grew past the %d bytes announced", job.memoryReserved))
+	}
+	if errors.Is(err, errTooLarge) {
+		return nil, tooLargeError(job.Size, job.downloadLimitMB())
+	}
+	if err != nil {
+		return nil, failJob("❌ Failed to save the file", err)
+	}
+
+	job.SHA256 = hex.EncodeToString(hasher.Sum(nil))
+	return resp.Header, nil
+}
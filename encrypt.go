@@ -0,0 +1,145 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// Encrypted files are a header of encryptMagic, a 16-byte salt and a
+// 4-byte nonce prefix, then the content in AES-256-GCM sealed chunks of
+// encryptChunkSize. Chunk i uses the nonce prefix followed by i as a
+// big-endian uint64, and its additional data is a single byte, 1 for the
+// last chunk and 0 otherwise, so a file cut off at a chunk boundary fails
+// to decrypt. The last chunk is the first one shorter than a full chunk,
+// possibly empty. The key is PBKDF2-HMAC-SHA256 of the passphrase.
+const (
+	encryptMagic      = "TGE1"
+	encryptChunkSize  = 64 << 10
+	encryptIterations = 600000
+)
+
+// decryptInstructions go in the caption of encrypted files. The script
+// follows the format described above.
+const decryptInstructions = `🔒 Encrypted with AES-256-GCM. To decrypt, save this as decrypt.py and run "python3 decrypt.py FILE PASSPHRASE > output" (needs "pip install cryptography"):
+
+import sys,hashlib
+from cryptography.hazmat.primitives.ciphers.aead import AESGCM
+f=open(sys.argv[1],'rb');h=f.read(24);o=sys.stdout.buffer
+k=hashlib.pbkdf2_hmac('sha256',sys.argv[2].encode(),h[4:20],600000)
+a,i,e=AESGCM(k),0,0
+while not e:
+ c=f.read(65552);e=len(c)<65552
+ o.write(a.decrypt(h[20:]+i.to_bytes(8,'big'),c,bytes([e])));i+=1`
+
+const passphraseLostMessage = "❌ The bot restarted, and passphrases aren't kept across restarts. Please send the request again."
+
+// hidePassphrase deletes a command that gives --encrypt a passphrase, so
+// the chat doesn't keep the key next to the file it opens. If the message
+// can't be deleted it tells the user and returns false, and the command
+// shouldn't go ahead.
+func hidePassphrase(bot *tgbotapi.BotAPI, message *tgbotapi.Message) bool {
+	if !mentionsEncrypt(message.Text) {
+		return true
+	}
+	if _, err := bot.Request(tgbotapi.NewDeleteMessage(message.Chat.ID, message.MessageID)); err != nil {
+		slog.Warn("Couldn't delete a message with a passphrase", "chat_id", message.Chat.ID, "error", err)
+		sendErrorMessage(bot, message.Chat.ID, "❌ Couldn't delete your message, so the passphrase in it is still visible here. Don't use that passphrase, and either let the bot delete messages or use --encrypt in a private chat with it.")
+		return false
+	}
+	return true
+}
+
+func mentionsEncrypt(text string) bool {
+	for _, field := range strings.Fields(text) {
+		name, _, _ := strings.Cut(strings.ToLower(strings.Trim(field, `"'`)), "=")
+		if name == "--encrypt" {
+			return true
+		}
+	}
+	return false
+}
+
+// encryptFile writes src, encrypted with passphrase, to dst.
+func encryptFile(dst io.Writer, src io.Reader, passphrase string) error {
+	header := make([]byte, len(encryptMagic)+16+4)
+	copy(header, encryptMagic)
+	if _, err := rand.Read(header[len(encryptMagic):]); err != nil {
+		return err
+	}
+	salt := header[len(encryptMagic) : len(encryptMagic)+16]
+	block, err := aes.NewCipher(pbkdf2.Key([]byte(passphrase), salt, encryptIterations, 32, sha256.New))
+	if err != nil {
+		return err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+	if _, err := dst.Write(header); err != nil {
+		return err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	copy(nonce, header[len(header)-4:])
+	plain := make([]byte, encryptChunkSize)
+	sealed := make([]byte, 0, encryptChunkSize+aead.Overhead())
+	for i := uint64(0); ; i++ {
+		n, err := io.ReadFull(src, plain)
+		last := n < encryptChunkSize
+		if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return err
+		}
+		binary.BigEndian.PutUint64(nonce[4:], i)
+		ad := []byte{0}
+		if last {
+			ad[0] = 1
+		}
+		if _, err := dst.Write(aead.Seal(sealed[:0], nonce, plain[:n], ad)); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+	}
+}
+
+// encryptJobFile encrypts the downloaded file into a new temp file, which
+// the caller has to remove.
+func encryptJobFile(bot *tgbotapi.BotAPI, job *Job, file *os.File) (*os.File, error) {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, failJob("❌ Failed to encrypt the file", err)
+	}
+	encrypted, err := os.CreateTemp(cfg.TempDir, "telegram-*.enc")
+	if err != nil {
+		return nil, failJob("❌ Failed to encrypt the file", err)
+	}
+	src := &ProgressReader{
+		Reader:     file,
+		total:      job.Size,
+		onProgress: progressUpdater(bot, job, "process", "🔒 Encrypting..."),
+	}
+	done := job.timeStage("encrypt")
+	if err := encryptFile(encrypted, src, job.options.passphrase); err != nil {
+		encrypted.Close()
+		os.Remove(encrypted.Name())
+		return nil, failJob("❌ Failed to encrypt the file", err)
+	}
+	if _, err := encrypted.Seek(0, io.SeekStart); err != nil {
+		encrypted.Close()
+		os.Remove(encrypted.Name())
+		return nil, failJob("❌ Failed to encrypt the file", err)
+	}
+	done()
+	return encrypted, nil
+}
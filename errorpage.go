@@ -0,0 +1,138 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// errorPageScanSize is how much of an error page is read looking for a
+// known message; the interesting part is normally near the top.
+const errorPageScanSize = 64 << 10
+
+// errorPagePattern recognises an error page a file host serves in place of
+// the file. Phrases are matched in lower case and include the translations
+// the host serves to visitors in other languages, since it picks the
+// language from the requester's location and the bot's server may be
+// anywhere.
+type errorPagePattern struct {
+	// hosts limits the pattern to these domains and their subdomains; an
+	// empty list matches any host.
+	hosts   []string
+	phrases []string
+	hint    string
+}
+
+var errorPagePatterns = []errorPagePattern{
+	{
+		hosts: []string{"drive.google.com", "docs.google.com", "drive.usercontent.google.com"},
+		phrases: []string{
+			"too many users have viewed or downloaded this file recently",
+			"download quota for this file has been exceeded",
+			"zu viele nutzer haben diese datei kürzlich",
+			"demasiados usuarios han visto o descargado este archivo",
+			"trop d'utilisateurs ont consulté ou téléchargé ce fichier",
+			"troppi utenti hanno visualizzato o scaricato questo file",
+			"muitos usuários visualizaram ou fizeram download deste arquivo",
+			"слишком много пользователей просмотрели или скачали этот файл",
+			"bu dosyayı son zamanlarda çok fazla kullanıcı",
+		},
+		hint: "📛 Google Drive quota exceeded for this file. Please try again in 24h, or make a copy of it in your own Drive and share that.",
+	},
+	{
+		hosts: []string{"drive.google.com", "docs.google.com", "drive.usercontent.google.com"},
+		phrases: []string{
+			"can't scan this file for viruses",
+			"kann diese datei nicht auf viren prüfen",
+			"no puede analizar este archivo en busca de virus",
+			"impossible de rechercher la présence de virus",
+			"не удается проверить этот файл на наличие вирусов",
+		},
+		hint: "📛 Google Drive asks for a confirmation before downloading large files. Please add &confirm=t to the link and try again.",
+	},
+	{
+		hosts: []string{"accounts.google.com", "drive.google.com", "docs.google.com"},
+		phrases: []string{
+			"you need access",
+			"request access",
+			"sie benötigen eine zugriffsberechtigung",
+			"necesitas acceso",
+			"vous avez besoin d'une autorisation",
+			"вам нужен доступ",
+		},
+		hint: "🔒 This Google Drive file isn't shared publicly. Please set it to \"Anyone with the link\" and try again.",
+	},
+	{
+		hosts: []string{"dropbox.com", "dropboxusercontent.com"},
+		phrases: []string{
+			"this link is temporarily disabled",
+			"generating too much traffic",
+			"dieser link ist vorübergehend deaktiviert",
+			"este vínculo se inhabilitó temporalmente",
+			"ce lien est temporairement désactivé",
+		},
+		hint: "📛 Dropbox has temporarily disabled this link because of too much traffic. Please try again later.",
+	},
+	{
+		hosts: []string{"onedrive.live.com", "1drv.ms", "sharepoint.com"},
+		phrases: []string{
+			"this item might not exist or is no longer available",
+			"dieses element ist möglicherweise nicht vorhanden",
+			"es posible que este elemento no exista",
+			"cet élément n'existe peut-être pas",
+		},
+		hint: "❌ OneDrive says this file doesn't exist or is no longer shared.",
+	},
+	{
+		hosts:   []string{"mediafire.com"},
+		phrases: []string{"invalid or deleted file", "file has been removed"},
+		hint:    "❌ MediaFire says this file was deleted.",
+	},
+	{
+		phrases: []string{"<title>just a moment...</title>", "cf-challenge", "challenges.cloudflare.com"},
+		hint:    "🤖 The site shows a bot check (Cloudflare) instead of the file, so it can't be downloaded directly.",
+	},
+	{
+		phrases: []string{"bandwidth limit exceeded", "download limit exceeded", "quota exceeded"},
+		hint:    "📛 The site says its download limit was reached. Please try again later.",
+	},
+	{
+		phrases: []string{`type="password"`, "type=password"},
+		hint:    "🔒 The site asks to log in before it hands out the file.",
+	},
+}
+
+// apostrophes normalises the ways pages spell the apostrophe in phrases
+// like "can't".
+var apostrophes = strings.NewReplacer("&#39;", "'", "&#x27;", "'", "’", "'")
+
+var htmlLangPattern = regexp.MustCompile(`(?i)<html[^>]*\slang=["']?([a-zA-Z-]+)`)
+
+// errorPageHint reads the start of an HTML response body, after the head
+// that was already read from it, and returns a specific hint if it is a
+// known error page, with the language the page declares for the logs.
+func errorPageHint(resp *http.Response, head []byte) (hint, lang string, ok bool) {
+	if !strings.Contains(resp.Header.Get("Content-Type"), "html") &&
+		!strings.HasPrefix(http.DetectContentType(head), "text/html") {
+		return "", "", false
+	}
+	rest, _ := io.ReadAll(io.LimitReader(resp.Body, errorPageScanSize-int64(len(head))))
+	page := apostrophes.Replace(strings.ToLower(string(head) + string(rest)))
+	if m := htmlLangPattern.FindStringSubmatch(page); m != nil {
+		lang = m[1]
+	}
+
+	host := resp.Request.URL.Hostname()
+	for _, p := range errorPagePatterns {
+		if len(p.hosts) > 0 && !matchesAnyDomain(host, p.hosts) {
+			continue
+		}
+		for _, phrase := range p.phrases {
+			if strings.Contains(page, phrase) {
+				return p.hint, lang, true
+			}
+		}
+	}
+	return "", lang, false
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// slotScheduler hands out the cfg.MaxConcurrentJobs job slots. When jobs
+// wait, a freed slot goes to the chat running the fewest jobs for its
+// weight, so one busy group can't take every slot however many of its
+// members send links. Within a chat jobs start in the order they came.
+// A user already running userCap jobs waits even when slots are free.
+type slotScheduler struct {
+	mu       sync.Mutex
+	capacity int
+	userCap  int
+	inUse    int
+	running  map[int64]int
+	users    map[int64]int
+	waiting  []*slotWaiter
+}
+
+type slotWaiter struct {
+	chatID  int64
+	userID  int64
+	granted chan struct{}
+}
+
+func newSlotScheduler(capacity, userCap int) *slotScheduler {
+	return &slotScheduler{capacity: capacity, userCap: userCap, running: map[int64]int{}, users: map[int64]int{}}
+}
+
+func (s *slotScheduler) used() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.inUse
+}
+
+// queued is how many jobs wait for a slot.
+func (s *slotScheduler) queued() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.waiting)
+}
+
+func (s *slotScheduler) size() int {
+	return s.capacity
+}
+
+// tryAcquire takes a slot for the job if one is free and no other job is
+// waiting for it.
+func (s *slotScheduler) tryAcquire(job *Job) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.free(job.UserID) {
+		return false
+	}
+	s.grant(job.ChatID, job.UserID)
+	job.holdsSlot = true
+	return true
+}
+
+// acquire waits for a slot for the job, until it is bumped or its context
+// is done.
+func (s *slotScheduler) acquire(job *Job) error {
+	s.mu.Lock()
+	if s.free(job.UserID) {
+		s.grant(job.ChatID, job.UserID)
+		s.mu.Unlock()
+		job.holdsSlot = true
+		return nil
+	}
+	w := &slotWaiter{chatID: job.ChatID, userID: job.UserID, granted: make(chan struct{})}
+	s.waiting = append(s.waiting, w)
+	s.mu.Unlock()
+
+	var err error
+	select {
+	case <-w.granted:
+		job.holdsSlot = true
+		return nil
+	case <-job.bumped:
+		job.logger().Info("Job bumped ahead of the queue")
+	case <-job.ctx.Done():
+		err = job.ctx.Err()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, other := range s.waiting {
+		if other == w {
+			s.waiting = append(s.waiting[:i], s.waiting[i+1:]...)
+			return err
+		}
+	}
+	// The slot was granted as the job stopped waiting.
+	s.releaseLocked(job.ChatID, job.UserID)
+	return err
+}
+
+func (s *slotScheduler) release(job *Job) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.releaseLocked(job.ChatID, job.UserID)
+}
+
+// free reports whether a job of userID may take a slot right away: one
+// is free, the user is under userCap and no waiting job could have it.
+func (s *slotScheduler) free(userID int64) bool {
+	return s.inUse < s.capacity && s.userFree(userID) && s.pick() < 0
+}
+
+func (s *slotScheduler) userFree(userID int64) bool {
+	return s.userCap <= 0 || userID == 0 || isAdmin(userID) || s.users[userID] < s.userCap
+}
+
+func (s *slotScheduler) grant(chatID, userID int64) {
+	s.inUse++
+	s.running[chatID]++
+	s.users[userID]++
+}
+
+func (s *slotScheduler) releaseLocked(chatID, userID int64) {
+	s.inUse--
+	if s.running[chatID]--; s.running[chatID] <= 0 {
+		delete(s.running, chatID)
+	}
+	if s.users[userID]--; s.users[userID] <= 0 {
+		delete(s.users, userID)
+	}
+	for s.inUse < s.capacity {
+		next := s.pick()
+		if next < 0 {
+			break
+		}
+		w := s.waiting[next]
+		s.waiting = append(s.waiting[:next], s.waiting[next+1:]...)
+		s.grant(w.chatID, w.userID)
+		close(w.granted)
+	}
+}
+
+// pick is the index of the waiter whose chat has the smallest share of
+// the running jobs for its weight, the earliest one on a tie, leaving out
+// users at their cap. It is -1 if no waiter may start.
+func (s *slotScheduler) pick() int {
+	best := -1
+	for i, w := range s.waiting {
+		if !s.userFree(w.userID) {
+			continue
+		}
+		if best < 0 {
+			best = i
+			continue
+		}
+		b := s.waiting[best]
+		// running/weight compared without dividing.
+		if s.running[w.chatID]*chatWeight(b.chatID) < s.running[b.chatID]*chatWeight(w.chatID) {
+			best = i
+		}
+	}
+	return best
+}
+
+// uploadSlots are the cfg.MaxConcurrentUploads slots for sending files
+// to Telegram; nil leaves uploads unlimited.
+var uploadSlots chan struct{}
+
+// acquireUploadSlot waits for an upload slot and returns the func giving
+// it back. A job that has its file gives up its job slot meanwhile, so
+// the next download can start while it uploads; one that streams keeps
+// both.
+func acquireUploadSlot(bot *tgbotapi.BotAPI, job *Job, streaming bool) (func(), error) {
+	if uploadSlots == nil {
+		return func() {}, nil
+	}
+	if !streaming {
+		job.releaseSlot()
+	}
+	select {
+	case uploadSlots <- struct{}{}:
+	default:
+		job.logger().Info("Waiting for an upload slot")
+		if job.StatusMessageID != 0 {
+			updateMessage(bot, job.ChatID, job.StatusMessageID, "⏳ Waiting for a free upload slot...")
+		}
+		select {
+		case uploadSlots <- struct{}{}:
+		case <-job.bumped:
+			// Bumped jobs run on top of every limit.
+			return func() {}, nil
+		case <-job.ctx.Done():
+			return nil, job.ctx.Err()
+		}
+	}
+	return func() { <-uploadSlots }, nil
+}
+
+// chatWeight is how many slots a chat gets for every one a chat without a
+// weight in cfg.ChatWeights gets.
+func chatWeight(chatID int64) int {
+	if w, ok := cfg.ChatWeights[chatID]; ok {
+		return w
+	}
+	return 1
+}
+
+func validateChatWeights(weights map[int64]int) error {
+	for chatID, w := range weights {
+		if w <= 0 {
+			return fmt.Errorf("weight of chat %d must be positive, got %d", chatID, w)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+const ffmpegMissingMessage = "❌ This needs ffmpeg, which isn't available on this bot."
+
+// runFFmpeg runs ffmpeg for the job with args after the common ones, and
+// reports a failure with what ffmpeg printed.
+func runFFmpeg(job *Job, args ...string) error {
+	cmd := exec.CommandContext(job.ctx, cfg.FFmpegPath, append([]string{"-hide_banner", "-loglevel", "error", "-nostdin", "-y"}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	job.addCPU(cmd)
+	if err == nil {
+		return nil
+	}
+	if job.ctx.Err() != nil {
+		return job.ctx.Err()
+	}
+	if errors.Is(err, exec.ErrNotFound) {
+		return failJob(ffmpegMissingMessage, err)
+	}
+	job.logger().Warn("ffmpeg failed", "error", err, "stderr", strings.TrimSpace(stderr.String()))
+	return fmt.Errorf("ffmpeg: %w: %s", err, strings.TrimSpace(stderr.String()))
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// uploadedFile is a file the bot already uploaded to Telegram. Sending the
+// same content again, to any chat, then only takes its file_id. A file_id
+// only works for the bot that got it, so they are kept per bot.
+type uploadedFile struct {
+	FileID   string    `json:"file_id"`
+	FileName string    `json:"file_name"`
+	Size     int64     `json:"size"`
+	SavedAt  time.Time `json:"saved_at"`
+	// SHA256 and the validators the server sent with the file are kept
+	// for uploads of a link, to tell whether it still serves that file.
+	SHA256       string `json:"sha256,omitempty"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+// reuseMaxAge is how old an upload of a link may be to be sent again
+// instead of downloading the link, however the server vouches for it.
+const reuseMaxAge = 30 * 24 * time.Hour
+
+func uploadKey(bot *tgbotapi.BotAPI, hash string) string {
+	return fmt.Sprintf("%d:%s", bot.Self.ID, hash)
+}
+
+// lookupUpload returns the file_id the job's file was uploaded under
+// before. The name is part of the file on Telegram's side, so a file
+// that is now sent under another name is uploaded again.
+func lookupUpload(bot *tgbotapi.BotAPI, job *Job) (string, bool) {
+	if job.SHA256 == "" {
+		return "", false
+	}
+	var upload uploadedFile
+	found, err := store.get(bucketUploads, uploadKey(bot, job.SHA256), &upload)
+	if err != nil {
+		slog.Error("Error looking up upload", "sha256", job.SHA256, "error", err)
+		return "", false
+	}
+	if !found || upload.FileName != job.FileName || upload.Size != job.Size {
+		return "", false
+	}
+	return upload.FileID, true
+}
+
+// uploadURLKey is where the last upload of a link is kept, for inline
+// queries, which only have the link to go on.
+func uploadURLKey(bot *tgbotapi.BotAPI, url string) string {
+	return fmt.Sprintf("url:%d:%s", bot.Self.ID, cacheKey(url))
+}
+
+func rememberUpload(bot *tgbotapi.BotAPI, job *Job, fileID string, header http.Header) {
+	upload := uploadedFile{FileID: fileID, FileName: job.FileName, Size: job.Size, SavedAt: time.Now()}
+	if err := store.put(bucketUploads, uploadKey(bot, job.SHA256), upload); err != nil {
+		job.logger().Error("Error saving upload", "error", err)
+	}
+	// A part of the file isn't what the link serves.
+	if job.options.Range != nil {
+		return
+	}
+	upload.SHA256 = job.SHA256
+	upload.ETag = header.Get("ETag")
+	upload.LastModified = header.Get("Last-Modified")
+	if err := store.put(bucketUploads, uploadURLKey(bot, job.URL), upload); err != nil {
+		job.logger().Error("Error saving upload", "error", err)
+	}
+}
+
+// lookupUploadByURL returns the file last uploaded from url, if that was
+// recent enough to still be what the link serves.
+func lookupUploadByURL(bot *tgbotapi.BotAPI, url string, maxAge time.Duration) (uploadedFile, bool) {
+	var upload uploadedFile
+	found, err := store.get(bucketUploads, uploadURLKey(bot, url), &upload)
+	if err != nil {
+		slog.Error("Error looking up upload", "url", redactURL(url), "error", err)
+		return uploadedFile{}, false
+	}
+	return upload, found && time.Since(upload.SavedAt) <= maxAge
+}
+
+// compare tells from the HEAD response for the link whether it still
+// serves the file of the upload; decided is false when the response
+// carries no validator to go by.
+func (u uploadedFile) compare(header http.Header, size int64) (same, decided bool) {
+	if size >= 0 && size != u.Size {
+		return false, true
+	}
+	if etag := header.Get("ETag"); etag != "" && u.ETag != "" {
+		return etag == u.ETag, true
+	}
+	if modified := header.Get("Last-Modified"); modified != "" && u.LastModified != "" {
+		return modified == u.LastModified, true
+	}
+	return false, false
+}
+
+// revalidateUpload asks the server with a conditional GET whether the
+// link still serves the file of the upload, for servers that leave the
+// validators out of HEAD responses or refuse HEAD.
+func revalidateUpload(job *Job, upload uploadedFile) (bool, error) {
+	req, err := http.NewRequestWithContext(job.ctx, http.MethodGet, job.URL, nil)
+	if err != nil {
+		return false, err
+	}
+	if upload.ETag != "" {
+		req.Header.Set("If-None-Match", upload.ETag)
+	}
+	if upload.LastModified != "" {
+		req.Header.Set("If-Modified-Since", upload.LastModified)
+	}
+	job.setLanguage(req)
+	if err := job.spendAttempt("revalidate"); err != nil {
+		return false, err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	// A changed file is on its way here; it is downloaded the usual way.
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotModified:
+		return true, nil
+	case resp.StatusCode < 400:
+		return false, nil
+	}
+	return false, fmt.Errorf("conditional request: %s", resp.Status)
+}
+
+// mayReuseUpload reports whether the job would send the file as the
+// link serves it, so an earlier upload of the link can stand in for it.
+func (j *Job) mayReuseUpload() bool {
+	o := j.options
+	return o.Range == nil && o.Destination == "" && o.Checksum == "" && !o.processes() &&
+		signer == nil && len(cfg.Hooks) == 0
+}
+
+// reuseUpload sends the file last uploaded from the job's link again by
+// its file_id, without downloading it, when the server still serves the
+// same version. It reports whether it did; if Telegram turns the file_id
+// down, the job downloads the link as usual.
+func reuseUpload(bot *tgbotapi.BotAPI, job *Job, header http.Header, fileSize int64) bool {
+	if !job.mayReuseUpload() {
+		return false
+	}
+	upload, ok := lookupUploadByURL(bot, job.URL, reuseMaxAge)
+	if !ok || upload.SHA256 == "" || upload.FileName != job.FileName {
+		return false
+	}
+	same, decided := upload.compare(header, fileSize)
+	if !decided && (upload.ETag != "" || upload.LastModified != "") {
+		var err error
+		if same, err = revalidateUpload(job, upload); err != nil {
+			job.logger().Warn("Error revalidating earlier upload", "error", err)
+			return false
+		}
+		decided = true
+	}
+	if !same {
+		if decided {
+			// Changed since: the download replaces the record.
+			job.logger().Info("Link changed since its last upload", "uploaded_at", upload.SavedAt)
+			if err := store.delete(bucketUploads, uploadURLKey(bot, job.URL)); err != nil {
+				job.logger().Error("Error forgetting upload", "error", err)
+			}
+		}
+		return false
+	}
+	// Listed and held files go the long way, which deals with them.
+	hashInfo, listed := lookupHash(upload.SHA256)
+	if listed && hashInfo.Verdict != hashAllow {
+		return false
+	}
+	if _, held := holdReason(job, hashInfo, listed); held {
+		return false
+	}
+
+	job.SHA256 = upload.SHA256
+	job.Size = upload.Size
+	if job.unchangedSinceLastRun() {
+		job.logger().Info("Recurring download unchanged, not posting it")
+		job.unchanged = true
+		return true
+	}
+
+	job.setState(jobUploading)
+	doc := tgbotapi.NewDocument(job.deliveryChatID(), tgbotapi.FileID(upload.FileID))
+	doc.ReplyToMessageID = job.deliveryReplyID()
+	doc.DisableNotification = job.silent
+	doc.Caption = buildHashtags(classifyFile(job.FileName, header.Get("Content-Type")), job.URL)
+	sent, err := bot.Send(doc)
+	if err != nil {
+		job.logger().Warn("Earlier upload no longer accepted, downloading again", "error", err)
+		forgetUpload(bot, job)
+		job.SHA256 = ""
+		job.Size = 0
+		return false
+	}
+	indexDelivery(job, sent)
+	job.reusedUpload = true
+	job.logger().Info("Sent by file_id from an earlier upload of the link", "uploaded_at", upload.SavedAt)
+	return true
+}
+
+// forgetUpload drops a file_id Telegram no longer accepts, so the next
+// attempt uploads the file again.
+func forgetUpload(bot *tgbotapi.BotAPI, job *Job) {
+	for _, key := range []string{uploadKey(bot, job.SHA256), uploadURLKey(bot, job.URL)} {
+		if err := store.delete(bucketUploads, key); err != nil {
+			job.logger().Error("Error forgetting upload", "error", err)
+		}
+	}
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	neturl "net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	maxGalleryItems = 50
+	// mediaGroupSize is the most items Telegram takes in one album.
+	mediaGroupSize = 10
+	// maxGalleryBytes is the most a gallery's items may come to together.
+	maxGalleryBytes = 1 << 30
+	// maxPhotoSize is the largest image Telegram accepts as a photo;
+	// bigger ones are sent as documents.
+	maxPhotoSize   = 10 << 20
+	galleryTimeout = time.Minute
+)
+
+// galleryHosts are sites whose links usually point at a page with several
+// images rather than a file, and which gallery-dl knows how to read.
+var galleryHosts = []string{
+	"imgur.com", "reddit.com", "flickr.com", "tumblr.com", "artstation.com", "deviantart.com", "pinterest.com",
+}
+
+// isGalleryURL reports whether the link is a gallery page to hand to
+// gallery-dl. Direct links to files on those hosts are downloaded as usual.
+// The items are sent as they come, so galleries are off when every file has
+// to go through clamd or the operator's hooks first, or may have to wait
+// for an approval.
+func isGalleryURL(rawURL string) bool {
+	if cfg.GalleryDLPath == "" || cfg.ClamdAddress != "" || len(cfg.Hooks) > 0 || len(cfg.holdURLs) > 0 {
+		return false
+	}
+	u, err := neturl.Parse(rawURL)
+	if err != nil || !matchesAnyDomain(u.Hostname(), galleryHosts) {
+		return false
+	}
+	if classifyFile(path.Base(u.Path), "") != categoryOther {
+		return false
+	}
+	if _, err := exec.LookPath(cfg.GalleryDLPath); err != nil {
+		return false
+	}
+	return !hashHoldsListed()
+}
+
+// galleryItemURLs asks gallery-dl for the direct links of a gallery's
+// images without downloading them; the downloads go through the bot's own
+// client like every other. gallery-dl reads the page through the guard
+// proxy, since it follows redirects and API calls of its own.
+func galleryItemURLs(job *Job) ([]string, error) {
+	ctx, cancel := context.WithTimeout(job.ctx, galleryTimeout)
+	defer cancel()
+	args := []string{"--get-urls", "--range", fmt.Sprintf("1-%d", maxGalleryItems)}
+	proxy, stopProxy, err := startGuardProxy(ctx)
+	if err != nil {
+		return nil, failJob("❌ Couldn't read that gallery.", err)
+	}
+	defer stopProxy()
+	if proxy != "" {
+		args = append(args, "--proxy", proxy)
+	}
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, cfg.GalleryDLPath, append(args, job.URL)...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	job.addCPU(cmd)
+	if job.ctx.Err() != nil {
+		return nil, job.ctx.Err()
+	}
+	if err != nil {
+		job.logger().Warn("gallery-dl failed", "error", err, "stderr", strings.TrimSpace(stderr.String()))
+		return nil, &jobError{
+			userMessage: "❌ Couldn't read that gallery.",
+			result:      resultRejected,
+			err:         fmt.Errorf("gallery-dl: %w: %s", err, strings.TrimSpace(stderr.String())),
+		}
+	}
+
+	var urls []string
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		// Fallback URLs start with "| ", and "ytdl:" ones need youtube-dl.
+		if strings.HasPrefix(line, "http://") || strings.HasPrefix(line, "https://") {
+			urls = append(urls, line)
+		}
+	}
+	return urls, nil
+}
+
+type galleryItem struct {
+	path        string
+	contentType string
+	category    fileCategory
+	size        int64
+	sha256      string
+}
+
+func (it galleryItem) inputMedia() interface{} {
+	if it.category == categoryVideo {
+		return tgbotapi.NewInputMediaVideo(tgbotapi.FilePath(it.path))
+	}
+	return tgbotapi.NewInputMediaPhoto(tgbotapi.FilePath(it.path))
+}
+
+// runGalleryJob sends every image of a gallery, as albums of up to ten.
+// Items that fail are skipped; so are ones too large for Telegram and ones
+// a download of their own wouldn't be sent for.
+func runGalleryJob(bot *tgbotapi.BotAPI, job *Job) error {
+	job.limitMB = chatMaxFileSizeMB(job.ChatID)
+	job.planStages("download", "upload")
+	job.setFileName(urlHost(job.URL) + " gallery")
+
+	updateMessage(bot, job.ChatID, job.StatusMessageID, "🖼 Looking through the gallery...")
+	urls, err := galleryItemURLs(job)
+	if err != nil {
+		return err
+	}
+	if len(urls) == 0 {
+		return &jobError{userMessage: "❌ Couldn't find any images in that gallery.", result: resultRejected}
+	}
+
+	dir, err := os.MkdirTemp(cfg.TempDir, "telegram-gallery-*")
+	if err != nil {
+		return failJob("❌ Failed to create temporary file", err)
+	}
+	defer os.RemoveAll(dir)
+
+	onProgress := progressUpdater(bot, job, "download", fmt.Sprintf("⏬ Downloading %d images...", len(urls)))
+	downloaded := job.timeStage("download")
+	var media, documents []galleryItem
+	skipped, refused := 0, 0
+	var refusal error
+	for i, url := range urls {
+		item, err := fetchGalleryItem(job, url, filepath.Join(dir, fmt.Sprintf("%03d-%s", i, path.Base(url))))
+		onProgress(float64(i+1) / float64(len(urls)) * 100)
+		if err != nil {
+			if job.ctx.Err() != nil {
+				return job.ctx.Err()
+			}
+			job.logger().Warn("Skipping gallery item", "item", redactURL(url), "error", err)
+			var jerr *jobError
+			if errors.As(err, &jerr) && jerr.result != resultFailed {
+				refused++
+				refusal = err
+			} else {
+				skipped++
+			}
+			continue
+		}
+		if err := checkGalleryItem(job, url, item); err != nil {
+			job.logger().Warn("Leaving out gallery item", "item", redactURL(url), "sha256", item.sha256, "error", err)
+			os.Remove(item.path)
+			refused++
+			refusal = err
+			continue
+		}
+		job.Size += item.size
+		switch {
+		case item.category == categoryVideo, item.category == categoryImage && item.size <= maxPhotoSize:
+			media = append(media, item)
+		default:
+			documents = append(documents, item)
+		}
+	}
+	downloaded()
+	job.noteDiskBytes(job.Size)
+	if len(media)+len(documents) == 0 {
+		if skipped == 0 && refusal != nil {
+			return refusal
+		}
+		return &jobError{userMessage: "❌ None of the gallery's images could be downloaded.", result: resultFailed}
+	}
+
+	caption := fmt.Sprintf("🖼 %d files from %s", len(media)+len(documents), urlHost(job.URL))
+	if skipped > 0 {
+		caption += fmt.Sprintf(" (%d couldn't be downloaded)", skipped)
+	}
+	if refused > 0 {
+		caption += fmt.Sprintf(" (%d left out by the chat's settings or the bot's rules)", refused)
+	}
+
+	releaseUpload, err := acquireUploadSlot(bot, job, false)
+	if err != nil {
+		return err
+	}
+	defer releaseUpload()
+	job.setState(jobUploading)
+	updateMessage(bot, job.ChatID, job.StatusMessageID, "📤 Uploading to Telegram...")
+	uploaded := job.timeStage("upload")
+	var first tgbotapi.Message
+	for start := 0; start < len(media); start += mediaGroupSize {
+		group := media[start:min(start+mediaGroupSize, len(media))]
+		if err := job.spendAttempt("upload"); err != nil {
+			return err
+		}
+		sent, err := sendGalleryGroup(bot, job, group, caption)
+		if err != nil {
+			return failJob(describeSendError(err, "❌ Failed to send the images"), err)
+		}
+		for _, item := range group {
+			job.addSent(item.size)
+		}
+		if caption != "" {
+			first = sent
+		}
+		caption = ""
+	}
+	for _, item := range documents {
+		if err := job.spendAttempt("upload"); err != nil {
+			return err
+		}
+		doc := tgbotapi.NewDocument(job.deliveryChatID(), tgbotapi.FilePath(item.path))
+		doc.ReplyToMessageID = job.deliveryReplyID()
+		doc.DisableNotification = job.silent
+		doc.Caption = caption
+		sent, err := bot.Send(doc)
+		if err != nil {
+			return failJob(describeSendError(err, "❌ Failed to send the images"), err)
+		}
+		job.addSent(item.size)
+		if caption != "" {
+			first = sent
+		}
+		caption = ""
+	}
+	uploaded()
+	// The index links to the gallery's first message.
+	indexDelivery(job, first)
+	return nil
+}
+
+func sendGalleryGroup(bot *tgbotapi.BotAPI, job *Job, group []galleryItem, caption string) (tgbotapi.Message, error) {
+	// An album needs at least two items.
+	if len(group) == 1 {
+		var msg tgbotapi.Chattable
+		if group[0].category == categoryVideo {
+			video := tgbotapi.NewVideo(job.deliveryChatID(), tgbotapi.FilePath(group[0].path))
+			video.ReplyToMessageID, video.Caption = job.deliveryReplyID(), caption
+			video.DisableNotification = job.silent
+			msg = video
+		} else {
+			photo := tgbotapi.NewPhoto(job.deliveryChatID(), tgbotapi.FilePath(group[0].path))
+			photo.ReplyToMessageID, photo.Caption = job.deliveryReplyID(), caption
+			photo.DisableNotification = job.silent
+			msg = photo
+		}
+		return bot.Send(msg)
+	}
+
+	items := make([]interface{}, len(group))
+	for i, item := range group {
+		items[i] = item.inputMedia()
+	}
+	// The album's caption is the one on its first item.
+	switch first := items[0].(type) {
+	case tgbotapi.InputMediaPhoto:
+		first.Caption = caption
+		items[0] = first
+	case tgbotapi.InputMediaVideo:
+		first.Caption = caption
+		items[0] = first
+	}
+	album := tgbotapi.NewMediaGroup(job.deliveryChatID(), items)
+	album.ReplyToMessageID = job.deliveryReplyID()
+	album.DisableNotification = job.silent
+	sent, err := bot.SendMediaGroup(album)
+	if err != nil || len(sent) == 0 {
+		return tgbotapi.Message{}, err
+	}
+	return sent[0], nil
+}
+
+// checkGalleryItem puts a downloaded item through what a download of its
+// own would have to pass before it is sent. Items can't wait for an
+// approval one by one, so a held one is left out.
+func checkGalleryItem(job *Job, url string, item galleryItem) error {
+	if err := job.checkFileType(item.path, item.contentType); err != nil {
+		return err
+	}
+	hold, err := screenPart(url, item.sha256)
+	if err != nil {
+		return err
+	}
+	if hold != "" {
+		return &jobError{userMessage: "⏸ This file needs an admin's approval.", result: resultBlocked, err: fmt.Errorf("held: %s", hold)}
+	}
+	if quotaMsg, ok := job.reserveQuota(job.Size + item.size); !ok {
+		return &jobError{userMessage: quotaMsg, result: resultRejected}
+	}
+	return nil
+}
+
+// fetchGalleryItem downloads one of a gallery's files to dest, hashing it
+// on the way.
+func fetchGalleryItem(job *Job, url, dest string) (galleryItem, error) {
+	if problem, ok := validateURL(url); !ok {
+		return galleryItem{}, fmt.Errorf("%s", problem)
+	}
+	if err := checkTarget(job.ctx, url); err != nil {
+		return galleryItem{}, err
+	}
+	part := &Job{ID: job.ID, ChatID: job.ChatID, UserID: job.UserID, URL: url, ctx: job.ctx, limitMB: job.limitMB}
+	resp, err := requestFile(part, 0)
+	if err != nil {
+		return galleryItem{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return galleryItem{}, statusError(resp)
+	}
+	limit := min(job.uploadLimit(), maxGalleryBytes-job.Size)
+	if resp.ContentLength > limit {
+		return galleryItem{}, tooLargeError(resp.ContentLength, job.limitMB)
+	}
+	if err := checkDiskSpace(resp.ContentLength); err != nil {
+		return galleryItem{}, err
+	}
+	if quotaMsg, ok := job.reserveQuota(job.Size + max(resp.ContentLength, 0)); !ok {
+		return galleryItem{}, &jobError{userMessage: quotaMsg, result: resultRejected}
+	}
+
+	file, err := os.Create(dest)
+	if err != nil {
+		return galleryItem{}, err
+	}
+	defer file.Close()
+	hasher := sha256.New()
+	body := &ProgressReader{Reader: &sizeGuard{Reader: job.throttle(resp.Body, "download"), limit: limit}, meter: job.meter("download")}
+	n, err := io.CopyBuffer(io.MultiWriter(file, hasher), body, make([]byte, copyBufferSize()))
+	if err != nil {
+		os.Remove(dest)
+		return galleryItem{}, err
+	}
+	contentType := resp.Header.Get("Content-Type")
+	return galleryItem{
+		path:        dest,
+		contentType: contentType,
+		category:    classifyFile(dest, contentType),
+		size:        n,
+		sha256:      hex.EncodeToString(hasher.Sum(nil)),
+	}, nil
+}
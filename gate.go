@@ -0,0 +1,250 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	verifyCaptcha = "captcha"
+	verifyChannel = "channel"
+
+	// captchaChoices is how many answers are offered. One wrong pick
+	// locks the user out for captchaLockout, so guessing passes one time
+	// in captchaChoices per lockout.
+	captchaChoices  = 8
+	captchaLockout  = 10 * time.Minute
+	verifiedMessage = "✅ Thanks, you're verified."
+)
+
+// verification is a user the gate stopped, with the message that is
+// taken up again once they pass.
+type verification struct {
+	message     *tgbotapi.Message
+	answer      int
+	lockedUntil time.Time
+}
+
+var (
+	verificationMu sync.Mutex
+	verifications  = map[int64]*verification{}
+)
+
+func init() {
+	// Registered in init because the handler takes the stopped message up
+	// again through handleUpdate, which refers back to the map.
+	callbackHandlers["human"] = handleHumanCallback
+}
+
+func userVerified(userID int64) bool {
+	if cfg.Verification == "" || isAdmin(userID) {
+		return true
+	}
+	var user userRecord
+	if _, err := store.get(bucketUsers, strconv.FormatInt(userID, 10), &user); err != nil {
+		slog.Error("Error loading user", "user_id", userID, "error", err)
+	}
+	return !user.VerifiedAt.IsZero()
+}
+
+func markVerified(user *tgbotapi.User) {
+	err := updateRecord(store, bucketUsers, strconv.FormatInt(user.ID, 10), func(r *userRecord, exists bool) error {
+		if !exists {
+			r.ID = user.ID
+			r.FirstSeen = time.Now()
+			r.LastSeen = r.FirstSeen
+		}
+		r.VerifiedAt = time.Now()
+		return nil
+	})
+	if err != nil {
+		slog.Error("Error saving verification", "user_id", user.ID, "error", err)
+	}
+	slog.Info("User verified", "user_id", user.ID, "method", cfg.Verification)
+}
+
+// userPassesGate reports whether user has passed cfg.Verification, or
+// passes it now by having joined the channel. It is for requests that
+// come with no message to take up again, like buttons.
+func userPassesGate(bot *tgbotapi.BotAPI, user *tgbotapi.User) bool {
+	if user == nil || userVerified(user.ID) {
+		return true
+	}
+	if cfg.Verification == verifyChannel && joinedVerifyChannel(bot, user.ID) {
+		markVerified(user)
+		return true
+	}
+	return false
+}
+
+// passesGate reports whether the sender of a message that would start a
+// download may do so. Someone who hasn't passed cfg.Verification yet is
+// asked to, and the message is taken up again when they do.
+func passesGate(bot *tgbotapi.BotAPI, message *tgbotapi.Message) bool {
+	if userPassesGate(bot, message.From) {
+		return true
+	}
+
+	verificationMu.Lock()
+	v := verifications[message.From.ID]
+	if v == nil {
+		v = &verification{}
+		verifications[message.From.ID] = v
+	}
+	v.message = message
+	locked := time.Now().Before(v.lockedUntil)
+	if !locked && cfg.Verification == verifyCaptcha {
+		v.answer = newCaptcha()
+	}
+	answer := v.answer
+	verificationMu.Unlock()
+
+	if locked {
+		sendErrorMessage(bot, message.Chat.ID, "⏳ That wasn't the right answer. Please try again in a few minutes.")
+		return false
+	}
+	msg := tgbotapi.NewMessage(message.Chat.ID, "")
+	msg.ReplyToMessageID = message.MessageID
+	if cfg.Verification == verifyChannel {
+		msg.Text, msg.ReplyMarkup = channelPrompt(message.From.ID)
+	} else {
+		msg.Text, msg.ReplyMarkup = captchaPrompt(message.From.ID, answer)
+	}
+	if _, err := bot.Send(msg); err != nil {
+		slog.Error("Error sending verification", "user_id", message.From.ID, "error", err)
+	}
+	return false
+}
+
+// newCaptcha picks the answer of a sum small enough to do in one's head.
+func newCaptcha() int {
+	return 4 + rand.N(14)
+}
+
+// captchaPrompt asks for the two numbers adding up to answer, with the
+// answer among wrong ones, in two rows.
+func captchaPrompt(userID int64, answer int) (string, tgbotapi.InlineKeyboardMarkup) {
+	a := 2 + rand.N(answer-3)
+	choices := []int{answer}
+	for len(choices) < captchaChoices {
+		wrong := answer + rand.N(21) - 10
+		if wrong > 0 && !slices.Contains(choices, wrong) {
+			choices = append(choices, wrong)
+		}
+	}
+	rand.Shuffle(len(choices), func(i, j int) { choices[i], choices[j] = choices[j], choices[i] })
+
+	id := strconv.FormatInt(userID, 10)
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for i, c := range choices {
+		if i%(captchaChoices/2) == 0 {
+			rows = append(rows, nil)
+		}
+		button := tgbotapi.NewInlineKeyboardButtonData(strconv.Itoa(c), "human:"+id+":"+strconv.Itoa(c))
+		rows[len(rows)-1] = append(rows[len(rows)-1], button)
+	}
+	text := fmt.Sprintf("🤖 Before your first download, please show you're human: what is %d + %d? You have one try.", a, answer-a)
+	return text, tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+func channelPrompt(userID int64) (string, tgbotapi.InlineKeyboardMarkup) {
+	id := strconv.FormatInt(userID, 10)
+	row := []tgbotapi.InlineKeyboardButton{tgbotapi.NewInlineKeyboardButtonData("✅ I've joined", "human:"+id+":joined")}
+	if name, ok := strings.CutPrefix(cfg.VerifyChannel, "@"); ok {
+		row = append([]tgbotapi.InlineKeyboardButton{tgbotapi.NewInlineKeyboardButtonURL("📢 Join", "https://t.me/"+name)}, row...)
+	}
+	text := fmt.Sprintf("📢 Before your first download, please join %s.", cfg.VerifyChannel)
+	return text, tgbotapi.NewInlineKeyboardMarkup(row)
+}
+
+// joinedVerifyChannel reports whether the user is a member of
+// cfg.VerifyChannel. The bot has to be an admin of the channel to see
+// its members.
+func joinedVerifyChannel(bot *tgbotapi.BotAPI, userID int64) bool {
+	config := tgbotapi.ChatConfigWithUser{UserID: userID}
+	if id, err := strconv.ParseInt(cfg.VerifyChannel, 10, 64); err == nil {
+		config.ChatID = id
+	} else {
+		config.SuperGroupUsername = cfg.VerifyChannel
+	}
+	member, err := bot.GetChatMember(tgbotapi.GetChatMemberConfig{ChatConfigWithUser: config})
+	if err != nil {
+		slog.Error("Error checking channel membership", "channel", cfg.VerifyChannel, "user_id", userID, "error", err)
+		return false
+	}
+	switch member.Status {
+	case "creator", "administrator", "member":
+		return true
+	case "restricted":
+		return member.IsMember
+	}
+	return false
+}
+
+func handleHumanCallback(bot *tgbotapi.BotAPI, query *tgbotapi.CallbackQuery, args []string) string {
+	if len(args) != 2 || query.From == nil || query.Message == nil {
+		return ""
+	}
+	userID, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return ""
+	}
+	if query.From.ID != userID {
+		return "This check is for someone else."
+	}
+
+	verificationMu.Lock()
+	v := verifications[userID]
+	verificationMu.Unlock()
+	if v == nil {
+		// Asked before a restart: joining still counts, a sum has to be
+		// asked again.
+		v = &verification{}
+	}
+
+	if args[1] == "joined" {
+		if !joinedVerifyChannel(bot, userID) {
+			return "You haven't joined yet."
+		}
+	} else {
+		picked, err := strconv.Atoi(args[1])
+		if err != nil {
+			return ""
+		}
+		verificationMu.Lock()
+		if v.answer == 0 || time.Now().Before(v.lockedUntil) {
+			verificationMu.Unlock()
+			updateMessage(bot, query.Message.Chat.ID, query.Message.MessageID, "⌛ This check expired. Please send your request again.")
+			return ""
+		}
+		if picked != v.answer {
+			v.answer = 0
+			v.lockedUntil = time.Now().Add(captchaLockout)
+			verificationMu.Unlock()
+			slog.Warn("User failed verification", "user_id", userID)
+			updateMessage(bot, query.Message.Chat.ID, query.Message.MessageID, "🚫 That's not it. Please try again in a few minutes.")
+			return "❌ That's not it."
+		}
+		verificationMu.Unlock()
+	}
+
+	markVerified(query.From)
+	verificationMu.Lock()
+	delete(verifications, userID)
+	verificationMu.Unlock()
+	updateMessage(bot, query.Message.Chat.ID, query.Message.MessageID, verifiedMessage)
+	if v.message != nil {
+		// Takes up the request that was stopped; the gate lets it through
+		// now.
+		go handleUpdate(bot, tgbotapi.Update{Message: v.message})
+	}
+	return ""
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"time"
+)
+
+// startGuardProxy serves an HTTP proxy on loopback for programs that
+// fetch a page and everything on it by themselves, like Chrome and
+// gallery-dl. It connects out with downloadDialer, so redirects,
+// subresources and scripts can't reach internal hosts any more than the
+// bot's own downloads can. stop shuts it down along with whatever it
+// still has open.
+//
+// With cfg.AllowPrivate there is nothing to guard and proxyURL is empty;
+// with cfg.DownloadProxy it is that proxy, which checks connections
+// itself, as it does for downloads.
+func startGuardProxy(ctx context.Context) (proxyURL string, stop func(), err error) {
+	if cfg.DownloadProxy != "" {
+		return cfg.DownloadProxy, func() {}, nil
+	}
+	if cfg.AllowPrivate {
+		return "", func() {}, nil
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	dialer := downloadDialer()
+	transport := &http.Transport{
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   30 * time.Second,
+		ResponseHeaderTimeout: time.Minute,
+		IdleConnTimeout:       30 * time.Second,
+	}
+	// Blocked connections are what the proxy is for, not errors.
+	errorLog := slog.NewLogLogger(slog.Default().Handler(), slog.LevelDebug)
+	forward := &httputil.ReverseProxy{
+		// Requests to a proxy carry the URL they're for.
+		Rewrite:   func(r *httputil.ProxyRequest) {},
+		Transport: transport,
+		ErrorLog:  errorLog,
+	}
+	server := &http.Server{
+		ReadHeaderTimeout: 30 * time.Second,
+		ErrorLog:          errorLog,
+		BaseContext:       func(net.Listener) context.Context { return ctx },
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case r.Method == http.MethodConnect:
+				tunnel(ctx, dialer, w, r)
+			case r.URL.IsAbs():
+				forward.ServeHTTP(w, r)
+			default:
+				http.Error(w, "not a proxy request", http.StatusBadRequest)
+			}
+		}),
+	}
+	go server.Serve(listener)
+	stop = func() {
+		cancel()
+		server.Close()
+		transport.CloseIdleConnections()
+	}
+	return "http://" + listener.Addr().String(), stop, nil
+}
+
+// tunnel connects a CONNECT request through to its host.
+func tunnel(ctx context.Context, dialer *net.Dialer, w http.ResponseWriter, r *http.Request) {
+	upstream, err := dialer.DialContext(ctx, "tcp", r.Host)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	defer upstream.Close()
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "can't tunnel", http.StatusInternalServerError)
+		return
+	}
+	client, buffered, err := hijacker.Hijack()
+	if err != nil {
+		return
+	}
+	defer client.Close()
+	if _, err := client.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n")); err != nil {
+		return
+	}
+
+	stop := context.AfterFunc(ctx, func() {
+		client.Close()
+		upstream.Close()
+	})
+	defer stop()
+	done := make(chan struct{})
+	go func() {
+		io.Copy(upstream, buffered)
+		done <- struct{}{}
+	}()
+	io.Copy(client, upstream)
+	client.Close()
+	upstream.Close()
+	<-done
+}
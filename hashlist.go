@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	hashAllow = "allow"
+	hashDeny  = "deny"
+)
+
+const hashUsage = "Usage:\n/admin hash list\n/admin hash allow <sha256> [note]\n/admin hash deny <sha256> [note]\n/admin hash hold <sha256> [note]\n/admin hash remove <sha256>"
+
+// hashEntry is an operator verdict on a file's SHA-256. Allowed entries
+// remember the file_id of their first delivery so repeats can skip the
+// upload.
+type hashEntry struct {
+	Hash    string    `json:"hash"`
+	Verdict string    `json:"verdict"`
+	Note    string    `json:"note,omitempty"`
+	FileID  string    `json:"file_id,omitempty"`
+	AddedBy int64     `json:"added_by"`
+	AddedAt time.Time `json:"added_at"`
+}
+
+func normalizeHash(s string) (string, bool) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if b, err := hex.DecodeString(s); err != nil || len(b) != 32 {
+		return "", false
+	}
+	return s, true
+}
+
+func lookupHash(hash string) (hashEntry, bool) {
+	var entry hashEntry
+	found, err := store.get(bucketHashes, hash, &entry)
+	if err != nil {
+		slog.Error("Error looking up hash", "sha256", hash, "error", err)
+		return entry, false
+	}
+	return entry, found
+}
+
+// hashHoldsListed reports whether any hash is on the hold list, for the
+// ways of sending files that can't stop to wait for an approval.
+func hashHoldsListed() bool {
+	found := false
+	err := store.forEachReverse(bucketHashes, func(_, value []byte) (bool, error) {
+		var e hashEntry
+		found = json.Unmarshal(value, &e) == nil && e.Verdict == hashHold
+		return !found, nil
+	})
+	if err != nil {
+		// Better to take the slower way than to skip a hold.
+		slog.Error("Error listing hashes", "error", err)
+		return true
+	}
+	return found
+}
+
+func rememberHashFileID(hash, fileID string) {
+	err := updateRecord(store, bucketHashes, hash, func(e *hashEntry, exists bool) error {
+		if exists && e.Verdict == hashAllow {
+			e.FileID = fileID
+		}
+		return nil
+	})
+	if err != nil {
+		slog.Error("Error saving file_id for hash", "sha256", hash, "error", err)
+	}
+}
+
+func adminHash(bot *tgbotapi.BotAPI, message *tgbotapi.Message, args []string) {
+	if len(args) == 0 {
+		sendErrorMessage(bot, message.Chat.ID, hashUsage)
+		return
+	}
+
+	switch args[0] {
+	case "list":
+		sendMessage(bot, message.Chat.ID, formatHashList())
+		return
+	case "allow", "deny", "remove":
+	case hashHold:
+		if cfg.LogChannelID == 0 {
+			sendErrorMessage(bot, message.Chat.ID, "❌ Holds need a log channel for the approval requests (log_channel_id).")
+			return
+		}
+	default:
+		sendErrorMessage(bot, message.Chat.ID, hashUsage)
+		return
+	}
+
+	if len(args) < 2 {
+		sendErrorMessage(bot, message.Chat.ID, hashUsage)
+		return
+	}
+	hash, ok := normalizeHash(args[1])
+	if !ok {
+		sendErrorMessage(bot, message.Chat.ID, "❌ That doesn't look like a SHA-256 hash (64 hex characters).")
+		return
+	}
+
+	if args[0] == "remove" {
+		if err := store.delete(bucketHashes, hash); err != nil {
+			sendErrorMessage(bot, message.Chat.ID, "❌ Failed to remove the hash: "+err.Error())
+			return
+		}
+		sendMessage(bot, message.Chat.ID, "✅ Removed "+hash)
+		return
+	}
+
+	entry := hashEntry{
+		Hash:    hash,
+		Verdict: args[0],
+		Note:    strings.Join(args[2:], " "),
+		AddedBy: message.From.ID,
+		AddedAt: time.Now(),
+	}
+	if err := store.put(bucketHashes, hash, entry); err != nil {
+		sendErrorMessage(bot, message.Chat.ID, "❌ Failed to save the hash: "+err.Error())
+		return
+	}
+	sendMessage(bot, message.Chat.ID, fmt.Sprintf("✅ %s is now on the %slist.", hash, args[0]))
+}
+
+func formatHashList() string {
+	var entries []hashEntry
+	err := store.forEach(bucketHashes, func(_, value []byte) error {
+		var e hashEntry
+		if err := json.Unmarshal(value, &e); err != nil {
+			return err
+		}
+		entries = append(entries, e)
+		return nil
+	})
+	if err != nil {
+		return "❌ Failed to read the hash lists: " + err.Error()
+	}
+	if len(entries) == 0 {
+		return "No hashes on the allow or deny lists."
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].AddedAt.Before(entries[j].AddedAt) })
+
+	lines := make([]string, 0, len(entries))
+	for _, e := range entries {
+		icon := "✅"
+		switch e.Verdict {
+		case hashDeny:
+			icon = "🚫"
+		case hashHold:
+			icon = "⏸"
+		}
+		line := fmt.Sprintf("%s %s", icon, e.Hash)
+		if e.Note != "" {
+			line += " — " + e.Note
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
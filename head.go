@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// fetchInfo finds out the size of the file at job.URL before downloading
+// it. Servers that refuse HEAD, as many CDNs do with 403 or 405, are asked
+// for the first byte with a ranged GET instead. The size is -1 if unknown.
+func fetchInfo(job *Job) (int64, http.Header, error) {
+	req, err := http.NewRequestWithContext(job.ctx, http.MethodHead, job.URL, nil)
+	if err != nil {
+		return 0, nil, failJob("❌ That doesn't look like a valid URL.", err)
+	}
+	job.setLanguage(req)
+	if err := job.spendAttempt("head"); err != nil {
+		return 0, nil, err
+	}
+	headDone := job.timeStage("head")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return 0, nil, failJob("❌ Failed to get file info", err)
+	}
+	resp.Body.Close()
+	headDone()
+	if resp.StatusCode < 400 {
+		return resp.ContentLength, resp.Header, nil
+	}
+
+	job.logger().Info("HEAD refused, falling back to a ranged GET", "status", resp.Status)
+	rangeDone := job.timeStage("range probe")
+	req, err = http.NewRequestWithContext(job.ctx, http.MethodGet, job.URL, nil)
+	if err != nil {
+		return 0, nil, failJob("❌ That doesn't look like a valid URL.", err)
+	}
+	req.Header.Set("Range", "bytes=0-0")
+	job.setLanguage(req)
+	if err := job.spendAttempt("range probe"); err != nil {
+		return 0, nil, err
+	}
+	resp, err = httpClient.Do(req)
+	if err != nil {
+		return 0, nil, failJob("❌ Failed to get file info", err)
+	}
+	defer resp.Body.Close()
+	if err := checkPresignedResponse(resp); err != nil {
+		return 0, nil, err
+	}
+	if resp.StatusCode >= 400 {
+		msg := fmt.Sprintf("❌ The server responded with %s.", resp.Status)
+		if hint, _, ok := errorPageHint(resp, nil); ok {
+			msg = hint
+		}
+		return 0, nil, &jobError{
+			userMessage: msg,
+			result:      resultRejected,
+			err:         fmt.Errorf("HEAD and ranged GET refused: %s", resp.Status),
+		}
+	}
+	rangeDone()
+
+	size := int64(-1)
+	if resp.StatusCode == http.StatusPartialContent {
+		size = contentRangeTotal(resp.Header.Get("Content-Range"))
+	} else {
+		// The range was ignored and the whole file is on its way; its
+		// length is all we need.
+		size = resp.ContentLength
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1))
+	return size, resp.Header, nil
+}
+
+// contentRangeTotal returns the complete length from a Content-Range header
+// like "bytes 0-0/12345", or -1 if it is missing or unknown ("*").
+func contentRangeTotal(header string) int64 {
+	_, total, ok := strings.Cut(header, "/")
+	if !ok {
+		return -1
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(total), 10, 64)
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+// uploadOverhead is what an upload request carries besides the file:
+// multipart boundaries and headers, the other fields and the caption.
+// Telegram counts it towards its limit, so a file right at the limit is
+// rejected.
+const uploadOverhead = 16 << 10
+
+// uploadLimit is the largest file that can be sent for the job: its size
+// limit, if that leaves room for the upload overhead within Telegram's.
+func (j *Job) uploadLimit() int64 {
+	return min(j.limitMB*1024*1024, telegramLimitMB()*1024*1024-uploadOverhead)
+}
+
+func tooLargeError(size, limitMB int64) error {
+	sizeMB := float64(size) / 1024 / 1024
+	if size <= limitMB*1024*1024 {
+		errorMsg := fmt.Sprintf("❌ At %.2f MB this file is just too large: Telegram counts the upload's own overhead towards the %d MB limit.", sizeMB, limitMB)
+		return &jobError{userMessage: errorMsg, result: resultRejected}
+	}
+	errorMsg := fmt.Sprintf("❌ File is too large (%.1f MB). The limit here is %d MB.\n\nPlease use a direct download link instead.", sizeMB, limitMB)
+	return &jobError{userMessage: errorMsg, result: resultRejected}
+}
+
+// sizeGuard stops a download whose size wasn't known up front as soon as
+// it grows past the limit, rather than after filling the disk.
+type sizeGuard struct {
+	io.Reader
+	limit int64
+	read  int64
+}
+
+var errTooLarge = errors.New("download exceeded the size limit")
+
+func (g *sizeGuard) Read(p []byte) (int, error) {
+	n, err := g.Reader.Read(p)
+	g.read += int64(n)
+	if g.read > g.limit {
+		return n, errTooLarge
+	}
+	return n, err
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	// telegramCheckInterval keeps readiness probes from turning into a
+	// getMe call every few seconds.
+	telegramCheckInterval = 30 * time.Second
+	// queueWedgedAfter is how long the queue may sit full without any job
+	// making progress before the bot is reported as not ready.
+	queueWedgedAfter = 15 * time.Minute
+)
+
+type healthChecker struct {
+	bot *tgbotapi.BotAPI
+
+	mu          sync.Mutex
+	checkedAt   time.Time
+	telegramErr error
+}
+
+// runHealthServer serves /healthz (the process is up) and /readyz (it can
+// actually do its job) on cfg.HealthAddr.
+func runHealthServer(bot *tgbotapi.BotAPI) {
+	hc := &healthChecker{bot: bot}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/readyz", hc.serveReady)
+
+	slog.Info("Health server listening", "addr", cfg.HealthAddr)
+	server := &http.Server{Addr: cfg.HealthAddr, Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	if err := server.ListenAndServe(); err != nil {
+		slog.Error("Health server stopped", "error", err)
+	}
+}
+
+func (hc *healthChecker) serveReady(w http.ResponseWriter, r *http.Request) {
+	checks := []struct {
+		name string
+		err  error
+	}{
+		{"telegram", hc.checkTelegram()},
+		{"updates", checkPolling()},
+		{"disk", checkDisk()},
+		{"queue", checkQueue()},
+	}
+
+	ready := true
+	var b strings.Builder
+	for _, c := range checks {
+		if c.err != nil {
+			ready = false
+			fmt.Fprintf(&b, "%s: %v\n", c.name, c.err)
+		} else {
+			fmt.Fprintf(&b, "%s: ok\n", c.name)
+		}
+	}
+	if errors.Is(context.Cause(jobsCtx), errShutdown) {
+		ready = false
+		b.WriteString("shutting down\n")
+	}
+
+	if !ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	fmt.Fprint(w, b.String())
+}
+
+func (hc *healthChecker) checkTelegram() error {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	if time.Since(hc.checkedAt) < telegramCheckInterval {
+		return hc.telegramErr
+	}
+	_, hc.telegramErr = hc.bot.GetMe()
+	hc.checkedAt = time.Now()
+	return hc.telegramErr
+}
+
+// checkDisk requires room for at least one maximum-size download.
+func checkDisk() error {
+	free, err := diskFree(cfg.TempDir)
+	if errors.Is(err, errors.ErrUnsupported) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if free < maxFileSize() {
+		return fmt.Errorf("only %.1f MB free in %s", float64(free)/1024/1024, cfg.TempDir)
+	}
+	return nil
+}
+
+// checkQueue reports the queue as wedged when every slot is taken, jobs
+// are waiting and nothing has moved for queueWedgedAfter.
+func checkQueue() error {
+	if jobSlots.used() < jobSlots.size() {
+		return nil
+	}
+	queued := 0
+	for _, job := range jobs.list() {
+		if job.State == jobQueued {
+			queued++
+		}
+	}
+	if queued == 0 {
+		return nil
+	}
+	idle := time.Since(time.Unix(0, stats.lastProgress.Load()))
+	if idle > queueWedgedAfter {
+		return fmt.Errorf("%d jobs waiting and no progress for %s", queued, idle.Round(time.Second))
+	}
+	return nil
+}
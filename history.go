@@ -0,0 +1,283 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	resultSuccess     = "success"
+	resultFailed      = "failed"
+	resultRejected    = "rejected"
+	resultBlocked     = "blocked"
+	resultInterrupted = "interrupted"
+)
+
+// jobRecord is the persisted outcome of a job. Keys are zero-padded job IDs
+// so bucket iteration returns jobs in creation order.
+type jobRecord struct {
+	ID         int64         `json:"id"`
+	ChatID     int64         `json:"chat_id"`
+	UserID     int64         `json:"user_id"`
+	MessageID  int           `json:"message_id,omitempty"`
+	URL        string        `json:"url"`
+	FileName   string        `json:"file_name,omitempty"`
+	Size       int64         `json:"size"`
+	SHA256     string        `json:"sha256,omitempty"`
+	CreatedAt  time.Time     `json:"created_at"`
+	StartedAt  time.Time     `json:"started_at"`
+	FinishedAt time.Time     `json:"finished_at"`
+	Duration   float64       `json:"duration_seconds"`
+	Result     string        `json:"result"`
+	Error      string        `json:"error,omitempty"`
+	Timings    []stageTiming `json:"timings,omitempty"`
+	Labels     []string      `json:"labels,omitempty"`
+	Options    jobOptions    `json:"options"`
+	Usage      *jobUsage     `json:"usage,omitempty"`
+	// RequeuedAs is the job that /requeue started again from this one.
+	RequeuedAs int64 `json:"requeued_as,omitempty"`
+}
+
+type userRecord struct {
+	ID        int64     `json:"id"`
+	Username  string    `json:"username,omitempty"`
+	FirstName string    `json:"first_name,omitempty"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	Jobs      int64     `json:"jobs"`
+	// VerifiedAt is when the user passed cfg.Verification.
+	VerifiedAt time.Time `json:"verified_at,omitempty"`
+}
+
+func jobKey(id int64) string {
+	return fmt.Sprintf("%020d", id)
+}
+
+func recordUser(user *tgbotapi.User) {
+	now := time.Now()
+	err := updateRecord(store, bucketUsers, strconv.FormatInt(user.ID, 10), func(r *userRecord, exists bool) error {
+		if !exists {
+			r.ID = user.ID
+			r.FirstSeen = now
+		}
+		r.Username = user.UserName
+		r.FirstName = user.FirstName
+		r.LastSeen = now
+		r.Jobs++
+		return nil
+	})
+	if err != nil {
+		slog.Error("Error recording user", "user_id", user.ID, "error", err)
+	}
+}
+
+func recordJob(job *Job, err error) {
+	now := time.Now()
+	record := jobRecord{
+		ID:         job.ID,
+		ChatID:     job.ChatID,
+		UserID:     job.UserID,
+		MessageID:  job.MessageID,
+		URL:        job.URL,
+		FileName:   job.FileName,
+		Size:       job.Size,
+		SHA256:     job.SHA256,
+		CreatedAt:  job.CreatedAt,
+		StartedAt:  job.StartedAt,
+		FinishedAt: now,
+		Duration:   now.Sub(job.StartedAt).Seconds(),
+		Result:     resultSuccess,
+		Timings:    job.timings,
+		Labels:     job.options.Labels,
+		Options:    job.options,
+	}
+	if usage := job.usage(); usage != (jobUsage{}) {
+		record.Usage = &usage
+	}
+
+	if err != nil {
+		record.Result = resultFailed
+		record.Error = err.Error()
+		var jerr *jobError
+		if errors.As(err, &jerr) && jerr.result != "" {
+			record.Result = jerr.result
+		}
+	}
+
+	if err := store.put(bucketJobs, jobKey(job.ID), record); err != nil {
+		job.logger().Error("Error recording job", "error", err)
+	}
+	if record.Result == resultSuccess {
+		noteDelivery(job.deliveryChatID())
+	}
+}
+
+const (
+	historyPageSize = 10
+	historyUsage    = "Usage: /history [all] [label]"
+)
+
+var resultIcons = map[string]string{
+	resultSuccess:     "✅",
+	resultFailed:      "❌",
+	resultRejected:    "⛔",
+	resultBlocked:     "🚫",
+	resultInterrupted: "⏸",
+}
+
+// userHistory returns one page of a user's jobs, newest first, and whether
+// there are older ones. Only jobs with label are included, if given. User
+// ID 0 stands for everyone, for admins and observers.
+func userHistory(userID int64, label string, page int) ([]jobRecord, bool, error) {
+	var records []jobRecord
+	skip := page * historyPageSize
+	more := false
+
+	err := store.forEachReverse(bucketJobs, func(_, value []byte) (bool, error) {
+		var r jobRecord
+		if err := json.Unmarshal(value, &r); err != nil {
+			return false, err
+		}
+		if (userID != 0 && r.UserID != userID) || (label != "" && !slices.Contains(r.Labels, label)) {
+			return true, nil
+		}
+		if skip > 0 {
+			skip--
+			return true, nil
+		}
+		if len(records) == historyPageSize {
+			more = true
+			return false, nil
+		}
+		records = append(records, r)
+		return true, nil
+	})
+	return records, more, err
+}
+
+func renderHistory(userID int64, label string, page int) (string, *tgbotapi.InlineKeyboardMarkup) {
+	records, more, err := userHistory(userID, label, page)
+	if err != nil {
+		slog.Error("Error reading history", "user_id", userID, "error", err)
+		return "❌ Failed to load your history.", nil
+	}
+	whose := "Your"
+	if userID == 0 {
+		whose = "All"
+	}
+	if len(records) == 0 && page == 0 && label != "" {
+		return fmt.Sprintf("📜 No downloads labelled %s yet.", label), nil
+	}
+	if len(records) == 0 && page == 0 {
+		return "📜 No downloads yet.", nil
+	}
+
+	title := fmt.Sprintf("📜 %s downloads (page %d)\n", whose, page+1)
+	if label != "" {
+		title = fmt.Sprintf("📜 %s downloads labelled %s (page %d)\n", whose, label, page+1)
+	}
+	lines := []string{title}
+	for _, r := range records {
+		name := r.FileName
+		if name == "" && userID == 0 {
+			name = urlHost(r.URL)
+		} else if name == "" {
+			name = redactURL(r.URL)
+		}
+		line := fmt.Sprintf("%s %s — %.1f MB — %s",
+			resultIcons[r.Result], name, float64(r.Size)/1024/1024, r.CreatedAt.Format("2006-01-02 15:04"))
+		if userID == 0 {
+			line += fmt.Sprintf(" — user %d", r.UserID)
+		}
+		if len(r.Labels) > 0 && label == "" {
+			line += " — 🏷 " + strings.Join(r.Labels, ", ")
+		}
+		lines = append(lines, line)
+	}
+
+	var buttons []tgbotapi.InlineKeyboardButton
+	if page > 0 {
+		buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonData("⬅️ Newer", fmt.Sprintf("history:%d:%d:%s", userID, page-1, label)))
+	}
+	if more {
+		buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonData("Older ➡️", fmt.Sprintf("history:%d:%d:%s", userID, page+1, label)))
+	}
+	if len(buttons) == 0 {
+		return strings.Join(lines, "\n"), nil
+	}
+	markup := tgbotapi.NewInlineKeyboardMarkup(buttons)
+	return strings.Join(lines, "\n"), &markup
+}
+
+func handleHistoryCommand(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
+	if message.From == nil {
+		return
+	}
+	userID, label := message.From.ID, ""
+	args, err := commandArgs(message)
+	if err != nil {
+		sendErrorMessage(bot, message.Chat.ID, usageError(err, historyUsage))
+		return
+	}
+	if len(args) > 0 && strings.EqualFold(args[0], "all") {
+		if !canObserve(message.From.ID) {
+			sendErrorMessage(bot, message.Chat.ID, "🚫 Only admins and observers can see everyone's history.")
+			return
+		}
+		userID, args = 0, args[1:]
+	}
+	if len(args) > 1 {
+		sendErrorMessage(bot, message.Chat.ID, historyUsage)
+		return
+	}
+	if len(args) == 1 {
+		var err error
+		if label, err = parseLabel(args[0]); err != nil {
+			sendErrorMessage(bot, message.Chat.ID, "❌ "+err.Error()+"\n\n"+historyUsage)
+			return
+		}
+	}
+	text, markup := renderHistory(userID, label, 0)
+	msg := tgbotapi.NewMessage(message.Chat.ID, text)
+	if markup != nil {
+		msg.ReplyMarkup = markup
+	}
+	bot.Send(msg)
+}
+
+func handleHistoryCallback(bot *tgbotapi.BotAPI, query *tgbotapi.CallbackQuery, args []string) string {
+	// Buttons from before labels have no third part.
+	if len(args) < 2 || len(args) > 3 || query.Message == nil {
+		return ""
+	}
+	var label string
+	if len(args) == 3 {
+		label = args[2]
+	}
+	userID, err1 := strconv.ParseInt(args[0], 10, 64)
+	page, err2 := strconv.Atoi(args[1])
+	if err1 != nil || err2 != nil || page < 0 {
+		return ""
+	}
+	if query.From == nil || (userID == 0 && !canObserve(query.From.ID)) || (userID != 0 && query.From.ID != userID) {
+		return "This isn't your history."
+	}
+
+	text, markup := renderHistory(userID, label, page)
+	var edit tgbotapi.EditMessageTextConfig
+	if markup != nil {
+		edit = tgbotapi.NewEditMessageTextAndMarkup(query.Message.Chat.ID, query.Message.MessageID, text, *markup)
+	} else {
+		edit = tgbotapi.NewEditMessageText(query.Message.Chat.ID, query.Message.MessageID, text)
+	}
+	bot.Send(edit)
+	return ""
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"sync"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// hashHold marks a hash whose files need an admin's approval before they
+// are delivered, like URLs matching cfg.HoldURLPatterns.
+const hashHold = "hold"
+
+var (
+	holdMu      sync.Mutex
+	holdPending = map[int64]chan bool{}
+)
+
+func compileHoldPatterns(patterns []string) ([]*regexp.Regexp, error) {
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("hold URL pattern %q: %w", p, err)
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+// holdReason says why a job's file has to wait for approval, if it does.
+func holdReason(job *Job, hashInfo hashEntry, listed bool) (string, bool) {
+	if listed && hashInfo.Verdict == hashHold {
+		if hashInfo.Note != "" {
+			return "hash on the hold list: " + hashInfo.Note, true
+		}
+		return "hash on the hold list", true
+	}
+	for _, re := range cfg.holdURLs {
+		if re.MatchString(job.URL) {
+			return "URL matches " + re.String(), true
+		}
+	}
+	return "", false
+}
+
+// screenPart checks one file of a gallery or an archive against the hash
+// lists and the hold patterns, as if it had been downloaded on its own. It
+// returns why the file has to wait for approval, if it does.
+func screenPart(url, hash string) (string, error) {
+	hashInfo, listed := lookupHash(hash)
+	if listed && hashInfo.Verdict == hashDeny {
+		return "", &jobError{
+			userMessage: "🚫 This file is blocked by the bot operator.",
+			result:      resultBlocked,
+			err:         fmt.Errorf("sha256 %s is on the denylist", hash),
+		}
+	}
+	reason, _ := holdReason(&Job{URL: url}, hashInfo, listed)
+	return reason, nil
+}
+
+// awaitApproval asks the admins in the log channel to approve or reject
+// the file and waits for their answer. The job gives up its slot while it
+// waits, since that may take a while.
+func awaitApproval(bot *tgbotapi.BotAPI, job *Job, reason string) error {
+	decision := make(chan bool, 1)
+	holdMu.Lock()
+	holdPending[job.ID] = decision
+	holdMu.Unlock()
+	defer func() {
+		holdMu.Lock()
+		delete(holdPending, job.ID)
+		holdMu.Unlock()
+	}()
+
+	id := strconv.FormatInt(job.ID, 10)
+	msg := tgbotapi.NewMessage(cfg.LogChannelID, fmt.Sprintf(
+		"⏸ Job #%d needs approval (%s)\n\nFile: %s (%.1f MB)\nURL: %s\nSHA-256: %s\nRequested by user %d in chat %d",
+		job.ID, reason, job.FileName, float64(job.Size)/1024/1024, redactURL(job.URL), job.SHA256, job.UserID, job.ChatID))
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("✅ Approve", "hold:"+id+":approve"),
+		tgbotapi.NewInlineKeyboardButtonData("❌ Reject", "hold:"+id+":reject"),
+	))
+	if _, err := bot.Send(msg); err != nil {
+		return failJob("❌ Failed to ask for approval", err)
+	}
+	job.logger().Info("Job held for approval", "reason", reason)
+	updateMessage(bot, job.ChatID, job.StatusMessageID, "⏸ This file needs an admin's approval before it can be sent. I'll send it once it's approved.")
+
+	// A bumped job never had a slot to give up.
+	hadSlot := job.holdsSlot
+	job.releaseSlot()
+	var approved bool
+	var err error
+	select {
+	case approved = <-decision:
+	case <-job.ctx.Done():
+		err = job.ctx.Err()
+	}
+	if hadSlot && err == nil {
+		err = jobSlots.acquire(job)
+	}
+
+	if err != nil {
+		return err
+	}
+	if !approved {
+		return &jobError{userMessage: "🚫 An admin declined to release this file.", result: resultBlocked}
+	}
+	job.logger().Info("Held job approved")
+	return nil
+}
+
+func handleHoldCallback(bot *tgbotapi.BotAPI, query *tgbotapi.CallbackQuery, args []string) string {
+	if len(args) != 2 {
+		return ""
+	}
+	if query.From == nil || !isAdmin(query.From.ID) {
+		return "Only bot admins can decide."
+	}
+	jobID, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return ""
+	}
+
+	holdMu.Lock()
+	decision, ok := holdPending[jobID]
+	delete(holdPending, jobID)
+	holdMu.Unlock()
+
+	verdict := "✅ Approved"
+	if args[1] != "approve" {
+		verdict = "❌ Rejected"
+	}
+	if query.Message != nil {
+		text := query.Message.Text
+		if ok {
+			text += fmt.Sprintf("\n\n%s by %d (@%s)", verdict, query.From.ID, query.From.UserName)
+		} else {
+			text += "\n\nNo longer waiting."
+		}
+		updateMessage(bot, query.Message.Chat.ID, query.Message.MessageID, text)
+	}
+	if !ok {
+		return "This job is no longer waiting."
+	}
+	decision <- args[1] == "approve"
+	return verdict
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	defaultHookTimeout = 60 * time.Second
+	// maxHookOutput caps what is kept of a hook's stdout and stderr.
+	maxHookOutput = 64 << 10
+)
+
+// hookConfig is an operator-defined step that runs on every file before it
+// is sent. A command gets a copy of the file it may change in place, in a
+// directory of its own, with none of the bot's environment; a webhook gets
+// the file POSTed to it. Either answers with a hookVerdict.
+type hookConfig struct {
+	Name           string   `yaml:"name"`
+	Command        []string `yaml:"command"`
+	URL            string   `yaml:"url"`
+	TimeoutSeconds int      `yaml:"timeout_seconds"`
+	// FailOpen sends the file anyway if the hook itself fails, instead of
+	// holding it back.
+	FailOpen bool `yaml:"fail_open"`
+}
+
+// hookVerdict is what a hook prints on stdout, or a webhook answers, as
+// JSON. No output from a command that exits with 0 means allow.
+type hookVerdict struct {
+	Verdict  string `json:"verdict"`
+	Reason   string `json:"reason,omitempty"`
+	FileName string `json:"file_name,omitempty"`
+}
+
+func (h hookConfig) timeout() time.Duration {
+	if h.TimeoutSeconds > 0 {
+		return time.Duration(h.TimeoutSeconds) * time.Second
+	}
+	return defaultHookTimeout
+}
+
+func validateHooks(hooks []hookConfig) error {
+	for i, h := range hooks {
+		if h.Name == "" {
+			return fmt.Errorf("hook %d has no name", i+1)
+		}
+		if (len(h.Command) == 0) == (h.URL == "") {
+			return fmt.Errorf("hook %s needs either a command or a URL", h.Name)
+		}
+		if h.TimeoutSeconds < 0 {
+			return fmt.Errorf("hook %s: timeout can't be negative", h.Name)
+		}
+	}
+	return nil
+}
+
+// hookEnv is all a hook command gets of the environment: the job's details
+// and a PATH, but not the bot's token or other secrets.
+func hookEnv(job *Job, file, contentType string) []string {
+	return []string{
+		"PATH=" + os.Getenv("PATH"),
+		"HOME=" + filepath.Dir(file),
+		"HOOK_FILE=" + file,
+		"HOOK_FILE_NAME=" + job.FileName,
+		"HOOK_CONTENT_TYPE=" + contentType,
+		"HOOK_URL=" + redactURL(job.URL),
+		"HOOK_SHA256=" + job.SHA256,
+		"HOOK_CHAT_ID=" + strconv.FormatInt(job.ChatID, 10),
+		"HOOK_USER_ID=" + strconv.FormatInt(job.UserID, 10),
+	}
+}
+
+func runCommandHook(ctx context.Context, h hookConfig, job *Job, file, contentType string) (hookVerdict, error) {
+	cmd := exec.CommandContext(ctx, h.Command[0], append(h.Command[1:], file)...)
+	cmd.Dir = filepath.Dir(file)
+	cmd.Env = hookEnv(job, file, contentType)
+	sandboxHook(cmd)
+	var stdout, stderr limitedBuffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err := cmd.Run()
+	job.addCPU(cmd)
+	if err != nil {
+		return hookVerdict{}, fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	if strings.TrimSpace(stdout.String()) == "" {
+		return hookVerdict{Verdict: "allow"}, nil
+	}
+	var verdict hookVerdict
+	if err := json.Unmarshal(stdout.Bytes(), &verdict); err != nil {
+		return hookVerdict{}, fmt.Errorf("reading verdict: %w", err)
+	}
+	return verdict, nil
+}
+
+func runWebhook(ctx context.Context, h hookConfig, job *Job, file, contentType string) (hookVerdict, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return hookVerdict{}, err
+	}
+	defer f.Close()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, f)
+	if err != nil {
+		return hookVerdict{}, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("X-File-Name", job.FileName)
+	req.Header.Set("X-File-Content-Type", contentType)
+	req.Header.Set("X-Source-URL", redactURL(job.URL))
+	req.Header.Set("X-SHA256", job.SHA256)
+	// The operator's own service, so not through the download proxy or the
+	// private address checks.
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return hookVerdict{}, err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxHookOutput))
+	if resp.StatusCode != http.StatusOK {
+		return hookVerdict{}, fmt.Errorf("webhook returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	var verdict hookVerdict
+	if err := json.Unmarshal(body, &verdict); err != nil {
+		return hookVerdict{}, fmt.Errorf("reading verdict: %w", err)
+	}
+	return verdict, nil
+}
+
+// runHooks runs the configured hooks on the file in turn. It returns the
+// file to send instead, if a hook command may have changed it, or nil if
+// there are no hooks; the caller removes it. A hook blocking the file, or
+// failing without fail_open, ends the job.
+func runHooks(bot *tgbotapi.BotAPI, job *Job, file *os.File, contentType string) (*os.File, error) {
+	if len(cfg.Hooks) == 0 {
+		return nil, nil
+	}
+	updateMessage(bot, job.ChatID, job.StatusMessageID, "🪝 Running checks...")
+	done := job.timeStage("hooks")
+
+	// Each job's hooks share a directory with a copy of the file, so
+	// changes carry over from one hook to the next.
+	dir, err := os.MkdirTemp(cfg.TempDir, "telegram-hook-*")
+	if err != nil {
+		return nil, failJob("❌ Failed to run the checks", err)
+	}
+	defer os.RemoveAll(dir)
+	copied := filepath.Join(dir, filepath.Base(job.FileName))
+	if err := copyFileTo(file, copied); err != nil {
+		return nil, failJob("❌ Failed to run the checks", err)
+	}
+
+	for _, h := range cfg.Hooks {
+		ctx, cancel := context.WithTimeout(job.ctx, h.timeout())
+		var verdict hookVerdict
+		if len(h.Command) > 0 {
+			verdict, err = runCommandHook(ctx, h, job, copied, contentType)
+		} else {
+			verdict, err = runWebhook(ctx, h, job, copied, contentType)
+		}
+		timedOut := errors.Is(ctx.Err(), context.DeadlineExceeded)
+		cancel()
+		if job.ctx.Err() != nil {
+			return nil, job.ctx.Err()
+		}
+		if err != nil {
+			if timedOut {
+				err = fmt.Errorf("timed out after %s", h.timeout())
+			}
+			job.logger().Warn("Hook failed", "hook", h.Name, "error", err)
+			if h.FailOpen {
+				continue
+			}
+			return nil, failJob("❌ A check on the file failed, so it was not sent. Please try again later.", fmt.Errorf("hook %s: %w", h.Name, err))
+		}
+
+		switch strings.ToLower(verdict.Verdict) {
+		case "", "allow":
+		case "block":
+			job.logger().Warn("Hook blocked file", "hook", h.Name, "reason", verdict.Reason, "sha256", job.SHA256)
+			msg := "🚫 This file was blocked by the bot operator's checks."
+			if verdict.Reason != "" {
+				msg = fmt.Sprintf("🚫 This file was blocked by the bot operator's checks: %s", verdict.Reason)
+			}
+			return nil, &jobError{userMessage: msg, result: resultBlocked, err: fmt.Errorf("blocked by hook %s", h.Name)}
+		default:
+			return nil, failJob("❌ A check on the file failed, so it was not sent. Please try again later.",
+				fmt.Errorf("hook %s: unknown verdict %q", h.Name, verdict.Verdict))
+		}
+		if name, ok := validFileName(verdict.FileName); ok {
+			job.logger().Info("Hook renamed file", "hook", h.Name, "file_name", name)
+			job.setFileName(name)
+		}
+	}
+	done()
+
+	// Moved out of the directory, which goes away with the deferred
+	// RemoveAll.
+	result, err := os.CreateTemp(cfg.TempDir, "telegram-hooked-*")
+	if err != nil {
+		return nil, failJob("❌ Failed to run the checks", err)
+	}
+	if err := os.Rename(copied, result.Name()); err != nil {
+		result.Close()
+		os.Remove(result.Name())
+		return nil, failJob("❌ Failed to run the checks", err)
+	}
+	// The open handle still points at the empty file that was replaced.
+	result.Close()
+	result, err = os.Open(result.Name())
+	if err != nil {
+		return nil, failJob("❌ Failed to run the checks", err)
+	}
+	if info, err := result.Stat(); err == nil && info.Size() > job.uploadLimit() {
+		result.Close()
+		os.Remove(result.Name())
+		return nil, tooLargeError(info.Size(), job.limitMB)
+	}
+	return result, nil
+}
+
+func copyFileTo(src *os.File, path string) error {
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	dst, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.CopyBuffer(dst, src, make([]byte, copyBufferSize())); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
+
+// limitedBuffer keeps the first maxHookOutput bytes written to it and
+// discards the rest, so a chatty hook can't fill the memory.
+type limitedBuffer struct {
+	bytes.Buffer
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := maxHookOutput - b.Len(); room > 0 {
+		b.Buffer.Write(p[:min(len(p), room)])
+	}
+	return len(p), nil
+}
@@ -0,0 +1,12 @@
+//go:build !unix
+
+package main
+
+import (
+	"os/exec"
+	"time"
+)
+
+func sandboxHook(cmd *exec.Cmd) {
+	cmd.WaitDelay = 5 * time.Second
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	minImageDim = 16
+	maxImageDim = 10000
+)
+
+// imageOutputFormat is what an image is converted to: the format asked
+// for, or, when it is only resized, its own if that is jpg or png.
+func imageOutputFormat(opts jobOptions, fileName string) string {
+	if opts.ImageFormat != "" {
+		return opts.ImageFormat
+	}
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".png":
+		return "png"
+	default:
+		return "jpg"
+	}
+}
+
+// convertImage converts the downloaded image with ffmpeg, which reads
+// webp, HEIC and the rest, and shrinks it to fit job.options.MaxDim
+// pixels on its longer side. Files that aren't images are sent as they
+// are.
+func convertImage(bot *tgbotapi.BotAPI, job *Job, file *os.File, contentType string) (*os.File, string, error) {
+	if classifyFile(job.FileName, contentType) != categoryImage {
+		job.logger().Info("Not converting, file isn't an image", "content_type", contentType)
+		return nil, "", nil
+	}
+	opts := job.options
+	format := imageOutputFormat(opts, job.FileName)
+	ext := strings.ToLower(filepath.Ext(job.FileName))
+	if opts.MaxDim == 0 && (ext == "."+format || (format == "jpg" && ext == ".jpeg")) {
+		return nil, "", nil
+	}
+
+	out, err := os.CreateTemp(cfg.TempDir, "telegram-*."+format)
+	if err != nil {
+		return nil, "", failJob("❌ Failed to convert the image", err)
+	}
+	out.Close()
+
+	updateMessage(bot, job.ChatID, job.StatusMessageID, "🖼 Converting image...")
+	done := job.timeStage("image")
+	args := []string{"-i", file.Name(), "-frames:v", "1", "-update", "1"}
+	if opts.MaxDim > 0 {
+		args = append(args, "-vf", fmt.Sprintf("scale=w='min(%[1]d,iw)':h='min(%[1]d,ih)':force_original_aspect_ratio=decrease", opts.MaxDim))
+	}
+	if format == "jpg" {
+		args = append(args, "-q:v", "2")
+	}
+	if err := runFFmpeg(job, append(args, out.Name())...); err != nil {
+		os.Remove(out.Name())
+		return nil, "", failJob("❌ Couldn't convert the image", err)
+	}
+
+	converted, err := os.Open(out.Name())
+	if err != nil {
+		os.Remove(out.Name())
+		return nil, "", failJob("❌ Failed to convert the image", err)
+	}
+	done()
+
+	note := "🖼 Converted to " + strings.ToUpper(format)
+	if opts.MaxDim > 0 {
+		note += fmt.Sprintf(", at most %dpx", opts.MaxDim)
+	}
+	job.setFileName(strings.TrimSuffix(job.FileName, filepath.Ext(job.FileName)) + "." + format)
+	return converted, note, nil
+}
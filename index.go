@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const indexUsage = "Usage: /index [csv|json] [chat id]"
+
+// indexEntry is a file delivered to a group or channel, kept so the chat
+// can export what it has received.
+type indexEntry struct {
+	ChatID      int64     `json:"chat_id"`
+	MessageID   int       `json:"message_id"`
+	Link        string    `json:"link,omitempty"`
+	FileName    string    `json:"file_name"`
+	Size        int64     `json:"size"`
+	SHA256      string    `json:"sha256,omitempty"`
+	URL         string    `json:"url"`
+	JobID       int64     `json:"job_id"`
+	DeliveredAt time.Time `json:"delivered_at"`
+}
+
+func indexPrefix(chatID int64) string {
+	return chatKey(chatID) + ":"
+}
+
+// messageLink is the t.me link to a message, which only public chats and
+// supergroups or channels have.
+func messageLink(chat *tgbotapi.Chat, messageID int) string {
+	if chat == nil {
+		return ""
+	}
+	if chat.UserName != "" {
+		return fmt.Sprintf("https://t.me/%s/%d", chat.UserName, messageID)
+	}
+	if id, ok := strings.CutPrefix(strconv.FormatInt(chat.ID, 10), "-100"); ok {
+		return fmt.Sprintf("https://t.me/c/%s/%d", id, messageID)
+	}
+	return ""
+}
+
+// indexDelivery notes the message a job's file was sent in and adds it to
+// its chat's index. Private chats have no index.
+func indexDelivery(job *Job, sent tgbotapi.Message) {
+	job.sentMessageID = sent.MessageID
+	if job.deliveryChatID() > 0 {
+		return
+	}
+	entry := indexEntry{
+		ChatID:      job.ChatID,
+		MessageID:   sent.MessageID,
+		Link:        messageLink(sent.Chat, sent.MessageID),
+		FileName:    job.FileName,
+		Size:        job.Size,
+		SHA256:      job.SHA256,
+		URL:         job.URL,
+		JobID:       job.ID,
+		DeliveredAt: time.Now(),
+	}
+	// Zero-padded so the entries come out in delivery order.
+	key := fmt.Sprintf("%s%020d", indexPrefix(job.ChatID), job.ID)
+	if err := store.put(bucketIndex, key, entry); err != nil {
+		job.logger().Error("Error saving index entry", "error", err)
+	}
+}
+
+func loadIndex(chatID int64) ([]indexEntry, error) {
+	var entries []indexEntry
+	err := store.forEachPrefix(bucketIndex, indexPrefix(chatID), func(_, value []byte) error {
+		var entry indexEntry
+		if err := json.Unmarshal(value, &entry); err != nil {
+			return err
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+	return entries, err
+}
+
+func encodeIndex(entries []indexEntry, format string) ([]byte, error) {
+	if format == "json" {
+		return json.MarshalIndent(entries, "", "  ")
+	}
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write([]string{"delivered_at", "message_link", "file_name", "size", "sha256", "source_url"})
+	for _, e := range entries {
+		w.Write([]string{
+			e.DeliveredAt.UTC().Format(time.RFC3339), e.Link, e.FileName,
+			strconv.FormatInt(e.Size, 10), e.SHA256, e.URL,
+		})
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// handleIndexCommand exports the index of the chat it is sent in, or,
+// from a private chat, of the one given by ID. Only that chat's admins
+// and bot admins may export it.
+func handleIndexCommand(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
+	if message.From == nil {
+		return
+	}
+	format := "csv"
+	chatID := message.Chat.ID
+	args, err := commandArgs(message)
+	if err != nil {
+		sendErrorMessage(bot, message.Chat.ID, usageError(err, indexUsage))
+		return
+	}
+	for _, arg := range args {
+		switch arg = strings.ToLower(arg); arg {
+		case "csv", "json":
+			format = arg
+		default:
+			id, err := strconv.ParseInt(arg, 10, 64)
+			if err != nil {
+				sendErrorMessage(bot, message.Chat.ID, indexUsage)
+				return
+			}
+			chatID = id
+		}
+	}
+	if chatID > 0 {
+		sendErrorMessage(bot, message.Chat.ID, "❌ Only groups and channels have an index. "+indexUsage)
+		return
+	}
+	if !isAdmin(message.From.ID) && !isChatAdmin(bot, chatID, message.From.ID) {
+		sendErrorMessage(bot, message.Chat.ID, "🚫 Only admins of that chat can export its index.")
+		return
+	}
+
+	entries, err := loadIndex(chatID)
+	if err != nil {
+		slog.Error("Error loading index", "chat_id", chatID, "error", err)
+		sendErrorMessage(bot, message.Chat.ID, "❌ Failed to load the index")
+		return
+	}
+	if len(entries) == 0 {
+		sendMessage(bot, message.Chat.ID, "📂 No files have been delivered there yet.")
+		return
+	}
+	data, err := encodeIndex(entries, format)
+	if err != nil {
+		slog.Error("Error encoding index", "chat_id", chatID, "error", err)
+		sendErrorMessage(bot, message.Chat.ID, "❌ Failed to export the index")
+		return
+	}
+
+	doc := tgbotapi.NewDocument(message.Chat.ID, tgbotapi.FileBytes{
+		Name:  fmt.Sprintf("index-%d-%s.%s", chatID, time.Now().Format(time.DateOnly), format),
+		Bytes: data,
+	})
+	doc.ReplyToMessageID = message.MessageID
+	doc.Caption = fmt.Sprintf("📂 %d delivered files", len(entries))
+	if _, err := bot.Send(doc); err != nil {
+		slog.Error("Error sending index", "chat_id", chatID, "error", err)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	// inlineStartParameter is the /start payload of the button that takes
+	// an inline user to the private chat to fetch the file.
+	inlineStartParameter = "inline"
+	// inlineMaxAge is how old an upload of a link may be to be offered
+	// for it without downloading it again.
+	inlineMaxAge = 24 * time.Hour
+)
+
+// inlineRequests holds the last link each user typed in an inline query.
+// Queries come in as the user types, so nothing is downloaded until they
+// ask for it in the private chat.
+var inlineRequests = struct {
+	mu   sync.Mutex
+	urls map[int64]string
+}{urls: map[int64]string{}}
+
+func isAuthorizedUser(userID int64) bool {
+	if isObserver(userID) {
+		return false
+	}
+	// What the user may do in their private chat with the bot.
+	return isAdmin(userID) || allowlist.allows(userID, userID)
+}
+
+// handleInlineQuery offers the file behind the link typed after the bot's
+// name, if the bot uploaded it lately, to be posted in that chat by its
+// file_id. Other links are fetched in the private chat first.
+func handleInlineQuery(bot *tgbotapi.BotAPI, query *tgbotapi.InlineQuery) {
+	answer := tgbotapi.InlineConfig{
+		InlineQueryID: query.ID,
+		Results:       []interface{}{},
+		IsPersonal:    true,
+	}
+	url := strings.TrimSpace(query.Query)
+	switch {
+	case query.From == nil || !isAuthorizedUser(query.From.ID):
+		answer.SwitchPMText = "🚫 You are not allowed to use this bot"
+		answer.SwitchPMParameter = inlineStartParameter
+	case url == "":
+		answer.SwitchPMText = "Type a link to share its file"
+		answer.SwitchPMParameter = inlineStartParameter
+	default:
+		if _, ok := validateURL(url); !ok {
+			answer.SwitchPMText = "❌ That doesn't look like a valid link"
+			answer.SwitchPMParameter = inlineStartParameter
+			break
+		}
+		if upload, ok := lookupUploadByURL(bot, url, inlineMaxAge); ok {
+			result := tgbotapi.NewInlineQueryResultCachedDocument(cacheKey(url)[:32], upload.FileID, upload.FileName)
+			result.Description = formatJobSize(upload.Size)
+			answer.Results = append(answer.Results, result)
+			answer.CacheTime = 60
+			break
+		}
+		inlineRequests.mu.Lock()
+		inlineRequests.urls[query.From.ID] = url
+		inlineRequests.mu.Unlock()
+		answer.SwitchPMText = "⏬ Fetch this file first"
+		answer.SwitchPMParameter = inlineStartParameter
+	}
+	if _, err := bot.Request(answer); err != nil {
+		slog.Warn("Error answering inline query", "query_id", query.ID, "error", err)
+	}
+}
+
+// handleStartCommand picks up the link of an inline query when the user
+// came over from one, and downloads it here so it can be shared after.
+func handleStartCommand(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
+	if message.CommandArguments() != inlineStartParameter || message.From == nil || !message.Chat.IsPrivate() {
+		return
+	}
+	inlineRequests.mu.Lock()
+	url, ok := inlineRequests.urls[message.From.ID]
+	inlineRequests.mu.Unlock()
+	if !ok {
+		sendMessage(bot, message.Chat.ID, fmt.Sprintf("👋 Type @%s followed by a link in any chat to share the file there.", bot.Self.UserName))
+		return
+	}
+	if !isAuthorized(message) {
+		sendErrorMessage(bot, message.Chat.ID, notAuthorizedMessage)
+		return
+	}
+	// The link is kept for when the message is taken up again after
+	// verification.
+	if !passesGate(bot, message) {
+		return
+	}
+	inlineRequests.mu.Lock()
+	delete(inlineRequests.urls, message.From.ID)
+	inlineRequests.mu.Unlock()
+	if slowDown, ok := checkRateLimit(message.From.ID); !ok {
+		sendErrorMessage(bot, message.Chat.ID, slowDown)
+		return
+	}
+
+	msg := tgbotapi.NewMessage(message.Chat.ID, "⏬ Fetching "+redactURL(url)+". Once it's here, share it with the button below.")
+	msg.DisableWebPagePreview = true
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonSwitch("↩️ Share it", url),
+	))
+	bot.Send(msg)
+	go handleURL(bot, jobs.start(message, url, jobOptions{}))
+}
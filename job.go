@@ -0,0 +1,397 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+type jobState string
+
+const (
+	jobQueued      jobState = "queued"
+	jobDownloading jobState = "downloading"
+	jobUploading   jobState = "uploading"
+)
+
+var jobStateIcons = map[jobState]string{
+	jobQueued:      "⏳",
+	jobDownloading: "⏬",
+	jobUploading:   "📤",
+}
+
+type Job struct {
+	ID              int64
+	ChatID          int64
+	UserID          int64
+	MessageID       int
+	StatusMessageID int
+	URL             string
+	CreatedAt       time.Time
+	StartedAt       time.Time
+
+	FileName string
+	Size     int64
+	SHA256   string
+
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	// partialPath is set when an interrupted download's temp file was kept
+	// for resuming.
+	partialPath string
+	// partialValidator is the ETag or Last-Modified of the download in
+	// partialPath; resuming it sends If-Range with it.
+	partialValidator string
+	// resumed is set for jobs picked up again from a checkpoint.
+	resumed bool
+	// resigned is set once an expired pre-signed URL was signed again.
+	resigned bool
+	options  jobOptions
+	// limitMB is the size limit that applied when the job started.
+	limitMB int64
+	// finalURL is where the download ended up after redirects, if
+	// anywhere else.
+	finalURL string
+	// mirrors are the other mirrors left to try and mirrorURL the one
+	// downloaded from, for hosts on a known mirror network.
+	mirrors   []string
+	mirrorURL string
+	// downloadDeadline is when cfg.DownloadTimeoutMinutes runs out, set on
+	// the first download request.
+	downloadDeadline time.Time
+	// holdsSlot is set while the job holds one of the job slots.
+	holdsSlot bool
+	// memoryReserved is the job's share of cfg.MemoryBudgetMB.
+	memoryReserved int64
+	// quotaReserved is what the job has counted against its user's quota
+	// for the day quotaDay, a quota key.
+	quotaMu       sync.Mutex
+	quotaReserved int64
+	quotaDay      string
+	// speedLimits are the job's own cfg.JobMaxSpeedMbps limiters, by
+	// direction, guarded by bandwidth.mu.
+	speedLimits map[string]*speedLimiter
+	// sentMessageID is the message the file was delivered in.
+	sentMessageID int
+	// storedInS3 is set when the file was too large for Telegram and
+	// went to the S3 bucket instead.
+	storedInS3 bool
+	// savedTo is the rclone remote:path the file was saved to.
+	savedTo string
+	// unchanged is set when a recurring download got the same file as
+	// last time and so didn't post it.
+	unchanged bool
+	// reusedUpload is set when the file was sent by the file_id of an
+	// earlier upload of the link, without downloading it.
+	reusedUpload bool
+	// silent, language and allowedTypes come from the chat's /settings,
+	// chatDisabled from /disable.
+	silent       bool
+	language     string
+	allowedTypes []fileCategory
+	chatDisabled bool
+	// automatic is set for jobs no one is waiting on, recurring downloads
+	// and feed items, which are deferred first under load.
+	automatic bool
+	// bumped is closed when an admin starts the job ahead of the queue.
+	bumped   chan struct{}
+	bumpOnce sync.Once
+
+	// Collected for the diagnostics snapshot of failed jobs.
+	responseHeader http.Header
+	timings        []stageTiming
+
+	mu    sync.Mutex
+	state jobState
+	// progress is the overall percentage across stages.
+	progress float64
+	stages   []string
+	// attempts counts the requests made against cfg.RetryBudget.
+	attempts int
+	// expectedSize is the size the server announced, if it did.
+	expectedSize int64
+	// meters measure the speed of the stages that move bytes.
+	meters map[string]*speedMeter
+	// renameOffered is set while the status message offers Rename,
+	// awaitingName once it was tapped, and customName is the name typed.
+	renameOffered bool
+	awaitingName  bool
+	customName    string
+	// cpuTime, diskBytes, peakDisk and unmeteredOut add up to the job's
+	// usage.
+	cpuTime      time.Duration
+	diskBytes    int64
+	peakDisk     int64
+	unmeteredOut int64
+}
+
+type jobSnapshot struct {
+	ID       int64
+	UserID   int64
+	ChatID   int64
+	URL      string
+	FileName string
+	State    jobState
+	Progress float64
+	Attempts int
+	Size     int64
+	Stages   []string
+	Created  time.Time
+	Usage    jobUsage
+}
+
+func (j *Job) setState(state jobState) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.state = state
+}
+
+// timeStage records how long a pipeline stage takes; call the returned
+// function once the stage completed successfully. Stages that never
+// complete are reported as where the job failed.
+func (j *Job) timeStage(stage string) func() {
+	start := time.Now()
+	j.timings = append(j.timings, stageTiming{Stage: stage})
+	i := len(j.timings) - 1
+	return func() {
+		j.timings[i].Seconds = time.Since(start).Seconds()
+		j.timings[i].Finished = true
+	}
+}
+
+// setFileName is used instead of assigning FileName directly because
+// /status reads it from other goroutines.
+func (j *Job) setFileName(name string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.FileName = name
+}
+
+func (j *Job) setExpectedSize(size int64) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.expectedSize = size
+}
+
+func (j *Job) snapshot() jobSnapshot {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return jobSnapshot{
+		ID:       j.ID,
+		UserID:   j.UserID,
+		ChatID:   j.ChatID,
+		URL:      j.URL,
+		FileName: j.FileName,
+		State:    j.state,
+		Progress: j.progress,
+		Attempts: j.attempts,
+		Size:     j.expectedSize,
+		Stages:   append([]string(nil), j.stages...),
+		Created:  j.CreatedAt,
+	}
+}
+
+type jobRegistry struct {
+	mu   sync.Mutex
+	jobs map[int64]*Job
+	wg   sync.WaitGroup
+}
+
+var jobs = &jobRegistry{jobs: map[int64]*Job{}}
+
+// start registers a new job for a /url request. It is called from the
+// update loop so that limits based on running jobs see it immediately.
+func (r *jobRegistry) start(message *tgbotapi.Message, url string, opts jobOptions) *Job {
+	job := &Job{
+		ChatID:    message.Chat.ID,
+		MessageID: message.MessageID,
+		URL:       url,
+		CreatedAt: time.Now(),
+		options:   opts,
+		state:     jobQueued,
+	}
+	if opts.Encrypt {
+		// hidePassphrase deleted the command, so there's nothing to reply to.
+		job.MessageID = 0
+	}
+	job.ctx, job.cancel = context.WithCancelCause(jobsCtx)
+	if message.From != nil {
+		job.UserID = message.From.ID
+		recordUser(message.From)
+	}
+
+	r.register(job)
+	return job
+}
+
+// requeue registers a new job for the same link and options as a recorded
+// one, replying to the original request.
+func (r *jobRegistry) requeue(rec jobRecord) *Job {
+	job := &Job{
+		ChatID:    rec.ChatID,
+		UserID:    rec.UserID,
+		MessageID: rec.MessageID,
+		URL:       rec.URL,
+		CreatedAt: time.Now(),
+		options:   rec.Options,
+		state:     jobQueued,
+	}
+	job.ctx, job.cancel = context.WithCancelCause(jobsCtx)
+	r.register(job)
+	return job
+}
+
+// cron registers a run of a recurring download.
+func (r *jobRegistry) cron(cj cronJob) *Job {
+	opts := cj.Options
+	opts.CronID = cj.ID
+	if cj.OnlyChanged {
+		opts.UnchangedSHA256 = cj.LastSHA256
+	}
+	job := &Job{
+		ChatID:    cj.ChatID,
+		UserID:    cj.CreatedBy,
+		MessageID: cj.MessageID,
+		URL:       runURL(cj.URL, cj.Template, cj.Params),
+		CreatedAt: time.Now(),
+		options:   opts,
+		state:     jobQueued,
+		automatic: true,
+	}
+	job.ctx, job.cancel = context.WithCancelCause(jobsCtx)
+	r.register(job)
+	return job
+}
+
+// feed registers the download of a new file in a watched feed.
+func (r *jobRegistry) feed(sub feedSubscription, url string) *Job {
+	job := &Job{
+		ChatID:    sub.ChatID,
+		UserID:    sub.CreatedBy,
+		MessageID: sub.MessageID,
+		URL:       url,
+		CreatedAt: time.Now(),
+		options:   sub.Options,
+		state:     jobQueued,
+		automatic: true,
+	}
+	job.ctx, job.cancel = context.WithCancelCause(jobsCtx)
+	r.register(job)
+	return job
+}
+
+// schedule registers the job a /schedule request put off, now that it is
+// due.
+func (r *jobRegistry) schedule(sj scheduledJob) *Job {
+	job := &Job{
+		ChatID:    sj.ChatID,
+		UserID:    sj.UserID,
+		MessageID: sj.MessageID,
+		URL:       runURL(sj.URL, sj.Template, sj.Params),
+		CreatedAt: time.Now(),
+		options:   sj.Options,
+		state:     jobQueued,
+	}
+	job.ctx, job.cancel = context.WithCancelCause(jobsCtx)
+	r.register(job)
+	return job
+}
+
+func (r *jobRegistry) register(job *Job) {
+	// IDs come from the database so they stay unique across restarts.
+	id, err := store.nextID(bucketJobs)
+	if err != nil {
+		slog.Error("Error allocating job ID", "error", err)
+		id = uint64(time.Now().UnixNano())
+	}
+	job.ID = int64(id)
+
+	// Checkpointed right away so the job survives a crash, not only a
+	// graceful shutdown.
+	saveCheckpoint(job, "", 0)
+	r.add(job)
+}
+
+// resume registers a job again from the checkpoint it left behind.
+func (r *jobRegistry) resume(cp checkpoint) *Job {
+	job := &Job{
+		ID:          cp.JobID,
+		ChatID:      cp.ChatID,
+		UserID:      cp.UserID,
+		MessageID:   cp.MessageID,
+		URL:         cp.URL,
+		CreatedAt:   cp.CreatedAt,
+		options:     cp.Options,
+		partialPath: cp.TempPath,
+		resumed:     true,
+		state:       jobQueued,
+	}
+	job.partialValidator = cp.Validator
+	job.ctx, job.cancel = context.WithCancelCause(jobsCtx)
+	r.add(job)
+	return job
+}
+
+func (r *jobRegistry) add(job *Job) {
+	job.bumped = make(chan struct{})
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.jobs[job.ID] = job
+	r.wg.Add(1)
+}
+
+func (r *jobRegistry) finish(job *Job) {
+	job.cancel(nil)
+	stats.touch()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.jobs, job.ID)
+	r.wg.Done()
+}
+
+func (r *jobRegistry) get(id int64) (*Job, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	job, ok := r.jobs[id]
+	return job, ok
+}
+
+func (r *jobRegistry) wait() {
+	r.wg.Wait()
+}
+
+func (r *jobRegistry) countForUser(userID int64) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for _, job := range r.jobs {
+		if job.UserID == userID {
+			n++
+		}
+	}
+	return n
+}
+
+// list returns snapshots of all registered jobs, oldest first.
+func (r *jobRegistry) list() []jobSnapshot {
+	r.mu.Lock()
+	all := make([]*Job, 0, len(r.jobs))
+	for _, job := range r.jobs {
+		all = append(all, job)
+	}
+	r.mu.Unlock()
+
+	snapshots := make([]jobSnapshot, len(all))
+	for i, job := range all {
+		snapshots[i] = job.snapshot()
+		snapshots[i].Usage = job.usage()
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].ID < snapshots[j].ID })
+	return snapshots
+}
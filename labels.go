@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const maxJobLabels = 5
+
+var labelPattern = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+
+// parseLabel normalizes a label given with --label, or in /history and
+// /admin stats.
+func parseLabel(value string) (string, error) {
+	label := strings.ToLower(strings.TrimPrefix(value, "#"))
+	if !labelPattern.MatchString(label) {
+		return "", fmt.Errorf("labels are up to 32 letters, digits, - or _, not %q", value)
+	}
+	return label, nil
+}
+
+func (o *jobOptions) addLabel(value string) error {
+	label, err := parseLabel(value)
+	if err != nil {
+		return err
+	}
+	if slices.Contains(o.Labels, label) {
+		return nil
+	}
+	if len(o.Labels) == maxJobLabels {
+		return fmt.Errorf("a job can have at most %d labels", maxJobLabels)
+	}
+	o.Labels = append(o.Labels, label)
+	return nil
+}
+
+func validateLabelRoutes(routes map[string]int64) error {
+	for label, chatID := range routes {
+		if normalized, err := parseLabel(label); err != nil || normalized != label {
+			return fmt.Errorf("label route %q: labels must be lowercase letters, digits, - or _", label)
+		}
+		if chatID == 0 {
+			return fmt.Errorf("label route %q has no chat ID", label)
+		}
+	}
+	return nil
+}
+
+// routeDelivery copies a delivered file to the chats cfg.LabelRoutes
+// assigns to the job's labels. Of an album only the first item is copied.
+func routeDelivery(bot *tgbotapi.BotAPI, job *Job) {
+	if job.sentMessageID == 0 {
+		return
+	}
+	routed := map[int64]bool{job.deliveryChatID(): true}
+	for _, label := range job.options.Labels {
+		chatID, ok := cfg.LabelRoutes[label]
+		if !ok || routed[chatID] {
+			continue
+		}
+		routed[chatID] = true
+		if _, err := bot.Send(tgbotapi.NewCopyMessage(chatID, job.deliveryChatID(), job.sentMessageID)); err != nil {
+			job.logger().Error("Error routing delivery", "label", label, "route_chat_id", chatID, "error", err)
+			continue
+		}
+		noteDelivery(chatID)
+		job.logger().Info("Routed delivery", "label", label, "route_chat_id", chatID)
+	}
+}
+
+// labelStats sums up the recorded jobs with label.
+func labelStats(label string) (string, error) {
+	var jobs, succeeded, failed int
+	var bytes int64
+	err := store.forEach(bucketJobs, func(_, value []byte) error {
+		var r jobRecord
+		if err := json.Unmarshal(value, &r); err != nil {
+			return err
+		}
+		if !slices.Contains(r.Labels, label) {
+			return nil
+		}
+		jobs++
+		switch r.Result {
+		case resultSuccess:
+			succeeded++
+			bytes += r.Size
+		case resultFailed:
+			failed++
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("📊 Stats for 🏷 %s\n\nJobs: %d\nSucceeded: %d\nFailed: %d\nOther: %d\nDelivered: %.1f MB",
+		label, jobs, succeeded, failed, jobs-succeeded-failed, float64(bytes)/1024/1024), nil
+}
+
+func adminLabelStats(bot *tgbotapi.BotAPI, message *tgbotapi.Message, value string) {
+	label, err := parseLabel(value)
+	if err != nil {
+		sendErrorMessage(bot, message.Chat.ID, "❌ "+err.Error())
+		return
+	}
+	text, err := labelStats(label)
+	if err != nil {
+		slog.Error("Error reading label stats", "label", label, "error", err)
+		sendErrorMessage(bot, message.Chat.ID, "❌ Failed to load the stats")
+		return
+	}
+	sendMessage(bot, message.Chat.ID, text)
+}
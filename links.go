@@ -0,0 +1,66 @@
+package main
+
+import (
+	"strings"
+	"unicode/utf16"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// linkInMessage finds the first link in a message's text or caption, for
+// /url sent as a reply to it.
+func linkInMessage(message *tgbotapi.Message) (string, bool) {
+	links := messageLinks(message)
+	if len(links) == 0 {
+		return "", false
+	}
+	return links[0], true
+}
+
+// messageLinks lists the links in a message's text and caption. Telegram
+// marks links as entities, including the ones behind the words of a
+// forwarded post; a message without them is searched for anything
+// starting with http:// or https://.
+func messageLinks(message *tgbotapi.Message) []string {
+	links := entityLinks(message.Text, message.Entities)
+	links = append(links, entityLinks(message.Caption, message.CaptionEntities)...)
+	if len(links) > 0 {
+		return links
+	}
+	for _, text := range []string{message.Text, message.Caption} {
+		for _, word := range strings.Fields(text) {
+			if strings.HasPrefix(word, "http://") || strings.HasPrefix(word, "https://") {
+				links = append(links, strings.TrimRight(word, ".,;:!?)»\"'"))
+			}
+		}
+	}
+	return links
+}
+
+func entityLinks(text string, entities []tgbotapi.MessageEntity) []string {
+	// Entity offsets count UTF-16 code units.
+	var units []uint16
+	var links []string
+	for _, entity := range entities {
+		switch entity.Type {
+		case "text_link":
+			links = append(links, entity.URL)
+		case "url":
+			if units == nil {
+				units = utf16.Encode([]rune(text))
+			}
+			end := entity.Offset + entity.Length
+			if entity.Offset < 0 || end > len(units) {
+				continue
+			}
+			url := string(utf16.Decode(units[entity.Offset:end]))
+			// Telegram also marks bare domains; those are fetched over
+			// https.
+			if !strings.Contains(url, "://") {
+				url = "https://" + url
+			}
+			links = append(links, url)
+		}
+	}
+	return links
+}
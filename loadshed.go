@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	loadCheckInterval = 15 * time.Second
+	// loadRecoveryChecks is how many checks in a row must find the bot
+	// below every threshold before shedding stops, so it doesn't flap.
+	loadRecoveryChecks = 4
+	deferredMessage    = "⏸ The bot is under heavy load, so this download waits until it recovers."
+)
+
+// loadShed is the load shedding state: while it is on, relief is open and
+// closed once the bot recovered.
+var loadShed struct {
+	mu      sync.Mutex
+	relief  chan struct{}
+	reasons []string
+	since   time.Time
+	clear   int
+}
+
+// shedding returns the channel closed on recovery and why the bot sheds
+// load, or nil if it doesn't.
+func shedding() (<-chan struct{}, []string) {
+	loadShed.mu.Lock()
+	defer loadShed.mu.Unlock()
+	if loadShed.relief == nil {
+		return nil, nil
+	}
+	return loadShed.relief, loadShed.reasons
+}
+
+// loadPressure lists the thresholds the bot is past.
+func loadPressure() []string {
+	var reasons []string
+	if cfg.ShedDiskFreeMB > 0 {
+		if free, err := diskFree(cfg.TempDir); err == nil && free < cfg.ShedDiskFreeMB*1024*1024 {
+			reasons = append(reasons, fmt.Sprintf("only %s of temp disk free", formatMB(free)))
+		}
+	}
+	if cfg.ShedMemoryMB > 0 {
+		var m runtime.MemStats
+		runtime.ReadMemStats(&m)
+		// What the Go runtime holds from the OS, close to the resident size.
+		if used := int64(m.Sys - m.HeapReleased); used > cfg.ShedMemoryMB*1024*1024 {
+			reasons = append(reasons, fmt.Sprintf("%s of memory in use", formatMB(used)))
+		}
+	}
+	if cfg.ShedQueueDepth > 0 {
+		if n := jobSlots.queued(); n >= cfg.ShedQueueDepth {
+			reasons = append(reasons, fmt.Sprintf("%d jobs waiting in the queue", n))
+		}
+	}
+	return reasons
+}
+
+func runLoadMonitor(bot *tgbotapi.BotAPI) {
+	if cfg.ShedDiskFreeMB == 0 && cfg.ShedMemoryMB == 0 && cfg.ShedQueueDepth == 0 {
+		return
+	}
+	for {
+		checkLoad(bot, loadPressure())
+		time.Sleep(loadCheckInterval)
+	}
+}
+
+func checkLoad(bot *tgbotapi.BotAPI, reasons []string) {
+	loadShed.mu.Lock()
+	var notice string
+	switch {
+	case len(reasons) > 0:
+		loadShed.clear = 0
+		loadShed.reasons = reasons
+		if loadShed.relief == nil {
+			loadShed.relief = make(chan struct{})
+			loadShed.since = time.Now()
+			slog.Warn("Shedding load", "reasons", strings.Join(reasons, "; "))
+			notice = "⚠️ The bot is shedding load: " + strings.Join(reasons, "; ") +
+				". Automatic jobs and large files wait until it recovers."
+		}
+	case loadShed.relief != nil:
+		if loadShed.clear++; loadShed.clear >= loadRecoveryChecks {
+			close(loadShed.relief)
+			loadShed.relief = nil
+			loadShed.reasons = nil
+			took := time.Since(loadShed.since).Round(time.Second)
+			slog.Info("Load shedding over", "took", took)
+			notice = fmt.Sprintf("✅ The bot recovered after %s and runs every job again.", took)
+		}
+	}
+	loadShed.mu.Unlock()
+
+	if notice != "" && cfg.LogChannelID != 0 {
+		sendMessage(bot, cfg.LogChannelID, notice)
+	}
+}
+
+// deferUnderLoad holds the job back while the bot sheds load, giving up
+// its slot meanwhile so the jobs that do run can finish.
+func deferUnderLoad(bot *tgbotapi.BotAPI, job *Job) error {
+	relief, reasons := shedding()
+	if relief == nil {
+		return nil
+	}
+	job.logger().Info("Deferring job under load", "reasons", strings.Join(reasons, "; "))
+	if job.StatusMessageID != 0 {
+		updateMessage(bot, job.ChatID, job.StatusMessageID, deferredMessage)
+	}
+	held := job.holdsSlot
+	job.releaseSlot()
+	select {
+	case <-relief:
+	case <-job.bumped:
+		job.logger().Info("Deferred job bumped")
+	case <-job.ctx.Done():
+		return job.ctx.Err()
+	}
+	if held {
+		return jobSlots.acquire(job)
+	}
+	return nil
+}
+
+// deferredUnderLoad reports whether a file of size bytes waits while the
+// bot sheds load.
+func (j *Job) deferredUnderLoad(size int64) bool {
+	return j.automatic || (cfg.ShedLargeFileMB > 0 && size > cfg.ShedLargeFileMB*1024*1024)
+}
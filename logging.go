@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	neturl "net/url"
+	"os"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func parseLogLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(strings.TrimSpace(s))); err != nil {
+		return 0, fmt.Errorf("invalid log level %q (use debug, info, warn or error)", s)
+	}
+	return level, nil
+}
+
+// setupLogging installs the default slog logger. Everything still going
+// through the standard log package, including the Bot API library, ends up
+// there too.
+func setupLogging() {
+	level, _ := parseLogLevel(cfg.LogLevel) // checked by validate
+	opts := &slog.HandlerOptions{Level: level, ReplaceAttr: redactErrorAttr}
+
+	var handler slog.Handler
+	if cfg.LogFormat == "json" {
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	} else {
+		handler = slog.NewTextHandler(os.Stderr, opts)
+	}
+	slog.SetDefault(slog.New(handler))
+	tgbotapi.SetLogger(botAPILogger{})
+}
+
+// redactErrorAttr logs errors the way redactErrorText shows them, so the
+// URLs request errors carry don't end up in the logs in full. Neither does
+// the bot token, which Bot API URLs have in their path.
+func redactErrorAttr(_ []string, a slog.Attr) slog.Attr {
+	if a.Value.Kind() != slog.KindAny {
+		return a
+	}
+	err, ok := a.Value.Any().(error)
+	if !ok {
+		return a
+	}
+	text := redactErrorText(err)
+	if cfg.TelegramToken != "" {
+		text = strings.ReplaceAll(text, cfg.TelegramToken, "REDACTED")
+	}
+	return slog.String(a.Key, text)
+}
+
+// botAPILogger sends the Bot API library's output, which is mostly request
+// dumps when bot.Debug is on, to slog at debug level.
+type botAPILogger struct{}
+
+func (botAPILogger) Println(v ...interface{}) {
+	slog.Debug(strings.TrimSpace(fmt.Sprintln(v...)), "component", "botapi")
+}
+
+func (botAPILogger) Printf(format string, v ...interface{}) {
+	slog.Debug(strings.TrimSpace(fmt.Sprintf(format, v...)), "component", "botapi")
+}
+
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+func urlHost(raw string) string {
+	u, err := neturl.Parse(raw)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
+
+// logger returns a logger carrying the fields identifying the job. Full URLs
+// are left out of the logs since they often carry tokens.
+func (j *Job) logger() *slog.Logger {
+	return slog.With("job_id", j.ID, "chat_id", j.ChatID, "user_id", j.UserID, "host", urlHost(j.URL))
+}
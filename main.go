@@ -1,166 +1,324 @@
 package main
 
 import (
-	"fmt"
-	"io"
-	"log"
+	"context"
+	"errors"
+	"flag"
+	"log/slog"
 	"net/http"
+	neturl "net/url"
 	"os"
-	"path/filepath"
+	"os/signal"
 	"strings"
-	"time"
+	"syscall"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
-	"github.com/joho/godotenv"
 )
 
 const (
 	MAX_TELEGRAM_FILE_SIZE = 50 * 1024 * 1024
+	localAPIFileSizeMB     = 2000
 )
 
-type ProgressReader struct {
-	io.Reader
-	total      int64
-	downloaded int64
-	onProgress func(float64)
-}
+// version is set at build time with -ldflags "-X main.version=...".
+var version = "dev"
 
-func (pr *ProgressReader) Read(p []byte) (int, error) {
-	n, err := pr.Reader.Read(p)
-	pr.downloaded += int64(n)
-	if pr.total > 0 {
-		progress := float64(pr.downloaded) / float64(pr.total) * 100
-		pr.onProgress(progress)
-	}
-	return n, err
-}
+var (
+	httpClient = http.DefaultClient
+	jobSlots   *slotScheduler
+)
 
 func main() {
-	if err := godotenv.Load(); err != nil {
-		log.Fatal("Error loading .env file")
+	loaded, err := LoadConfig(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
 	}
+	if err != nil {
+		fatal("Error loading configuration", "error", err)
+	}
+	cfg = loaded
+	setupLogging()
+	tuneRuntime()
 
-	bot, err := tgbotapi.NewBotAPI(os.Getenv("TELEGRAM_BOT_TOKEN"))
+	httpClient, err = newProxyClient(cfg.DownloadProxy)
 	if err != nil {
-		log.Panic(err)
+		fatal("Invalid download proxy", "error", err)
+	}
+	protectDownloads(httpClient)
+	tuneTransport(httpClient)
+	if cfg.PrefetchDNS && cfg.DownloadProxy == "" {
+		enablePrefetch(httpClient)
+	}
+	apiClient, err := newProxyClient(cfg.TelegramProxy)
+	if err != nil {
+		fatal("Invalid Telegram proxy", "error", err)
+	}
+	jobSlots = newSlotScheduler(cfg.MaxConcurrentJobs, cfg.UserMaxConcurrentDownloads)
+	if cfg.MaxConcurrentUploads > 0 {
+		uploadSlots = make(chan struct{}, cfg.MaxConcurrentUploads)
+	}
+	allowlist.load(cfg.AllowedUserIDs, cfg.AllowedChatIDs)
+	fileSizeLimitMB.Store(cfg.MaxFileSizeMB)
+	if err := loadSigningKey(); err != nil {
+		fatal("Error loading signing key", "error", err)
+	}
+	if signer != nil {
+		slog.Info("Signing delivered files", "key_id", signer.keyID(), "public_key", signer.publicKey())
 	}
 
-	bot.Debug = true
-	log.Printf("Authorized on account %s", bot.Self.UserName)
+	store, err = OpenStore(cfg.DBPath)
+	if err != nil {
+		fatal("Error opening database", "error", err)
+	}
+	defer store.Close()
+	if cfg.CacheDir != "" {
+		if err := os.MkdirAll(cfg.CacheDir, 0o700); err != nil {
+			fatal("Error creating cache directory", "error", err)
+		}
+	}
+	applySetup()
+	allowlist.loadBans()
+	pruneOldQuotas()
+	pruneDiagnostics()
 
-	u := tgbotapi.NewUpdate(0)
-	u.Timeout = 60
+	endpoint := tgbotapi.APIEndpoint
+	if cfg.TelegramAPIURL != "" {
+		endpoint = strings.TrimSuffix(cfg.TelegramAPIURL, "/") + "/bot%s/%s"
+	}
+	bot, err := tgbotapi.NewBotAPIWithClient(cfg.TelegramToken, endpoint, apiClient)
+	if err != nil {
+		fatal("Error connecting to Telegram", "error", err)
+	}
 
-	updates := bot.GetUpdatesChan(u)
+	bot.Debug = slog.Default().Enabled(context.Background(), slog.LevelDebug)
+	slog.Info("Authorized", "account", bot.Self.UserName, "version", version)
 
-	for update := range updates {
-		if update.Message == nil {
-			continue
-		}
+	go runTempSweeper()
+	go runInactiveChatJanitor(bot)
+	go runDigestReporter(bot)
+	go runScheduler(bot)
+	go runFeedWatcher(bot)
+	go runLoadMonitor(bot)
+	go runMaintenance(bot)
+	if cfg.HealthAddr != "" {
+		go runHealthServer(bot)
+	}
+	resumeJobs(bot)
 
-		// Check if message starts with /url command
-		if strings.HasPrefix(update.Message.Text, "/url ") {
-			// Extract URL from the command
-			url := strings.TrimPrefix(update.Message.Text, "/url ")
-			url = strings.TrimSpace(url)
-
-			if url != "" {
-				// Process URL in the same group where command was received
-				go handleURL(bot, update.Message, url)
-			} else {
-				sendErrorMessage(bot, update.Message.Chat.ID, "❌ No URL was given. Please provide a URL after the /url command.")
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	updates := pollUpdates(ctx, bot)
+	for {
+		select {
+		case <-ctx.Done():
+			shutdown(bot)
+			return
+		case update, ok := <-updates:
+			if !ok {
+				updates = nil
+				continue
 			}
-		} else if strings.HasPrefix(update.Message.Text, "http://") || strings.HasPrefix(update.Message.Text, "https://") {
-			sendErrorMessage(bot, update.Message.Chat.ID, "❌ Please use the /url command followed by the link.")
-		} else if strings.TrimSpace(update.Message.Text) == "/url" {
-			sendErrorMessage(bot, update.Message.Chat.ID, "❌ No URL was given. Please provide a URL after the /url command.")
+			handleUpdate(bot, update)
 		}
 	}
 }
 
-func handleURL(bot *tgbotapi.BotAPI, message *tgbotapi.Message, url string) {
-	statusMsg := tgbotapi.NewMessage(message.Chat.ID, "⏳ Starting download...")
-	status, err := bot.Send(statusMsg)
-	if err != nil {
-		log.Printf("Error sending initial status: %v", err)
+func handleUpdate(bot *tgbotapi.BotAPI, update tgbotapi.Update) {
+	if update.MyChatMember != nil {
+		go handleMyChatMember(bot, update.MyChatMember)
 		return
 	}
 
-	resp, err := http.Head(url)
-	if err != nil {
-		sendErrorMessage(bot, message.Chat.ID, "❌ Failed to get file info")
+	if update.CallbackQuery != nil {
+		go handleCallbackQuery(bot, update.CallbackQuery)
 		return
 	}
-	fileSize := resp.ContentLength
 
-	if fileSize > MAX_TELEGRAM_FILE_SIZE {
-		sizeMB := float64(fileSize) / 1024 / 1024
-		errorMsg := fmt.Sprintf("❌ File is too large (%.1f MB). Telegram bot limit is 50 MB.\n\nPlease use a direct download link instead.", sizeMB)
-		sendErrorMessage(bot, message.Chat.ID, errorMsg)
+	if update.InlineQuery != nil {
+		go handleInlineQuery(bot, update.InlineQuery)
 		return
 	}
 
-	resp, err = http.Get(url)
-	if err != nil {
-		sendErrorMessage(bot, message.Chat.ID, "❌ Failed to download the file")
+	if update.Message == nil {
 		return
 	}
-	defer resp.Body.Close()
 
-	fileName := filepath.Base(url)
-	if fileName == "" {
-		fileName = "downloaded_file"
+	touchChat(update.Message.Chat)
+
+	if takeCustomName(update.Message) {
+		return
 	}
 
-	tempFile, err := os.CreateTemp("", "telegram-*-"+fileName)
-	if err != nil {
-		sendErrorMessage(bot, message.Chat.ID, "❌ Failed to create temporary file")
-		return
-	}
-	defer os.Remove(tempFile.Name())
-	defer tempFile.Close()
-
-	lastUpdate := time.Now()
-	progressReader := &ProgressReader{
-		Reader: resp.Body,
-		total:  fileSize,
-		onProgress: func(progress float64) {
-			// Update status message every 2 seconds to avoid flooding
-			if time.Since(lastUpdate) >= 2*time.Second {
-				statusText := fmt.Sprintf("⏬ Downloading: %.1f%%", progress)
-				updateMessage(bot, message.Chat.ID, status.MessageID, statusText)
-				lastUpdate = time.Now()
-			}
-		},
+	command := update.Message.Command()
+	if downloadCommands[command] && chatDisabled(update.Message.Chat.ID) {
+		sendErrorMessage(bot, update.Message.Chat.ID, chatDisabledMessage)
+		return
+	}
+	if downloadCommands[command] && isAuthorized(update.Message) && !passesGate(bot, update.Message) {
+		return
 	}
 
-	_, err = io.Copy(tempFile, progressReader)
-	if err != nil {
-		sendErrorMessage(bot, message.Chat.ID, "❌ Failed to save the file")
+	switch command {
+	case "url":
+		handleURLCommand(bot, update.Message)
+		return
+	case "start":
+		handleStartCommand(bot, update.Message)
+		return
+	case "admin":
+		handleAdminCommand(bot, update.Message)
+		return
+	case "allowlist":
+		handleAllowlistCommand(bot, update.Message)
+		return
+	case "history":
+		go handleHistoryCommand(bot, update.Message)
+		return
+	case "status":
+		handleStatusCommand(bot, update.Message)
+		return
+	case "queue":
+		handleQueueCommand(bot, update.Message)
+		return
+	case "cancelall":
+		handleCancelAllCommand(bot, update.Message)
+		return
+	case "requeue":
+		handleRequeueCommand(bot, update.Message)
+		return
+	case "alias":
+		handleAliasCommand(bot, update.Message)
+		return
+	case "verify":
+		go handleVerifyCommand(bot, update.Message)
+		return
+	case "zip":
+		handleZipCommand(bot, update.Message)
+		return
+	case "shot":
+		handleShotCommand(bot, update.Message)
+		return
+	case "audio":
+		handleAudioCommand(bot, update.Message)
+		return
+	case "index":
+		go handleIndexCommand(bot, update.Message)
+		return
+	case "digest":
+		handleDigestCommand(bot, update.Message)
+		return
+	case "schedule":
+		handleScheduleCommand(bot, update.Message)
+		return
+	case "cron":
+		handleCronCommand(bot, update.Message)
+		return
+	case "setup":
+		handleSetupCommand(bot, update.Message)
+		return
+	case "watch":
+		go handleWatchCommand(bot, update.Message)
+		return
+	case "enable", "disable":
+		go handleEnableCommand(bot, update.Message, command == "enable")
+		return
+	case "settings":
+		handleSettingsCommand(bot, update.Message)
+		return
+	case "autodownload":
+		go handleAutoDownloadCommand(bot, update.Message)
 		return
 	}
 
-	updateMessage(bot, message.Chat.ID, status.MessageID, "📤 Uploading to Telegram...")
+	if command == "" && autoDownload(bot, update.Message) {
+		return
+	}
+	if strings.HasPrefix(update.Message.Text, "http://") || strings.HasPrefix(update.Message.Text, "https://") {
+		sendErrorMessage(bot, update.Message.Chat.ID, "❌ Please use the /url command followed by the link.")
+	}
+}
 
-	tempFile.Seek(0, 0)
+func handleURLCommand(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
+	if !isAuthorized(message) {
+		sendErrorMessage(bot, message.Chat.ID, notAuthorizedMessage)
+		return
+	}
+	if !hidePassphrase(bot, message) {
+		return
+	}
+	args, err := commandArgs(message)
+	if err != nil {
+		sendErrorMessage(bot, message.Chat.ID, usageError(err, urlUsage))
+		return
+	}
+	opts, args, err := parseJobOptions(args)
+	if err != nil {
+		sendErrorMessage(bot, message.Chat.ID, usageError(err, urlUsage))
+		return
+	}
+	if len(args) == 0 && message.ReplyToMessage != nil {
+		link, ok := linkInMessage(message.ReplyToMessage)
+		if !ok {
+			sendErrorMessage(bot, message.Chat.ID, "❌ The message you replied to has no link in it.")
+			return
+		}
+		args = []string{link}
+	}
+	if len(args) == 0 {
+		sendErrorMessage(bot, message.Chat.ID, "❌ No URL was given. Please provide a URL after the /url command, or reply /url to a message with a link.\n\n"+urlUsage)
+		return
+	}
 
-	doc := tgbotapi.NewDocument(message.Chat.ID, tgbotapi.FilePath(tempFile.Name()))
-	doc.ReplyToMessageID = message.MessageID
+	url, ok := resolveURLArgs(message, args)
+	if !ok {
+		sendErrorMessage(bot, message.Chat.ID, url)
+		return
+	}
+	if problem, ok := validateURL(url); !ok {
+		sendErrorMessage(bot, message.Chat.ID, problem)
+		return
+	}
 
-	_, err = bot.Send(doc)
-	if err != nil {
-		sendErrorMessage(bot, message.Chat.ID, "❌ Failed to send the file")
+	if duplicates.isDuplicate(message) {
+		slog.Debug("Ignoring duplicate /url", "chat_id", message.Chat.ID)
+		return
+	}
+	var userID int64
+	if message.From != nil {
+		userID = message.From.ID
+	}
+	if slowDown, ok := checkRateLimit(userID); !ok {
+		sendErrorMessage(bot, message.Chat.ID, slowDown)
 		return
 	}
+	// Process URL in the same group where command was received
+	go handleURL(bot, jobs.start(message, url, opts))
+}
 
-	updateMessage(bot, message.Chat.ID, status.MessageID, "✅ File sent successfully!")
+func newProxyClient(proxy string) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if proxy != "" {
+		proxyURL, err := neturl.Parse(proxy)
+		if err != nil {
+			return nil, err
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	return &http.Client{Transport: transport}, nil
 }
 
 func updateMessage(bot *tgbotapi.BotAPI, chatID int64, messageID int, text string) {
 	edit := tgbotapi.NewEditMessageText(chatID, messageID, text)
+	edit.ReplyMarkup = statusKeyboard(chatID, messageID)
 	bot.Send(edit)
 }
 
+func sendMessage(bot *tgbotapi.BotAPI, chatID int64, text string) {
+	msg := tgbotapi.NewMessage(chatID, text)
+	bot.Send(msg)
+}
+
 func sendErrorMessage(bot *tgbotapi.BotAPI, chatID int64, message string) {
 	msg := tgbotapi.NewMessage(chatID, message)
 	bot.Send(msg)
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	// compactMinFree is the share of the database file that has to be free
+	// pages before compacting it is worth holding up the store.
+	compactMinFree = 0.25
+	// orphanMinAge keeps files a job is just moving into the cache from
+	// being taken for orphans before its entry is saved.
+	orphanMinAge = time.Hour
+)
+
+// maintenanceMu keeps a run from /admin maintenance and a scheduled one
+// from overlapping.
+var maintenanceMu sync.Mutex
+
+type maintenanceReport struct {
+	took time.Duration
+
+	cacheChecked  int
+	cacheExpired  int
+	cacheCorrupt  int
+	cacheMissing  int
+	orphans       int
+	cacheFreed    int64
+	cacheErrors   int
+	dbProblems    []error
+	dbBefore      int64
+	dbAfter       int64
+	compactFailed error
+}
+
+func (r maintenanceReport) String() string {
+	lines := []string{fmt.Sprintf("🧹 Maintenance done in %s", r.took.Round(time.Second))}
+	if cacheEnabled() {
+		line := fmt.Sprintf("🗄 Cache: %d entries checked, %d expired, %d corrupt, %d missing, %d orphan files removed, %s freed",
+			r.cacheChecked, r.cacheExpired, r.cacheCorrupt, r.cacheMissing, r.orphans, formatMB(r.cacheFreed))
+		if r.cacheErrors > 0 {
+			line += fmt.Sprintf(" (%d couldn't be checked)", r.cacheErrors)
+		}
+		lines = append(lines, line)
+	}
+	if len(r.dbProblems) == 0 {
+		lines = append(lines, "✅ Database: no problems found")
+	} else {
+		lines = append(lines, fmt.Sprintf("⚠️ Database: %d problems found, the first: %v. It isn't compacted until they are fixed.", len(r.dbProblems), r.dbProblems[0]))
+	}
+	switch {
+	case len(r.dbProblems) > 0:
+	case r.compactFailed != nil:
+		lines = append(lines, "❌ Compacting the database failed: "+r.compactFailed.Error())
+	case r.dbAfter != r.dbBefore:
+		lines = append(lines, fmt.Sprintf("📉 Database compacted from %s to %s", formatMB(r.dbBefore), formatMB(r.dbAfter)))
+	default:
+		lines = append(lines, fmt.Sprintf("💾 Database is %s, not worth compacting", formatMB(r.dbBefore)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func runMaintenance(bot *tgbotapi.BotAPI) {
+	if cfg.maintenance == nil {
+		return
+	}
+	for {
+		next := cfg.maintenance.next(time.Now())
+		if next.IsZero() {
+			slog.Warn("Maintenance schedule never matches", "schedule", cfg.MaintenanceSchedule)
+			return
+		}
+		time.Sleep(time.Until(next))
+		report := maintain()
+		if cfg.LogChannelID != 0 {
+			sendMessage(bot, cfg.LogChannelID, report.String())
+		}
+	}
+}
+
+// maintain checks the cache and the database and compacts the latter.
+func maintain() maintenanceReport {
+	maintenanceMu.Lock()
+	defer maintenanceMu.Unlock()
+
+	start := time.Now()
+	var r maintenanceReport
+	if cacheEnabled() {
+		checkCache(&r)
+	}
+
+	var err error
+	if r.dbProblems, err = store.check(); err != nil {
+		r.dbProblems = append(r.dbProblems, err)
+	}
+	for _, problem := range r.dbProblems {
+		slog.Error("Database inconsistency", "error", problem)
+	}
+	if len(r.dbProblems) == 0 {
+		// A damaged file is left as it is for whoever repairs it.
+		r.dbBefore, r.dbAfter, r.compactFailed = store.compact(compactMinFree)
+	}
+	pruneOldQuotas()
+	r.took = time.Since(start)
+
+	slog.Info("Maintenance done", "took", r.took.Round(time.Second), "cache_checked", r.cacheChecked,
+		"cache_expired", r.cacheExpired, "cache_corrupt", r.cacheCorrupt, "cache_missing", r.cacheMissing,
+		"orphans", r.orphans, "cache_freed", r.cacheFreed, "db_problems", len(r.dbProblems),
+		"db_before", r.dbBefore, "db_after", r.dbAfter, "compact_error", r.compactFailed)
+	return r
+}
+
+// checkCache drops cache entries that expired or whose copy is gone or no
+// longer matches its hash, and removes files in the cache directory no
+// entry points at.
+func checkCache(r *maintenanceReport) {
+	entries := map[string]cacheEntry{}
+	var broken []string
+	err := store.forEach(bucketCache, func(key, value []byte) error {
+		var entry cacheEntry
+		if err := json.Unmarshal(value, &entry); err != nil {
+			broken = append(broken, string(key))
+			return nil
+		}
+		entries[string(key)] = entry
+		return nil
+	})
+	if err != nil {
+		slog.Error("Error listing cache entries", "error", err)
+		r.cacheErrors++
+		return
+	}
+	for _, key := range broken {
+		r.cacheCorrupt++
+		if err := store.delete(bucketCache, key); err != nil {
+			slog.Error("Error removing cache entry", "key", key, "error", err)
+		}
+	}
+
+	known := map[string]bool{}
+	for key, entry := range entries {
+		r.cacheChecked++
+		if time.Since(entry.StoredAt) > cacheTTL() {
+			r.cacheExpired++
+			r.cacheFreed += entry.Size
+			dropCacheEntry(key, entry)
+			continue
+		}
+		state, err := verifyCacheEntry(entry)
+		switch {
+		case err != nil:
+			slog.Warn("Error checking cache entry", "key", key, "path", entry.Path, "object_key", entry.ObjectKey, "error", err)
+			r.cacheErrors++
+			known[entry.Path] = true
+		case state == cacheIntact:
+			known[entry.Path] = true
+		default:
+			slog.Warn("Dropping cache entry", "key", key, "path", entry.Path, "object_key", entry.ObjectKey, "state", state)
+			if state == cacheGone {
+				r.cacheMissing++
+			} else {
+				r.cacheCorrupt++
+				r.cacheFreed += entry.Size
+			}
+			dropCacheEntry(key, entry)
+		}
+	}
+
+	if cfg.CacheDir == "" {
+		return
+	}
+	files, err := os.ReadDir(cfg.CacheDir)
+	if err != nil {
+		slog.Error("Error listing cache directory", "dir", cfg.CacheDir, "error", err)
+		r.cacheErrors++
+		return
+	}
+	for _, file := range files {
+		path := filepath.Join(cfg.CacheDir, file.Name())
+		info, err := file.Info()
+		if err != nil || file.IsDir() || known[path] || time.Since(info.ModTime()) < orphanMinAge {
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			slog.Error("Error removing orphan cache file", "path", path, "error", err)
+			continue
+		}
+		r.orphans++
+		r.cacheFreed += info.Size()
+	}
+}
+
+type cacheState string
+
+const (
+	cacheIntact  cacheState = "intact"
+	cacheCorrupt cacheState = "corrupt"
+	cacheGone    cacheState = "gone"
+)
+
+// verifyCacheEntry checks that the cached copy is still the file that was
+// stored. Files on disk are hashed again; objects in the bucket are only
+// looked up and their size compared, since hashing them would download
+// the whole cache.
+func verifyCacheEntry(entry cacheEntry) (cacheState, error) {
+	if entry.ObjectKey != "" {
+		if !s3Enabled() {
+			return cacheGone, nil
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		resp, err := doS3Request(ctx, http.MethodHead, entry.ObjectKey, nil, 0)
+		if err != nil {
+			return "", err
+		}
+		resp.Body.Close()
+		switch {
+		case resp.StatusCode == http.StatusNotFound:
+			return cacheGone, nil
+		case resp.StatusCode != http.StatusOK:
+			return "", fmt.Errorf("S3 lookup: %s", resp.Status)
+		case resp.ContentLength >= 0 && resp.ContentLength != entry.Size:
+			return cacheCorrupt, nil
+		}
+		return cacheIntact, nil
+	}
+
+	file, err := os.Open(entry.Path)
+	if os.IsNotExist(err) {
+		return cacheGone, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hasher := sha256.New()
+	n, err := io.Copy(hasher, file)
+	if err != nil {
+		return "", err
+	}
+	if n != entry.Size || (entry.SHA256 != "" && hex.EncodeToString(hasher.Sum(nil)) != entry.SHA256) {
+		return cacheCorrupt, nil
+	}
+	return cacheIntact, nil
+}
+
+func adminMaintenance(bot *tgbotapi.BotAPI, message *tgbotapi.Message, _ []string) {
+	if !maintenanceMu.TryLock() {
+		sendErrorMessage(bot, message.Chat.ID, "⏳ Maintenance is already running.")
+		return
+	}
+	maintenanceMu.Unlock()
+	sendMessage(bot, message.Chat.ID, "🧹 Checking the cache and the database…")
+	go func() {
+		sendMessage(bot, message.Chat.ID, maintain().String())
+	}()
+}
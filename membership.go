@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const groupGreeting = "👋 Hi! Send /url <link> and I'll download the file and post it here (up to %d MB)."
+
+// sendRights is decoded from raw API responses because newer Bot API versions
+// report can_send_documents, which the tgbotapi types don't know about yet.
+type sendRights struct {
+	Status               string `json:"status"`
+	CanPostMessages      bool   `json:"can_post_messages"`
+	CanSendMessages      *bool  `json:"can_send_messages"`
+	CanSendMediaMessages *bool  `json:"can_send_media_messages"`
+	CanSendDocuments     *bool  `json:"can_send_documents"`
+}
+
+func (r sendRights) messages() bool {
+	return r.CanSendMessages != nil && *r.CanSendMessages
+}
+
+func (r sendRights) documents() bool {
+	if r.CanSendDocuments != nil {
+		return *r.CanSendDocuments
+	}
+	return r.CanSendMediaMessages != nil && *r.CanSendMediaMessages
+}
+
+func handleMyChatMember(bot *tgbotapi.BotAPI, update *tgbotapi.ChatMemberUpdated) {
+	chat := update.Chat
+	if chat.IsPrivate() {
+		return
+	}
+
+	newStatus := update.NewChatMember.Status
+	if newStatus == "left" || newStatus == "kicked" {
+		slog.Info("Removed from chat", "chat_id", chat.ID, "title", chat.Title)
+		forgetChat(chat.ID)
+		return
+	}
+
+	oldStatus := update.OldChatMember.Status
+	joined := oldStatus == "left" || oldStatus == "kicked"
+	if joined {
+		touchChat(&chat)
+	}
+
+	missing, canMessage, err := missingRights(bot, chat)
+	if err != nil {
+		slog.Error("Error checking permissions", "chat_id", chat.ID, "error", err)
+		return
+	}
+
+	if len(missing) == 0 {
+		if joined && !chat.IsChannel() {
+			sendMessage(bot, chat.ID, fmt.Sprintf(groupGreeting, maxFileSizeMB()))
+		}
+		return
+	}
+
+	notice := fmt.Sprintf("⚠️ I can't work properly in \"%s\" yet. Please grant me: %s.", chat.Title, strings.Join(missing, ", "))
+	if _, err := bot.Send(tgbotapi.NewMessage(update.From.ID, notice)); err != nil {
+		slog.Error("Error notifying user about missing rights", "user_id", update.From.ID, "error", err)
+		if canMessage {
+			sendMessage(bot, chat.ID, notice)
+		}
+	}
+}
+
+// missingRights lists the permissions the bot still needs in chat, and
+// reports whether it can at least post text messages there.
+func missingRights(bot *tgbotapi.BotAPI, chat tgbotapi.Chat) ([]string, bool, error) {
+	var member sendRights
+	if err := requestJSON(bot, tgbotapi.GetChatMemberConfig{
+		ChatConfigWithUser: tgbotapi.ChatConfigWithUser{ChatID: chat.ID, UserID: bot.Self.ID},
+	}, &member); err != nil {
+		return nil, false, err
+	}
+
+	if chat.IsChannel() {
+		if member.Status == "administrator" && member.CanPostMessages {
+			return nil, true, nil
+		}
+		return []string{"admin rights with 'Post Messages'"}, false, nil
+	}
+
+	rights := member
+	switch member.Status {
+	case "creator", "administrator":
+		return nil, true, nil
+	case "member":
+		// Plain members inherit the chat's default permissions.
+		var info struct {
+			Permissions *sendRights `json:"permissions"`
+		}
+		if err := requestJSON(bot, tgbotapi.ChatInfoConfig{
+			ChatConfig: tgbotapi.ChatConfig{ChatID: chat.ID},
+		}, &info); err != nil {
+			return nil, false, err
+		}
+		if info.Permissions == nil {
+			return nil, true, nil
+		}
+		rights = *info.Permissions
+	}
+
+	var missing []string
+	if !rights.messages() {
+		missing = append(missing, "'Send Messages'")
+	}
+	if !rights.documents() {
+		missing = append(missing, "'Send Media' (documents)")
+	}
+	return missing, rights.messages(), nil
+}
+
+func requestJSON(bot *tgbotapi.BotAPI, c tgbotapi.Chattable, v interface{}) error {
+	resp, err := bot.Request(c)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(resp.Result, v)
+}
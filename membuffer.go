@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// memoryBuffers tracks how much of cfg.MemoryBudgetMB the temp files kept
+// in cfg.MemoryDir take up.
+var memoryBuffers struct {
+	mu   sync.Mutex
+	used int64
+}
+
+// bufferDir picks where the job's temp file of size bytes goes: the
+// memory-backed cfg.MemoryDir for a small file while the budget has room,
+// cfg.TempDir otherwise. A file of unknown size could grow past anything
+// reserved for it, so it always goes to disk, and a download in memory is
+// stopped if it grows past the size it reserved.
+func (j *Job) bufferDir(size int64) string {
+	if cfg.MemoryBufferMB <= 0 || size <= 0 || size > cfg.MemoryBufferMB*1024*1024 {
+		return cfg.TempDir
+	}
+	memoryBuffers.mu.Lock()
+	defer memoryBuffers.mu.Unlock()
+	if memoryBuffers.used+size > cfg.MemoryBudgetMB*1024*1024 {
+		j.logger().Debug("Memory budget used up, buffering on disk", "size", size, "used", memoryBuffers.used)
+		return cfg.TempDir
+	}
+	memoryBuffers.used += size
+	j.memoryReserved = size
+	return cfg.MemoryDir
+}
+
+// releaseBuffer returns the job's share of the memory budget.
+func (j *Job) releaseBuffer() {
+	if j.memoryReserved == 0 {
+		return
+	}
+	memoryBuffers.mu.Lock()
+	defer memoryBuffers.mu.Unlock()
+	memoryBuffers.used -= j.memoryReserved
+	j.memoryReserved = 0
+}
+
+func validateMemoryBuffering(c *Config) error {
+	if c.MemoryBufferMB <= 0 {
+		return nil
+	}
+	if c.MemoryBudgetMB < c.MemoryBufferMB {
+		return fmt.Errorf("memory budget (%d MB) must be at least the memory buffer size (%d MB)", c.MemoryBudgetMB, c.MemoryBufferMB)
+	}
+	info, err := os.Stat(c.MemoryDir)
+	if err != nil {
+		return fmt.Errorf("memory dir: %w", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("memory dir %s is not a directory", c.MemoryDir)
+	}
+	return nil
+}
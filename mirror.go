@@ -0,0 +1,133 @@
+package main
+
+import (
+	"math/rand/v2"
+	neturl "net/url"
+	"regexp"
+	"strings"
+)
+
+// mirrorNetwork is a set of hosts that serve the same files under the
+// same paths, so a failing download can be tried on another one.
+type mirrorNetwork struct {
+	name  string
+	hosts []string
+	// suffix also counts any subdomain ending in it as a member.
+	suffix string
+}
+
+var mirrorNetworks = []mirrorNetwork{
+	{
+		name: "SourceForge",
+		hosts: []string{
+			"downloads.sourceforge.net", "netix.dl.sourceforge.net", "phoenixnap.dl.sourceforge.net",
+			"altushost-swe.dl.sourceforge.net", "freefr.dl.sourceforge.net", "kumisystems.dl.sourceforge.net",
+			"deac-riga.dl.sourceforge.net", "onboardcloud.dl.sourceforge.net", "excellmedia.dl.sourceforge.net",
+		},
+		suffix: ".dl.sourceforge.net",
+	},
+	{
+		name:  "kernel.org",
+		hosts: []string{"cdn.kernel.org", "mirrors.edge.kernel.org", "www.kernel.org"},
+	},
+	{
+		name: "Debian",
+		hosts: []string{
+			"deb.debian.org", "ftp.debian.org", "ftp.de.debian.org", "ftp.us.debian.org",
+			"ftp.uk.debian.org", "ftp.fr.debian.org", "ftp.nl.debian.org",
+		},
+	},
+	{
+		name: "Ubuntu",
+		hosts: []string{
+			"archive.ubuntu.com", "us.archive.ubuntu.com", "de.archive.ubuntu.com",
+			"gb.archive.ubuntu.com", "fr.archive.ubuntu.com", "nl.archive.ubuntu.com",
+		},
+	},
+	{
+		name:  "CPAN",
+		hosts: []string{"www.cpan.org", "cpan.metacpan.org"},
+	},
+}
+
+func (n mirrorNetwork) has(host string) bool {
+	if n.suffix != "" && strings.HasSuffix(host, n.suffix) {
+		return true
+	}
+	for _, h := range n.hosts {
+		if h == host {
+			return true
+		}
+	}
+	return false
+}
+
+// sourceForgePage matches the project page links people usually copy,
+// which only redirect to the download.
+var sourceForgePage = regexp.MustCompile(`^/projects/([^/]+)/files/(.+)/download$`)
+
+// findMirrors returns the other mirrors of rawURL's network in random
+// order, leaving out skip, or nothing if it isn't on one.
+func findMirrors(rawURL, skip string) []string {
+	u, err := neturl.Parse(rawURL)
+	if err != nil {
+		return nil
+	}
+	host := strings.ToLower(u.Hostname())
+	if host == "sourceforge.net" || host == "www.sourceforge.net" {
+		m := sourceForgePage.FindStringSubmatch(u.Path)
+		if m == nil {
+			return nil
+		}
+		u.Host, u.Path, u.RawPath = "downloads.sourceforge.net", "/project/"+m[1]+"/"+m[2], ""
+		host = u.Host
+	}
+
+	for _, network := range mirrorNetworks {
+		if !network.has(host) {
+			continue
+		}
+		var mirrors []string
+		for _, h := range network.hosts {
+			if h == host || strings.EqualFold(h, skip) {
+				continue
+			}
+			alt := *u
+			alt.Scheme, alt.Host = "https", h
+			mirrors = append(mirrors, alt.String())
+		}
+		rand.Shuffle(len(mirrors), func(i, j int) { mirrors[i], mirrors[j] = mirrors[j], mirrors[i] })
+		return mirrors
+	}
+	return nil
+}
+
+// downloadURL is where the job downloads from: its own URL, or the mirror
+// it switched to.
+func (j *Job) downloadURL() string {
+	if j.mirrorURL != "" {
+		return j.mirrorURL
+	}
+	return j.URL
+}
+
+// nextMirror switches the job to another mirror of its host's network, if
+// there is one left to try. The mirrors are found on the first call.
+func (j *Job) nextMirror() (string, bool) {
+	if j.mirrors == nil {
+		j.mirrors = findMirrors(j.URL, urlHost(j.finalURL))
+		if j.mirrors == nil {
+			j.mirrors = []string{}
+		}
+	}
+	for len(j.mirrors) > 0 {
+		mirror := j.mirrors[0]
+		j.mirrors = j.mirrors[1:]
+		// The domain lists apply to mirrors as well.
+		if _, ok := validateURL(mirror); ok {
+			j.mirrorURL = mirror
+			return mirror, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxFileNameLength is the longest name --name takes, which is as long as
+// file systems allow.
+const maxFileNameLength = 255
+
+const urlUsage = "Usage: /url <url> [options], or /url [options] in reply to a message with a link\n\nOptions: --name \"file name\", --label <name>, --dm, --card, --compress, --encrypt <passphrase>, --timeout <duration>, --stall <duration>, --range <start-end>, format=jpg|png, maxdim=<pixels>, sha256=<digest>, to:@channel or to:remote:path. Values with spaces go in quotes, and --flag=value works too. A message with --encrypt is deleted so the passphrase doesn't stay in the chat."
+
+// jobOptions are the settings given after the link in /url, as name=value,
+// --flag value or --flag=value. They are kept in the checkpoint, so a resumed job still
+// honors them, except for the passphrase.
+type jobOptions struct {
+	// ChecksumAlgo and Checksum are the digest the download must match
+	// before it is sent.
+	ChecksumAlgo string `json:"checksum_algo,omitempty"`
+	Checksum     string `json:"checksum,omitempty"`
+	// Encrypt asks for the file to be encrypted with passphrase.
+	Encrypt    bool `json:"encrypt,omitempty"`
+	passphrase string
+	// ZipURLs are the links of a /zip job, which are sent as one archive.
+	ZipURLs []string `json:"zip_urls,omitempty"`
+	// Compress asks for text files to be gzipped before they are sent.
+	Compress bool `json:"compress,omitempty"`
+	// ImageFormat (jpg or png) and MaxDim convert and shrink downloaded
+	// images.
+	ImageFormat string `json:"image_format,omitempty"`
+	MaxDim      int    `json:"max_dim,omitempty"`
+	// Card posts the file's metadata instead of the file.
+	Card bool `json:"card,omitempty"`
+	// ShotWidth and ShotFull are set by /shot, which sends a screenshot
+	// of the page instead of downloading it.
+	ShotWidth int  `json:"shot_width,omitempty"`
+	ShotFull  bool `json:"shot_full,omitempty"`
+	// AudioFormat is set by /audio to send only the audio track, as mp3
+	// or m4a.
+	AudioFormat string `json:"audio_format,omitempty"`
+	// Labels are given with --label, to find the job again in /history
+	// and to route the file with cfg.LabelRoutes.
+	Labels []string `json:"labels,omitempty"`
+	// TimeoutSeconds and StallSeconds override the configured download
+	// timeouts, given with --timeout and --stall.
+	TimeoutSeconds int `json:"timeout_seconds,omitempty"`
+	StallSeconds   int `json:"stall_seconds,omitempty"`
+	// DM sends the file to the requester's private chat, leaving only a
+	// confirmation in the group.
+	DM bool `json:"dm,omitempty"`
+	// TargetChat is the channel or group given as to:@name or to:-100…
+	// to post the file in, and TargetChatID its ID once looked up.
+	TargetChat   string `json:"target_chat,omitempty"`
+	TargetChatID int64  `json:"target_chat_id,omitempty"`
+	// CronID is the recurring download the job is a run of, and
+	// UnchangedSHA256 the content of its last run when the file is only
+	// posted if it changed.
+	CronID          int64  `json:"cron_id,omitempty"`
+	UnchangedSHA256 string `json:"unchanged_sha256,omitempty"`
+	// Destination is the rclone remote:path given as to:remote:path to
+	// save the file to instead of sending it.
+	Destination string `json:"destination,omitempty"`
+	// FileName is the name given with --name to send the file under.
+	FileName string `json:"file_name,omitempty"`
+	// Range is the part of the file asked for with --range.
+	Range *byteRange `json:"range,omitempty"`
+}
+
+var checksumAlgos = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+}
+
+// parseJobOptions takes the options out of the /url arguments, wherever
+// they are, and returns the rest: the link and its template parameters.
+func parseJobOptions(args []string) (jobOptions, []string, error) {
+	var opts jobOptions
+	args = expandFlagValues(args)
+	rest := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if strings.EqualFold(arg, "--encrypt") {
+			if i+1 == len(args) {
+				return opts, nil, fmt.Errorf("--encrypt needs a passphrase")
+			}
+			i++
+			opts.Encrypt, opts.passphrase = true, args[i]
+			continue
+		}
+		if strings.EqualFold(arg, "--compress") {
+			opts.Compress = true
+			continue
+		}
+		if strings.EqualFold(arg, "--card") {
+			opts.Card = true
+			continue
+		}
+		if strings.EqualFold(arg, "--dm") {
+			opts.DM = true
+			continue
+		}
+		if strings.EqualFold(arg, "--timeout") || strings.EqualFold(arg, "--stall") {
+			flag := strings.ToLower(arg)
+			if i+1 == len(args) {
+				return opts, nil, fmt.Errorf("%s needs a duration like 90s or 30m", flag)
+			}
+			i++
+			var err error
+			if flag == "--timeout" {
+				opts.TimeoutSeconds, err = parseTimeoutOption(flag, args[i], minTimeoutOverride, time.Duration(cfg.MaxDownloadTimeoutMinutes)*time.Minute)
+			} else {
+				opts.StallSeconds, err = parseTimeoutOption(flag, args[i], minStallOverride, time.Duration(cfg.MaxStallTimeoutSeconds)*time.Second)
+			}
+			if err != nil {
+				return opts, nil, err
+			}
+			continue
+		}
+		if strings.EqualFold(arg, "--name") {
+			if i+1 == len(args) {
+				return opts, nil, fmt.Errorf("--name needs a file name")
+			}
+			i++
+			name, ok := validFileName(args[i])
+			if !ok || len(name) > maxFileNameLength {
+				return opts, nil, fmt.Errorf("%q can't be used as a file name", args[i])
+			}
+			opts.FileName = name
+			continue
+		}
+		if strings.EqualFold(arg, "--range") {
+			if i+1 == len(args) {
+				return opts, nil, fmt.Errorf("--range needs a byte range like 0-10485760")
+			}
+			i++
+			r, err := parseByteRange(args[i])
+			if err != nil {
+				return opts, nil, err
+			}
+			opts.Range = r
+			continue
+		}
+		if strings.EqualFold(arg, "--label") {
+			if i+1 == len(args) {
+				return opts, nil, fmt.Errorf("--label needs a name")
+			}
+			i++
+			if err := opts.addLabel(args[i]); err != nil {
+				return opts, nil, err
+			}
+			continue
+		}
+		if dest, ok := strings.CutPrefix(arg, "to:"); ok {
+			if opts.Destination != "" || opts.TargetChat != "" {
+				return opts, nil, fmt.Errorf("only one to: destination can be given")
+			}
+			if isTargetChat(dest) {
+				opts.TargetChat = dest
+				continue
+			}
+			var err error
+			if opts.Destination, err = parseDestination(dest); err != nil {
+				return opts, nil, err
+			}
+			continue
+		}
+
+		// name=value options only come after the link, since links have
+		// = in them too, unless given as --name=value.
+		name, value, found := strings.Cut(arg, "=")
+		name = strings.ToLower(name)
+		dashed := strings.HasPrefix(name, "--")
+		name = strings.TrimPrefix(name, "--")
+		found = found && (len(rest) > 0 || dashed)
+		if found && (name == "format" || name == "maxdim") {
+			if err := opts.setImageOption(name, value); err != nil {
+				return opts, nil, err
+			}
+			continue
+		}
+		newHash, isChecksum := checksumAlgos[name]
+		if !found || !isChecksum {
+			rest = append(rest, arg)
+			continue
+		}
+
+		if opts.Checksum != "" {
+			return opts, nil, fmt.Errorf("only one checksum can be given")
+		}
+		value = strings.ToLower(value)
+		if _, err := hex.DecodeString(value); err != nil || len(value) != newHash().Size()*2 {
+			return opts, nil, fmt.Errorf("that isn't a valid %s digest", name)
+		}
+		opts.ChecksumAlgo, opts.Checksum = name, value
+	}
+	if opts.DM && opts.TargetChat != "" {
+		return opts, nil, fmt.Errorf("--dm and to:%s can't be combined", opts.TargetChat)
+	}
+	if opts.Range != nil && opts.Destination != "" {
+		return opts, nil, fmt.Errorf("--range and to:%s can't be combined", opts.Destination)
+	}
+	return opts, rest, nil
+}
+
+// valueFlags are the options that take a value as the next argument,
+// which can also be given as --flag=value.
+var valueFlags = map[string]bool{"--encrypt": true, "--timeout": true, "--stall": true, "--label": true, "--name": true, "--range": true}
+
+// expandFlagValues rewrites --flag=value into the form parseJobOptions
+// reads: two arguments for the flags above and to:value for --to. The
+// options that are written name=value anyway are left as they are.
+func expandFlagValues(args []string) []string {
+	expanded := make([]string, 0, len(args))
+	for _, arg := range args {
+		name, value, found := strings.Cut(arg, "=")
+		name = strings.ToLower(name)
+		switch {
+		case !found || !strings.HasPrefix(name, "--"):
+			expanded = append(expanded, arg)
+		case valueFlags[name]:
+			expanded = append(expanded, name, value)
+		case name == "--to":
+			expanded = append(expanded, "to:"+value)
+		default:
+			expanded = append(expanded, arg)
+		}
+	}
+	return expanded
+}
+
+func (o *jobOptions) setImageOption(name, value string) error {
+	if name == "format" {
+		format := strings.ToLower(value)
+		if format == "jpeg" {
+			format = "jpg"
+		}
+		if format != "jpg" && format != "png" {
+			return fmt.Errorf("images can be converted to jpg or png, not %q", value)
+		}
+		o.ImageFormat = format
+		return nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < minImageDim || n > maxImageDim {
+		return fmt.Errorf("maxdim must be between %d and %d pixels", minImageDim, maxImageDim)
+	}
+	o.MaxDim = n
+	return nil
+}
+
+// processes reports whether the file is changed between download and
+// upload, which makes that a stage of its own.
+func (o jobOptions) processes() bool {
+	return o.Encrypt || o.Compress || o.AudioFormat != "" || o.ImageFormat != "" || o.MaxDim > 0
+}
+
+// checksumNote lets recipients check the file they got. It is left out
+// for encrypted files: the hash of the content would tell anyone who
+// has a candidate file whether it is the one that was sent.
+func (j *Job) checksumNote() string {
+	if j.SHA256 == "" || j.options.Encrypt {
+		return ""
+	}
+	return "\n🔑 SHA-256: " + j.SHA256
+}
+
+// verifyChecksum checks the downloaded file against the digest the user
+// asked for, if any. SHA-256 is already known from the download; the
+// others take another pass over the file.
+func verifyChecksum(job *Job, file *os.File) error {
+	algo, want := job.options.ChecksumAlgo, job.options.Checksum
+	if want == "" {
+		return nil
+	}
+
+	got := job.SHA256
+	if algo != "sha256" || got == "" {
+		h := checksumAlgos[algo]()
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return failJob("❌ Failed to verify the file", err)
+		}
+		if _, err := io.Copy(h, file); err != nil {
+			return failJob("❌ Failed to verify the file", err)
+		}
+		got = hex.EncodeToString(h.Sum(nil))
+	}
+
+	if got != want {
+		job.logger().Warn("Checksum mismatch", "algo", algo, "want", want, "got", got)
+		return &jobError{
+			userMessage: fmt.Sprintf("❌ Checksum mismatch, so the file was not sent.\n\nExpected %s: %s\nGot: %s", algo, want, got),
+			result:      resultRejected,
+			err:         fmt.Errorf("%s mismatch: want %s, got %s", algo, want, got),
+		}
+	}
+	job.logger().Info("Checksum verified", "algo", algo)
+	return nil
+}
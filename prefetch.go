@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	neturl "net/url"
+	"sync"
+	"time"
+)
+
+const dnsCacheTTL = 5 * time.Minute
+
+type dnsEntry struct {
+	addrs   []string
+	expires time.Time
+}
+
+// dnsCache lets queued jobs resolve their host ahead of time; the download
+// client's dialer then uses the cached addresses.
+type dnsCache struct {
+	mu      sync.Mutex
+	entries map[string]dnsEntry
+}
+
+var resolverCache = &dnsCache{entries: map[string]dnsEntry{}}
+
+var tlsSessions = tls.NewLRUClientSessionCache(128)
+
+func (c *dnsCache) lookup(ctx context.Context, host string) ([]string, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[host]
+	c.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.addrs, nil
+	}
+
+	addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.entries[host] = dnsEntry{addrs: addrs, expires: time.Now().Add(dnsCacheTTL)}
+	c.mu.Unlock()
+	return addrs, nil
+}
+
+func (c *dnsCache) dialContext(dialer *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil || net.ParseIP(host) != nil {
+			return dialer.DialContext(ctx, network, addr)
+		}
+
+		addrs, err := c.lookup(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+
+		var errs []error
+		for _, ip := range addrs {
+			conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(ip, port))
+			if err == nil {
+				return conn, nil
+			}
+			errs = append(errs, err)
+		}
+		return nil, errors.Join(errs...)
+	}
+}
+
+// enablePrefetch makes client use the shared DNS and TLS session caches.
+// It is skipped when downloads go through a proxy, since the proxy does the
+// resolving and the TLS handshake with the origin then.
+func enablePrefetch(client *http.Client) {
+	transport, ok := client.Transport.(*http.Transport)
+	if !ok {
+		return
+	}
+	transport.DialContext = resolverCache.dialContext(downloadDialer())
+	if transport.TLSClientConfig == nil {
+		transport.TLSClientConfig = &tls.Config{}
+	}
+	transport.TLSClientConfig.ClientSessionCache = tlsSessions
+}
+
+// prefetch warms up the caches for a queued job so its transfer can start
+// as soon as a worker slot frees up.
+func prefetch(rawURL string) {
+	if !cfg.PrefetchDNS || cfg.DownloadProxy != "" {
+		return
+	}
+
+	u, err := neturl.Parse(rawURL)
+	if err != nil || u.Hostname() == "" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	addrs, err := resolverCache.lookup(ctx, u.Hostname())
+	if err != nil {
+		slog.Debug("Prefetch: resolving failed", "host", u.Hostname(), "error", err)
+		return
+	}
+
+	if !cfg.PrewarmTLS || u.Scheme != "https" || len(addrs) == 0 {
+		return
+	}
+
+	port := u.Port()
+	if port == "" {
+		port = "443"
+	}
+	netDialer := downloadDialer()
+	netDialer.Timeout = 10 * time.Second
+	dialer := &tls.Dialer{
+		NetDialer: netDialer,
+		Config:    &tls.Config{ServerName: u.Hostname(), ClientSessionCache: tlsSessions},
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(addrs[0], port))
+	if err != nil {
+		slog.Debug("Prefetch: TLS warm-up failed", "host", u.Hostname(), "error", err)
+		return
+	}
+	defer conn.Close()
+
+	// TLS 1.3 servers send session tickets after the handshake; they are
+	// only picked up (and cached) when the client reads from the connection.
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	conn.Read(make([]byte, 1))
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	neturl "net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// presignCredential lets the bot re-sign expired pre-signed links for a
+// domain (and its subdomains). It uses AWS Signature V4, which both S3 and
+// Google Cloud Storage (with HMAC keys) accept. Only links that were
+// signed with the same access key are re-signed, and with PathPrefixes
+// set only those for objects under one of them.
+type presignCredential struct {
+	Domain          string   `yaml:"domain"`
+	AccessKeyID     string   `yaml:"access_key_id"`
+	SecretAccessKey string   `yaml:"secret_access_key"`
+	Region          string   `yaml:"region"`
+	Service         string   `yaml:"service"`
+	PathPrefixes    []string `yaml:"path_prefixes"`
+}
+
+const (
+	presignExpiry = time.Hour
+	amzDateFormat = "20060102T150405Z"
+)
+
+var errPresignedExpired = errors.New("pre-signed URL expired or its signature was rejected")
+
+func isPresigned(u *neturl.URL) bool {
+	q := u.Query()
+	return q.Has("X-Amz-Signature") || q.Has("X-Goog-Signature") ||
+		(q.Has("Signature") && q.Has("Expires"))
+}
+
+// presignedExpiry works out when a pre-signed URL stops being valid, if
+// the URL says so.
+func presignedExpiry(u *neturl.URL) (time.Time, bool) {
+	q := u.Query()
+	for _, prefix := range []string{"X-Amz-", "X-Goog-"} {
+		signed, err := time.Parse(amzDateFormat, q.Get(prefix+"Date"))
+		if err != nil {
+			continue
+		}
+		seconds, err := strconv.Atoi(q.Get(prefix + "Expires"))
+		if err != nil {
+			continue
+		}
+		return signed.Add(time.Duration(seconds) * time.Second), true
+	}
+	// Legacy S3 and GCS signed URLs carry an absolute Unix timestamp.
+	if unix, err := strconv.ParseInt(q.Get("Expires"), 10, 64); err == nil {
+		return time.Unix(unix, 0), true
+	}
+	return time.Time{}, false
+}
+
+// checkPresignedResponse recognizes the errors S3 and GCS give for an
+// expired or otherwise rejected signature and explains them. It returns
+// nil for anything else; resp.Body is only read when it might match.
+func checkPresignedResponse(resp *http.Response) error {
+	if resp.StatusCode != http.StatusForbidden && resp.StatusCode != http.StatusBadRequest {
+		return nil
+	}
+	u := resp.Request.URL
+	if !isPresigned(u) {
+		return nil
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	text := string(body)
+	signatureProblem := false
+	for _, marker := range []string{"Request has expired", "ExpiredToken", "SignatureDoesNotMatch", "AuthorizationQueryParametersError", "expired"} {
+		if strings.Contains(text, marker) {
+			signatureProblem = true
+			break
+		}
+	}
+	expiresAt, known := presignedExpiry(u)
+	if !signatureProblem && !(known && time.Now().After(expiresAt)) {
+		return nil
+	}
+
+	msg := "⌛ This pre-signed link has expired or its signature is no longer valid. Please generate a fresh link and try again."
+	if known && time.Now().After(expiresAt) {
+		msg = fmt.Sprintf("⌛ This pre-signed link expired at %s (%s ago). Please generate a fresh link and try again.",
+			expiresAt.UTC().Format("2006-01-02 15:04 MST"), time.Since(expiresAt).Round(time.Minute))
+	}
+	return &jobError{
+		userMessage: msg,
+		result:      resultRejected,
+		err:         fmt.Errorf("%w: %s", errPresignedExpired, resp.Status),
+	}
+}
+
+// signedAccessKey returns the access key a pre-signed URL was signed
+// with.
+func signedAccessKey(q neturl.Values) string {
+	for _, key := range []string{"X-Amz-Credential", "X-Goog-Credential"} {
+		if credential := q.Get(key); credential != "" {
+			akid, _, _ := strings.Cut(credential, "/")
+			return akid
+		}
+	}
+	return q.Get("AWSAccessKeyId")
+}
+
+// credentialFor finds the credentials u may be re-signed with: they have
+// to be the ones it was signed with in the first place, so a link the
+// operator never handed out can't borrow their keys.
+func credentialFor(u *neturl.URL) (presignCredential, bool) {
+	akid := signedAccessKey(u.Query())
+	if akid == "" {
+		return presignCredential{}, false
+	}
+	for _, c := range cfg.PresignCredentials {
+		if !matchesDomain(u.Hostname(), c.Domain) || c.AccessKeyID != akid {
+			continue
+		}
+		if len(c.PathPrefixes) == 0 {
+			return c, true
+		}
+		object := path.Clean("/" + u.Path)
+		for _, prefix := range c.PathPrefixes {
+			if strings.HasPrefix(object, "/"+strings.TrimPrefix(prefix, "/")) {
+				return c, true
+			}
+		}
+	}
+	return presignCredential{}, false
+}
+
+// resignURL signs raw again with the credentials configured for its host,
+// keeping the region and service of the original signature unless the
+// credentials say otherwise.
+func resignURL(raw string, now time.Time) (string, bool) {
+	u, err := neturl.Parse(raw)
+	if err != nil || !isPresigned(u) {
+		return "", false
+	}
+	cred, ok := credentialFor(u)
+	if !ok {
+		return "", false
+	}
+
+	q := u.Query()
+	region, service := cred.Region, cred.Service
+	// X-Amz-Credential is AKID/date/region/service/aws4_request.
+	if parts := strings.Split(q.Get("X-Amz-Credential"), "/"); len(parts) == 5 {
+		if region == "" {
+			region = parts[2]
+		}
+		if service == "" {
+			service = parts[3]
+		}
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+	if service == "" {
+		service = "s3"
+	}
+
+	for key := range q {
+		lower := strings.ToLower(key)
+		if strings.HasPrefix(lower, "x-amz-") || strings.HasPrefix(lower, "x-goog-") ||
+			key == "Signature" || key == "Expires" || key == "AWSAccessKeyId" || key == "GoogleAccessId" {
+			q.Del(key)
+		}
+	}
+
+	u.RawQuery = presignQuery(http.MethodGet, u, q, cred.AccessKeyID, cred.SecretAccessKey, region, service, presignExpiry, now)
+	return u.String(), true
+}
+
+// presignQuery adds a SigV4 query signature valid for expires to q and
+// returns the resulting query string for u.
+func presignQuery(method string, u *neturl.URL, q neturl.Values, accessKeyID, secret, region, service string, expires time.Duration, now time.Time) string {
+	date := now.UTC().Format(amzDateFormat)
+	scope := fmt.Sprintf("%s/%s/%s/aws4_request", date[:8], region, service)
+	q.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	q.Set("X-Amz-Credential", accessKeyID+"/"+scope)
+	q.Set("X-Amz-Date", date)
+	q.Set("X-Amz-Expires", strconv.Itoa(int(expires.Seconds())))
+	q.Set("X-Amz-SignedHeaders", "host")
+
+	canonicalQuery := canonicalQueryString(q)
+	canonicalRequest := strings.Join([]string{
+		method,
+		u.EscapedPath(),
+		canonicalQuery,
+		"host:" + u.Host + "\n",
+		"host",
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+	signature := sigV4Signature(secret, date, scope, region, service, canonicalRequest)
+	return canonicalQuery + "&X-Amz-Signature=" + signature
+}
+
+func sigV4Signature(secret, date, scope, region, service, canonicalRequest string) string {
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		date,
+		scope,
+		hexSHA256(canonicalRequest),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+secret), date[:8])
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+// canonicalQueryString sorts and escapes query parameters the way SigV4
+// expects, which is stricter than url.Values.Encode.
+func canonicalQueryString(q neturl.Values) string {
+	var pairs []string
+	for key, values := range q {
+		for _, v := range values {
+			pairs = append(pairs, sigV4Escape(key)+"="+sigV4Escape(v))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+func sigV4Escape(s string) string {
+	return strings.ReplaceAll(neturl.QueryEscape(s), "+", "%20")
+}
+
+func hexSHA256(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// refreshSignature swaps the job's URL for a freshly signed one after the
+// old signature was rejected. It only does so once per job.
+func (j *Job) refreshSignature(err error) bool {
+	if j.resigned || !errors.Is(err, errPresignedExpired) {
+		return false
+	}
+	fresh, ok := resignURL(j.URL, time.Now())
+	if !ok {
+		return false
+	}
+	j.resigned = true
+	j.logger().Info("Re-signed expired pre-signed URL")
+	j.mu.Lock()
+	j.URL = fresh
+	j.mu.Unlock()
+	return true
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"os"
+	"runtime/debug"
+)
+
+const (
+	profileDefault   = "default"
+	profileLowMemory = "low-memory"
+
+	lowMemoryMaxJobs = 2
+	// lowMemoryLimit is a soft limit for the Go heap that leaves room for
+	// the OS and page cache on a 256 MB host.
+	lowMemoryLimit = 160 << 20
+)
+
+// copyBufferSize is the chunk size downloads are streamed to disk with.
+func copyBufferSize() int {
+	if cfg.Profile == profileLowMemory {
+		return 8 << 10
+	}
+	return 64 << 10
+}
+
+// applyProfile adjusts the configuration for the selected profile. It runs
+// after all other settings are loaded, so its limits are caps rather than
+// defaults that could be overridden.
+func (c *Config) applyProfile() {
+	if c.Profile != profileLowMemory {
+		return
+	}
+	if c.MaxConcurrentJobs > lowMemoryMaxJobs {
+		c.MaxConcurrentJobs = lowMemoryMaxJobs
+	}
+	if c.UserMaxConcurrentJobs > lowMemoryMaxJobs {
+		c.UserMaxConcurrentJobs = lowMemoryMaxJobs
+	}
+	c.MemoryBufferMB = 0
+}
+
+// tuneRuntime makes the garbage collector work harder in the low-memory
+// profile. GOGC and GOMEMLIMIT, when set, still take precedence.
+func tuneRuntime() {
+	if cfg.Profile != profileLowMemory {
+		return
+	}
+	if os.Getenv("GOGC") == "" {
+		debug.SetGCPercent(50)
+	}
+	if os.Getenv("GOMEMLIMIT") == "" {
+		debug.SetMemoryLimit(lowMemoryLimit)
+	}
+	slog.Info("Using the low-memory profile", "max_concurrent_jobs", cfg.MaxConcurrentJobs)
+}
+
+// tuneTransport keeps fewer idle connections and smaller buffers around in
+// the low-memory profile.
+func tuneTransport(client *http.Client) {
+	transport, ok := client.Transport.(*http.Transport)
+	if !ok || cfg.Profile != profileLowMemory {
+		return
+	}
+	transport.MaxIdleConns = 4
+	transport.MaxIdleConnsPerHost = 1
+	transport.ReadBufferSize = 4 << 10
+	transport.WriteBufferSize = 4 << 10
+}
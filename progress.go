@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// stageWeights is how much of the overall progress bar each pipeline stage
+// takes up; only the stages a job actually goes through count, so a plain
+// download and upload splits the bar 75/25.
+var stageWeights = map[string]float64{
+	"download": 60,
+	"process":  20,
+	"upload":   20,
+	"stream":   100, // download and upload at once
+}
+
+const progressBarWidth = 10
+
+// planStages sets the stages the job's overall progress is made of, in
+// order.
+func (j *Job) planStages(stages ...string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.stages = stages
+	j.progress = 0
+}
+
+// setStageProgress records progress within stage and returns the overall
+// percentage: all earlier stages count as done.
+func (j *Job) setStageProgress(stage string, progress float64) float64 {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	var total, done float64
+	found := false
+	for _, s := range j.stages {
+		w := stageWeights[s]
+		total += w
+		switch {
+		case found:
+		case s == stage:
+			done += w * min(progress, 100) / 100
+			found = true
+		default:
+			done += w
+		}
+	}
+	if total == 0 || !found {
+		j.progress = progress
+	} else {
+		j.progress = done / total * 100
+	}
+	return j.progress
+}
+
+func progressBar(progress float64) string {
+	filled := int(progress / 100 * progressBarWidth)
+	filled = max(0, min(filled, progressBarWidth))
+	return strings.Repeat("▓", filled) + strings.Repeat("░", progressBarWidth-filled)
+}
+
+// progressUpdater records progress within stage on the job and shows the
+// overall progress in the status message, under label.
+func progressUpdater(bot *tgbotapi.BotAPI, job *Job, stage, label string) func(float64) {
+	lastUpdate := time.Now()
+	return func(progress float64) {
+		overall := job.setStageProgress(stage, progress)
+		// Update status message every 2 seconds to avoid flooding
+		if time.Since(lastUpdate) >= 2*time.Second {
+			statusText := fmt.Sprintf("%s\n%s %.1f%%", label, progressBar(overall), overall)
+			if m := job.existingMeter(stage); m != nil {
+				if line := m.describe(); line != "" {
+					statusText += "\n" + line
+				}
+			}
+			updateMessage(bot, job.ChatID, job.StatusMessageID, statusText)
+			lastUpdate = time.Now()
+			stats.touch()
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// maxQueueButtons caps how many jobs get buttons in /queue; Telegram
+// doesn't take arbitrarily large keyboards.
+const maxQueueButtons = 10
+
+var errCancelledByAdmin = errors.New("cancelled by an admin")
+
+func cancelledByAdmin(job *Job) bool {
+	return errors.Is(context.Cause(job.ctx), errCancelledByAdmin)
+}
+
+func (j *Job) releaseSlot() {
+	if j.holdsSlot {
+		jobSlots.release(j)
+		j.holdsSlot = false
+	}
+}
+
+// bump lets a queued job start right away without waiting for a slot, so
+// it runs on top of cfg.MaxConcurrentJobs.
+func (j *Job) bump() {
+	j.bumpOnce.Do(func() { close(j.bumped) })
+}
+
+func handleQueueCommand(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
+	if message.From == nil || !canObserve(message.From.ID) {
+		sendErrorMessage(bot, message.Chat.ID, "🚫 Only bot admins can use /queue.")
+		return
+	}
+	text, markup := renderQueue(isAdmin(message.From.ID))
+	msg := tgbotapi.NewMessage(message.Chat.ID, text)
+	msg.ReplyMarkup = markup
+	bot.Send(msg)
+}
+
+// renderQueue lists the jobs, with buttons to manage them if manage is
+// set; observers only get to refresh the list.
+func renderQueue(manage bool) (string, tgbotapi.InlineKeyboardMarkup) {
+	snapshots := jobs.list()
+	refresh := tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("🔄 Refresh", "queue:refresh"))
+	if len(snapshots) == 0 {
+		return "📋 The queue is empty.", tgbotapi.NewInlineKeyboardMarkup(refresh)
+	}
+
+	lines := make([]string, 0, len(snapshots))
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for i, job := range snapshots {
+		lines = append(lines, formatQueueLine(job))
+		if !manage || i >= maxQueueButtons {
+			continue
+		}
+		id := strconv.FormatInt(job.ID, 10)
+		var row []tgbotapi.InlineKeyboardButton
+		if job.State == jobQueued {
+			row = append(row, tgbotapi.NewInlineKeyboardButtonData("⏫ #"+id, "queue:bump:"+id))
+		}
+		row = append(row,
+			tgbotapi.NewInlineKeyboardButtonData("🛑 #"+id, "queue:cancel:"+id),
+			tgbotapi.NewInlineKeyboardButtonData("🔍 #"+id, "queue:inspect:"+id),
+		)
+		rows = append(rows, row)
+	}
+	rows = append(rows, refresh)
+
+	header := fmt.Sprintf("📋 %d jobs, %d/%d slots in use", len(snapshots), jobSlots.used(), jobSlots.size())
+	if uploadSlots != nil {
+		header += fmt.Sprintf(", %d/%d uploads", len(uploadSlots), cap(uploadSlots))
+	}
+	return header + "\n\n" + strings.Join(lines, "\n"), tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+func formatQueueLine(job jobSnapshot) string {
+	line := fmt.Sprintf("%s #%d %s", jobStateIcons[job.State], job.ID, job.State)
+	if job.State != jobQueued && job.Progress > 0 {
+		line += fmt.Sprintf(" %.1f%%", job.Progress)
+	}
+	return line + fmt.Sprintf(" — user %d — %s — %s — %s",
+		job.UserID, urlHost(job.URL), formatJobSize(job.Size), time.Since(job.Created).Round(time.Second))
+}
+
+func formatJobSize(size int64) string {
+	if size <= 0 {
+		return "size unknown"
+	}
+	return fmt.Sprintf("%.1f MB", float64(size)/1024/1024)
+}
+
+func inspectJob(job *Job) string {
+	s := job.snapshot()
+	lines := []string{
+		fmt.Sprintf("🔍 Job #%d — %s", s.ID, s.State),
+		"URL: " + redactURL(s.URL),
+		fmt.Sprintf("Requested by user %d in chat %d", s.UserID, s.ChatID),
+		"Queued: " + s.Created.Format(time.DateTime),
+		"Size: " + formatJobSize(s.Size),
+	}
+	if s.FileName != "" {
+		lines = append(lines, "File: "+s.FileName)
+	}
+	if len(s.Stages) > 0 {
+		lines = append(lines, fmt.Sprintf("Stages: %s (%.1f%% overall)", strings.Join(s.Stages, " → "), s.Progress))
+	}
+	for _, stage := range s.Stages {
+		if m := job.existingMeter(stage); m != nil {
+			if line := m.describe(); line != "" {
+				lines = append(lines, "Speed ("+stage+"): "+strings.TrimPrefix(line, "⚡ "))
+			}
+		}
+	}
+	if s.Attempts > 0 {
+		lines = append(lines, fmt.Sprintf("Attempts: %d", s.Attempts))
+	}
+	if opts := job.options; opts.Checksum != "" || opts.Encrypt || len(opts.ZipURLs) > 0 {
+		var flags []string
+		if opts.Checksum != "" {
+			flags = append(flags, opts.ChecksumAlgo+" check")
+		}
+		if opts.Encrypt {
+			flags = append(flags, "encrypted")
+		}
+		if len(opts.ZipURLs) > 0 {
+			flags = append(flags, fmt.Sprintf("zip of %d links", len(opts.ZipURLs)))
+		}
+		lines = append(lines, "Options: "+strings.Join(flags, ", "))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func handleQueueCallback(bot *tgbotapi.BotAPI, query *tgbotapi.CallbackQuery, args []string) string {
+	if query.From == nil || !canObserve(query.From.ID) {
+		return "Only bot admins can manage the queue."
+	}
+	if query.Message == nil || len(args) == 0 {
+		return ""
+	}
+	if args[0] == "refresh" {
+		text, markup := renderQueue(isAdmin(query.From.ID))
+		bot.Send(tgbotapi.NewEditMessageTextAndMarkup(query.Message.Chat.ID, query.Message.MessageID, text, markup))
+		return ""
+	}
+	if !isAdmin(query.From.ID) {
+		return "Only bot admins can manage the queue."
+	}
+	if len(args) != 2 {
+		return ""
+	}
+	jobID, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return ""
+	}
+	job, ok := jobs.get(jobID)
+	if !ok {
+		return "This job has already finished."
+	}
+
+	answer := ""
+	switch args[0] {
+	case "bump":
+		if job.snapshot().State != jobQueued {
+			return "This job is already running."
+		}
+		job.bump()
+		job.logger().Info("Admin bumped job", "admin_id", query.From.ID)
+		answer = fmt.Sprintf("⏫ Started #%d", jobID)
+	case "cancel":
+		job.cancel(errCancelledByAdmin)
+		job.logger().Info("Admin cancelled job", "admin_id", query.From.ID)
+		answer = fmt.Sprintf("🛑 Cancelled #%d", jobID)
+	case "inspect":
+		sendMessage(bot, query.Message.Chat.ID, inspectJob(job))
+		return ""
+	default:
+		return ""
+	}
+
+	text, markup := renderQueue(true)
+	bot.Send(tgbotapi.NewEditMessageTextAndMarkup(query.Message.Chat.ID, query.Message.MessageID, text, markup))
+	return answer
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// Quotas are tracked per UTC day; the key is "<user id>:<YYYY-MM-DD>".
+type quotaUsage struct {
+	Bytes int64 `json:"bytes"`
+}
+
+func quotaKey(userID int64, day time.Time) string {
+	return fmt.Sprintf("%d:%s", userID, day.UTC().Format(time.DateOnly))
+}
+
+func quotaResetTime(now time.Time) time.Time {
+	return now.UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+}
+
+var errQuotaExceeded = errors.New("daily quota exceeded")
+
+// reserveQuota counts total bytes of the job against its user's daily
+// quota, or returns a user-facing message if they don't fit. The part not
+// reserved yet is added to the day's usage right away, so jobs checked at
+// the same time can't all pass on the same room. settleQuota later puts
+// what the job actually transferred in place of the reservation.
+func (j *Job) reserveQuota(total int64) (string, bool) {
+	quota := cfg.DailyQuotaMB * 1024 * 1024
+	if quota <= 0 || j.UserID == 0 || isAdmin(j.UserID) {
+		return "", true
+	}
+
+	j.quotaMu.Lock()
+	defer j.quotaMu.Unlock()
+	extra := max(total-j.quotaReserved, 0)
+	if extra == 0 && j.quotaDay != "" {
+		return "", true
+	}
+	key := j.quotaDay
+	if key == "" {
+		key = quotaKey(j.UserID, time.Now())
+	}
+
+	var used int64
+	err := updateRecord(store, bucketQuotas, key, func(u *quotaUsage, _ bool) error {
+		used = u.Bytes
+		if used >= quota || used+extra > quota {
+			return errQuotaExceeded
+		}
+		u.Bytes += extra
+		return nil
+	})
+	if err != nil && !errors.Is(err, errQuotaExceeded) {
+		// Don't block downloads because the database hiccuped.
+		slog.Error("Error reserving quota", "user_id", j.UserID, "error", err)
+		return "", true
+	}
+	if err == nil {
+		j.quotaDay, j.quotaReserved = key, j.quotaReserved+extra
+		return "", true
+	}
+
+	reset := quotaResetTime(time.Now())
+	text := fmt.Sprintf("❌ Daily download quota exceeded.\n\nUsed today: %.1f MB of %d MB\nResets in %s (%s UTC).",
+		float64(used)/1024/1024, cfg.DailyQuotaMB,
+		time.Until(reset).Round(time.Minute), reset.Format("15:04"))
+	if extra > 0 && used < quota {
+		text += fmt.Sprintf("\n\nThis file is %.1f MB.", float64(extra)/1024/1024)
+	}
+	return text, false
+}
+
+// settleQuota charges the bytes the job downloaded, whether it finished or
+// not, in place of what it reserved.
+func (j *Job) settleQuota() {
+	if j.UserID == 0 {
+		return
+	}
+	transferred := j.usage().BytesIn
+
+	j.quotaMu.Lock()
+	defer j.quotaMu.Unlock()
+	delta := transferred - j.quotaReserved
+	if delta == 0 {
+		return
+	}
+	key := j.quotaDay
+	if key == "" {
+		key = quotaKey(j.UserID, time.Now())
+	}
+	err := updateRecord(store, bucketQuotas, key, func(u *quotaUsage, _ bool) error {
+		u.Bytes = max(u.Bytes+delta, 0)
+		return nil
+	})
+	if err != nil {
+		slog.Error("Error recording quota usage", "user_id", j.UserID, "error", err)
+		return
+	}
+	j.quotaDay, j.quotaReserved = key, transferred
+}
+
+func pruneOldQuotas() {
+	today := time.Now().UTC().Format(time.DateOnly)
+
+	var stale []string
+	err := store.forEach(bucketQuotas, func(key, _ []byte) error {
+		if i := strings.LastIndexByte(string(key), ':'); i >= 0 && string(key[i+1:]) < today {
+			stale = append(stale, string(key))
+		}
+		return nil
+	})
+	if err != nil {
+		slog.Error("Error listing quotas", "error", err)
+		return
+	}
+
+	for _, key := range stale {
+		if err := store.delete(bucketQuotas, key); err != nil {
+			slog.Error("Error pruning quota", "key", key, "error", err)
+		}
+	}
+}
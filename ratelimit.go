@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// userRateLimiter hands out cfg.UserJobsPerMinute job starts per user,
+// refilled continuously, with bursts of up to the same amount.
+type userRateLimiter struct {
+	mu      sync.Mutex
+	buckets map[int64]*tokenBucket
+}
+
+var rateLimiter = &userRateLimiter{buckets: map[int64]*tokenBucket{}}
+
+// take consumes a token for userID, or returns how long until one is
+// available.
+func (l *userRateLimiter) take(userID int64, perMinute int) (time.Duration, bool) {
+	capacity := float64(perMinute)
+	rate := capacity / 60 // tokens per second
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[userID]
+	if !ok {
+		b = &tokenBucket{tokens: capacity, last: now}
+		l.buckets[userID] = b
+	}
+
+	b.tokens = math.Min(capacity, b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0, true
+	}
+	wait := time.Duration((1 - b.tokens) / rate * float64(time.Second))
+	return wait, false
+}
+
+// checkRateLimit returns a "slow down" reply if userID may not start another
+// job right now.
+func checkRateLimit(userID int64) (string, bool) {
+	if userID == 0 || isAdmin(userID) {
+		return "", true
+	}
+
+	if limit := cfg.UserMaxConcurrentJobs; limit > 0 && jobs.countForUser(userID) >= limit {
+		// Queued jobs count too, see cfg.UserMaxConcurrentDownloads.
+		return fmt.Sprintf("🐢 Slow down! You already have %d downloads running or waiting in the queue. Please wait for one to finish.", limit), false
+	}
+
+	if perMinute := cfg.UserJobsPerMinute; perMinute > 0 {
+		if wait, ok := rateLimiter.take(userID, perMinute); !ok {
+			return fmt.Sprintf("🐢 Slow down! You can start up to %d downloads per minute. Try again in %s.",
+				perMinute, max(wait, time.Second).Round(time.Second)), false
+		}
+	}
+	return "", true
+}
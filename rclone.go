@@ -0,0 +1,254 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const rcloneFailedMessage = "❌ Couldn't save the file to the remote. Please try again later."
+
+// parseDestination checks a to:remote:path option against the remotes
+// the operator allows and returns remote:path.
+func parseDestination(value string) (string, error) {
+	if len(cfg.RcloneRemotes) == 0 {
+		return "", fmt.Errorf("saving to remotes isn't enabled on this bot")
+	}
+	remote, dir, _ := strings.Cut(value, ":")
+	allowed := false
+	for _, r := range cfg.RcloneRemotes {
+		if strings.EqualFold(r, remote) {
+			remote, allowed = r, true
+			break
+		}
+	}
+	if !allowed {
+		return "", fmt.Errorf("unknown remote %q; the bot can save to %s", remote, strings.Join(cfg.RcloneRemotes, ", "))
+	}
+	for _, part := range strings.Split(dir, "/") {
+		if part == ".." {
+			return "", fmt.Errorf("the remote path can't go up with ..")
+		}
+	}
+	return remote + ":" + strings.TrimPrefix(dir, "/"), nil
+}
+
+// rcloneTarget is where the job's file goes: the path given, or the file
+// under it if the path is a directory, as marked by a trailing slash or by
+// being the remote's root.
+func (j *Job) rcloneTarget() string {
+	dest := j.options.Destination
+	remote, dir, _ := strings.Cut(dest, ":")
+	if dir == "" || strings.HasSuffix(dir, "/") {
+		return remote + ":" + path.Join(dir, safeFileName(j.FileName))
+	}
+	return dest
+}
+
+// runRcloneJob saves the download to an rclone remote instead of sending
+// it. Like with cfg.StreamUploads, the download is piped straight to
+// rclone unless something has to look at the whole file first.
+func runRcloneJob(bot *tgbotapi.BotAPI, job *Job) error {
+	if _, err := exec.LookPath(cfg.RclonePath); err != nil {
+		return failJob(rcloneFailedMessage, err)
+	}
+	job.limitMB = chatMaxFileSizeMB(job.ChatID)
+	fileSize, headHeader, err := fetchInfo(job)
+	if err != nil {
+		return err
+	}
+	job.setExpectedSize(fileSize)
+	fileName := job.resolveFileName(fileSize)
+	job.setFileName(fileName)
+	if err := job.checkFileType(fileName, headHeader.Get("Content-Type")); err != nil {
+		return err
+	}
+	if fileSize > job.downloadLimit() {
+		return tooLargeError(fileSize, job.downloadLimitMB())
+	}
+	if quotaMsg, ok := job.reserveQuota(fileSize); !ok {
+		return &jobError{userMessage: quotaMsg, result: resultRejected}
+	}
+
+	_, held := holdReason(job, hashEntry{}, false)
+	if cfg.ClamdAddress == "" && len(cfg.Hooks) == 0 && job.options.Checksum == "" && !held && job.partialPath == "" {
+		job.planStages("stream")
+		return pipeToRclone(bot, job, fileSize)
+	}
+	job.planStages("download", "upload")
+	return copyToRclone(bot, job, fileSize)
+}
+
+func pipeToRclone(bot *tgbotapi.BotAPI, job *Job, headSize int64) error {
+	resp, err := requestFile(job, 0)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	job.responseHeader = resp.Header
+	job.noteFinalURL(resp)
+	if err := checkPresignedResponse(resp); err != nil {
+		return err
+	}
+	if resp.StatusCode >= 400 {
+		return statusError(resp)
+	}
+	expected := resp.ContentLength
+	if expected < 0 && !resp.Uncompressed {
+		expected = headSize
+	}
+	if expected > job.downloadLimit() {
+		return tooLargeError(expected, job.downloadLimitMB())
+	}
+
+	target := job.rcloneTarget()
+	hasher := sha256.New()
+	guard := &sizeGuard{Reader: job.throttle(resp.Body, "download"), limit: job.downloadLimit()}
+	progressReader := &ProgressReader{
+		Reader:     guard,
+		total:      expected,
+		onProgress: progressUpdater(bot, job, "stream", "☁️ Saving to "+target+"..."+job.redirectNote()),
+		meter:      job.meter("stream"),
+	}
+	args := []string{"rcat"}
+	if expected > 0 {
+		args = append(args, "--size", strconv.FormatInt(expected, 10))
+	}
+	if err := job.spendAttempt("upload"); err != nil {
+		return err
+	}
+	job.setState(jobUploading)
+	streamed := job.timeStage("stream")
+	runErr := runRclone(job, io.TeeReader(progressReader, hasher), append(args, target)...)
+	job.Size = progressReader.downloaded
+
+	// A download that failed can leave a partial file on the remote.
+	switch {
+	case guard.read > guard.limit:
+		deleteFromRclone(job, target)
+		return tooLargeError(job.Size, job.downloadLimitMB())
+	case runErr == nil && expected > 0 && job.Size != expected:
+		deleteFromRclone(job, target)
+		return failJob("❌ The download was cut off before it finished. Please try again later.", &truncatedError{got: job.Size, want: expected})
+	case runErr != nil:
+		// Except on shutdown, which leaves the job to be resumed.
+		if !interrupted(job) {
+			deleteFromRclone(job, target)
+		}
+		if job.ctx.Err() != nil {
+			return job.ctx.Err()
+		}
+		return failJob(rcloneFailedMessage, runErr)
+	}
+	streamed()
+
+	job.SHA256 = hex.EncodeToString(hasher.Sum(nil))
+	if hashInfo, listed := lookupHash(job.SHA256); listed && hashInfo.Verdict == hashDeny {
+		job.logger().Warn("Blocked denylisted file after saving it", "sha256", job.SHA256)
+		deleteFromRclone(job, target)
+		return &jobError{userMessage: "🚫 This file is blocked by the bot operator.", result: resultBlocked}
+	}
+	job.savedTo = target
+	return nil
+}
+
+func copyToRclone(bot *tgbotapi.BotAPI, job *Job, fileSize int64) error {
+	if err := checkDiskSpace(fileSize); err != nil {
+		return err
+	}
+	tempFile, resumeFrom, err := openTempFile(job, cfg.TempDir, job.FileName)
+	if err != nil {
+		return failJob("❌ Failed to create temporary file", err)
+	}
+	saveCheckpoint(job, tempFile.Name(), resumeFrom)
+	keepTemp := false
+	defer func() {
+		tempFile.Close()
+		if !keepTemp {
+			os.Remove(tempFile.Name())
+		}
+	}()
+
+	header, err := downloadWithRetries(bot, job, tempFile, fileSize, resumeFrom)
+	if err != nil && interrupted(job) {
+		keepTemp = true
+		job.partialPath = tempFile.Name()
+	}
+	if err != nil {
+		return err
+	}
+	if err := verifyChecksum(job, tempFile); err != nil {
+		return err
+	}
+	if err := scanJobFile(bot, job, tempFile); err != nil {
+		return err
+	}
+	hashInfo, listed := lookupHash(job.SHA256)
+	if listed && hashInfo.Verdict == hashDeny {
+		job.logger().Warn("Blocked denylisted file", "sha256", job.SHA256)
+		return &jobError{userMessage: "🚫 This file is blocked by the bot operator.", result: resultBlocked}
+	}
+	if reason, held := holdReason(job, hashInfo, listed); held {
+		if err := awaitApproval(bot, job, reason); err != nil {
+			return err
+		}
+	}
+	delivered := tempFile
+	hooked, err := runHooks(bot, job, tempFile, header.Get("Content-Type"))
+	if err != nil {
+		return err
+	}
+	if hooked != nil {
+		defer func() {
+			hooked.Close()
+			os.Remove(hooked.Name())
+		}()
+		delivered = hooked
+	}
+
+	target := job.rcloneTarget()
+	job.setState(jobUploading)
+	updateMessage(bot, job.ChatID, job.StatusMessageID, "☁️ Saving to "+target+"...")
+	if err := job.spendAttempt("upload"); err != nil {
+		return err
+	}
+	uploaded := job.timeStage("upload")
+	if err := runRclone(job, nil, "copyto", delivered.Name(), target); err != nil {
+		if job.ctx.Err() != nil {
+			return job.ctx.Err()
+		}
+		return failJob(rcloneFailedMessage, err)
+	}
+	uploaded()
+	job.savedTo = target
+	return nil
+}
+
+// runRclone runs an rclone command with stdin, if given. rclone reads its
+// remotes from its own config file, or RCLONE_CONFIG.
+func runRclone(job *Job, stdin io.Reader, args ...string) error {
+	cmd := exec.CommandContext(job.ctx, cfg.RclonePath, args...)
+	var stderr limitedBuffer
+	cmd.Stdin, cmd.Stderr = stdin, &stderr
+	err := cmd.Run()
+	job.addCPU(cmd)
+	if err != nil {
+		return fmt.Errorf("rclone %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+func deleteFromRclone(job *Job, target string) {
+	cmd := exec.Command(cfg.RclonePath, "deletefile", target)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		job.logger().Error("Error deleting file from remote", "target", target, "error", err, "output", strings.TrimSpace(string(output)))
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// checkRedirect is the download client's redirect policy: at most
+// cfg.MaxRedirects hops, each of which has to pass the same URL and
+// address checks as the original link.
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) > cfg.MaxRedirects {
+		return &jobError{
+			userMessage: fmt.Sprintf("❌ The link redirected more than %d times.", cfg.MaxRedirects),
+			result:      resultRejected,
+			err:         fmt.Errorf("stopped after %d redirects at %s", len(via), redactURL(req.URL.String())),
+		}
+	}
+	if problem, ok := validateURL(req.URL.String()); !ok {
+		return &jobError{userMessage: problem, result: resultBlocked, err: fmt.Errorf("redirect to %s refused", redactURL(req.URL.String()))}
+	}
+	return checkHost(req.Context(), req.URL.Hostname())
+}
+
+// noteFinalURL remembers where the download actually came from after
+// redirects.
+func (j *Job) noteFinalURL(resp *http.Response) {
+	if final := resp.Request.URL.String(); final != j.URL {
+		j.finalURL = final
+	}
+}
+
+// redirectNote is appended to status messages of redirected jobs so users
+// know what was fetched. The URL is redacted since redirect targets often
+// carry tokens.
+func (j *Job) redirectNote() string {
+	if j.finalURL == "" {
+		return ""
+	}
+	return fmt.Sprintf("\n↪️ Redirected to %s (%s)", urlHost(j.finalURL), redactURL(j.finalURL))
+}
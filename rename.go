@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const renamePromptTimeout = 2 * time.Minute
+
+type renamePrompt struct {
+	job     *Job
+	choice  chan string
+	awaited bool // waiting for the user to type a custom name
+}
+
+var (
+	renameMu      sync.Mutex
+	renamePrompts = map[int64]*renamePrompt{}
+)
+
+// chatFileNames returns the names of files previously delivered to chatID
+// and, for each, whether any of them had a different hash than sha256.
+func chatFileNames(chatID int64, sha256 string) (map[string]bool, error) {
+	names := map[string]bool{}
+	err := store.forEach(bucketJobs, func(_, value []byte) error {
+		var r jobRecord
+		if err := json.Unmarshal(value, &r); err != nil {
+			return err
+		}
+		if r.ChatID == chatID && r.Result == resultSuccess && r.FileName != "" {
+			names[r.FileName] = names[r.FileName] || r.SHA256 != sha256
+		}
+		return nil
+	})
+	return names, err
+}
+
+func suffixedName(name string, taken map[string]bool) string {
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 2; ; i++ {
+		candidate := fmt.Sprintf("%s (%d)%s", base, i, ext)
+		if _, ok := taken[candidate]; !ok {
+			return candidate
+		}
+	}
+}
+
+// resolveNameCollision asks the requester what to do if a different file
+// with the same name was already sent to the chat, and returns the name to
+// upload under. Without an answer the original name is kept.
+func resolveNameCollision(bot *tgbotapi.BotAPI, job *Job) string {
+	names, err := chatFileNames(job.deliveryChatID(), job.SHA256)
+	if err != nil || !names[job.FileName] {
+		return job.FileName
+	}
+	suffixed := suffixedName(job.FileName, names)
+
+	prompt := &renamePrompt{job: job, choice: make(chan string, 1)}
+	renameMu.Lock()
+	renamePrompts[job.ID] = prompt
+	renameMu.Unlock()
+	defer func() {
+		renameMu.Lock()
+		delete(renamePrompts, job.ID)
+		renameMu.Unlock()
+	}()
+
+	id := strconv.FormatInt(job.ID, 10)
+	markup := tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("Keep name", "rename:"+id+":keep"),
+		tgbotapi.NewInlineKeyboardButtonData(suffixed, "rename:"+id+":suffix"),
+		tgbotapi.NewInlineKeyboardButtonData("Custom…", "rename:"+id+":custom"),
+	))
+	text := fmt.Sprintf("⚠️ A different file named \"%s\" was already sent here. What should I name this one?", job.FileName)
+	edit := tgbotapi.NewEditMessageTextAndMarkup(job.ChatID, job.StatusMessageID, text, markup)
+	bot.Send(edit)
+
+	select {
+	case choice := <-prompt.choice:
+		switch choice {
+		case "keep":
+			return job.FileName
+		case "suffix":
+			return suffixed
+		default:
+			return choice
+		}
+	case <-time.After(renamePromptTimeout):
+		return job.FileName
+	case <-job.ctx.Done():
+		return job.FileName
+	}
+}
+
+func handleRenameCallback(bot *tgbotapi.BotAPI, query *tgbotapi.CallbackQuery, args []string) string {
+	if len(args) != 2 {
+		return ""
+	}
+	jobID, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return ""
+	}
+
+	renameMu.Lock()
+	defer renameMu.Unlock()
+	prompt, ok := renamePrompts[jobID]
+	if !ok {
+		return "This prompt has expired."
+	}
+	if query.From == nil || query.From.ID != prompt.job.UserID {
+		return "Only the person who requested the file can choose."
+	}
+
+	switch args[1] {
+	case "keep", "suffix":
+		offerChoice(prompt, args[1])
+	case "custom":
+		prompt.awaited = true
+		updateMessage(bot, prompt.job.ChatID, prompt.job.StatusMessageID, "✏️ Reply with the file name you'd like to use.")
+	}
+	return ""
+}
+
+// offerChoice never blocks, so a double tap can't wedge the callback
+// handler; only the first answer counts.
+func offerChoice(prompt *renamePrompt, choice string) {
+	select {
+	case prompt.choice <- choice:
+	default:
+	}
+}
+
+// takeCustomName delivers message as the custom file name if its sender has
+// a rename prompt waiting for one in that chat.
+func takeCustomName(message *tgbotapi.Message) bool {
+	if message.From == nil || message.Text == "" || strings.HasPrefix(message.Text, "/") {
+		return false
+	}
+
+	if job, ok := jobs.awaitingName(message.Chat.ID, message.From.ID); ok {
+		name, ok := validFileName(message.Text)
+		if !ok {
+			return false
+		}
+		job.setCustomName(name)
+		job.logger().Info("Requester renamed the file", "name", name)
+		return true
+	}
+
+	renameMu.Lock()
+	defer renameMu.Unlock()
+	for _, prompt := range renamePrompts {
+		if prompt.awaited && prompt.job.ChatID == message.Chat.ID && prompt.job.UserID == message.From.ID {
+			name, ok := validFileName(message.Text)
+			if !ok {
+				return false
+			}
+			prompt.awaited = false
+			offerChoice(prompt, name)
+			return true
+		}
+	}
+	return false
+}
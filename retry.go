@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// spendAttempt charges one request of the given stage to the job's retry
+// budget, which is shared by every stage so that a job failing in new ways
+// each time still gives up eventually. A budget of 0 means no limit.
+func (j *Job) spendAttempt(stage string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if cfg.RetryBudget > 0 && j.attempts >= cfg.RetryBudget {
+		return &jobError{
+			userMessage: fmt.Sprintf("❌ Gave up after %d attempts. Please try again later.", j.attempts),
+			result:      resultFailed,
+			err:         fmt.Errorf("retry budget of %d attempts used up before %s", cfg.RetryBudget, stage),
+		}
+	}
+	j.attempts++
+	return nil
+}
+
+const (
+	retryBaseDelay = time.Second
+	retryMaxDelay  = 30 * time.Second
+)
+
+// httpStatusError is a download the server answered with an error status.
+type httpStatusError struct {
+	code   int
+	status string
+}
+
+func (e *httpStatusError) Error() string {
+	return "download: " + e.status
+}
+
+// statusError describes an error response, with a specific hint if its
+// body is an error page of a host we know.
+func statusError(resp *http.Response) error {
+	msg := fmt.Sprintf("❌ The server responded with %s.", resp.Status)
+	if hint, _, ok := errorPageHint(resp, nil); ok {
+		msg = hint
+	}
+	return &jobError{
+		userMessage: msg,
+		result:      resultRejected,
+		err:         &httpStatusError{code: resp.StatusCode, status: resp.Status},
+	}
+}
+
+// isTransient reports whether a failed download is worth trying again:
+// it was cut off, timed out, stalled, had its connection reset, or the
+// server had a temporary problem.
+func isTransient(err error) bool {
+	var truncated *truncatedError
+	var status *httpStatusError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, errDownloadTimeout):
+		return false
+	case errors.As(err, &truncated), errors.Is(err, errStalled), errors.Is(err, syscall.ECONNRESET):
+		return true
+	case errors.As(err, &status):
+		return status.code >= 500 || status.code == http.StatusTooManyRequests
+	case errors.As(err, &netErr):
+		return netErr.Timeout()
+	}
+	return false
+}
+
+// retryDelay is the wait before retry number attempt (from 1): doubling
+// from retryBaseDelay up to retryMaxDelay, with jitter so that jobs that
+// failed together don't all come back at once.
+func retryDelay(attempt int) time.Duration {
+	delay := retryMaxDelay
+	if attempt < 6 {
+		delay = min(retryBaseDelay<<(attempt-1), retryMaxDelay)
+	}
+	return delay/2 + rand.N(delay/2+1)
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// maxS3LinkTTLHours is the longest SigV4 lets a pre-signed link live.
+const maxS3LinkTTLHours = 7 * 24
+
+var unsafeKeyChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+func s3Enabled() bool {
+	return cfg.S3Endpoint != "" && cfg.S3Bucket != ""
+}
+
+// overflowLimitMB is how large a file that doesn't fit Telegram may be to
+// be uploaded to the S3 bucket instead, or 0 if that's not an option for
+// the job. Chats with a limit of their own below Telegram's keep it.
+func (j *Job) overflowLimitMB() int64 {
+	if !s3Enabled() || j.limitMB < telegramLimitMB() || len(j.options.ZipURLs) > 0 || j.options.Card {
+		return 0
+	}
+	return cfg.S3MaxFileSizeMB
+}
+
+func validateS3(c *Config) error {
+	if c.S3Endpoint == "" && c.S3Bucket == "" {
+		return nil
+	}
+	if c.S3Endpoint == "" || c.S3Bucket == "" || c.S3AccessKeyID == "" || c.S3SecretAccessKey == "" {
+		return fmt.Errorf("S3 storage needs an endpoint, a bucket and credentials")
+	}
+	if u, err := neturl.Parse(c.S3Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("S3 endpoint must be an http or https URL, got %q", c.S3Endpoint)
+	}
+	if c.S3LinkTTLHours <= 0 || c.S3LinkTTLHours > maxS3LinkTTLHours {
+		return fmt.Errorf("S3 link lifetime must be between 1 and %d hours, got %d", maxS3LinkTTLHours, c.S3LinkTTLHours)
+	}
+	if c.S3MaxFileSizeMB <= 0 {
+		return fmt.Errorf("S3 max file size must be positive, got %d MB", c.S3MaxFileSizeMB)
+	}
+	return nil
+}
+
+// s3ObjectURL is the path-style URL of key, which MinIO and other
+// S3-compatible servers understand as well as AWS does.
+func s3ObjectURL(key string) *neturl.URL {
+	u, _ := neturl.Parse(strings.TrimSuffix(cfg.S3Endpoint, "/") + "/" + cfg.S3Bucket + "/" + key)
+	return u
+}
+
+// signS3Request signs req with SigV4 in the Authorization header. The
+// payload is left unsigned, which spares reading the file twice.
+func signS3Request(req *http.Request) {
+	date := time.Now().UTC().Format(amzDateFormat)
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", date[:8], cfg.S3Region)
+	req.Header.Set("X-Amz-Date", date)
+	req.Header.Set("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		"",
+		"host:" + req.URL.Host + "\nx-amz-content-sha256:UNSIGNED-PAYLOAD\nx-amz-date:" + date + "\n",
+		signedHeaders,
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+	signature := sigV4Signature(cfg.S3SecretAccessKey, date, scope, cfg.S3Region, "s3", canonicalRequest)
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		cfg.S3AccessKeyID, scope, signedHeaders, signature))
+}
+
+// doS3Request sends a signed request for key. The operator's own storage,
+// so not through the download proxy or the private address checks.
+func doS3Request(ctx context.Context, method, key string, body io.Reader, size int64) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, s3ObjectURL(key).String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+		req.Header.Set("Content-Type", "application/octet-stream")
+	}
+	signS3Request(req)
+	return http.DefaultClient.Do(req)
+}
+
+func s3Error(resp *http.Response, what string) error {
+	text, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return fmt.Errorf("S3 %s returned %s: %s", what, resp.Status, strings.TrimSpace(string(text)))
+}
+
+// putS3Object uploads size bytes from body as key.
+func putS3Object(ctx context.Context, key string, body io.Reader, size int64) error {
+	resp, err := doS3Request(ctx, http.MethodPut, key, body, size)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return s3Error(resp, "upload")
+	}
+	return nil
+}
+
+// getS3Object starts the download of key. The caller closes the body.
+func getS3Object(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := doS3Request(ctx, http.MethodGet, key, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, s3Error(resp, "download")
+	}
+	return resp.Body, nil
+}
+
+func deleteS3Object(ctx context.Context, key string) error {
+	resp, err := doS3Request(ctx, http.MethodDelete, key, nil, 0)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return s3Error(resp, "delete")
+	}
+	return nil
+}
+
+// s3DownloadLink is a pre-signed GET of key that lives for
+// cfg.S3LinkTTLHours and saves the file under its own name.
+func s3DownloadLink(key, fileName string, now time.Time) string {
+	u := s3ObjectURL(key)
+	q := neturl.Values{}
+	q.Set("response-content-disposition", fmt.Sprintf("attachment; filename=%q", fileName))
+	ttl := time.Duration(cfg.S3LinkTTLHours) * time.Hour
+	u.RawQuery = presignQuery(http.MethodGet, u, q, cfg.S3AccessKeyID, cfg.S3SecretAccessKey, cfg.S3Region, "s3", ttl, now)
+	return u.String()
+}
+
+// sendViaS3 uploads a file too large for Telegram to the bucket and
+// replies with a link to it instead.
+func sendViaS3(bot *tgbotapi.BotAPI, job *Job, file *os.File, size int64, caption string) error {
+	key := fmt.Sprintf("%s/%d-%s", time.Now().UTC().Format("2006/01/02"), job.ID, unsafeKeyChars.ReplaceAllString(job.FileName, "_"))
+	job.setState(jobUploading)
+	updateMessage(bot, job.ChatID, job.StatusMessageID, "☁️ Too large for Telegram, uploading to storage...")
+	if err := job.spendAttempt("upload"); err != nil {
+		return err
+	}
+	uploaded := job.timeStage("upload")
+	file.Seek(0, io.SeekStart)
+	body := &ProgressReader{
+		Reader:     job.throttle(file, "upload"),
+		total:      size,
+		onProgress: progressUpdater(bot, job, "upload", "☁️ Too large for Telegram, uploading to storage..."),
+		meter:      job.meter("upload"),
+	}
+	if err := putS3Object(job.ctx, key, body, size); err != nil {
+		if job.ctx.Err() != nil {
+			return job.ctx.Err()
+		}
+		return failJob("❌ The file is too large for Telegram and couldn't be uploaded to storage either. Please try again later.", err)
+	}
+	uploaded()
+
+	link := s3DownloadLink(key, job.FileName, time.Now())
+	text := fmt.Sprintf("☁️ %s (%.1f MB) is too large for Telegram, so it was uploaded to storage.\n\nThe download link expires in %d hours.",
+		job.FileName, float64(size)/1024/1024, cfg.S3LinkTTLHours)
+	if caption != "" {
+		text += "\n\n" + caption
+	}
+	msg := tgbotapi.NewMessage(job.deliveryChatID(), text)
+	msg.ReplyToMessageID = job.deliveryReplyID()
+	msg.DisableWebPagePreview = true
+	msg.DisableNotification = job.silent
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonURL("⬇️ Download", link),
+	))
+	sent, err := bot.Send(msg)
+	if err != nil {
+		return failJob(describeSendError(err, "❌ Failed to send the download link"), err)
+	}
+	job.logger().Info("Sent file through S3", "key", key, "size", size)
+	job.storedInS3 = true
+	indexDelivery(job, sent)
+	return nil
+}
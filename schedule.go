@@ -0,0 +1,271 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	scheduleUsage = "Usage: /schedule <HH:MM|+duration> <url> [options]\n/schedule list\n/schedule cancel <id>\n\nFor example: /schedule 22:00 https://example.com/big.iso or /schedule +2h https://example.com/big.iso"
+
+	maxScheduleAhead      = 7 * 24 * time.Hour
+	maxScheduledPerUser   = 10
+	schedulerPollInterval = 30 * time.Second
+)
+
+// scheduledJob is a /url request put off until RunAt. It is kept in the
+// database, so it still runs if the bot restarts in between.
+type scheduledJob struct {
+	ID        int64  `json:"id"`
+	ChatID    int64  `json:"chat_id"`
+	UserID    int64  `json:"user_id"`
+	MessageID int    `json:"message_id"`
+	URL       string `json:"url"`
+	// Template and Params are what URL was filled in from, filled in
+	// again when the job runs.
+	Template  string     `json:"template,omitempty"`
+	Params    []string   `json:"params,omitempty"`
+	Options   jobOptions `json:"options"`
+	RunAt     time.Time  `json:"run_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// parseScheduleTime understands a time of day, which is the next time the
+// clock shows it, and a delay like +2h or +90m.
+func parseScheduleTime(value string, now time.Time) (time.Time, error) {
+	if delay, ok := strings.CutPrefix(value, "+"); ok {
+		d, err := time.ParseDuration(delay)
+		if err != nil || d <= 0 {
+			return time.Time{}, fmt.Errorf("%q isn't a delay like +2h or +90m", value)
+		}
+		return now.Add(d), nil
+	}
+	clock, err := time.ParseInLocation("15:04", value, now.Location())
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q isn't a time like 22:00 or a delay like +2h", value)
+	}
+	at := time.Date(now.Year(), now.Month(), now.Day(), clock.Hour(), clock.Minute(), 0, 0, now.Location())
+	if !at.After(now) {
+		at = at.AddDate(0, 0, 1)
+	}
+	return at, nil
+}
+
+func handleScheduleCommand(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
+	if !isAuthorized(message) {
+		sendErrorMessage(bot, message.Chat.ID, notAuthorizedMessage)
+		return
+	}
+	if !hidePassphrase(bot, message) {
+		return
+	}
+	if message.From == nil {
+		sendErrorMessage(bot, message.Chat.ID, "❌ Scheduled downloads need a user to belong to.")
+		return
+	}
+	args, err := commandArgs(message)
+	if err != nil {
+		sendErrorMessage(bot, message.Chat.ID, usageError(err, scheduleUsage))
+		return
+	}
+	switch {
+	case len(args) == 1 && strings.EqualFold(args[0], "list"):
+		listScheduledJobs(bot, message)
+		return
+	case len(args) == 2 && strings.EqualFold(args[0], "cancel"):
+		cancelScheduledJob(bot, message, args[1])
+		return
+	case len(args) < 2:
+		sendErrorMessage(bot, message.Chat.ID, scheduleUsage)
+		return
+	}
+	if !passesGate(bot, message) {
+		return
+	}
+
+	now := time.Now()
+	runAt, err := parseScheduleTime(args[0], now)
+	if err != nil {
+		sendErrorMessage(bot, message.Chat.ID, usageError(err, scheduleUsage))
+		return
+	}
+	if runAt.Sub(now) > maxScheduleAhead {
+		sendErrorMessage(bot, message.Chat.ID, "❌ Downloads can be scheduled up to 7 days ahead.")
+		return
+	}
+
+	opts, args, err := parseJobOptions(args[1:])
+	if err != nil {
+		sendErrorMessage(bot, message.Chat.ID, usageError(err, scheduleUsage))
+		return
+	}
+	if len(args) == 0 {
+		sendErrorMessage(bot, message.Chat.ID, scheduleUsage)
+		return
+	}
+	tmpl, ok := resolveURLTemplate(message, args[0])
+	if !ok {
+		sendErrorMessage(bot, message.Chat.ID, tmpl)
+		return
+	}
+	url, ok := fillURLTemplate(tmpl, args[1:], time.Now())
+	if !ok {
+		sendErrorMessage(bot, message.Chat.ID, url)
+		return
+	}
+	if problem, ok := validateURL(url); !ok {
+		sendErrorMessage(bot, message.Chat.ID, problem)
+		return
+	}
+	if opts.Encrypt {
+		// The passphrase isn't written to the database.
+		sendErrorMessage(bot, message.Chat.ID, "❌ Encrypted downloads can't be scheduled.")
+		return
+	}
+
+	pending, err := loadScheduledJobs()
+	if err != nil {
+		slog.Error("Error loading scheduled jobs", "error", err)
+		sendErrorMessage(bot, message.Chat.ID, "❌ Failed to schedule the download")
+		return
+	}
+	mine := 0
+	for _, sj := range pending {
+		if sj.UserID == message.From.ID {
+			mine++
+		}
+	}
+	if mine >= maxScheduledPerUser && !isAdmin(message.From.ID) {
+		sendErrorMessage(bot, message.Chat.ID, fmt.Sprintf("❌ You already have %d scheduled downloads. Cancel some with /schedule cancel <id> first.", mine))
+		return
+	}
+
+	id, err := store.nextID(bucketScheduled)
+	if err != nil {
+		slog.Error("Error allocating schedule ID", "error", err)
+		sendErrorMessage(bot, message.Chat.ID, "❌ Failed to schedule the download")
+		return
+	}
+	sj := scheduledJob{
+		ID:        int64(id),
+		ChatID:    message.Chat.ID,
+		UserID:    message.From.ID,
+		MessageID: message.MessageID,
+		URL:       url,
+		Template:  tmpl,
+		Params:    args[1:],
+		Options:   opts,
+		RunAt:     runAt,
+		CreatedAt: now,
+	}
+	if err := store.put(bucketScheduled, jobKey(sj.ID), sj); err != nil {
+		slog.Error("Error saving scheduled job", "error", err)
+		sendErrorMessage(bot, message.Chat.ID, "❌ Failed to schedule the download")
+		return
+	}
+	slog.Info("Scheduled job", "schedule_id", sj.ID, "chat_id", sj.ChatID, "user_id", sj.UserID, "host", urlHost(url), "run_at", runAt)
+	msg := tgbotapi.NewMessage(message.Chat.ID, fmt.Sprintf("🕙 Scheduled #%d for %s (in %s).\n\nCancel it with /schedule cancel %d.",
+		sj.ID, runAt.Format("Mon 15:04"), runAt.Sub(now).Round(time.Minute), sj.ID))
+	msg.ReplyToMessageID = message.MessageID
+	bot.Send(msg)
+}
+
+func loadScheduledJobs() ([]scheduledJob, error) {
+	var pending []scheduledJob
+	err := store.forEach(bucketScheduled, func(_, value []byte) error {
+		var sj scheduledJob
+		if err := json.Unmarshal(value, &sj); err != nil {
+			return err
+		}
+		pending = append(pending, sj)
+		return nil
+	})
+	sort.Slice(pending, func(i, j int) bool { return pending[i].RunAt.Before(pending[j].RunAt) })
+	return pending, err
+}
+
+func listScheduledJobs(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
+	pending, err := loadScheduledJobs()
+	if err != nil {
+		slog.Error("Error loading scheduled jobs", "error", err)
+		sendErrorMessage(bot, message.Chat.ID, "❌ Failed to load the scheduled downloads")
+		return
+	}
+	var lines []string
+	for _, sj := range pending {
+		if sj.UserID == message.From.ID && sj.ChatID == message.Chat.ID {
+			lines = append(lines, fmt.Sprintf("#%d %s — %s", sj.ID, sj.RunAt.Format("Mon 15:04"), redactURL(sj.URL)))
+		}
+	}
+	if len(lines) == 0 {
+		sendMessage(bot, message.Chat.ID, "🕙 You have no scheduled downloads here.")
+		return
+	}
+	msg := tgbotapi.NewMessage(message.Chat.ID, "🕙 Scheduled downloads:\n\n"+strings.Join(lines, "\n"))
+	msg.DisableWebPagePreview = true
+	bot.Send(msg)
+}
+
+func cancelScheduledJob(bot *tgbotapi.BotAPI, message *tgbotapi.Message, arg string) {
+	id, err := strconv.ParseInt(strings.TrimPrefix(arg, "#"), 10, 64)
+	if err != nil {
+		sendErrorMessage(bot, message.Chat.ID, scheduleUsage)
+		return
+	}
+	var sj scheduledJob
+	found, err := store.get(bucketScheduled, jobKey(id), &sj)
+	if err != nil {
+		slog.Error("Error loading scheduled job", "schedule_id", id, "error", err)
+		sendErrorMessage(bot, message.Chat.ID, "❌ Failed to cancel the download")
+		return
+	}
+	if !found || (sj.UserID != message.From.ID && !isAdmin(message.From.ID)) {
+		sendErrorMessage(bot, message.Chat.ID, fmt.Sprintf("❌ No scheduled download #%d of yours.", id))
+		return
+	}
+	if err := store.delete(bucketScheduled, jobKey(id)); err != nil {
+		slog.Error("Error deleting scheduled job", "schedule_id", id, "error", err)
+		sendErrorMessage(bot, message.Chat.ID, "❌ Failed to cancel the download")
+		return
+	}
+	sendMessage(bot, message.Chat.ID, fmt.Sprintf("✅ Cancelled scheduled download #%d.", id))
+}
+
+// runScheduler starts scheduled jobs and recurring downloads once they
+// are due. Jobs that came due while the bot was down start right away.
+func runScheduler(bot *tgbotapi.BotAPI) {
+	for {
+		startDueJobs(bot, time.Now())
+		startDueCronJobs(bot, time.Now())
+		time.Sleep(schedulerPollInterval)
+	}
+}
+
+func startDueJobs(bot *tgbotapi.BotAPI, now time.Time) {
+	pending, err := loadScheduledJobs()
+	if err != nil {
+		slog.Error("Error loading scheduled jobs", "error", err)
+		return
+	}
+	for _, sj := range pending {
+		if sj.RunAt.After(now) {
+			break
+		}
+		// Removed first, so a crash can't start it twice; the job's own
+		// checkpoint takes over from here.
+		if err := store.delete(bucketScheduled, jobKey(sj.ID)); err != nil {
+			slog.Error("Error deleting scheduled job", "schedule_id", sj.ID, "error", err)
+			continue
+		}
+		job := jobs.schedule(sj)
+		job.logger().Info("Starting scheduled job", "schedule_id", sj.ID, "late_by", now.Sub(sj.RunAt).Round(time.Second))
+		go handleURL(bot, job)
+	}
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// settingsLanguages are the languages /settings offers, in its order.
+var settingsLanguages = []struct{ code, label string }{
+	{"en", "English"}, {"de", "Deutsch"}, {"es", "Español"}, {"fr", "Français"},
+	{"it", "Italiano"}, {"pt", "Português"}, {"ru", "Русский"}, {"tr", "Türkçe"},
+	{"fa", "فارسی"}, {"ar", "العربية"}, {"zh", "中文"}, {"ja", "日本語"},
+}
+
+var settingsSizes = []int64{10, 20, 50, 100, 500, 2000}
+
+type fileTypeChoice struct {
+	category    fileCategory
+	label, name string
+}
+
+var settingsTypes = []fileTypeChoice{
+	{categoryVideo, "🎬 Videos", "videos"}, {categoryAudio, "🎵 Audio", "audio"},
+	{categoryImage, "🖼 Images", "images"}, {categoryDoc, "📄 Documents", "documents"},
+	{categoryArchive, "🗜 Archives", "archives"}, {categoryOther, "📦 Other files", "other files"},
+}
+
+func (j *Job) setLanguage(req *http.Request) {
+	if j.language != "" {
+		req.Header.Set("Accept-Language", j.language+", *;q=0.5")
+	}
+}
+
+func loadChatRecord(chatID int64) chatRecord {
+	var chat chatRecord
+	if _, err := store.get(bucketChats, chatKey(chatID), &chat); err != nil {
+		slog.Error("Error loading chat", "chat_id", chatID, "error", err)
+	}
+	return chat
+}
+
+// applyChatSettings takes on the settings of the job's chat that shape
+// how it is downloaded and sent.
+func (j *Job) applyChatSettings() {
+	chat := loadChatRecord(j.ChatID)
+	j.silent = chat.Silent
+	j.language = chat.Language
+	j.allowedTypes = chat.AllowedTypes
+	j.chatDisabled = chat.Disabled
+}
+
+// checkFileType turns away files of a kind the chat doesn't take.
+func (j *Job) checkFileType(fileName, contentType string) error {
+	if len(j.allowedTypes) == 0 {
+		return nil
+	}
+	category := classifyFile(fileName, contentType)
+	if slices.Contains(j.allowedTypes, category) {
+		return nil
+	}
+	return &jobError{
+		userMessage: "🚫 This chat only takes " + describeTypes(j.allowedTypes) + ". Admins can change that with /settings.",
+		result:      resultRejected,
+		err:         fmt.Errorf("file type %s not allowed in the chat", category),
+	}
+}
+
+// deliveredName is what fileName is sent as, which for /audio is only the
+// audio track, so that kind of file is what the chat has to take.
+func (j *Job) deliveredName(fileName string) string {
+	if j.options.AudioFormat == "" {
+		return fileName
+	}
+	return strings.TrimSuffix(fileName, filepath.Ext(fileName)) + "." + j.options.AudioFormat
+}
+
+func describeTypes(types []fileCategory) string {
+	if len(types) == 0 {
+		return "all files"
+	}
+	var names []string
+	for _, t := range settingsTypes {
+		if slices.Contains(types, t.category) {
+			names = append(names, t.name)
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+func handleSettingsCommand(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
+	if !isAuthorized(message) {
+		sendErrorMessage(bot, message.Chat.ID, notAuthorizedMessage)
+		return
+	}
+	text, markup := renderSettings(message.Chat.ID, "menu")
+	msg := tgbotapi.NewMessage(message.Chat.ID, text)
+	msg.ReplyMarkup = markup
+	bot.Send(msg)
+}
+
+func describeSettings(chat chatRecord) string {
+	onOff := func(b bool) string {
+		if b {
+			return "on"
+		}
+		return "off"
+	}
+	language := "any"
+	for _, l := range settingsLanguages {
+		if l.code == chat.Language {
+			language = l.label
+		}
+	}
+	size := fmt.Sprintf("%d MB", chatMaxFileSizeMB(chat.ID))
+	if chat.SizeCapMB == 0 {
+		size += " (the bot's limit)"
+	}
+	return strings.Join([]string{
+		"⚙️ Settings of this chat",
+		"",
+		"🌐 Language asked of servers: " + language,
+		"📦 Largest file: " + size,
+		"⚡ Download posted links without /url: " + onOff(chat.AutoDownload),
+		"🗂 Files taken: " + describeTypes(chat.AllowedTypes),
+		"🔕 Send files silently: " + onOff(chat.Silent),
+	}, "\n")
+}
+
+// renderSettings draws one page of the /settings menu: the overview or
+// the choices of one setting.
+func renderSettings(chatID int64, page string) (string, tgbotapi.InlineKeyboardMarkup) {
+	chat := loadChatRecord(chatID)
+	chat.ID = chatID
+	text := describeSettings(chat)
+	button := tgbotapi.NewInlineKeyboardButtonData
+	back := tgbotapi.NewInlineKeyboardRow(button("⬅️ Back", "settings:menu"))
+	var rows [][]tgbotapi.InlineKeyboardButton
+
+	switch page {
+	case "language":
+		var row []tgbotapi.InlineKeyboardButton
+		for _, l := range settingsLanguages {
+			label := l.label
+			if l.code == chat.Language {
+				label = "• " + label
+			}
+			row = append(row, button(label, "settings:language:"+l.code))
+			if len(row) == 3 {
+				rows = append(rows, row)
+				row = nil
+			}
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(button("Any", "settings:language:any")), back)
+		text += "\n\nWhich language should servers send their pages and files in, where they have a choice?"
+	case "size":
+		var row []tgbotapi.InlineKeyboardButton
+		for _, mb := range settingsSizes {
+			row = append(row, button(fmt.Sprintf("%d MB", mb), "settings:size:"+strconv.FormatInt(mb, 10)))
+			if len(row) == 3 {
+				rows = append(rows, row)
+				row = nil
+			}
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(button("The bot's limit", "settings:size:0")), back)
+		text += "\n\nHow large may files downloaded here be? A limit above the bot's own doesn't raise it."
+	case "types":
+		var row []tgbotapi.InlineKeyboardButton
+		for _, t := range settingsTypes {
+			mark := "⬜ "
+			if len(chat.AllowedTypes) == 0 || slices.Contains(chat.AllowedTypes, t.category) {
+				mark = "✅ "
+			}
+			row = append(row, button(mark+t.label, "settings:type:"+string(t.category)))
+			if len(row) == 2 {
+				rows = append(rows, row)
+				row = nil
+			}
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(button("Take all files", "settings:type:all")), back)
+		text += "\n\nWhich kinds of file should the bot take here? Tap one to switch it."
+	default:
+		rows = [][]tgbotapi.InlineKeyboardButton{
+			tgbotapi.NewInlineKeyboardRow(button("🌐 Language", "settings:language"), button("📦 Largest file", "settings:size")),
+			tgbotapi.NewInlineKeyboardRow(button("⚡ Auto-download", "settings:auto"), button("🗂 File types", "settings:types")),
+			tgbotapi.NewInlineKeyboardRow(button("🔕 Silent", "settings:silent"), button("✖️ Close", "settings:close")),
+		}
+	}
+	return text, tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+func handleSettingsCallback(bot *tgbotapi.BotAPI, query *tgbotapi.CallbackQuery, args []string) string {
+	if len(args) == 0 || query.From == nil || query.Message == nil {
+		return ""
+	}
+	chat := query.Message.Chat
+	if args[0] == "close" {
+		bot.Request(tgbotapi.NewDeleteMessage(chat.ID, query.Message.MessageID))
+		return ""
+	}
+
+	page := args[0]
+	var change func(r *chatRecord)
+	switch {
+	case len(args) == 1 && args[0] == "auto":
+		page = "menu"
+		change = func(r *chatRecord) { r.AutoDownload = !r.AutoDownload }
+	case len(args) == 1 && args[0] == "silent":
+		page = "menu"
+		change = func(r *chatRecord) { r.Silent = !r.Silent }
+	case len(args) == 2 && args[0] == "language":
+		page = "menu"
+		code := args[1]
+		if code == "any" {
+			code = ""
+		} else if !slices.ContainsFunc(settingsLanguages, func(l struct{ code, label string }) bool { return l.code == code }) {
+			return ""
+		}
+		change = func(r *chatRecord) { r.Language = code }
+	case len(args) == 2 && args[0] == "size":
+		mb, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil || mb < 0 {
+			return ""
+		}
+		page = "menu"
+		change = func(r *chatRecord) { r.SizeCapMB = mb }
+	case len(args) == 2 && args[0] == "type":
+		page = "types"
+		category := fileCategory(args[1])
+		change = func(r *chatRecord) { r.AllowedTypes = toggleType(r.AllowedTypes, category) }
+	}
+
+	if change != nil {
+		if !canManageChat(bot, chat, query.From.ID) {
+			return "Only admins of this group can change its settings."
+		}
+		err := updateRecord(store, bucketChats, chatKey(chat.ID), func(r *chatRecord, exists bool) error {
+			if !exists {
+				r.ID = chat.ID
+				r.Title = chat.Title
+				r.Type = chat.Type
+				r.LastActivity = time.Now()
+			}
+			change(r)
+			return nil
+		})
+		if err != nil {
+			slog.Error("Error saving chat settings", "chat_id", chat.ID, "error", err)
+			return "❌ Failed to save the setting"
+		}
+		slog.Info("Chat settings changed", "chat_id", chat.ID, "user_id", query.From.ID, "setting", strings.Join(args, ":"))
+	}
+
+	text, markup := renderSettings(chat.ID, page)
+	bot.Send(tgbotapi.NewEditMessageTextAndMarkup(chat.ID, query.Message.MessageID, text, markup))
+	if change != nil {
+		return "✅ Saved"
+	}
+	return ""
+}
+
+// toggleType switches one kind of file on or off, keeping at least one.
+// No types listed means all are taken, so switching one off starts from
+// all of them; "all" goes back to that.
+func toggleType(types []fileCategory, category fileCategory) []fileCategory {
+	if category == "all" {
+		return nil
+	}
+	if !slices.ContainsFunc(settingsTypes, func(t fileTypeChoice) bool { return t.category == category }) {
+		return types
+	}
+	if len(types) == 0 {
+		for _, t := range settingsTypes {
+			types = append(types, t.category)
+		}
+	}
+	switch i := slices.Index(types, category); {
+	case i < 0:
+		types = append(slices.Clone(types), category)
+	case len(types) > 1:
+		types = slices.Delete(slices.Clone(types), i, i+1)
+	}
+	if len(types) == len(settingsTypes) {
+		return nil
+	}
+	return types
+}
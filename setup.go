@@ -0,0 +1,336 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const setupKey = "setup"
+
+// setupSettings are the answers of /setup. They are kept in the store and
+// applied over the configuration at startup, so a setting left unset
+// keeps what the config file, the environment or the flags say.
+type setupSettings struct {
+	MaxFileSizeMB  int64     `json:"max_file_size_mb,omitempty"`
+	DailyQuotaMB   *int64    `json:"daily_quota_mb,omitempty"`
+	AllowedChatIDs []int64   `json:"allowed_chat_ids,omitempty"`
+	LogChannelID   int64     `json:"log_channel_id,omitempty"`
+	WeeklyDigest   *bool     `json:"weekly_digest,omitempty"`
+	StreamUploads  *bool     `json:"stream_uploads,omitempty"`
+	SavedBy        int64     `json:"saved_by"`
+	SavedAt        time.Time `json:"saved_at"`
+}
+
+// setupChoice is one button of a step: its label and the value its
+// callback carries.
+type setupChoice struct{ label, value string }
+
+type setupStep struct {
+	prompt  string
+	choices func() []setupChoice
+	apply   func(s *setupSettings, chatID int64, value string)
+}
+
+var setupSteps = []setupStep{
+	{
+		prompt: "📦 How large may downloaded files be?",
+		choices: func() []setupChoice {
+			choices := []setupChoice{{"10 MB", "10"}, {"20 MB", "20"}, {"50 MB", "50"}}
+			if telegramLimitMB() > 50 {
+				choices = append(choices, setupChoice{"500 MB", "500"}, setupChoice{fmt.Sprintf("%d MB", telegramLimitMB()), strconv.FormatInt(telegramLimitMB(), 10)})
+			}
+			return append(choices, setupChoice{fmt.Sprintf("Keep %d MB", maxFileSizeMB()), "keep"})
+		},
+		apply: func(s *setupSettings, _ int64, value string) {
+			if mb, err := strconv.ParseInt(value, 10, 64); err == nil {
+				s.MaxFileSizeMB = mb
+			}
+		},
+	},
+	{
+		prompt: "📊 How much may each user download per day?",
+		choices: func() []setupChoice {
+			return []setupChoice{{"No limit", "0"}, {"500 MB", "500"}, {"2 GB", "2048"}, {"10 GB", "10240"}, {"Keep", "keep"}}
+		},
+		apply: func(s *setupSettings, _ int64, value string) {
+			if mb, err := strconv.ParseInt(value, 10, 64); err == nil {
+				s.DailyQuotaMB = &mb
+			}
+		},
+	},
+	{
+		prompt: "🔒 Should this chat be on the allowlist? Once anything is on it, only allowlisted users and chats can use the bot.",
+		choices: func() []setupChoice {
+			return []setupChoice{{"Allow this chat", "chat"}, {"Skip", "keep"}}
+		},
+		apply: func(s *setupSettings, chatID int64, value string) {
+			if value == "chat" {
+				s.AllowedChatIDs = append(s.AllowedChatIDs, chatID)
+			}
+		},
+	},
+	{
+		prompt: "📋 Should the bot send its logs, approval requests and digests to this chat?",
+		choices: func() []setupChoice {
+			return []setupChoice{{"Log to this chat", "chat"}, {"Skip", "keep"}}
+		},
+		apply: func(s *setupSettings, chatID int64, value string) {
+			if value == "chat" {
+				s.LogChannelID = chatID
+			}
+		},
+	},
+	{
+		prompt: "🗓 Post a weekly digest of downloads?",
+		choices: func() []setupChoice {
+			return []setupChoice{{"On", "on"}, {"Off", "off"}, {"Keep", "keep"}}
+		},
+		apply: func(s *setupSettings, _ int64, value string) {
+			if value != "keep" {
+				on := value == "on"
+				s.WeeklyDigest = &on
+			}
+		},
+	},
+	{
+		prompt: "📡 Stream files straight to Telegram while they download? It saves disk space but skips some checks.",
+		choices: func() []setupChoice {
+			return []setupChoice{{"On", "on"}, {"Off", "off"}, {"Keep", "keep"}}
+		},
+		apply: func(s *setupSettings, _ int64, value string) {
+			if value != "keep" {
+				on := value == "on"
+				s.StreamUploads = &on
+			}
+		},
+	},
+}
+
+// setupSession is the owner's /setup in progress. There is only one
+// owner, so only one session.
+var setupSession struct {
+	mu     sync.Mutex
+	active bool
+	chatID int64
+	step   int
+	draft  setupSettings
+}
+
+// isOwner reports whether userID is the bot's owner, the first of
+// cfg.AdminIDs.
+func isOwner(userID int64) bool {
+	return len(cfg.AdminIDs) > 0 && cfg.AdminIDs[0] == userID
+}
+
+func handleSetupCommand(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
+	if message.From == nil || !isOwner(message.From.ID) {
+		sendErrorMessage(bot, message.Chat.ID, "🚫 Only the bot's owner, the first of its admins, can use /setup.")
+		return
+	}
+	if strings.EqualFold(strings.TrimSpace(message.CommandArguments()), "reset") {
+		if err := store.delete(bucketMeta, setupKey); err != nil {
+			slog.Error("Error resetting setup", "error", err)
+			sendErrorMessage(bot, message.Chat.ID, "❌ Failed to reset the settings")
+			return
+		}
+		sendMessage(bot, message.Chat.ID, "✅ The settings from /setup are gone. The configuration applies again after a restart.")
+		return
+	}
+
+	setupSession.mu.Lock()
+	setupSession.active = true
+	setupSession.chatID = message.Chat.ID
+	setupSession.step = 0
+	setupSession.draft = setupSettings{}
+	text, markup := renderSetupStep(0)
+	setupSession.mu.Unlock()
+
+	msg := tgbotapi.NewMessage(message.Chat.ID, "🛠 Let's set up the bot. Settings you skip keep their current value.\n\n"+text)
+	msg.ReplyMarkup = markup
+	bot.Send(msg)
+}
+
+func renderSetupStep(step int) (string, tgbotapi.InlineKeyboardMarkup) {
+	if step == len(setupSteps) {
+		return "💾 That's everything. Save these settings?", tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("💾 Save", fmt.Sprintf("setup:%d:save", step)),
+			tgbotapi.NewInlineKeyboardButtonData("✖️ Cancel", fmt.Sprintf("setup:%d:cancel", step)),
+		))
+	}
+	var rows [][]tgbotapi.InlineKeyboardButton
+	var row []tgbotapi.InlineKeyboardButton
+	for _, choice := range setupSteps[step].choices() {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData(choice.label, fmt.Sprintf("setup:%d:%s", step, choice.value)))
+		if len(row) == 3 {
+			rows = append(rows, row)
+			row = nil
+		}
+	}
+	if len(row) > 0 {
+		rows = append(rows, row)
+	}
+	text := fmt.Sprintf("Step %d of %d\n\n%s", step+1, len(setupSteps), setupSteps[step].prompt)
+	return text, tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+func handleSetupCallback(bot *tgbotapi.BotAPI, query *tgbotapi.CallbackQuery, args []string) string {
+	if query.From == nil || !isOwner(query.From.ID) {
+		return "Only the bot's owner can do this."
+	}
+	if len(args) != 2 || query.Message == nil {
+		return ""
+	}
+	step, err := strconv.Atoi(args[0])
+	if err != nil {
+		return ""
+	}
+
+	setupSession.mu.Lock()
+	defer setupSession.mu.Unlock()
+	if !setupSession.active || setupSession.step != step || setupSession.chatID != query.Message.Chat.ID {
+		return "This setup is no longer in progress."
+	}
+
+	var text string
+	var markup tgbotapi.InlineKeyboardMarkup
+	switch {
+	case step < len(setupSteps):
+		setupSteps[step].apply(&setupSession.draft, setupSession.chatID, args[1])
+		setupSession.step++
+		text, markup = renderSetupStep(setupSession.step)
+		if setupSession.step == len(setupSteps) {
+			text = describeSetup(setupSession.draft) + "\n\n" + text
+		}
+	case args[1] == "save":
+		setupSession.active = false
+		draft := setupSession.draft
+		draft.SavedBy, draft.SavedAt = query.From.ID, time.Now()
+		if err := saveSetup(draft); err != nil {
+			slog.Error("Error saving setup", "error", err)
+			bot.Send(tgbotapi.NewEditMessageText(query.Message.Chat.ID, query.Message.MessageID, "❌ Failed to save the settings"))
+			return ""
+		}
+		slog.Info("Saved setup", "owner_id", query.From.ID)
+		bot.Send(tgbotapi.NewEditMessageText(query.Message.Chat.ID, query.Message.MessageID,
+			"✅ Saved. The size limit and the allowlist apply right away, the rest after the bot restarts."))
+		return ""
+	default:
+		setupSession.active = false
+		bot.Send(tgbotapi.NewEditMessageText(query.Message.Chat.ID, query.Message.MessageID, "✖️ Setup cancelled, nothing was changed."))
+		return ""
+	}
+	bot.Send(tgbotapi.NewEditMessageTextAndMarkup(query.Message.Chat.ID, query.Message.MessageID, text, markup))
+	return ""
+}
+
+func describeSetup(s setupSettings) string {
+	onOff := func(b *bool) string {
+		switch {
+		case b == nil:
+			return "unchanged"
+		case *b:
+			return "on"
+		}
+		return "off"
+	}
+	lines := []string{"🛠 New settings:"}
+	if s.MaxFileSizeMB > 0 {
+		lines = append(lines, fmt.Sprintf("File size limit: %d MB", s.MaxFileSizeMB))
+	} else {
+		lines = append(lines, "File size limit: unchanged")
+	}
+	switch {
+	case s.DailyQuotaMB == nil:
+		lines = append(lines, "Daily quota: unchanged")
+	case *s.DailyQuotaMB == 0:
+		lines = append(lines, "Daily quota: none")
+	default:
+		lines = append(lines, fmt.Sprintf("Daily quota: %d MB", *s.DailyQuotaMB))
+	}
+	if len(s.AllowedChatIDs) > 0 {
+		lines = append(lines, "Allowlist: add this chat")
+	}
+	if s.LogChannelID != 0 {
+		lines = append(lines, "Log channel: this chat")
+	}
+	lines = append(lines, "Weekly digest: "+onOff(s.WeeklyDigest), "Streaming uploads: "+onOff(s.StreamUploads))
+	return strings.Join(lines, "\n")
+}
+
+// saveSetup stores the settings on top of earlier ones and applies those
+// that can change while the bot runs.
+func saveSetup(draft setupSettings) error {
+	err := updateRecord(store, bucketMeta, setupKey, func(s *setupSettings, _ bool) error {
+		if draft.MaxFileSizeMB > 0 {
+			s.MaxFileSizeMB = draft.MaxFileSizeMB
+		}
+		if draft.DailyQuotaMB != nil {
+			s.DailyQuotaMB = draft.DailyQuotaMB
+		}
+		for _, id := range draft.AllowedChatIDs {
+			if !slices.Contains(s.AllowedChatIDs, id) {
+				s.AllowedChatIDs = append(s.AllowedChatIDs, id)
+			}
+		}
+		if draft.LogChannelID != 0 {
+			s.LogChannelID = draft.LogChannelID
+		}
+		if draft.WeeklyDigest != nil {
+			s.WeeklyDigest = draft.WeeklyDigest
+		}
+		if draft.StreamUploads != nil {
+			s.StreamUploads = draft.StreamUploads
+		}
+		s.SavedBy, s.SavedAt = draft.SavedBy, draft.SavedAt
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if draft.MaxFileSizeMB > 0 {
+		fileSizeLimitMB.Store(draft.MaxFileSizeMB)
+	}
+	for _, id := range draft.AllowedChatIDs {
+		allowlist.set("chat", id, true)
+	}
+	return nil
+}
+
+// applySetup puts the settings saved with /setup over the configuration.
+// It runs at startup, before anything reads them.
+func applySetup() {
+	var s setupSettings
+	found, err := store.get(bucketMeta, setupKey, &s)
+	if err != nil {
+		slog.Error("Error loading setup", "error", err)
+		return
+	}
+	if !found {
+		return
+	}
+	if s.MaxFileSizeMB > 0 && s.MaxFileSizeMB <= telegramLimitMB() {
+		cfg.MaxFileSizeMB = s.MaxFileSizeMB
+		fileSizeLimitMB.Store(s.MaxFileSizeMB)
+	}
+	if s.DailyQuotaMB != nil && *s.DailyQuotaMB >= 0 {
+		cfg.DailyQuotaMB = *s.DailyQuotaMB
+	}
+	allowlist.load(nil, s.AllowedChatIDs)
+	if s.LogChannelID != 0 {
+		cfg.LogChannelID = s.LogChannelID
+	}
+	if s.WeeklyDigest != nil {
+		cfg.WeeklyDigest = *s.WeeklyDigest
+	}
+	if s.StreamUploads != nil {
+		cfg.StreamUploads = *s.StreamUploads
+	}
+	slog.Info("Applied settings from /setup", "saved_at", s.SavedAt)
+}
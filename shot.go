@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	shotUsage        = "Usage: /shot <url> [width=1280] [full]"
+	defaultShotWidth = 1280
+	minShotWidth     = 320
+	maxShotWidth     = 3840
+	// fullShotHeight is how much of the page a full screenshot covers.
+	// Chrome's command line can't measure the page, so it renders one
+	// tall window; short pages get blank space below them.
+	fullShotHeight = 8000
+	shotTimeout    = 90 * time.Second
+)
+
+func handleShotCommand(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
+	if !isAuthorized(message) {
+		sendErrorMessage(bot, message.Chat.ID, notAuthorizedMessage)
+		return
+	}
+	if cfg.ChromePath == "" {
+		sendErrorMessage(bot, message.Chat.ID, "❌ Screenshots aren't enabled on this bot.")
+		return
+	}
+
+	opts := jobOptions{ShotWidth: defaultShotWidth}
+	words, err := commandArgs(message)
+	if err != nil {
+		sendErrorMessage(bot, message.Chat.ID, usageError(err, shotUsage))
+		return
+	}
+	var args []string
+	for _, arg := range words {
+		name, value, _ := strings.Cut(arg, "=")
+		switch strings.ToLower(name) {
+		case "full":
+			opts.ShotFull = true
+		case "width":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < minShotWidth || n > maxShotWidth {
+				sendErrorMessage(bot, message.Chat.ID, fmt.Sprintf("❌ The width must be between %d and %d pixels.", minShotWidth, maxShotWidth))
+				return
+			}
+			opts.ShotWidth = n
+		default:
+			args = append(args, arg)
+		}
+	}
+	if len(args) == 0 {
+		sendErrorMessage(bot, message.Chat.ID, shotUsage)
+		return
+	}
+
+	url, ok := resolveURLArgs(message, args)
+	if !ok {
+		sendErrorMessage(bot, message.Chat.ID, url)
+		return
+	}
+	if problem, ok := validateURL(url); !ok {
+		sendErrorMessage(bot, message.Chat.ID, problem)
+		return
+	}
+	if duplicates.isDuplicate(message) {
+		slog.Debug("Ignoring duplicate /shot", "chat_id", message.Chat.ID)
+		return
+	}
+	var userID int64
+	if message.From != nil {
+		userID = message.From.ID
+	}
+	if slowDown, ok := checkRateLimit(userID); !ok {
+		sendErrorMessage(bot, message.Chat.ID, slowDown)
+		return
+	}
+	go handleURL(bot, jobs.start(message, url, opts))
+}
+
+// runShotJob renders the job's URL in headless Chrome and sends the
+// screenshot: as a photo, or as a document for full-page ones, which
+// Telegram would scale down beyond reading.
+func runShotJob(bot *tgbotapi.BotAPI, job *Job) error {
+	job.limitMB = chatMaxFileSizeMB(job.ChatID)
+	job.planStages("process", "upload")
+	job.setFileName(fmt.Sprintf("%s-%s.png", urlHost(job.URL), time.Now().Format("2006-01-02-150405")))
+	if err := job.checkFileType(job.FileName, "image/png"); err != nil {
+		return err
+	}
+
+	// Chrome needs a profile directory of its own to run next to other
+	// instances.
+	dir, err := os.MkdirTemp(cfg.TempDir, "telegram-shot-*")
+	if err != nil {
+		return failJob("❌ Failed to take the screenshot", err)
+	}
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "shot.png")
+
+	height := job.options.ShotWidth * 10 / 16
+	if job.options.ShotFull {
+		height = fullShotHeight
+	}
+	args := []string{
+		"--headless=new", "--disable-gpu", "--hide-scrollbars", "--mute-audio", "--no-first-run",
+		"--user-data-dir=" + filepath.Join(dir, "profile"),
+		fmt.Sprintf("--window-size=%d,%d", job.options.ShotWidth, height),
+		"--virtual-time-budget=10000",
+		"--screenshot=" + out,
+	}
+	// Chrome refuses to sandbox itself as root, which is how it usually
+	// runs in containers.
+	if os.Geteuid() == 0 {
+		args = append(args, "--no-sandbox")
+	}
+	// The browser fetches the page and everything on it by itself, so it
+	// goes through the guard proxy, loopback included, and can't resolve
+	// or reach anything on its own.
+	proxy, stopProxy, err := startGuardProxy(job.ctx)
+	if err != nil {
+		return failJob("❌ Failed to take the screenshot", err)
+	}
+	defer stopProxy()
+	if proxy != "" {
+		args = append(args, "--proxy-server="+proxy, "--proxy-bypass-list=<-loopback>",
+			"--host-resolver-rules=MAP * ~NOTFOUND , EXCLUDE "+urlHost(proxy),
+			"--force-webrtc-ip-handling-policy=disable_non_proxied_udp")
+	}
+
+	updateMessage(bot, job.ChatID, job.StatusMessageID, "📸 Taking a screenshot...")
+	done := job.timeStage("screenshot")
+	ctx, cancel := context.WithTimeout(job.ctx, shotTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, cfg.ChromePath, append(args, job.URL)...)
+	output, err := cmd.CombinedOutput()
+	job.addCPU(cmd)
+	if job.ctx.Err() != nil {
+		return job.ctx.Err()
+	}
+	data, readErr := os.ReadFile(out)
+	if err != nil || readErr != nil {
+		job.logger().Warn("Screenshot failed", "error", err, "output", strings.TrimSpace(string(output)))
+		if err == nil {
+			err = readErr
+		}
+		return failJob("❌ Couldn't take a screenshot of that page.", err)
+	}
+	done()
+
+	job.Size = int64(len(data))
+	job.noteDiskBytes(job.Size)
+	sum := sha256.Sum256(data)
+	job.SHA256 = hex.EncodeToString(sum[:])
+	if job.Size > job.uploadLimit() {
+		return tooLargeError(job.Size, job.limitMB)
+	}
+	hashInfo, listed := lookupHash(job.SHA256)
+	if listed && hashInfo.Verdict == hashDeny {
+		job.logger().Warn("Blocked denylisted file", "sha256", job.SHA256)
+		return &jobError{userMessage: "🚫 This file is blocked by the bot operator.", result: resultBlocked}
+	}
+	if reason, held := holdReason(job, hashInfo, listed); held {
+		if err := awaitApproval(bot, job, reason); err != nil {
+			return err
+		}
+	}
+
+	file := tgbotapi.FileBytes{Name: job.FileName, Bytes: data}
+	caption := redactURL(job.URL)
+	var msg tgbotapi.Chattable
+	if job.options.ShotFull {
+		doc := tgbotapi.NewDocument(job.deliveryChatID(), file)
+		doc.ReplyToMessageID = job.deliveryReplyID()
+		doc.DisableNotification = job.silent
+		doc.Caption = caption
+		msg = doc
+	} else {
+		photo := tgbotapi.NewPhoto(job.deliveryChatID(), file)
+		photo.ReplyToMessageID = job.deliveryReplyID()
+		photo.DisableNotification = job.silent
+		photo.Caption = caption
+		msg = photo
+	}
+
+	releaseUpload, err := acquireUploadSlot(bot, job, false)
+	if err != nil {
+		return err
+	}
+	defer releaseUpload()
+	job.setState(jobUploading)
+	if err := job.spendAttempt("upload"); err != nil {
+		return err
+	}
+	uploaded := job.timeStage("upload")
+	sent, err := bot.Send(msg)
+	if err != nil {
+		return failJob(describeSendError(err, "❌ Failed to send the screenshot"), err)
+	}
+	uploaded()
+	job.addSent(job.Size)
+	indexDelivery(job, sent)
+	return nil
+}
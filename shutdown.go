@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+var errShutdown = errors.New("bot is shutting down")
+
+// jobsCtx is the parent of every job's context. Cancelling it with
+// errShutdown interrupts downloads and queued jobs; uploads already in
+// flight are not context-aware and are allowed to finish.
+var jobsCtx, stopJobs = context.WithCancelCause(context.Background())
+
+// checkpoint is what's left of a job interrupted by a shutdown: enough to
+// tell the requester about it and to pick it up again, including the
+// partial download if one was in progress.
+type checkpoint struct {
+	JobID           int64      `json:"job_id"`
+	ChatID          int64      `json:"chat_id"`
+	UserID          int64      `json:"user_id"`
+	MessageID       int        `json:"message_id"`
+	StatusMessageID int        `json:"status_message_id"`
+	URL             string     `json:"url"`
+	CreatedAt       time.Time  `json:"created_at"`
+	TempPath        string     `json:"temp_path,omitempty"`
+	Downloaded      int64      `json:"downloaded,omitempty"`
+	Validator       string     `json:"validator,omitempty"`
+	Options         jobOptions `json:"options"`
+}
+
+func interrupted(job *Job) bool {
+	return errors.Is(context.Cause(job.ctx), errShutdown)
+}
+
+func saveCheckpoint(job *Job, tempPath string, downloaded int64) {
+	cp := checkpoint{
+		JobID:           job.ID,
+		ChatID:          job.ChatID,
+		UserID:          job.UserID,
+		MessageID:       job.MessageID,
+		StatusMessageID: job.StatusMessageID,
+		URL:             job.URL,
+		CreatedAt:       job.CreatedAt,
+		TempPath:        tempPath,
+		Downloaded:      downloaded,
+		Validator:       job.partialValidator,
+		Options:         job.options,
+	}
+	if err := store.put(bucketCheckpoints, jobKey(job.ID), cp); err != nil {
+		job.logger().Error("Error checkpointing job", "error", err)
+	}
+}
+
+func clearCheckpoint(job *Job) {
+	if err := store.delete(bucketCheckpoints, jobKey(job.ID)); err != nil {
+		job.logger().Error("Error clearing checkpoint", "error", err)
+	}
+}
+
+// resumeJobs re-enqueues every job left behind by the previous run, whether
+// it was interrupted by a shutdown or lost in a crash.
+func resumeJobs(bot *tgbotapi.BotAPI) {
+	var pending []checkpoint
+	err := store.forEach(bucketCheckpoints, func(_, value []byte) error {
+		var cp checkpoint
+		if err := json.Unmarshal(value, &cp); err != nil {
+			return err
+		}
+		pending = append(pending, cp)
+		return nil
+	})
+	if err != nil {
+		slog.Error("Error loading checkpoints", "error", err)
+		return
+	}
+
+	for _, cp := range pending {
+		slog.Info("Resuming job", "job_id", cp.JobID, "chat_id", cp.ChatID, "host", urlHost(cp.URL))
+		go handleURL(bot, jobs.resume(cp))
+	}
+}
+
+// shutdown interrupts downloads and waits up to cfg.ShutdownTimeoutSeconds
+// for running jobs to wrap up.
+func shutdown(bot *tgbotapi.BotAPI) {
+	slog.Info("Shutting down", "jobs", len(jobs.list()))
+	bot.StopReceivingUpdates()
+	stopJobs(errShutdown)
+
+	done := make(chan struct{})
+	go func() {
+		jobs.wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		slog.Info("All jobs finished")
+	case <-time.After(time.Duration(cfg.ShutdownTimeoutSeconds) * time.Second):
+		slog.Warn("Gave up waiting for jobs", "jobs", len(jobs.list()))
+	}
+}
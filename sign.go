@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"golang.org/x/crypto/blake2b"
+)
+
+// minisignKey is an operator's minisign secret key, used to sign every
+// delivered file. Only unencrypted keys (minisign -G -W) are supported.
+type minisignKey struct {
+	id  [8]byte
+	key ed25519.PrivateKey
+}
+
+var signer *minisignKey
+
+// loadSigningKey reads cfg.SigningKey, if set, and logs the public key
+// recipients verify against.
+func loadSigningKey() error {
+	if cfg.SigningKey == "" {
+		return nil
+	}
+	data, err := os.ReadFile(cfg.SigningKey)
+	if err != nil {
+		return fmt.Errorf("reading signing key: %w", err)
+	}
+	key, err := parseMinisignKey(data)
+	if err != nil {
+		return fmt.Errorf("signing key %s: %w", cfg.SigningKey, err)
+	}
+	signer = key
+	return nil
+}
+
+func parseMinisignKey(data []byte) (*minisignKey, error) {
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[len(lines)-1]))
+	if err != nil {
+		return nil, fmt.Errorf("not a minisign secret key: %w", err)
+	}
+	// sig alg, kdf alg, checksum alg, kdf salt, opslimit, memlimit, key
+	// ID, secret key, checksum
+	if len(raw) != 2+2+2+32+8+8+8+64+32 || string(raw[:2]) != "Ed" || string(raw[4:6]) != "B2" {
+		return nil, errors.New("not a minisign secret key")
+	}
+	if !bytes.Equal(raw[2:4], []byte{0, 0}) {
+		return nil, errors.New("the key is password protected; create one without a password (minisign -G -W)")
+	}
+
+	k := &minisignKey{key: ed25519.PrivateKey(bytes.Clone(raw[62:126]))}
+	copy(k.id[:], raw[54:62])
+	// Without a key, blake2b.New* can't fail.
+	sum, _ := blake2b.New256(nil)
+	sum.Write(raw[:2])
+	sum.Write(raw[54:126])
+	if !bytes.Equal(sum.Sum(nil), raw[126:]) {
+		return nil, errors.New("the key's checksum doesn't match")
+	}
+	return k, nil
+}
+
+// publicKey is the key in the form minisign -P takes.
+func (k *minisignKey) publicKey() string {
+	pub := append([]byte("Ed"), k.id[:]...)
+	pub = append(pub, k.key.Public().(ed25519.PublicKey)...)
+	return base64.StdEncoding.EncodeToString(pub)
+}
+
+// keyID is the key ID as minisign shows it.
+func (k *minisignKey) keyID() string {
+	return fmt.Sprintf("%016X", binary.LittleEndian.Uint64(k.id[:]))
+}
+
+// signature returns the contents of a .minisig file, given the BLAKE2b-512
+// hash of the file; minisign's prehashed format lets the file be hashed
+// as it streams by.
+func (k *minisignKey) signature(fileHash []byte, fileName string) []byte {
+	sig := append([]byte("ED"), k.id[:]...)
+	sig = append(sig, ed25519.Sign(k.key, fileHash)...)
+	trusted := fmt.Sprintf("timestamp:%d\tfile:%s\thashed", time.Now().Unix(), fileName)
+	global := ed25519.Sign(k.key, append(bytes.Clone(sig[10:]), trusted...))
+
+	return fmt.Appendf(nil, "untrusted comment: signature from url-to-file bot, key %s\n%s\ntrusted comment: %s\n%s\n",
+		k.keyID(), base64.StdEncoding.EncodeToString(sig), trusted, base64.StdEncoding.EncodeToString(global))
+}
+
+// signFile signs the file about to be delivered, leaving it at the start.
+func signFile(file *os.File, fileName string) ([]byte, error) {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	h, _ := blake2b.New512(nil)
+	if _, err := io.Copy(h, file); err != nil {
+		return nil, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return signer.signature(h.Sum(nil), fileName), nil
+}
+
+// sendSignature posts the signature as a reply to the delivered file. The
+// file is already there, so a failure here only gets logged.
+func sendSignature(bot *tgbotapi.BotAPI, job *Job, replyTo int, signature []byte) {
+	doc := tgbotapi.NewDocument(job.deliveryChatID(), tgbotapi.FileBytes{Name: job.FileName + ".minisig", Bytes: signature})
+	doc.ReplyToMessageID = replyTo
+	doc.DisableNotification = job.silent
+	doc.Caption = fmt.Sprintf("🔏 Signature. Verify with: minisign -Vm %s -P %s", job.FileName, signer.publicKey())
+	if _, err := bot.Send(doc); err != nil {
+		job.logger().Error("Error sending signature", "error", err)
+	}
+}
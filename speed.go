@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// speedIdleGap is the longest pause between reads that still counts
+	// as transferring. Longer ones are a stall, a retry's backoff or a
+	// job waiting on something, and would only drag the speed down.
+	speedIdleGap = 2 * time.Second
+	// speedWindow is how much transfer time the current speed is taken
+	// over.
+	speedWindow = 10 * time.Second
+)
+
+// speedMeter measures how fast a stage moves its bytes, over the time
+// they are actually moving. It carries over retries of the stage, so
+// the average covers everything transferred.
+type speedMeter struct {
+	mu     sync.Mutex
+	bytes  int64
+	active time.Duration
+	last   time.Time
+	// done and total are the position in the file, for the ETA.
+	done, total int64
+	// samples are recent points of bytes against active time, oldest
+	// first, for the current speed.
+	samples []speedSample
+}
+
+type speedSample struct {
+	bytes  int64
+	active time.Duration
+}
+
+// meter returns the stage's speed meter, starting it the first time.
+func (j *Job) meter(stage string) *speedMeter {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.meters == nil {
+		j.meters = map[string]*speedMeter{}
+	}
+	m, ok := j.meters[stage]
+	if !ok {
+		m = &speedMeter{}
+		j.meters[stage] = m
+	}
+	return m
+}
+
+// existingMeter is the stage's meter if anything was measured in it.
+func (j *Job) existingMeter(stage string) *speedMeter {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.meters[stage]
+}
+
+func (m *speedMeter) add(n int, done, total int64, now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.last.IsZero() {
+		if gap := now.Sub(m.last); gap <= speedIdleGap {
+			m.active += gap
+		}
+	}
+	m.last = now
+	m.bytes += int64(n)
+	m.done, m.total = done, total
+
+	if len(m.samples) == 0 || m.active-m.samples[len(m.samples)-1].active >= time.Second/2 {
+		m.samples = append(m.samples, speedSample{bytes: m.bytes, active: m.active})
+	}
+	for len(m.samples) > 2 && m.active-m.samples[1].active >= speedWindow {
+		m.samples = m.samples[1:]
+	}
+}
+
+// rates are the average and current speed in bytes per second, and how
+// long the rest should take at the current speed, if known.
+func (m *speedMeter) rates() (average, current float64, eta time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.active < time.Second {
+		return 0, 0, 0
+	}
+	average = float64(m.bytes) / m.active.Seconds()
+	current = average
+	if oldest := m.samples[0]; m.active-oldest.active >= time.Second {
+		current = float64(m.bytes-oldest.bytes) / (m.active - oldest.active).Seconds()
+	}
+	if m.total > 0 && current > 0 {
+		eta = time.Duration(float64(m.total-m.done) / current * float64(time.Second))
+	}
+	return average, current, max(eta, 0)
+}
+
+// describe is the speed line under a progress bar.
+func (m *speedMeter) describe() string {
+	average, current, eta := m.rates()
+	if average == 0 {
+		return ""
+	}
+	line := fmt.Sprintf("⚡ %s (avg %s)", formatSpeed(current), formatSpeed(average))
+	if eta > 0 {
+		line += ", about " + eta.Round(time.Second).String() + " left"
+	}
+	return line
+}
+
+func formatSpeed(bytesPerSecond float64) string {
+	if bytesPerSecond < 1024*1024 {
+		return fmt.Sprintf("%.0f KB/s", bytesPerSecond/1024)
+	}
+	return fmt.Sprintf("%.1f MB/s", bytesPerSecond/1024/1024)
+}
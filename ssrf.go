@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	neturl "net/url"
+	"syscall"
+	"time"
+)
+
+var errBlockedAddress = errors.New("address is not publicly routable")
+
+// Ranges net/netip has no predicate for.
+var extraBlockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"), // carrier-grade NAT, also Alibaba Cloud's metadata service
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b:1::/48"),
+}
+
+// IPv6 ranges that carry an IPv4 address, which is checked in turn: NAT64
+// (64:ff9b::/96, the last 32 bits), which DNS64 networks use to reach every
+// IPv4 site, and 6to4 (2002::/16, bits 16 to 48).
+var (
+	nat64Prefix = netip.MustParsePrefix("64:ff9b::/96")
+	sixToFour   = netip.MustParsePrefix("2002::/16")
+)
+
+// isBlockedAddr reports whether downloads must not connect to addr:
+// loopback, private, link-local (which includes the 169.254.169.254 cloud
+// metadata endpoint), multicast and other non-public ranges.
+func isBlockedAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if addr.IsLoopback() || addr.IsPrivate() || addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() ||
+		addr.IsInterfaceLocalMulticast() || addr.IsMulticast() || addr.IsUnspecified() {
+		return true
+	}
+	for _, prefix := range extraBlockedPrefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	if embedded, ok := embeddedIPv4(addr); ok {
+		return isBlockedAddr(embedded)
+	}
+	return false
+}
+
+func embeddedIPv4(addr netip.Addr) (netip.Addr, bool) {
+	b := addr.As16()
+	switch {
+	case nat64Prefix.Contains(addr):
+		return netip.AddrFrom4([4]byte(b[12:16])), true
+	case sixToFour.Contains(addr):
+		return netip.AddrFrom4([4]byte(b[2:6])), true
+	}
+	return netip.Addr{}, false
+}
+
+// checkHost resolves host and fails if any of its addresses is blocked, so
+// a name can't sneak an internal address in next to a public one.
+func checkHost(ctx context.Context, host string) error {
+	if cfg.AllowPrivate {
+		return nil
+	}
+	if addr, err := netip.ParseAddr(host); err == nil {
+		if isBlockedAddr(addr) {
+			return fmt.Errorf("%s: %w", host, errBlockedAddress)
+		}
+		return nil
+	}
+
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		// Let the actual request report resolution problems.
+		return nil
+	}
+	for _, addr := range addrs {
+		if isBlockedAddr(addr) {
+			return fmt.Errorf("%s resolves to %s: %w", host, addr, errBlockedAddress)
+		}
+	}
+	return nil
+}
+
+func checkTarget(ctx context.Context, rawURL string) error {
+	u, err := neturl.Parse(rawURL)
+	if err != nil {
+		return nil
+	}
+	return checkHost(ctx, u.Hostname())
+}
+
+// rejectBlockedDial runs right before every connection, after DNS, so it
+// also catches redirects and names that resolve differently the second
+// time around.
+func rejectBlockedDial(network, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	if isBlockedAddr(addrPort.Addr()) {
+		return fmt.Errorf("connecting to %s: %w", addrPort.Addr(), errBlockedAddress)
+	}
+	return nil
+}
+
+func downloadDialer() *net.Dialer {
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	if !cfg.AllowPrivate {
+		dialer.Control = rejectBlockedDial
+	}
+	return dialer
+}
+
+// protectDownloads keeps client from reaching internal hosts. Connections
+// to a proxy aren't checked, since proxies are commonly on the local
+// network; redirects are checked by name either way.
+func protectDownloads(client *http.Client) {
+	if transport, ok := client.Transport.(*http.Transport); ok && cfg.DownloadProxy == "" {
+		transport.DialContext = downloadDialer().DialContext
+	}
+	client.CheckRedirect = checkRedirect
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+var (
+	errStalled         = errors.New("download stalled")
+	errDownloadTimeout = errors.New("download timed out")
+)
+
+const (
+	minStallOverride   = 5 * time.Second
+	minTimeoutOverride = time.Minute
+)
+
+// limitError is what a download is cancelled with when one of its limits
+// runs out, so the message can name the limit that applied to the job.
+type limitError struct {
+	kind  error
+	limit time.Duration
+}
+
+func (e *limitError) Error() string { return fmt.Sprintf("%v after %s", e.kind, e.limit) }
+func (e *limitError) Unwrap() error { return e.kind }
+
+// stallTimeout and downloadTimeout are the job's limits: the ones given
+// with --stall and --timeout, or else the configured ones.
+func (j *Job) stallTimeout() time.Duration {
+	if j.options.StallSeconds > 0 {
+		return time.Duration(j.options.StallSeconds) * time.Second
+	}
+	return time.Duration(cfg.StallTimeoutSeconds) * time.Second
+}
+
+func (j *Job) downloadTimeout() time.Duration {
+	if j.options.TimeoutSeconds > 0 {
+		return time.Duration(j.options.TimeoutSeconds) * time.Second
+	}
+	return time.Duration(cfg.DownloadTimeoutMinutes) * time.Minute
+}
+
+// parseTimeoutOption checks a --timeout or --stall value against the
+// bounds the operator allows, given by max; 0 means no overrides.
+func parseTimeoutOption(flag, value string, least, max time.Duration) (int, error) {
+	if max <= 0 {
+		return 0, fmt.Errorf("%s can't be changed on this bot", flag)
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s needs a duration like 90s or 30m", flag)
+	}
+	if d < least || d > max {
+		return 0, fmt.Errorf("%s must be between %s and %s", flag, least, max)
+	}
+	return int(d.Round(time.Second).Seconds()), nil
+}
+
+// downloadContext is the context for one download request of job. It is
+// cancelled with errDownloadTimeout once the job has spent its download
+// timeout downloading, counted from its first request, and with
+// errStalled by the returned watchdog.
+func downloadContext(job *Job) (context.Context, *stallWatchdog) {
+	ctx, cancel := context.WithCancelCause(job.ctx)
+	if timeout := job.downloadTimeout(); timeout > 0 {
+		if job.downloadDeadline.IsZero() {
+			job.downloadDeadline = time.Now().Add(timeout)
+		}
+		var stop context.CancelFunc
+		ctx, stop = context.WithDeadlineCause(ctx, job.downloadDeadline, &limitError{errDownloadTimeout, timeout})
+		parent := cancel
+		cancel = func(cause error) {
+			parent(cause)
+			stop()
+		}
+	}
+	w := &stallWatchdog{ctx: ctx, cancel: cancel}
+	if idle := job.stallTimeout(); idle > 0 {
+		w.idle = idle
+		w.timer = time.AfterFunc(idle, func() { cancel(&limitError{errStalled, idle}) })
+	}
+	return ctx, w
+}
+
+// stallWatchdog cancels a download that hasn't received a byte for its
+// idle timeout, whether it is still waiting for the response or in the
+// middle of the body. It wraps the response body.
+type stallWatchdog struct {
+	io.ReadCloser
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	idle   time.Duration
+	timer  *time.Timer
+	once   sync.Once
+}
+
+// Read only counts the time spent waiting for the server: a consumer that
+// is slow to come back for more, like a streamed upload, is not a stall.
+func (w *stallWatchdog) Read(p []byte) (int, error) {
+	if w.timer != nil {
+		w.timer.Reset(w.idle)
+	}
+	n, err := w.ReadCloser.Read(p)
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	if err != nil && err != io.EOF {
+		err = w.cause(err)
+	}
+	return n, err
+}
+
+func (w *stallWatchdog) Close() error {
+	var err error
+	if w.ReadCloser != nil {
+		err = w.ReadCloser.Close()
+	}
+	w.stop()
+	return err
+}
+
+func (w *stallWatchdog) stop() {
+	w.once.Do(func() {
+		if w.timer != nil {
+			w.timer.Stop()
+		}
+		w.cancel(nil)
+	})
+}
+
+// cause replaces the error of a request cut off by the watchdog or the
+// overall timeout with the reason it was cut off.
+func (w *stallWatchdog) cause(err error) error {
+	cause := context.Cause(w.ctx)
+	if errors.Is(err, cause) {
+		return err
+	}
+	if errors.Is(cause, errStalled) || errors.Is(cause, errDownloadTimeout) {
+		return fmt.Errorf("%w: %v", cause, err)
+	}
+	return err
+}
+
+func stalledMessage(err error) string {
+	var limit *limitError
+	if !errors.As(err, &limit) {
+		return "❌ Download stalled: no data arrived for too long. Please try again later."
+	}
+	return fmt.Sprintf("❌ Download stalled: no data arrived for %s. Please try again later.", limit.limit)
+}
+
+func downloadTimeoutMessage(err error) string {
+	var limit *limitError
+	if !errors.As(err, &limit) {
+		return "❌ The download took longer than the time limit."
+	}
+	return fmt.Sprintf("❌ The download took longer than the %s limit.", limit.limit)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const statusUsage = "Usage: /status [verbose]"
+
+func handleStatusCommand(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
+	var userID int64
+	if message.From != nil {
+		userID = message.From.ID
+	}
+	verbose := false
+	switch strings.ToLower(strings.TrimSpace(message.CommandArguments())) {
+	case "":
+	case "verbose", "-v":
+		verbose = true
+	default:
+		sendErrorMessage(bot, message.Chat.ID, statusUsage)
+		return
+	}
+	sendMessage(bot, message.Chat.ID, renderStatus(userID, canObserve(userID), verbose))
+}
+
+// renderStatus shows every job to admins and only the user's own jobs to
+// everyone else; the queue depth is always global. Verbose adds what each
+// job has used so far.
+func renderStatus(userID int64, all, verbose bool) string {
+	snapshots := jobs.list()
+
+	queued := 0
+	var lines []string
+	for _, job := range snapshots {
+		if job.State == jobQueued {
+			queued++
+		}
+		if !all && job.UserID != userID {
+			continue
+		}
+		lines = append(lines, formatJobLine(job, all))
+		if verbose && job.State != jobQueued {
+			lines = append(lines, "   ⚙️ "+job.Usage.String())
+		}
+	}
+
+	header := fmt.Sprintf("📋 Active jobs: %d, waiting in queue: %d", len(snapshots)-queued, queued)
+	if len(lines) == 0 {
+		if all {
+			return header + "\n\nNothing is running."
+		}
+		return header + "\n\nYou have no jobs running."
+	}
+	return header + "\n\n" + strings.Join(lines, "\n")
+}
+
+func formatJobLine(job jobSnapshot, showOwner bool) string {
+	name := job.FileName
+	if name == "" && showOwner {
+		// Only the host of other users' links, as in /queue.
+		name = urlHost(job.URL)
+	} else if name == "" {
+		name = redactURL(job.URL)
+	}
+
+	line := fmt.Sprintf("%s #%d %s — %s", jobStateIcons[job.State], job.ID, name, job.State)
+	if job.State != jobQueued && job.Progress > 0 {
+		line += fmt.Sprintf(" %.1f%%", job.Progress)
+	}
+	if job.Attempts > 0 && cfg.RetryBudget > 0 {
+		line += fmt.Sprintf(", %d/%d attempts", job.Attempts, cfg.RetryBudget)
+	}
+	line += fmt.Sprintf(" (%s)", time.Since(job.Created).Round(time.Second))
+	if showOwner {
+		line += fmt.Sprintf(" — user %d", job.UserID)
+	}
+	return line
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	bucketChats  = []byte("chats")
+	bucketQuotas = []byte("quotas")
+	bucketHashes = []byte("hashes")
+	bucketJobs   = []byte("jobs")
+	bucketUsers  = []byte("users")
+
+	bucketCheckpoints = []byte("checkpoints")
+	bucketDiagnostics = []byte("diagnostics")
+	bucketAliases     = []byte("aliases")
+	bucketCache       = []byte("cache")
+	bucketMeta        = []byte("meta")
+	bucketMembers     = []byte("members")
+	bucketIndex       = []byte("index")
+	bucketUploads     = []byte("uploads")
+	bucketScheduled   = []byte("scheduled")
+	bucketCron        = []byte("cron")
+	bucketFeeds       = []byte("feeds")
+)
+
+type Store struct {
+	// mu is held for writing only while compact swaps the database file.
+	mu   sync.RWMutex
+	db   *bolt.DB
+	path string
+}
+
+var store *Store
+
+func OpenStore(path string) (*Store, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("opening database %s: %w", path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{bucketChats, bucketQuotas, bucketHashes, bucketJobs, bucketUsers, bucketCheckpoints, bucketDiagnostics, bucketAliases, bucketCache, bucketMeta, bucketMembers, bucketIndex, bucketUploads, bucketScheduled, bucketCron, bucketFeeds} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("initializing database: %w", err)
+	}
+
+	return &Store{db: db, path: path}, nil
+}
+
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.db.Close()
+}
+
+func (s *Store) put(bucket []byte, key string, v interface{}) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Put([]byte(key), data)
+	})
+}
+
+// get decodes the value stored under key into v and reports whether it
+// existed.
+func (s *Store) get(bucket []byte, key string, v interface{}) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var data []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if raw := tx.Bucket(bucket).Get([]byte(key)); raw != nil {
+			data = append([]byte(nil), raw...)
+		}
+		return nil
+	})
+	if err != nil || data == nil {
+		return false, err
+	}
+	return true, json.Unmarshal(data, v)
+}
+
+func (s *Store) delete(bucket []byte, key string) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Delete([]byte(key))
+	})
+}
+
+func (s *Store) nextID(bucket []byte) (uint64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var id uint64
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		id, err = tx.Bucket(bucket).NextSequence()
+		return err
+	})
+	return id, err
+}
+
+func (s *Store) forEach(bucket []byte, fn func(key, value []byte) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).ForEach(fn)
+	})
+}
+
+func (s *Store) forEachPrefix(bucket []byte, prefix string, fn func(key, value []byte) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucket).Cursor()
+		p := []byte(prefix)
+		for k, v := c.Seek(p); k != nil && bytes.HasPrefix(k, p); k, v = c.Next() {
+			if err := fn(k, v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// forEachReverse walks bucket from the last key to the first until fn
+// returns false.
+func (s *Store) forEachReverse(bucket []byte, fn func(key, value []byte) (bool, error)) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucket).Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			more, err := fn(k, v)
+			if err != nil || !more {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// updateRecord runs fn on the decoded value under key inside a single
+// transaction and stores the result, so read-modify-write cycles from
+// concurrent jobs don't lose updates.
+func updateRecord[T any](s *Store, bucket []byte, key string, fn func(v *T, exists bool) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucket)
+
+		var v T
+		raw := b.Get([]byte(key))
+		if raw != nil {
+			if err := json.Unmarshal(raw, &v); err != nil {
+				return err
+			}
+		}
+		if err := fn(&v, raw != nil); err != nil {
+			return err
+		}
+
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(key), data)
+	})
+}
+
+// check walks every page of the database and returns the inconsistencies
+// bbolt finds.
+func (s *Store) check() ([]error, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var problems []error
+	err := s.db.View(func(tx *bolt.Tx) error {
+		for err := range tx.Check() {
+			problems = append(problems, err)
+		}
+		return nil
+	})
+	return problems, err
+}
+
+// compact rewrites the database without its free pages once they make up
+// at least minFree of the file, and returns its size before and after.
+// Everything else waits on the store meanwhile.
+func (s *Store) compact(minFree float64) (before, after int64, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return 0, 0, err
+	}
+	before = info.Size()
+	if before == 0 || float64(s.db.Stats().FreeAlloc) < minFree*float64(before) {
+		return before, before, nil
+	}
+
+	tmpPath := s.path + ".compact"
+	os.Remove(tmpPath)
+	dst, err := bolt.Open(tmpPath, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return before, before, fmt.Errorf("opening %s: %w", tmpPath, err)
+	}
+	if err := bolt.Compact(dst, s.db, 64<<20); err != nil {
+		dst.Close()
+		os.Remove(tmpPath)
+		return before, before, fmt.Errorf("compacting: %w", err)
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(tmpPath)
+		return before, before, err
+	}
+
+	if err := s.db.Close(); err != nil {
+		os.Remove(tmpPath)
+		return before, before, err
+	}
+	renameErr := os.Rename(tmpPath, s.path)
+	if renameErr != nil {
+		os.Remove(tmpPath)
+	}
+	// Reopened either way: the compacted copy, or the original if it
+	// couldn't take its place.
+	if s.db, err = bolt.Open(s.path, 0o600, &bolt.Options{Timeout: 5 * time.Second}); err != nil {
+		return before, before, fmt.Errorf("reopening database %s: %w", s.path, err)
+	}
+	if renameErr != nil {
+		return before, before, renameErr
+	}
+	if info, err := os.Stat(s.path); err == nil {
+		after = info.Size()
+	}
+	return before, after, nil
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"sync"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"golang.org/x/crypto/blake2b"
+)
+
+// ringBuffer is a fixed-size pipe: writes block while it is full and
+// reads block while it is empty, so a fast download can run at most
+// len(buf) bytes ahead of the upload.
+type ringBuffer struct {
+	mu         sync.Mutex
+	cond       *sync.Cond
+	buf        []byte
+	start, n   int
+	err        error // io.EOF once the writer is done
+	readerGone bool
+}
+
+func newRingBuffer(size int) *ringBuffer {
+	r := &ringBuffer{buf: make([]byte, size)}
+	r.cond = sync.NewCond(&r.mu)
+	return r
+}
+
+func (r *ringBuffer) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	written := 0
+	for len(p) > 0 {
+		for r.n == len(r.buf) && r.err == nil && !r.readerGone {
+			r.cond.Wait()
+		}
+		if r.err != nil || r.readerGone {
+			return written, io.ErrClosedPipe
+		}
+		end := (r.start + r.n) % len(r.buf)
+		chunk := min(len(p), len(r.buf)-r.n, len(r.buf)-end)
+		copy(r.buf[end:], p[:chunk])
+		r.n += chunk
+		p = p[chunk:]
+		written += chunk
+		r.cond.Broadcast()
+	}
+	return written, nil
+}
+
+func (r *ringBuffer) Read(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for r.n == 0 && r.err == nil {
+		r.cond.Wait()
+	}
+	if r.n == 0 {
+		return 0, r.err
+	}
+	chunk := min(len(p), r.n, len(r.buf)-r.start)
+	copy(p, r.buf[r.start:r.start+chunk])
+	r.start = (r.start + chunk) % len(r.buf)
+	r.n -= chunk
+	r.cond.Broadcast()
+	return chunk, nil
+}
+
+// closeWrite ends the stream; readers get err (io.EOF if nil) once the
+// buffered data is drained.
+func (r *ringBuffer) closeWrite(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err == nil {
+		err = io.EOF
+	}
+	if r.err == nil {
+		r.err = err
+	}
+	r.cond.Broadcast()
+}
+
+// closeRead unblocks a writer after the reader gave up.
+func (r *ringBuffer) closeRead() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.readerGone = true
+	r.cond.Broadcast()
+}
+
+func streamBufferSize() int {
+	if cfg.Profile == profileLowMemory {
+		return 64 << 10
+	}
+	return 256 << 10
+}
+
+// canStream reports whether cfg.StreamUploads applies to the job. Only
+// files whose size the server announced are streamed: the others could
+// turn out too large halfway through the upload, or be cut off with no way
+// to tell. A partial download left by a restart is finished the usual way,
+// and so is a file that has to be looked at as a whole or approved before
+// it is sent. Whether the file's hash is on the hold list is only known
+// once it is sent, so nothing is streamed while any hash is.
+func (j *Job) canStream(size int64) bool {
+	if _, held := holdReason(j, hashEntry{}, false); held || hashHoldsListed() {
+		return false
+	}
+	return cfg.StreamUploads && size > 0 && size <= j.uploadLimit() && j.partialPath == "" && j.options.Checksum == "" && j.options.Range == nil &&
+		!j.options.processes() && cfg.ClamdAddress == "" && len(cfg.Hooks) == 0 && j.options.UnchangedSHA256 == ""
+}
+
+// streamJob sends the download straight on to Telegram without a temp
+// file, for hosts where disk writes are slow or wear out the storage. The
+// price: no resuming, no retries after truncation, no rename prompt, and
+// the denylist can only be applied after the upload, by deleting the
+// message again.
+func streamJob(bot *tgbotapi.BotAPI, job *Job, headSize int64) error {
+	releaseUpload, err := acquireUploadSlot(bot, job, true)
+	if err != nil {
+		return err
+	}
+	defer releaseUpload()
+	resp, err := requestFile(job, 0)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	job.responseHeader = resp.Header
+	job.noteFinalURL(resp)
+	if err := checkPresignedResponse(resp); err != nil {
+		return err
+	}
+	if resp.StatusCode >= 400 {
+		return statusError(resp)
+	}
+
+	expected := resp.ContentLength
+	if expected < 0 && !resp.Uncompressed {
+		expected = headSize
+	}
+	// The GET may announce a different size than the HEAD did.
+	if expected > job.uploadLimit() {
+		return tooLargeError(expected, job.limitMB)
+	}
+
+	ring := newRingBuffer(streamBufferSize())
+	hasher := sha256.New()
+	sinks := []io.Writer{ring, hasher}
+	var signHash hash.Hash
+	if signer != nil {
+		signHash, _ = blake2b.New512(nil)
+		sinks = append(sinks, signHash)
+	}
+	progressReader := &ProgressReader{
+		Reader:     &sizeGuard{Reader: job.throttle(resp.Body, "download"), limit: job.uploadLimit()},
+		total:      expected,
+		onProgress: progressUpdater(bot, job, "stream", "📡 Streaming to Telegram..."+job.redirectNote()),
+		meter:      job.meter("stream"),
+	}
+	copied := make(chan error, 1)
+	go func() {
+		_, err := io.CopyBuffer(io.MultiWriter(sinks...), progressReader, make([]byte, copyBufferSize()))
+		if errors.Is(err, io.ErrUnexpectedEOF) || (err == nil && expected > 0 && progressReader.downloaded != expected) {
+			err = &truncatedError{got: progressReader.downloaded, want: expected}
+		}
+		ring.closeWrite(err)
+		copied <- err
+	}()
+
+	doc := tgbotapi.NewDocument(job.deliveryChatID(), tgbotapi.FileReader{Name: job.FileName, Reader: ring})
+	doc.ReplyToMessageID = job.deliveryReplyID()
+	doc.DisableNotification = job.silent
+	doc.Caption = buildHashtags(classifyFile(job.FileName, resp.Header.Get("Content-Type")), job.URL)
+
+	if err := job.spendAttempt("upload"); err != nil {
+		return err
+	}
+	streamed := job.timeStage("stream")
+	sent, sendErr := bot.Send(doc)
+	ring.closeRead()
+	resp.Body.Close()
+	copyErr := <-copied
+	job.Size = progressReader.downloaded
+
+	var truncated *truncatedError
+	switch {
+	case errors.Is(copyErr, errTooLarge):
+		return tooLargeError(job.Size, job.limitMB)
+	case errors.As(copyErr, &truncated):
+		return failJob("❌ The download was cut off before it finished. Please try again later.", copyErr)
+	case copyErr != nil && !errors.Is(copyErr, io.ErrClosedPipe):
+		return failJob("❌ Failed to download the file", copyErr)
+	case sendErr != nil:
+		return failJob(describeSendError(sendErr, "❌ Failed to send the file"), sendErr)
+	}
+	streamed()
+
+	job.SHA256 = hex.EncodeToString(hasher.Sum(nil))
+	hashInfo, listed := lookupHash(job.SHA256)
+	if listed && hashInfo.Verdict == hashDeny {
+		job.logger().Warn("Blocked denylisted file after streaming it", "sha256", job.SHA256)
+		bot.Request(tgbotapi.NewDeleteMessage(job.deliveryChatID(), sent.MessageID))
+		return &jobError{userMessage: "🚫 This file is blocked by the bot operator.", result: resultBlocked}
+	}
+	indexDelivery(job, sent)
+	if listed && hashInfo.FileID == "" && sent.Document != nil {
+		rememberHashFileID(job.SHA256, sent.Document.FileID)
+	}
+	if sent.Document != nil {
+		rememberUpload(bot, job, sent.Document.FileID, job.responseHeader)
+	}
+	if signHash != nil {
+		sendSignature(bot, job, sent.MessageID, signer.signature(signHash.Sum(nil), job.FileName))
+	}
+	return nil
+}
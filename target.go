@@ -0,0 +1,50 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// isTargetChat tells to:@channel and to:-100123 apart from the rclone
+// remotes to: also takes.
+func isTargetChat(value string) bool {
+	if strings.HasPrefix(value, "@") {
+		return len(value) > 1
+	}
+	id, err := strconv.ParseInt(value, 10, 64)
+	return err == nil && id < 0
+}
+
+// resolveTargetChat looks up the chat given with to: and checks that the
+// requester administers it and that the bot may post there.
+func resolveTargetChat(bot *tgbotapi.BotAPI, job *Job) error {
+	target := job.options.TargetChat
+	lookup := tgbotapi.ChatInfoConfig{}
+	if id, err := strconv.ParseInt(target, 10, 64); err == nil {
+		lookup.ChatID = id
+	} else {
+		lookup.SuperGroupUsername = target
+	}
+	chat, err := bot.GetChat(lookup)
+	if err != nil {
+		return &jobError{userMessage: "❌ I can't find " + target + ". Add me to it first.", result: resultRejected, err: err}
+	}
+	if job.UserID == 0 || (!isAdmin(job.UserID) && !isChatAdmin(bot, chat.ID, job.UserID)) {
+		return &jobError{userMessage: "🚫 Only admins of " + target + " can have files posted there.", result: resultRejected}
+	}
+
+	me, err := bot.GetChatMember(tgbotapi.GetChatMemberConfig{
+		ChatConfigWithUser: tgbotapi.ChatConfigWithUser{ChatID: chat.ID, UserID: bot.Self.ID},
+	})
+	canPost := err == nil && !me.HasLeft() && !me.WasKicked()
+	if chat.IsChannel() {
+		canPost = err == nil && (me.IsCreator() || (me.IsAdministrator() && me.CanPostMessages))
+	}
+	if !canPost {
+		return &jobError{userMessage: "❌ I'm not allowed to post in " + target + ".", result: resultRejected, err: err}
+	}
+	job.options.TargetChatID = chat.ID
+	return nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const tempSweepInterval = time.Hour
+
+// runTempSweeper removes the temp files earlier runs left behind when
+// they crashed, and then, every hour, the ones no job has touched for
+// cfg.TempMaxAgeHours.
+func runTempSweeper() {
+	if removed, freed := purgeTempFiles(stats.startedAt); removed > 0 {
+		slog.Info("Removed stale temp files", "files", removed, "bytes", freed)
+	}
+	if cfg.TempMaxAgeHours <= 0 {
+		return
+	}
+	for {
+		time.Sleep(tempSweepInterval)
+		maxAge := time.Duration(cfg.TempMaxAgeHours) * time.Hour
+		if removed, freed := purgeTempFiles(time.Now().Add(-maxAge)); removed > 0 {
+			slog.Warn("Removed abandoned temp files", "files", removed, "bytes", freed, "max_age", maxAge)
+		}
+	}
+}
+
+// purgeTempFiles removes the bot's temp files and directories last
+// modified before olderThan, except partial downloads kept for resuming.
+func purgeTempFiles(olderThan time.Time) (int, int64) {
+	dirs := []string{cfg.TempDir}
+	if cfg.MemoryBufferMB > 0 && cfg.MemoryDir != cfg.TempDir {
+		dirs = append(dirs, cfg.MemoryDir)
+	}
+	keep := checkpointPaths()
+
+	var removed int
+	var freed int64
+	for _, dir := range dirs {
+		matches, err := filepath.Glob(filepath.Join(dir, "telegram-*"))
+		if err != nil {
+			slog.Error("Error listing temp files", "dir", dir, "error", err)
+			continue
+		}
+		for _, path := range matches {
+			info, err := os.Stat(path)
+			if err != nil || keep[path] || !info.ModTime().Before(olderThan) {
+				continue
+			}
+			size := info.Size()
+			if info.IsDir() {
+				size = dirSize(path)
+			}
+			if err := os.RemoveAll(path); err != nil {
+				slog.Error("Error removing temp file", "path", path, "error", err)
+				continue
+			}
+			removed++
+			freed += size
+		}
+	}
+	return removed, freed
+}
+
+func checkpointPaths() map[string]bool {
+	paths := make(map[string]bool)
+	err := store.forEach(bucketCheckpoints, func(_, value []byte) error {
+		var cp checkpoint
+		if err := json.Unmarshal(value, &cp); err != nil {
+			return err
+		}
+		if cp.TempPath != "" {
+			paths[cp.TempPath] = true
+		}
+		return nil
+	})
+	if err != nil {
+		slog.Error("Error loading checkpoints", "error", err)
+	}
+	return paths
+}
+
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.WalkDir(dir, func(_ string, d os.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			if info, err := d.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	neturl "net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+var (
+	placeholderPattern = regexp.MustCompile(`\{([^{}]*)\}`)
+	// {date}, {date:2006-01-02}, {date-1d}, {date+2w:20060102}...
+	datePlaceholderPattern = regexp.MustCompile(`^date(?:([+-]\d+)([hdwmy]))?(?::(.+))?$`)
+)
+
+const templateHelp = "Templates can use {date}, {date:2006-01-02}, {date-1d} (units h, d, w, m, y) and {1}, {2}… for the words after the URL."
+
+// expandURL fills in the placeholders of a URL template: dates relative to
+// now and positional parameters, which may themselves be date placeholders
+// (as in "/url report {date-1d}").
+func expandURL(tmpl string, params []string, now time.Time) (string, error) {
+	var firstErr error
+	expanded := placeholderPattern.ReplaceAllStringFunc(tmpl, func(match string) string {
+		name := match[1 : len(match)-1]
+
+		if n, err := strconv.Atoi(name); err == nil {
+			if n < 1 || n > len(params) {
+				setErr(&firstErr, fmt.Errorf("the link needs a value for {%d}", n))
+				return match
+			}
+			value, err := expandDates(params[n-1], now)
+			if err != nil {
+				setErr(&firstErr, err)
+				return match
+			}
+			return neturl.PathEscape(value)
+		}
+
+		value, err := expandDate(name, now)
+		if err != nil {
+			setErr(&firstErr, err)
+			return match
+		}
+		return value
+	})
+	return expanded, firstErr
+}
+
+func expandDates(s string, now time.Time) (string, error) {
+	var firstErr error
+	expanded := placeholderPattern.ReplaceAllStringFunc(s, func(match string) string {
+		value, err := expandDate(match[1:len(match)-1], now)
+		if err != nil {
+			setErr(&firstErr, err)
+			return match
+		}
+		return value
+	})
+	return expanded, firstErr
+}
+
+func expandDate(name string, now time.Time) (string, error) {
+	m := datePlaceholderPattern.FindStringSubmatch(name)
+	if m == nil {
+		return "", fmt.Errorf("unknown placeholder {%s}", name)
+	}
+
+	t := now
+	if m[1] != "" {
+		n, err := strconv.Atoi(m[1])
+		if err != nil {
+			return "", fmt.Errorf("invalid offset in {%s}", name)
+		}
+		switch m[2] {
+		case "h":
+			t = t.Add(time.Duration(n) * time.Hour)
+		case "d":
+			t = t.AddDate(0, 0, n)
+		case "w":
+			t = t.AddDate(0, 0, 7*n)
+		case "m":
+			t = t.AddDate(0, n, 0)
+		case "y":
+			t = t.AddDate(n, 0, 0)
+		}
+	}
+
+	layout := time.DateOnly
+	if m[3] != "" {
+		layout = m[3]
+	}
+	return t.Format(layout), nil
+}
+
+func setErr(dst *error, err error) {
+	if *dst == nil {
+		*dst = err
+	}
+}
+
+// resolveURLArgs turns the arguments of /url into the URL to fetch: the
+// first one is a URL or alias, possibly a template, and the rest are its
+// parameters. If that fails, the returned string is the message for the
+// user instead.
+func resolveURLArgs(message *tgbotapi.Message, args []string) (string, bool) {
+	tmpl, ok := resolveURLTemplate(message, args[0])
+	if !ok {
+		return tmpl, false
+	}
+	return fillURLTemplate(tmpl, args[1:], time.Now())
+}
+
+// resolveURLTemplate resolves an alias to the link it stands for, leaving
+// the template in it to be filled in. Jobs that run later keep this, so
+// {date} is the day they run rather than the day they were set up.
+func resolveURLTemplate(message *tgbotapi.Message, url string) (string, bool) {
+	if !isAliasName(url) {
+		return url, true
+	}
+	target, ok := resolveAlias(message, url)
+	if !ok {
+		return fmt.Sprintf("❌ No alias named %s. See /alias list.", url), false
+	}
+	return target, true
+}
+
+// fillURLTemplate fills in a template's placeholders for now, or returns the
+// message for the user if it can't.
+func fillURLTemplate(tmpl string, params []string, now time.Time) (string, bool) {
+	expanded, err := expandURL(tmpl, params, now)
+	if err != nil {
+		return fmt.Sprintf("❌ Couldn't fill in the link: %v.\n\n%s", err, templateHelp), false
+	}
+	return strings.TrimSpace(expanded), true
+}
+
+// runURL is the URL a stored job fetches when it runs: its template filled
+// in for now, or the URL it was set up with if it has none.
+func runURL(url, tmpl string, params []string) string {
+	if tmpl == "" {
+		return url
+	}
+	expanded, ok := fillURLTemplate(tmpl, params, time.Now())
+	if !ok {
+		// It filled in when the job was set up, so this only happens to a
+		// record changed by hand.
+		slog.Warn("Couldn't fill in a stored link template", "url", redactURL(url))
+		return url
+	}
+	return expanded
+}
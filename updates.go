@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	// updateOffsetKey is where the offset of the next update is kept, so a
+	// restart neither misses updates nor handles the last batch twice.
+	updateOffsetKey = "update_offset"
+	// conflictDelay is how long to wait after Telegram said another
+	// instance is polling, which won't change within seconds.
+	conflictDelay = 30 * time.Second
+	// pollFailingAfter is how long polling may fail before the bot is
+	// reported as not ready.
+	pollFailingAfter = 2 * time.Minute
+)
+
+// polling tracks whether updates are coming in, for /readyz.
+var polling struct {
+	mu           sync.Mutex
+	failingSince time.Time
+	err          error
+}
+
+func notePoll(err error) {
+	polling.mu.Lock()
+	defer polling.mu.Unlock()
+	polling.err = err
+	switch {
+	case err == nil:
+		polling.failingSince = time.Time{}
+	case polling.failingSince.IsZero():
+		polling.failingSince = time.Now()
+	}
+}
+
+func checkPolling() error {
+	polling.mu.Lock()
+	defer polling.mu.Unlock()
+	if polling.failingSince.IsZero() {
+		return nil
+	}
+	if failing := time.Since(polling.failingSince); failing > pollFailingAfter {
+		return fmt.Errorf("failing for %s: %v", failing.Round(time.Second), polling.err)
+	}
+	return nil
+}
+
+// pollUpdates long-polls Telegram for updates until ctx is done, then
+// closes the channel. Unlike the library's GetUpdatesChan it backs off on
+// errors, says why polling fails, and carries the offset over restarts.
+func pollUpdates(ctx context.Context, bot *tgbotapi.BotAPI) <-chan tgbotapi.Update {
+	updates := make(chan tgbotapi.Update)
+	go func() {
+		defer close(updates)
+		offset := 0
+		if _, err := store.get(bucketMeta, updateOffsetKey, &offset); err != nil {
+			slog.Error("Error loading update offset", "error", err)
+		}
+		saved := offset
+		failures := 0
+		for ctx.Err() == nil {
+			// Asking from an offset confirms everything before it, so the
+			// offset is only saved once the updates before it were taken.
+			if offset != saved {
+				if err := store.put(bucketMeta, updateOffsetKey, offset); err != nil {
+					slog.Error("Error saving update offset", "error", err)
+				}
+				saved = offset
+			}
+
+			config := tgbotapi.NewUpdate(offset)
+			config.Timeout = 60
+			batch, err := bot.GetUpdates(config)
+			notePoll(err)
+			if err != nil {
+				failures++
+				delay := pollErrorDelay(err, failures)
+				select {
+				case <-time.After(delay):
+				case <-ctx.Done():
+				}
+				continue
+			}
+			if failures > 0 {
+				slog.Info("Receiving updates again", "failed_polls", failures)
+				failures = 0
+			}
+			for _, update := range batch {
+				if update.UpdateID < offset {
+					continue
+				}
+				select {
+				case updates <- update:
+				case <-ctx.Done():
+					return
+				}
+				offset = update.UpdateID + 1
+			}
+		}
+	}()
+	return updates
+}
+
+// pollErrorDelay logs why polling failed and returns how long to wait
+// before trying again.
+func pollErrorDelay(err error, failures int) time.Duration {
+	delay := retryDelay(failures)
+	var apiErr *tgbotapi.Error
+	if errors.As(err, &apiErr) {
+		switch {
+		case apiErr.Code == http.StatusConflict && strings.Contains(strings.ToLower(apiErr.Message), "webhook"):
+			slog.Error("Can't poll for updates while a webhook is set for the bot", "error", err)
+			return conflictDelay
+		case apiErr.Code == http.StatusConflict:
+			// Each instance gets only some of the updates, so neither works.
+			slog.Error("Another instance of the bot is polling for updates with the same token", "error", err)
+			return conflictDelay
+		case apiErr.Code == http.StatusUnauthorized:
+			slog.Error("Telegram rejected the bot token", "error", err)
+			return conflictDelay
+		case apiErr.RetryAfter > 0:
+			delay = time.Duration(apiErr.RetryAfter) * time.Second
+		}
+	}
+	slog.Warn("Error polling for updates, retrying", "attempt", failures, "delay", delay.Round(time.Millisecond), "error", err)
+	return delay
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	neturl "net/url"
+	"slices"
+	"strings"
+)
+
+// matchesDomain reports whether host is domain or one of its subdomains.
+func matchesDomain(host, domain string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	domain = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(domain), "."))
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+func matchesAnyDomain(host string, domains []string) bool {
+	for _, domain := range domains {
+		if matchesDomain(host, domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateURL checks a link against the configured schemes, credentials
+// policy and domain lists before anything is fetched. If it isn't
+// acceptable, the returned string is the message for the user.
+func validateURL(raw string) (string, bool) {
+	u, err := neturl.Parse(raw)
+	if err != nil || u.Host == "" || u.Hostname() == "" {
+		return "❌ That doesn't look like a valid URL.", false
+	}
+
+	if !slices.Contains(cfg.AllowedSchemes, strings.ToLower(u.Scheme)) {
+		return fmt.Sprintf("❌ Only %s links are supported.", strings.Join(cfg.AllowedSchemes, ", ")), false
+	}
+	if u.User != nil && !cfg.AllowURLCreds {
+		return "❌ Links with a username or password in them aren't allowed.", false
+	}
+
+	host := u.Hostname()
+	if matchesAnyDomain(host, cfg.BlockedDomains) ||
+		(len(cfg.AllowedDomains) > 0 && !matchesAnyDomain(host, cfg.AllowedDomains)) {
+		return fmt.Sprintf("🚫 Downloads from %s are not allowed.", host), false
+	}
+	return "", true
+}
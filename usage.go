@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"sync/atomic"
+	"time"
+)
+
+// jobUsage is what a job cost the machine, for tuning the worker count and
+// limits: the CPU time of the programs it ran, like ffmpeg, the most temp
+// disk it held at once, and the bytes it moved over the network.
+type jobUsage struct {
+	CPUSeconds    float64 `json:"cpu_seconds,omitempty"`
+	PeakDiskBytes int64   `json:"peak_disk_bytes,omitempty"`
+	BytesIn       int64   `json:"bytes_in,omitempty"`
+	BytesOut      int64   `json:"bytes_out,omitempty"`
+}
+
+func (u jobUsage) String() string {
+	return fmt.Sprintf("CPU %.1fs, disk %s peak, %s in, %s out",
+		u.CPUSeconds, formatMB(u.PeakDiskBytes), formatMB(u.BytesIn), formatMB(u.BytesOut))
+}
+
+func formatMB(n int64) string {
+	return fmt.Sprintf("%.1f MB", float64(n)/1024/1024)
+}
+
+// addCPU adds the CPU time of a program the job ran, once it exited.
+func (j *Job) addCPU(cmd *exec.Cmd) {
+	if cmd.ProcessState == nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.cpuTime += cmd.ProcessState.UserTime() + cmd.ProcessState.SystemTime()
+}
+
+// noteDisk adds a temp file to the job's disk usage. A job's temp files
+// are all removed when it finishes, so their sum is its peak.
+func (j *Job) noteDisk(file *os.File) {
+	info, err := file.Stat()
+	if err != nil {
+		return
+	}
+	j.noteDiskBytes(info.Size())
+}
+
+func (j *Job) noteDiskBytes(n int64) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.diskBytes += n
+	j.peakDisk = max(j.peakDisk, j.diskBytes)
+}
+
+// addSent counts bytes uploaded without a speed meter on them.
+func (j *Job) addSent(n int64) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.unmeteredOut += n
+}
+
+func (j *Job) usage() jobUsage {
+	j.mu.Lock()
+	u := jobUsage{
+		CPUSeconds:    j.cpuTime.Seconds(),
+		PeakDiskBytes: j.peakDisk,
+		BytesOut:      j.unmeteredOut,
+	}
+	meters := make(map[string]*speedMeter, len(j.meters))
+	for stage, m := range j.meters {
+		meters[stage] = m
+	}
+	j.mu.Unlock()
+
+	// A stream reads and sends the same bytes.
+	for stage, m := range meters {
+		m.mu.Lock()
+		n := m.bytes
+		m.mu.Unlock()
+		switch stage {
+		case "download":
+			u.BytesIn += n
+		case "upload":
+			u.BytesOut += n
+		case "stream":
+			u.BytesIn += n
+			u.BytesOut += n
+		}
+	}
+	return u
+}
+
+// usageTotals adds up the usage of every job since the bot started.
+type usageTotals struct {
+	cpu      atomic.Int64
+	bytesIn  atomic.Int64
+	bytesOut atomic.Int64
+	// peakDisk is the largest peak of a single job.
+	peakDisk atomic.Int64
+}
+
+func (t *usageTotals) add(u jobUsage) {
+	t.cpu.Add(int64(u.CPUSeconds * float64(time.Second)))
+	t.bytesIn.Add(u.BytesIn)
+	t.bytesOut.Add(u.BytesOut)
+	for {
+		peak := t.peakDisk.Load()
+		if u.PeakDiskBytes <= peak || t.peakDisk.CompareAndSwap(peak, u.PeakDiskBytes) {
+			return
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+const probeSize = 4096
+
+// probeContent fetches the first few KB of rawURL and checks that what comes
+// back plausibly is the file it claims to be. This catches error pages,
+// login walls and hotlink blocks before the job takes a worker slot.
+func probeContent(ctx context.Context, rawURL string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return failJob("❌ That doesn't look like a valid URL.", err)
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=0-%d", probeSize-1))
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return failJob("❌ Failed to get file info", err)
+	}
+	defer resp.Body.Close()
+
+	if err := checkPresignedResponse(resp); err != nil {
+		return err
+	}
+	if resp.StatusCode >= 400 {
+		return statusError(resp)
+	}
+
+	head, err := io.ReadAll(io.LimitReader(resp.Body, probeSize))
+	if err != nil || len(head) == 0 {
+		// Nothing conclusive to check; let the real download deal with it.
+		return nil
+	}
+
+	claimed := resp.Header.Get("Content-Type")
+	sniffed := http.DetectContentType(head)
+	if !strings.HasPrefix(sniffed, "text/") {
+		return nil
+	}
+
+	// Text where a binary file was expected (by extension or by the
+	// server's own Content-Type) is almost always an error page.
+	name := filepath.Base(resp.Request.URL.Path)
+	ext := strings.ToLower(filepath.Ext(name))
+	if ext == ".svg" || strings.Contains(claimed, "svg") {
+		return nil
+	}
+	switch classifyFile(name, claimed) {
+	case categoryVideo, categoryAudio, categoryImage, categoryArchive:
+	default:
+		if ext != ".pdf" {
+			return nil
+		}
+	}
+
+	hint := "❌ The link returned a text page instead of the file."
+	err = fmt.Errorf("probe: expected %q (%s) but content looks like %s", name, claimed, sniffed)
+	if strings.HasPrefix(sniffed, "text/html") {
+		hint = "❌ The link returned a web page instead of the file. It may require a login or block direct downloads."
+		if known, lang, ok := errorPageHint(resp, head); ok {
+			hint = known
+			err = fmt.Errorf("%w (known error page, lang %q)", err, lang)
+		}
+	}
+	return &jobError{userMessage: hint, result: resultRejected, err: err}
+}
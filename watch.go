@@ -0,0 +1,526 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	neturl "net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	watchUsage = "Usage: /watch <feed-url> [options]\n/watch list\n/watch remove <id>\n\nNew files in the RSS or Atom feed, like podcast episodes or release downloads, are posted here."
+
+	maxFeedsPerChat     = 10
+	maxFeedSize         = 5 * 1024 * 1024
+	maxFeedItemsPerPoll = 5
+	// maxFeedSeen bounds how many item IDs are remembered per feed.
+	maxFeedSeen = 1000
+	// maxFeedFailures is how many polls in a row may fail before the chat
+	// hears about it.
+	maxFeedFailures   = 10
+	feedFetchTimeout  = 30 * time.Second
+	feedWatchInterval = time.Minute
+)
+
+// errFeedDeleted stops an update of a feed unsubscribed in the meantime
+// from writing it back.
+var errFeedDeleted = errors.New("feed subscription was deleted")
+
+// feedSubscription is a feed a chat watches. Seen holds the IDs of the
+// items last found in it, so only ones added since are downloaded.
+type feedSubscription struct {
+	ID        int64      `json:"id"`
+	ChatID    int64      `json:"chat_id"`
+	CreatedBy int64      `json:"created_by"`
+	MessageID int        `json:"message_id"`
+	URL       string     `json:"url"`
+	Title     string     `json:"title,omitempty"`
+	Options   jobOptions `json:"options"`
+	Seen      []string   `json:"seen,omitempty"`
+	LastCheck time.Time  `json:"last_check"`
+	Failures  int        `json:"failures,omitempty"`
+}
+
+// feedDocument holds what is needed of RSS 2.0, RSS 1.0 and Atom feeds at
+// once; only the elements of the feed's own kind are filled in.
+type feedDocument struct {
+	Channel struct {
+		Title string    `xml:"title"`
+		Items []rssItem `xml:"item"`
+	} `xml:"channel"`
+	Title   string      `xml:"title"`
+	Items   []rssItem   `xml:"item"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type rssItem struct {
+	Title      string `xml:"title"`
+	Link       string `xml:"link"`
+	GUID       string `xml:"guid"`
+	Enclosures []struct {
+		URL string `xml:"url,attr"`
+	} `xml:"enclosure"`
+}
+
+type atomEntry struct {
+	ID    string `xml:"id"`
+	Title string `xml:"title"`
+	Links []struct {
+		Href string `xml:"href,attr"`
+		Rel  string `xml:"rel,attr"`
+	} `xml:"link"`
+}
+
+// feedItem is an item of a feed, oldest first. FileURL is empty for items
+// that only link to a web page.
+type feedItem struct {
+	ID      string
+	Title   string
+	FileURL string
+}
+
+type parsedFeed struct {
+	Title string
+	Items []feedItem
+}
+
+var webPageExtensions = map[string]bool{
+	"": true, ".html": true, ".htm": true, ".shtml": true, ".php": true, ".asp": true, ".aspx": true, ".jsp": true,
+}
+
+// fileLink resolves a link of the feed and keeps it if it points at a
+// file rather than a web page, judging by its extension.
+func fileLink(base *neturl.URL, link string, enclosure bool) string {
+	link = strings.TrimSpace(link)
+	if link == "" {
+		return ""
+	}
+	u, err := base.Parse(link)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return ""
+	}
+	if !enclosure && webPageExtensions[strings.ToLower(path.Ext(u.Path))] {
+		return ""
+	}
+	return u.String()
+}
+
+func parseFeed(feedURL string, data []byte) (parsedFeed, error) {
+	base, err := neturl.Parse(feedURL)
+	if err != nil {
+		return parsedFeed{}, err
+	}
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	// Only the links and IDs are used, which are ASCII in any charset.
+	decoder.CharsetReader = func(_ string, input io.Reader) (io.Reader, error) { return input, nil }
+	decoder.Strict = false
+	var doc feedDocument
+	if err := decoder.Decode(&doc); err != nil {
+		return parsedFeed{}, fmt.Errorf("not an RSS or Atom feed: %w", err)
+	}
+
+	feed := parsedFeed{Title: strings.TrimSpace(doc.Channel.Title)}
+	if feed.Title == "" {
+		feed.Title = strings.TrimSpace(doc.Title)
+	}
+	for _, item := range append(doc.Channel.Items, doc.Items...) {
+		fi := feedItem{Title: strings.TrimSpace(item.Title)}
+		for _, enclosure := range item.Enclosures {
+			if fi.FileURL = fileLink(base, enclosure.URL, true); fi.FileURL != "" {
+				break
+			}
+		}
+		if fi.FileURL == "" {
+			fi.FileURL = fileLink(base, item.Link, false)
+		}
+		fi.ID = firstNonEmpty(strings.TrimSpace(item.GUID), fi.FileURL, strings.TrimSpace(item.Link))
+		feed.Items = append(feed.Items, fi)
+	}
+	for _, entry := range doc.Entries {
+		fi := feedItem{ID: strings.TrimSpace(entry.ID), Title: strings.TrimSpace(entry.Title)}
+		for _, link := range entry.Links {
+			if link.Rel == "enclosure" {
+				fi.FileURL = fileLink(base, link.Href, true)
+				break
+			}
+		}
+		if fi.FileURL == "" {
+			for _, link := range entry.Links {
+				if link.Rel == "" || link.Rel == "alternate" {
+					if fi.FileURL = fileLink(base, link.Href, false); fi.FileURL != "" {
+						break
+					}
+				}
+			}
+		}
+		if fi.ID == "" {
+			fi.ID = fi.FileURL
+		}
+		feed.Items = append(feed.Items, fi)
+	}
+	if len(doc.Channel.Items)+len(doc.Items)+len(doc.Entries) == 0 && feed.Title == "" {
+		return parsedFeed{}, fmt.Errorf("not an RSS or Atom feed")
+	}
+
+	// Feeds list the newest items first.
+	for i, j := 0, len(feed.Items)-1; i < j; i, j = i+1, j-1 {
+		feed.Items[i], feed.Items[j] = feed.Items[j], feed.Items[i]
+	}
+	return feed, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func fetchFeed(feedURL string) (parsedFeed, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), feedFetchTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, feedURL, nil)
+	if err != nil {
+		return parsedFeed{}, err
+	}
+	req.Header.Set("Accept", "application/rss+xml, application/atom+xml, application/xml;q=0.9, */*;q=0.8")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return parsedFeed{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return parsedFeed{}, fmt.Errorf("the server responded with %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxFeedSize+1))
+	if err != nil {
+		return parsedFeed{}, err
+	}
+	if len(data) > maxFeedSize {
+		return parsedFeed{}, fmt.Errorf("the feed is larger than %d MB", maxFeedSize/1024/1024)
+	}
+	return parseFeed(feedURL, data)
+}
+
+// seenIDs are the item IDs to remember after a poll: the ones in the feed
+// now, newest kept if there are too many.
+func seenIDs(items []feedItem) []string {
+	ids := make([]string, 0, len(items))
+	for _, item := range items {
+		if item.ID != "" {
+			ids = append(ids, item.ID)
+		}
+	}
+	if len(ids) > maxFeedSeen {
+		ids = ids[len(ids)-maxFeedSeen:]
+	}
+	return ids
+}
+
+// canManageChat reports whether the user may change what the bot posts
+// in the chat on its own, like watched feeds. Files are posted to
+// everyone, so in groups that takes an admin.
+func canManageChat(bot *tgbotapi.BotAPI, chat *tgbotapi.Chat, userID int64) bool {
+	if chat.IsPrivate() || isAdmin(userID) {
+		return true
+	}
+	return isChatAdmin(bot, chat.ID, userID)
+}
+
+func handleWatchCommand(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
+	if !isAuthorized(message) {
+		sendErrorMessage(bot, message.Chat.ID, notAuthorizedMessage)
+		return
+	}
+	if !hidePassphrase(bot, message) {
+		return
+	}
+	if message.From == nil {
+		sendErrorMessage(bot, message.Chat.ID, "❌ Feed subscriptions need a user to belong to.")
+		return
+	}
+	args, err := commandArgs(message)
+	if err != nil {
+		sendErrorMessage(bot, message.Chat.ID, usageError(err, watchUsage))
+		return
+	}
+	switch {
+	case len(args) == 1 && strings.EqualFold(args[0], "list"):
+		listFeeds(bot, message)
+		return
+	case len(args) == 2 && strings.EqualFold(args[0], "remove"):
+		removeFeed(bot, message, args[1])
+		return
+	case len(args) == 0:
+		sendErrorMessage(bot, message.Chat.ID, watchUsage)
+		return
+	}
+	if !canManageChat(bot, message.Chat, message.From.ID) {
+		sendErrorMessage(bot, message.Chat.ID, "🚫 Only group admins can add feeds here.")
+		return
+	}
+	if !passesGate(bot, message) {
+		return
+	}
+
+	opts, args, err := parseJobOptions(args)
+	if err != nil {
+		sendErrorMessage(bot, message.Chat.ID, usageError(err, watchUsage))
+		return
+	}
+	if len(args) != 1 {
+		sendErrorMessage(bot, message.Chat.ID, watchUsage)
+		return
+	}
+	if opts.Encrypt {
+		sendErrorMessage(bot, message.Chat.ID, "❌ Feed downloads can't be encrypted.")
+		return
+	}
+	feedURL := args[0]
+	if problem, ok := validateURL(feedURL); !ok {
+		sendErrorMessage(bot, message.Chat.ID, problem)
+		return
+	}
+
+	existing, err := loadFeeds()
+	if err != nil {
+		slog.Error("Error loading feeds", "error", err)
+		sendErrorMessage(bot, message.Chat.ID, "❌ Failed to add the feed")
+		return
+	}
+	inChat := 0
+	for _, sub := range existing {
+		if sub.ChatID != message.Chat.ID {
+			continue
+		}
+		if sub.URL == feedURL {
+			sendErrorMessage(bot, message.Chat.ID, fmt.Sprintf("❌ This chat already watches that feed as #%d.", sub.ID))
+			return
+		}
+		inChat++
+	}
+	if inChat >= maxFeedsPerChat {
+		sendErrorMessage(bot, message.Chat.ID, fmt.Sprintf("❌ This chat already watches %d feeds. Remove some with /watch remove <id> first.", inChat))
+		return
+	}
+
+	feed, err := fetchFeed(feedURL)
+	if err != nil {
+		slog.Info("Error reading feed", "host", urlHost(feedURL), "error", err)
+		sendErrorMessage(bot, message.Chat.ID, "❌ Couldn't read that feed: "+err.Error()+".")
+		return
+	}
+
+	id, err := store.nextID(bucketFeeds)
+	if err != nil {
+		slog.Error("Error allocating feed ID", "error", err)
+		sendErrorMessage(bot, message.Chat.ID, "❌ Failed to add the feed")
+		return
+	}
+	sub := feedSubscription{
+		ID:        int64(id),
+		ChatID:    message.Chat.ID,
+		CreatedBy: message.From.ID,
+		MessageID: message.MessageID,
+		URL:       feedURL,
+		Title:     feed.Title,
+		Options:   opts,
+		// What is in the feed already isn't new.
+		Seen:      seenIDs(feed.Items),
+		LastCheck: time.Now(),
+	}
+	if err := store.put(bucketFeeds, jobKey(sub.ID), sub); err != nil {
+		slog.Error("Error saving feed", "error", err)
+		sendErrorMessage(bot, message.Chat.ID, "❌ Failed to add the feed")
+		return
+	}
+	slog.Info("Added feed", "feed_id", sub.ID, "chat_id", sub.ChatID, "user_id", sub.CreatedBy, "host", urlHost(feedURL))
+	sendMessage(bot, message.Chat.ID, fmt.Sprintf("📡 Watching %s as #%d. New files will be posted here; the %d items already in it are skipped.\n\nStop with /watch remove %d.",
+		sub.name(), sub.ID, len(feed.Items), sub.ID))
+}
+
+func (s feedSubscription) name() string {
+	if s.Title != "" {
+		return "“" + s.Title + "”"
+	}
+	return redactURL(s.URL)
+}
+
+func loadFeeds() ([]feedSubscription, error) {
+	var all []feedSubscription
+	err := store.forEach(bucketFeeds, func(_, value []byte) error {
+		var sub feedSubscription
+		if err := json.Unmarshal(value, &sub); err != nil {
+			return err
+		}
+		all = append(all, sub)
+		return nil
+	})
+	sort.Slice(all, func(i, j int) bool { return all[i].ID < all[j].ID })
+	return all, err
+}
+
+func listFeeds(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
+	all, err := loadFeeds()
+	if err != nil {
+		slog.Error("Error loading feeds", "error", err)
+		sendErrorMessage(bot, message.Chat.ID, "❌ Failed to load the feeds")
+		return
+	}
+	var lines []string
+	for _, sub := range all {
+		if sub.ChatID != message.Chat.ID {
+			continue
+		}
+		line := fmt.Sprintf("#%d %s — %s", sub.ID, sub.name(), redactURL(sub.URL))
+		if sub.Failures > 0 {
+			line += fmt.Sprintf(" — failing (%d)", sub.Failures)
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) == 0 {
+		sendMessage(bot, message.Chat.ID, "📡 This chat watches no feeds.")
+		return
+	}
+	msg := tgbotapi.NewMessage(message.Chat.ID, "📡 Watched feeds:\n\n"+strings.Join(lines, "\n"))
+	msg.DisableWebPagePreview = true
+	bot.Send(msg)
+}
+
+func removeFeed(bot *tgbotapi.BotAPI, message *tgbotapi.Message, arg string) {
+	id, err := strconv.ParseInt(strings.TrimPrefix(arg, "#"), 10, 64)
+	if err != nil {
+		sendErrorMessage(bot, message.Chat.ID, watchUsage)
+		return
+	}
+	var sub feedSubscription
+	found, err := store.get(bucketFeeds, jobKey(id), &sub)
+	if err != nil {
+		slog.Error("Error loading feed", "feed_id", id, "error", err)
+		sendErrorMessage(bot, message.Chat.ID, "❌ Failed to remove the feed")
+		return
+	}
+	if !found || (sub.ChatID != message.Chat.ID && !isAdmin(message.From.ID)) {
+		sendErrorMessage(bot, message.Chat.ID, fmt.Sprintf("❌ This chat doesn't watch a feed #%d.", id))
+		return
+	}
+	if sub.CreatedBy != message.From.ID && !canManageChat(bot, message.Chat, message.From.ID) {
+		sendErrorMessage(bot, message.Chat.ID, "🚫 Only group admins can remove feeds here.")
+		return
+	}
+	if err := store.delete(bucketFeeds, jobKey(id)); err != nil {
+		slog.Error("Error deleting feed", "feed_id", id, "error", err)
+		sendErrorMessage(bot, message.Chat.ID, "❌ Failed to remove the feed")
+		return
+	}
+	slog.Info("Removed feed", "feed_id", id, "user_id", message.From.ID)
+	sendMessage(bot, message.Chat.ID, fmt.Sprintf("✅ Stopped watching %s.", sub.name()))
+}
+
+// runFeedWatcher polls each watched feed every cfg.FeedPollMinutes.
+func runFeedWatcher(bot *tgbotapi.BotAPI) {
+	for {
+		pollFeeds(bot, time.Now())
+		time.Sleep(feedWatchInterval)
+	}
+}
+
+func pollFeeds(bot *tgbotapi.BotAPI, now time.Time) {
+	all, err := loadFeeds()
+	if err != nil {
+		slog.Error("Error loading feeds", "error", err)
+		return
+	}
+	interval := time.Duration(cfg.FeedPollMinutes) * time.Minute
+	for _, sub := range all {
+		if now.Sub(sub.LastCheck) >= interval {
+			checkFeed(bot, sub, now)
+		}
+	}
+}
+
+// checkFeed downloads the files of items added to the feed since the last
+// poll, oldest first. Items without a file are only remembered.
+func checkFeed(bot *tgbotapi.BotAPI, sub feedSubscription, now time.Time) {
+	logger := slog.With("feed_id", sub.ID, "chat_id", sub.ChatID)
+	feed, fetchErr := fetchFeed(sub.URL)
+
+	var fresh []feedItem
+	err := updateRecord(store, bucketFeeds, jobKey(sub.ID), func(r *feedSubscription, exists bool) error {
+		if !exists {
+			return errFeedDeleted
+		}
+		r.LastCheck = now
+		if fetchErr != nil {
+			r.Failures++
+			sub = *r
+			return nil
+		}
+		seen := make(map[string]bool, len(r.Seen))
+		for _, id := range r.Seen {
+			seen[id] = true
+		}
+		fresh = fresh[:0]
+		for _, item := range feed.Items {
+			if item.ID != "" && !seen[item.ID] {
+				fresh = append(fresh, item)
+			}
+		}
+		r.Seen = seenIDs(feed.Items)
+		r.Failures = 0
+		if feed.Title != "" {
+			r.Title = feed.Title
+		}
+		sub = *r
+		return nil
+	})
+	if errors.Is(err, errFeedDeleted) {
+		return
+	}
+	if err != nil {
+		logger.Error("Error updating feed", "error", err)
+		return
+	}
+	if fetchErr != nil {
+		logger.Warn("Error polling feed", "failures", sub.Failures, "error", fetchErr)
+		if sub.Failures == maxFeedFailures {
+			sendMessage(bot, sub.ChatID, fmt.Sprintf("⚠️ Couldn't read the feed %s the last %d times: %s.\n\nIt's still watched; stop with /watch remove %d.",
+				sub.name(), maxFeedFailures, fetchErr.Error(), sub.ID))
+		}
+		return
+	}
+
+	started := 0
+	for _, item := range fresh {
+		if item.FileURL == "" {
+			continue
+		}
+		if _, ok := validateURL(item.FileURL); !ok {
+			logger.Info("Skipping feed item with an unusable link", "item", item.Title)
+			continue
+		}
+		if started == maxFeedItemsPerPoll {
+			logger.Info("Too many new feed items, skipping the rest", "new", len(fresh))
+			break
+		}
+		job := jobs.feed(sub, item.FileURL)
+		job.logger().Info("Starting feed download", "feed_id", sub.ID, "item", item.Title)
+		go handleURL(bot, job)
+		started++
+	}
+}
@@ -0,0 +1,439 @@
+package main
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	neturl "net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	maxZipURLs = 10
+	zipUsage   = "Usage: /zip <url1> <url2> … (up to 10 links or aliases)"
+)
+
+func handleZipCommand(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
+	if !isAuthorized(message) {
+		sendErrorMessage(bot, message.Chat.ID, notAuthorizedMessage)
+		return
+	}
+	args, err := commandArgs(message)
+	if err != nil {
+		sendErrorMessage(bot, message.Chat.ID, usageError(err, zipUsage))
+		return
+	}
+	if len(args) < 2 || len(args) > maxZipURLs {
+		sendErrorMessage(bot, message.Chat.ID, zipUsage)
+		return
+	}
+
+	urls := make([]string, 0, len(args))
+	for _, arg := range args {
+		url, ok := resolveURLArgs(message, []string{arg})
+		if !ok {
+			sendErrorMessage(bot, message.Chat.ID, url)
+			return
+		}
+		if problem, ok := validateURL(url); !ok {
+			sendErrorMessage(bot, message.Chat.ID, problem+"\n\n"+arg)
+			return
+		}
+		urls = append(urls, url)
+	}
+
+	if duplicates.isDuplicate(message) {
+		slog.Debug("Ignoring duplicate /zip", "chat_id", message.Chat.ID)
+		return
+	}
+	var userID int64
+	if message.From != nil {
+		userID = message.From.ID
+	}
+	if slowDown, ok := checkRateLimit(userID); !ok {
+		sendErrorMessage(bot, message.Chat.ID, slowDown)
+		return
+	}
+	go handleURL(bot, jobs.start(message, urls[0], jobOptions{ZipURLs: urls}))
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// zipEntryName is the name a download gets inside the archive: the last
+// part of its path, made unique among taken.
+func zipEntryName(rawURL string, taken map[string]bool) string {
+	name := "file"
+	if u, err := neturl.Parse(rawURL); err == nil {
+		if base := path.Base(u.Path); base != "." && base != "/" {
+			name = base
+		}
+	}
+	if taken[name] {
+		name = suffixedName(name, taken)
+	}
+	taken[name] = true
+	return name
+}
+
+// runZipJob downloads each of the job's URLs into one zip archive and sends
+// that. Links that fail, or whose file a download of its own wouldn't be
+// sent for, are left out and listed in the caption.
+func runZipJob(bot *tgbotapi.BotAPI, job *Job) error {
+	job.limitMB = chatMaxFileSizeMB(job.ChatID)
+	job.setFileName(fmt.Sprintf("files-%s.zip", time.Now().Format(time.DateOnly)))
+	if job.canStreamZip() {
+		job.planStages("stream")
+		return streamZipJob(bot, job)
+	}
+	job.planStages("download", "upload")
+
+	if err := checkDiskSpace(-1); err != nil {
+		return err
+	}
+	tempFile, err := os.CreateTemp(cfg.TempDir, "telegram-*-"+job.FileName)
+	if err != nil {
+		return failJob("❌ Failed to create temporary file", err)
+	}
+	defer func() {
+		tempFile.Close()
+		os.Remove(tempFile.Name())
+	}()
+
+	hasher := sha256.New()
+	z, err := fillZip(bot, job, io.MultiWriter(tempFile, hasher), "download", "📦 Downloading %d files...")
+	if err != nil {
+		return err
+	}
+	job.Size = z.size
+	job.SHA256 = hex.EncodeToString(hasher.Sum(nil))
+	job.noteDiskBytes(z.size)
+
+	if err := scanJobFile(bot, job, tempFile); err != nil {
+		return err
+	}
+	delivered := tempFile
+	hooked, err := runHooks(bot, job, tempFile, "application/zip")
+	if err != nil {
+		return err
+	}
+	if hooked != nil {
+		defer func() {
+			hooked.Close()
+			os.Remove(hooked.Name())
+		}()
+		job.noteDisk(hooked)
+		delivered = hooked
+	}
+	if len(z.holds) > 0 {
+		if err := awaitApproval(bot, job, strings.Join(z.holds, "; ")); err != nil {
+			return err
+		}
+	}
+
+	delivered.Seek(0, io.SeekStart)
+	doc := tgbotapi.NewDocument(job.deliveryChatID(), tgbotapi.FileReader{Name: job.FileName, Reader: &ProgressReader{
+		Reader:     job.throttle(delivered, "upload"),
+		total:      job.Size,
+		onProgress: progressUpdater(bot, job, "upload", "📤 Uploading to Telegram..."),
+		meter:      job.meter("upload"),
+	}})
+	doc.ReplyToMessageID = job.deliveryReplyID()
+	doc.DisableNotification = job.silent
+	doc.Caption = z.caption()
+
+	releaseUpload, err := acquireUploadSlot(bot, job, false)
+	if err != nil {
+		return err
+	}
+	defer releaseUpload()
+	job.setState(jobUploading)
+	if err := job.spendAttempt("upload"); err != nil {
+		return err
+	}
+	uploaded := job.timeStage("upload")
+	sent, err := bot.Send(doc)
+	if err != nil {
+		return failJob(describeSendError(err, "❌ Failed to send the file"), err)
+	}
+	uploaded()
+	indexDelivery(job, sent)
+	return nil
+}
+
+// canStreamZip reports whether cfg.StreamUploads applies to a /zip job:
+// the archive can't be scanned or held for approval as a whole if it is
+// uploaded while it is being written.
+func (j *Job) canStreamZip() bool {
+	if !cfg.StreamUploads || cfg.ClamdAddress != "" || len(cfg.Hooks) > 0 || hashHoldsListed() {
+		return false
+	}
+	for _, url := range j.options.ZipURLs {
+		if _, held := holdReason(&Job{URL: url}, hashEntry{}, false); held {
+			return false
+		}
+	}
+	return true
+}
+
+// streamZipJob uploads the archive while it is being written, so later
+// links are still downloading while the first ones are on their way to
+// Telegram. The caption, listing what made it in, is added once the
+// upload is done.
+func streamZipJob(bot *tgbotapi.BotAPI, job *Job) error {
+	releaseUpload, err := acquireUploadSlot(bot, job, true)
+	if err != nil {
+		return err
+	}
+	defer releaseUpload()
+	if err := job.spendAttempt("upload"); err != nil {
+		return err
+	}
+	ring := newRingBuffer(streamBufferSize())
+	doc := tgbotapi.NewDocument(job.deliveryChatID(), tgbotapi.FileReader{Name: job.FileName, Reader: ring})
+	doc.ReplyToMessageID = job.deliveryReplyID()
+	doc.DisableNotification = job.silent
+	type sendResult struct {
+		sent tgbotapi.Message
+		err  error
+	}
+	uploaded := make(chan sendResult, 1)
+	go func() {
+		sent, err := bot.Send(doc)
+		ring.closeRead()
+		uploaded <- sendResult{sent, err}
+	}()
+
+	streamed := job.timeStage("stream")
+	hasher := sha256.New()
+	z, err := fillZip(bot, job, io.MultiWriter(ring, hasher), "stream", "📡 Zipping %d files straight to Telegram...")
+	if err == nil && len(z.holds) > 0 {
+		// A hash was put on hold since the job started; the archive is
+		// already on its way and can't wait.
+		err = &jobError{
+			userMessage: "⏸ A file in this archive needs an admin's approval now. Please send /zip again.",
+			result:      resultBlocked,
+			err:         fmt.Errorf("held: %s", strings.Join(z.holds, "; ")),
+		}
+	}
+	// The upload must not go through with a broken, empty or held archive.
+	ring.closeWrite(err)
+	result := <-uploaded
+	if err != nil {
+		if result.err != nil && errors.Is(err, io.ErrClosedPipe) {
+			return failJob(describeSendError(result.err, "❌ Failed to send the file"), result.err)
+		}
+		return err
+	}
+	if result.err != nil {
+		return failJob(describeSendError(result.err, "❌ Failed to send the file"), result.err)
+	}
+	streamed()
+	job.Size = z.size
+	job.SHA256 = hex.EncodeToString(hasher.Sum(nil))
+	indexDelivery(job, result.sent)
+
+	if _, err := bot.Send(tgbotapi.NewEditMessageCaption(job.deliveryChatID(), result.sent.MessageID, z.caption())); err != nil {
+		job.logger().Warn("Error adding the zip caption", "error", err)
+	}
+	return nil
+}
+
+type zipResult struct {
+	added, skipped, holds []string
+	size                  int64
+}
+
+func (z zipResult) caption() string {
+	caption := fmt.Sprintf("📦 %d files: %s", len(z.added), strings.Join(z.added, ", "))
+	if len(z.skipped) > 0 {
+		caption += "\n\n⚠️ Left out: " + strings.Join(z.skipped, ", ")
+	}
+	// Telegram allows 1024 characters.
+	if len(caption) > 1000 {
+		cut := strings.LastIndex(caption[:1000], " ")
+		caption = caption[:max(cut, 0)] + " …"
+	}
+	return caption
+}
+
+// fillZip writes the archive of the job's links to w. It fails if none of
+// them could be added.
+func fillZip(bot *tgbotapi.BotAPI, job *Job, w io.Writer, stage, status string) (zipResult, error) {
+	urls := job.options.ZipURLs
+	limit := job.uploadLimit()
+	out := &countingWriter{Writer: w}
+	archive := zip.NewWriter(out)
+	onProgress := progressUpdater(bot, job, stage, fmt.Sprintf(status, len(urls)))
+	taken := map[string]bool{}
+	var z zipResult
+	// contents is what the added files come to before compression, which
+	// is what the quota counts.
+	var contents int64
+
+	downloaded := job.timeStage("download")
+	for i, url := range urls {
+		part := &Job{
+			ID: job.ID, ChatID: job.ChatID, UserID: job.UserID, MessageID: job.MessageID,
+			URL: url, ctx: job.ctx, limitMB: job.limitMB, allowedTypes: job.allowedTypes,
+		}
+		name := zipEntryName(url, taken)
+		member, err := addToZip(archive, job, part, name, limit-out.n, contents, job.meter(stage), func(p float64) {
+			onProgress((float64(i) + p/100) / float64(len(urls)) * 100)
+		})
+		var skip *zipSkipError
+		switch {
+		case errors.As(err, &skip):
+			part.logger().Warn("Left a file out of the zip", "error", skip.err)
+			z.skipped = append(z.skipped, fmt.Sprintf("%s (%s)", name, skip.reason()))
+		case err != nil:
+			return z, err
+		default:
+			z.added = append(z.added, name)
+			contents += member.size
+			if member.hold != "" {
+				z.holds = append(z.holds, name+": "+member.hold)
+			}
+		}
+	}
+	if len(z.added) == 0 {
+		return z, &jobError{userMessage: "❌ None of the files could be downloaded:\n" + strings.Join(z.skipped, "\n"), result: resultRejected}
+	}
+	if err := archive.Close(); err != nil {
+		return z, failJob("❌ Failed to save the file", err)
+	}
+	downloaded()
+	z.size = out.n
+	return z, nil
+}
+
+// zipSkipError is a link left out of the archive, so the rest can still be
+// sent.
+type zipSkipError struct {
+	err error
+}
+
+func (e *zipSkipError) Error() string { return e.err.Error() }
+func (e *zipSkipError) Unwrap() error { return e.err }
+
+func (e *zipSkipError) reason() string {
+	var jerr *jobError
+	if errors.As(e.err, &jerr) && jerr.userMessage != "" {
+		return strings.TrimSpace(strings.TrimLeft(jerr.userMessage, "❌🚫⚡ "))
+	}
+	return e.err.Error()
+}
+
+type zipMember struct {
+	size int64
+	// hold is why the member has to wait for approval, if it does.
+	hold string
+}
+
+// addToZip downloads part to a temp file, hashing it on the way, and adds
+// it to the archive once it passed the checks a download of its own would
+// have to: the chat's file types, the hash lists and the quota of owner,
+// the zip job, counting the used bytes of the files already in the
+// archive.
+func addToZip(archive *zip.Writer, owner, part *Job, name string, remaining, used int64, meter *speedMeter, onProgress func(float64)) (zipMember, error) {
+	if msg, ok := checkCircuit(part.URL); !ok {
+		return zipMember{}, &zipSkipError{&jobError{userMessage: msg, result: resultRejected}}
+	}
+	if err := checkTarget(part.ctx, part.URL); err != nil {
+		return zipMember{}, &zipSkipError{failJob("", err)}
+	}
+	resp, err := requestFile(part, 0)
+	if err != nil {
+		recordHostResult(part, err)
+		return zipMember{}, &zipSkipError{err}
+	}
+	defer resp.Body.Close()
+	if err := checkPresignedResponse(resp); err != nil {
+		return zipMember{}, &zipSkipError{err}
+	}
+	if resp.StatusCode >= 400 {
+		err := statusError(resp)
+		recordHostResult(part, err)
+		return zipMember{}, &zipSkipError{err}
+	}
+	if err := part.checkFileType(name, resp.Header.Get("Content-Type")); err != nil {
+		return zipMember{}, &zipSkipError{err}
+	}
+	if resp.ContentLength > remaining {
+		return zipMember{}, &zipSkipError{tooLargeError(resp.ContentLength, part.limitMB)}
+	}
+	if quotaMsg, ok := owner.reserveQuota(used + max(resp.ContentLength, 0)); !ok {
+		return zipMember{}, &zipSkipError{&jobError{userMessage: quotaMsg, result: resultRejected}}
+	}
+	if err := checkDiskSpace(resp.ContentLength); err != nil {
+		return zipMember{}, &zipSkipError{err}
+	}
+
+	staged, err := os.CreateTemp(cfg.TempDir, "telegram-zip-*")
+	if err != nil {
+		return zipMember{}, failJob("❌ Failed to create temporary file", err)
+	}
+	defer func() {
+		staged.Close()
+		os.Remove(staged.Name())
+	}()
+	hasher := sha256.New()
+	reader := &ProgressReader{
+		Reader:     &sizeGuard{Reader: part.throttle(resp.Body, "download"), limit: remaining},
+		total:      resp.ContentLength,
+		onProgress: onProgress,
+		meter:      meter,
+	}
+	_, err = io.CopyBuffer(io.MultiWriter(staged, hasher), reader, make([]byte, copyBufferSize()))
+	if errors.Is(err, errTooLarge) {
+		return zipMember{}, &zipSkipError{tooLargeError(reader.downloaded, part.limitMB)}
+	}
+	if errors.Is(err, io.ErrUnexpectedEOF) || (err == nil && resp.ContentLength > 0 && reader.downloaded != resp.ContentLength) {
+		err = &truncatedError{got: reader.downloaded, want: resp.ContentLength}
+	}
+	recordHostResult(part, err)
+	if err != nil {
+		if part.ctx.Err() != nil {
+			return zipMember{}, part.ctx.Err()
+		}
+		return zipMember{}, &zipSkipError{failJob(fmt.Sprintf("❌ Failed to download %s", name), err)}
+	}
+
+	member := zipMember{size: reader.downloaded}
+	sum := hex.EncodeToString(hasher.Sum(nil))
+	if member.hold, err = screenPart(part.URL, sum); err != nil {
+		part.logger().Warn("Blocked denylisted file in a zip", "sha256", sum)
+		return zipMember{}, &zipSkipError{err}
+	}
+	if quotaMsg, ok := owner.reserveQuota(used + member.size); !ok {
+		return zipMember{}, &zipSkipError{&jobError{userMessage: quotaMsg, result: resultRejected}}
+	}
+
+	w, err := archive.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now()})
+	if err != nil {
+		return zipMember{}, failJob("❌ Failed to save the file", err)
+	}
+	staged.Seek(0, io.SeekStart)
+	if _, err := io.CopyBuffer(w, staged, make([]byte, copyBufferSize())); err != nil {
+		return zipMember{}, failJob("❌ Failed to save the file", err)
+	}
+	return member, nil
+}